@@ -0,0 +1,40 @@
+// Command execrec-collector is a reference collector for the gRPC sink of
+// kubectl execrec: it stores the sessions streamed to it, every session in a
+// directory named after its ID.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/collector"
+)
+
+func main() {
+	listen := flag.String("listen", ":8443", "address to listen on")
+	dir := flag.String("dir", "sessions", "directory storing the sessions")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, HTTP/2 without TLS if empty")
+	tlsKey := flag.String("tls-key", "", "PEM key file of the certificate")
+	flag.Parse()
+
+	server := &collector.Server{
+		Dir:   *dir,
+		Token: os.Getenv("EXECREC_COLLECTOR_TOKEN"),
+		Logf:  log.Printf,
+	}
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(*tlsCert == "")
+	srv := &http.Server{Addr: *listen, Handler: server, Protocols: protocols}
+
+	log.Printf("collecting sessions in %s on %s", *dir, *listen)
+	var err error
+	if *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	log.Fatal(err)
+}
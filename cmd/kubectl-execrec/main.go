@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/keidarcy/kubectl-execrec/pkg/cmd"
@@ -15,6 +16,7 @@ func main() {
 	}
 	command := cmd.NewCmd(streams)
 	if err := command.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
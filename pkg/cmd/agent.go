@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func newAgentCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var interval, maxBackoff time.Duration
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Upload the recorded sessions in the background",
+		Long: `Run an upload agent watching the spool directory. While the agent runs, sessions are queued when they end instead of being uploaded, so finishing a session never waits for the network. The agent uploads the queued sessions and retries the failed uploads with an exponential backoff, it also recovers the sessions that terminated abnormally.
+
+The uploads are configured with the same environment variables as for a session.
+
+Examples:
+  KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec agent
+  kubectl execrec agent --interval 10s --max-backoff 30m
+  kubectl execrec agent install`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uploaders, err := o.uploaders()
+			if err != nil {
+				return err
+			}
+			if len(uploaders) == 0 {
+				return fmt.Errorf("no upload is configured")
+			}
+
+			sp := spool{dir: o.spoolDir}
+			release, err := sp.lockAgent()
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			a := &agent{
+				spool:      sp,
+				uploaders:  o.uploaders,
+				now:        o.now,
+				log:        streams.ErrOut,
+				interval:   interval,
+				maxBackoff: maxBackoff,
+				retries:    map[string]int{},
+				next:       map[string]time.Time{},
+			}
+			a.run(ctx)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "How often the spool directory is checked")
+	cmd.Flags().DurationVar(&maxBackoff, "max-backoff", time.Hour, "Maximum delay between two attempts to upload a session")
+	cmd.AddCommand(newAgentInstallCmd(streams, o))
+	return cmd
+}
+
+// agent uploads the sessions queued in the spool directory
+type agent struct {
+	spool      spool
+	uploaders  func() ([]upload.Uploader, error)
+	now        func() time.Time
+	log        io.Writer
+	interval   time.Duration
+	maxBackoff time.Duration
+
+	// retries is the number of failed attempts and next the time of the next
+	// attempt of the pending uploads, by spool file
+	retries map[string]int
+	next    map[string]time.Time
+}
+
+// run checks the spool directory every interval until ctx is done
+func (a *agent) run(ctx context.Context) {
+	a.logf("upload agent started, watching %s", a.spool.dir)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		a.poll()
+		select {
+		case <-ctx.Done():
+			a.logf("upload agent stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll recovers the orphaned sessions and uploads the pending ones that are
+// due
+func (a *agent) poll() {
+	recovered, err := a.spool.recover()
+	for _, ev := range recovered {
+		a.logf("recovered the session %s that terminated abnormally", ev.LogFile)
+	}
+	if err != nil {
+		a.logf("failed to recover a session: %v", err)
+	}
+
+	uploads, err := a.spool.pending()
+	if err != nil {
+		a.logf("failed to read the pending uploads: %v", err)
+	}
+	for _, u := range uploads {
+		if a.now().Before(a.next[u.path]) {
+			continue
+		}
+		if err := a.upload(u.event); err != nil {
+			a.retries[u.path]++
+			backoff := min(a.interval<<min(a.retries[u.path], 20), a.maxBackoff)
+			a.next[u.path] = a.now().Add(backoff)
+			a.logf("failed to upload %s, retrying in %s: %v", u.event.LogFile, backoff, err)
+			continue
+		}
+		delete(a.retries, u.path)
+		delete(a.next, u.path)
+		if err := os.Remove(u.path); err != nil {
+			a.logf("failed to remove %s from the spool directory: %v", u.event.LogFile, err)
+		}
+	}
+}
+
+// upload uploads a session with every uploader of the profile of its context
+func (a *agent) upload(ev recorder.Event) error {
+	if _, err := config.useContext(ev.Context); err != nil {
+		return err
+	}
+	ev, err := sealSession(ev)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt the session: %w", err)
+	}
+	uploaders, err := a.uploaders()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range uploaders {
+		location, err := u.Upload(ev)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		a.logf("uploaded %s to %s", ev.LogFile, location)
+	}
+	return errors.Join(errs...)
+}
+
+func (a *agent) logf(format string, args ...any) {
+	fmt.Fprintf(a.log, "%s %s\n", a.now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
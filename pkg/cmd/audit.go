@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// auditLockTimeout is how long an invocation waits for another one appending
+// to the audit journal
+const auditLockTimeout = 2 * time.Second
+
+// auditEntry is an invocation of kubectl execrec in the audit journal
+type auditEntry struct {
+	Time    string `json:"time"`
+	User    string `json:"user"`
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	// SessionID is the session, or the group of the sessions of a run on
+	// several pods, empty if the invocation failed before
+	SessionID string `json:"sessionId,omitempty"`
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	LogFile   string `json:"logFile,omitempty"`
+	NoRecord  string `json:"noRecord,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+	ExitCode  int    `json:"exitCode"`
+	Error     string `json:"error,omitempty"`
+	// Reason and Deleted are the reason of the deletion of the recordings
+	// of a session by rm and the files and S3 objects it deleted, or the
+	// reason of its evidence bundle
+	Reason  string   `json:"reason,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+	// Prev is the SHA-256 of the previous line of the journal, so that a
+	// line removed or changed breaks the chain
+	Prev string `json:"prev"`
+}
+
+// auditPath returns the path of the audit journal
+func (o *options) auditPath() string {
+	if path := setting("audit-journal"); path != "" {
+		return path
+	}
+	return o.auditFile
+}
+
+// newAuditEntry creates the audit entry of an invocation
+func newAuditEntry(o *options, args []string) *auditEntry {
+	return &auditEntry{
+		Time:    o.now().Format(time.RFC3339),
+		User:    whoami(),
+		PID:     os.Getpid(),
+		Command: "kubectl execrec " + strings.Join(args, " "),
+	}
+}
+
+// finish records the result of the invocation
+func (e *auditEntry) finish(err error) {
+	e.ExitCode = exitCode(err)
+	if err != nil && e.ExitCode == -1 {
+		e.Error = err.Error()
+	}
+}
+
+// appendAudit appends an entry to the audit journal, readable by its owner
+// only and chained to its previous line
+func appendAudit(path string, e *auditEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	unlock, err := lockAudit(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	prev, err := lastLineHash(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.Prev = prev
+	data, err := json.Marshal(e)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lastLineHash returns the SHA-256 of the last line of the journal, empty if
+// it is empty. The journal is read backwards until the line before, whatever
+// the length of the last line.
+func lastLineHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return "", err
+	}
+	var line []byte
+	buf := make([]byte, 4<<10)
+	for end := info.Size(); end > 0; {
+		chunk := buf[:min(end, int64(len(buf)))]
+		end -= int64(len(chunk))
+		if _, err := f.ReadAt(chunk, end); err != nil {
+			return "", err
+		}
+		if line == nil {
+			// the newline ending the last line
+			chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			line = append(bytes.Clone(chunk[i+1:]), line...)
+			break
+		}
+		line = append(bytes.Clone(chunk), line...)
+	}
+	return lineHash(line), nil
+}
+
+// lineHash returns the SHA-256 of a line of the journal without its newline
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyAudit checks that the prev field of every line of a journal is the
+// SHA-256 of the line before, it returns the number of lines and the hash of
+// the last one
+func verifyAudit(r io.Reader) (int, string, error) {
+	br := bufio.NewReader(r)
+	prev := ""
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return n - 1, prev, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n - 1, prev, err
+		}
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			return n - 1, prev, fmt.Errorf("line %d is truncated", n)
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		var e auditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n - 1, prev, fmt.Errorf("line %d is not a journal entry: %w", n, err)
+		}
+		if e.Prev != prev {
+			return n - 1, prev, fmt.Errorf("line %d breaks the chain: prev is %q, the previous line hashes to %q", n, e.Prev, prev)
+		}
+		prev = lineHash(line)
+	}
+}
+
+// auditFailure appends an invocation that failed before its session could be
+// run, e.g. with invalid flags, to the audit journal and returns its error
+func auditFailure(streams genericclioptions.IOStreams, o *options, args []string, err error) error {
+	entry := newAuditEntry(o, args)
+	entry.finish(err)
+	// the journal may be set in the config file, the error of the
+	// invocation is the one reported if it cannot be read
+	_ = loadConfig("")
+	if auditErr := appendAudit(o.auditPath(), entry); auditErr != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to append to the audit journal: %v\n", auditErr)
+	}
+	return err
+}
+
+// lockAudit keeps other invocations from appending to the journal until the
+// returned function is called, so that the chain is not forked. A lock left
+// by a process that died is removed.
+func lockAudit(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(auditLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, _ = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			f.Close()
+			return func() { _ = os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		data, _ := os.ReadFile(lock)
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && !processAlive(pid) {
+			_ = os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the audit journal is locked by %s", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
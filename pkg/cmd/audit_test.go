@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// a line longer than the chunks lastLineHash reads
+	for _, command := range []string{"kubectl execrec web", strings.Repeat("x", 100<<10), "kubectl execrec rm 01TEST"} {
+		if err := appendAudit(path, &auditEntry{Command: command}); err != nil {
+			t.Fatalf("appendAudit: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	n, last, err := verifyAudit(strings.NewReader(string(data)))
+	if err != nil || n != 3 {
+		t.Fatalf("verifyAudit = %d, %v, want 3 lines", n, err)
+	}
+	if want := lineHash([]byte(strings.TrimSuffix(lines[2], "\n"))); last != want {
+		t.Errorf("last line hash = %s, want %s", last, want)
+	}
+
+	tests := []struct {
+		name    string
+		journal string
+		err     string
+	}{
+		{name: "line removed", journal: lines[0] + lines[2], err: "line 2 breaks the chain"},
+		{name: "line changed", journal: lines[0] + strings.Replace(lines[1], "xxx", "yyy", 1) + lines[2], err: "line 3 breaks the chain"},
+		{name: "truncated", journal: lines[0] + lines[1][:100], err: "line 2 is truncated"},
+		{name: "not JSON", journal: lines[0] + "garbage\n", err: "line 2 is not a journal entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := verifyAudit(strings.NewReader(tt.journal)); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("verifyAudit error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func newAuditCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Check the audit journal",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newAuditVerifyCmd(streams, o))
+	return cmd
+}
+
+func newAuditVerifyCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [FILE]",
+		Short: "Verify the hash chain of the audit journal",
+		Long: `Verify the hash chain of the audit journal, the one of KUBECTL_EXECREC_AUDIT_JOURNAL or the default one if FILE is not given: the prev field of every line must be the SHA-256 of the line before, so that a line removed, inserted or changed is reported with its number.
+
+The lines removed from the end of the journal do not break the chain, the hash of the last line is printed so that it can be compared with a copy kept elsewhere.
+
+Examples:
+  kubectl execrec audit verify
+  kubectl execrec audit verify /var/log/kubectl-execrec/audit.jsonl`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := o.auditPath()
+			if len(args) == 1 {
+				path = args[0]
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			n, last, err := verifyAudit(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			fmt.Fprintf(streams.Out, "%s: %d lines, the chain is intact, last line %s\n", path, n, last)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// defaultCaptureLines is the number of log lines captured by
+// --capture-context given without a value
+const defaultCaptureLines = "100"
+
+// parseCaptureLines parses the number of log lines of --capture-context, 0
+// if the context is not captured
+func parseCaptureLines(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid --capture-context %q, expected a number of log lines", s)
+	}
+	return n, nil
+}
+
+// captureContext stores what operators look at before a session next to the
+// log file: the last lines of the logs of the container and the output of
+// 'kubectl describe' for the exec target. A command failing is a warning,
+// the session starts anyway.
+func captureContext(command func(string, ...string) *exec.Cmd, t target, rec *recorder.Recorder, lines int, errOut io.Writer) {
+	if t.Resource == "" {
+		fmt.Fprintf(errOut, "Warning: failed to capture the context: no exec target found in arguments\n")
+		return
+	}
+	resource := t.Resource
+	if t.Pod != "" {
+		resource = "pod/" + t.Pod
+	}
+
+	logs := []string{"logs", resource, "--tail", strconv.Itoa(lines)}
+	if t.Container != "" {
+		logs = append(logs, "-c", t.Container)
+	}
+	captures := []struct {
+		name   string
+		suffix string
+		args   []string
+	}{
+		{"logs", ".pod-logs.txt", logs},
+		{"describe", ".describe.txt", []string{"describe", resource}},
+	}
+	for _, c := range captures {
+		path := sidecarPath(rec.LogPath(), c.suffix)
+		if err := captureKubectl(command, append(c.args, t.KubeFlags...), path); err != nil {
+			fmt.Fprintf(errOut, "Warning: failed to capture the %s of %s: %v\n", c.name, resource, err)
+			continue
+		}
+		rec.Attach(path)
+	}
+}
+
+// captureKubectl writes the output of a kubectl command to a file
+func captureKubectl(command func(string, ...string) *exec.Cmd, args []string, path string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := command("kubectl", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("kubectl %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return os.WriteFile(path, stdout.Bytes(), 0o644)
+}
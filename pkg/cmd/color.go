@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// colorRole is what a colored text is
+type colorRole int
+
+const (
+	colorHeader colorRole = iota
+	colorPass
+	colorWarn
+	colorFail
+	colorSkip
+	// colorDim is for the secondary texts, e.g. the fixes of the failed
+	// checks
+	colorDim
+)
+
+// colorTheme are the SGR parameters of the roles, the texts of the missing
+// roles are plain
+type colorTheme map[colorRole]string
+
+// colorThemes are the themes of KUBECTL_EXECREC_COLOR_THEME, dark by
+// default. light avoids yellow and faint texts, unreadable on a white
+// background, and mono only uses bold and reverse video.
+var colorThemes = map[string]colorTheme{
+	"dark":  {colorHeader: "1", colorPass: "1;32", colorWarn: "1;33", colorFail: "1;31", colorSkip: "2", colorDim: "2"},
+	"light": {colorHeader: "1", colorPass: "32", colorWarn: "35", colorFail: "1;31", colorSkip: "34", colorDim: "34"},
+	"mono":  {colorHeader: "1", colorWarn: "1", colorFail: "1;7"},
+}
+
+// colorModes are the values of --color and KUBECTL_EXECREC_COLOR
+var colorModes = []string{"auto", "always", "never"}
+
+// colorFlags are the --color and --no-color flags of the subcommands
+// printing colored output
+type colorFlags struct {
+	mode    string
+	noColor bool
+}
+
+// addColorFlags adds --color and --no-color
+func addColorFlags(cmd *cobra.Command) *colorFlags {
+	f := &colorFlags{}
+	cmd.Flags().StringVar(&f.mode, "color", "", "When to color the output: auto, always or never, auto by default")
+	cmd.Flags().BoolVar(&f.noColor, "no-color", false, "Do not color the output, same as --color never")
+	return f
+}
+
+// colors returns the colors of the output to w. --no-color, then --color,
+// then KUBECTL_EXECREC_COLOR tell when to color it, auto only coloring a
+// terminal if NO_COLOR is not set and TERM is not dumb.
+func (f *colorFlags) colors(w io.Writer) (colors, error) {
+	mode := f.mode
+	if mode == "" {
+		mode = setting("color")
+	}
+	if mode == "" {
+		mode = "auto"
+	}
+	if !slices.Contains(colorModes, mode) {
+		return colors{}, fmt.Errorf("invalid color %q, expected auto, always or never", mode)
+	}
+	name := setting("color-theme")
+	if name == "" {
+		name = "dark"
+	}
+	theme, ok := colorThemes[name]
+	if !ok {
+		return colors{}, fmt.Errorf("invalid color theme %q, expected dark, light or mono", name)
+	}
+	switch {
+	case f.noColor, mode == "never":
+		return colors{}, nil
+	case mode == "always":
+		return colors{theme: theme}, nil
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return colors{}, nil
+	}
+	// the pager shows the colors on its terminal
+	if p, ok := w.(*pager); ok {
+		w = p.out
+	}
+	if file, ok := w.(*os.File); !ok || !term.IsTerminal(int(file.Fd())) {
+		return colors{}, nil
+	}
+	return colors{theme: theme}, nil
+}
+
+// colors colors texts with a theme, or not at all without one
+type colors struct {
+	theme colorTheme
+}
+
+// paint colors s as a text of the role
+func (c colors) paint(role colorRole, s string) string {
+	sgr := c.theme[role]
+	if sgr == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+}
+
+// paintLines writes the lines of text, aligned by a tabwriter, to out with
+// the color of each line. paint returns the line colored, it is only called
+// with a theme since the escape sequences would be aligned as text.
+func (c colors) paintLines(out io.Writer, text []byte, paint func(i int, line string) string) error {
+	if c.theme == nil {
+		_, err := out.Write(text)
+		return err
+	}
+	lines := strings.SplitAfter(string(text), "\n")
+	var b bytes.Buffer
+	for i, line := range lines {
+		content, newline := strings.CutSuffix(line, "\n")
+		b.WriteString(paint(i, content))
+		if newline {
+			b.WriteByte('\n')
+		}
+	}
+	_, err := b.WriteTo(out)
+	return err
+}
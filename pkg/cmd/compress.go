@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// compression returns the compression level of the log files set with
+// compress and compression-level, and whether they are compressed
+func compression() (int, bool, error) {
+	switch method := strings.ToLower(setting("compress")); method {
+	case "", "none":
+		return 0, false, nil
+	case "gzip":
+	default:
+		return 0, false, fmt.Errorf("invalid compression %q, expected gzip or none", method)
+	}
+	level, err := compress.ParseLevel(setting("compression-level"))
+	if err != nil {
+		return 0, false, err
+	}
+	return level, true, nil
+}
+
+// compressSession compresses the files of a finished session with gzip when
+// compress is set and returns the event referring to the compressed files.
+// The files already compressed are kept.
+func compressSession(ev recorder.Event) (recorder.Event, error) {
+	level, enabled, err := compression()
+	if err != nil || !enabled || ev.LogFile == "" {
+		return ev, err
+	}
+	compressFile := func(path string) (string, error) {
+		if strings.HasSuffix(path, compress.Ext) {
+			return path, nil
+		}
+		return compress.CompressFile(path, level)
+	}
+
+	logFile, err := compressFile(ev.LogFile)
+	if err != nil {
+		return ev, err
+	}
+	attachments := make([]string, len(ev.Attachments))
+	for i, path := range ev.Attachments {
+		if attachments[i], err = compressFile(path); err != nil {
+			return ev, err
+		}
+	}
+	ev.LogFile = logFile
+	ev.Attachments = attachments
+	return ev, nil
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/sink"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// recorderOptions returns the recording options set with flags and
+// environment variables
+func recorderOptions(flags flagValues) (recorder.Options, error) {
+	opts := recorder.Options{
+		DetectBinary:  isTrue(setting("detect-binary")),
+		Commands:      isTrue(setting("command-summary")),
+		PromptMarkers: isTrue(setting("prompt-markers")),
+		PlainText:     isTrue(setting("plain-text")),
+		RecordInput:   isTrue(setting("record-input")),
+		NoRecord:      strings.TrimSpace(flags.get("no-record")),
+	}
+
+	var err error
+	if opts.MinFreeSpace, err = parseSize(flags.get("min-free-space")); err != nil {
+		return opts, fmt.Errorf("invalid --min-free-space: %w", err)
+	}
+	if opts.MaxLogSize, err = parseSize(flags.get("max-log-size")); err != nil {
+		return opts, fmt.Errorf("invalid --max-log-size: %w", err)
+	}
+	if opts.MaxLogSizePolicy, err = recorder.ParseSizePolicy(flags.get("max-log-size-policy")); err != nil {
+		return opts, err
+	}
+	if opts.Signals, err = recorder.ParseSignals(setting("signals")); err != nil {
+		return opts, err
+	}
+	if opts.MaxOutputRate, err = parseSize(flags.get("max-output-rate")); err != nil {
+		return opts, fmt.Errorf("invalid --max-output-rate: %w", err)
+	}
+	size, err := parseSize(flags.get("buffer-size"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid --buffer-size: %w", err)
+	}
+	if size != 0 && (size < recorder.MinBufferSize || size > recorder.MaxBufferSize) {
+		return opts, fmt.Errorf("invalid --buffer-size %q, expected %d bytes to 16M", flags.get("buffer-size"), recorder.MinBufferSize)
+	}
+	opts.BufferSize = int(size)
+	if opts.IdleTimeout, err = parseDuration(flags.get("idle-timeout")); err != nil {
+		return opts, fmt.Errorf("invalid --idle-timeout: %w", err)
+	}
+	if opts.IdleWarning, err = parseDuration(setting("idle-warning")); err != nil {
+		return opts, fmt.Errorf("invalid idle-warning: %w", err)
+	}
+	if opts.HeartbeatInterval, err = parseDuration(setting("heartbeat-interval")); err != nil {
+		return opts, fmt.Errorf("invalid heartbeat-interval: %w", err)
+	}
+	if _, _, err := compression(); err != nil {
+		return opts, err
+	}
+	if opts.Timeout, err = parseDuration(flags.get("timeout")); err != nil {
+		return opts, fmt.Errorf("invalid --timeout: %w", err)
+	}
+	if retry := flags.get("retry"); retry != "" {
+		if opts.Retries, err = strconv.Atoi(retry); err != nil || opts.Retries < 0 {
+			return opts, fmt.Errorf("invalid --retry %q, expected a number of attempts", retry)
+		}
+	}
+	opts.UTC = flags.bool("utc")
+	if opts.TimeFormat, err = parseTimeFormat(flags.get("time-format")); err != nil {
+		return opts, err
+	}
+	if opts.FileTimeFormat, err = parseTimeFormat(flags.get("file-time-format")); err != nil {
+		return opts, err
+	}
+	if strings.ContainsAny(opts.FileTimeFormat, `/\`) {
+		return opts, fmt.Errorf("invalid file time format %q, it must not contain path separators", opts.FileTimeFormat)
+	}
+	if name := flags.get("log-name"); name != "" {
+		if opts.LogName, err = recorder.ParseLogName(name); err != nil {
+			return opts, fmt.Errorf("invalid --log-name: %w", err)
+		}
+	}
+	switch keys := flags.get("detach-keys"); keys {
+	case "":
+		opts.DetachKeys = recorder.DefaultDetachKeys
+	case "none":
+	default:
+		if opts.DetachKeys, err = recorder.ParseDetachKeys(keys); err != nil {
+			return opts, err
+		}
+	}
+	if expr := setting("prompt-regex"); expr != "" {
+		if opts.PromptRegex, err = regexp.Compile(expr); err != nil {
+			return opts, fmt.Errorf("invalid KUBECTL_EXECREC_PROMPT_REGEX: %w", err)
+		}
+		opts.PromptMarkers = true
+	}
+	// the built-in secret rules apply unless redact-secrets is false
+	if v := setting("redact-secrets"); v == "" || isTrue(v) {
+		opts.Redact = append(opts.Redact, recorder.SecretRules...)
+	}
+	rules, err := config.redactRules(flags.get("redact-ruleset"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Redact = append(opts.Redact, rules...)
+	return opts, nil
+}
+
+// newSinks creates the sinks enabled through environment variables
+func newSinks() ([]recorder.Sink, error) {
+	var sinks []recorder.Sink
+	// add queues a sink, the sinks are closed if its queue settings are
+	// invalid
+	add := func(name string, s recorder.Sink) error {
+		q, err := queued(name, s)
+		if err != nil {
+			_ = s.Close()
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+			return err
+		}
+		sinks = append(sinks, q)
+		return nil
+	}
+	if url := setting("nats-url"); url != "" {
+		s, err := sink.NewNATS(url, setting("nats-subject"), setting("nats-creds"))
+		if err != nil {
+			return nil, err
+		}
+		if err := add("nats", s); err != nil {
+			return nil, err
+		}
+	}
+	if addr := setting("fluentd-addr"); addr != "" {
+		s, err := sink.NewFluentd(addr, setting("fluentd-tag"), setting("fluentd-shared-key"))
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		if err := add("fluentd", s); err != nil {
+			return nil, err
+		}
+	}
+	if addr := setting("grpc-url"); addr != "" {
+		s, err := newGRPCSink(addr)
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		if err := add("grpc", s); err != nil {
+			return nil, err
+		}
+	}
+	if addr := setting("live-stream-addr"); addr != "" {
+		s, err := sink.NewLiveStream(addr, setting("live-stream-token"))
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		// the clients have their own queue
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// liveStream returns the live stream of the session, nil if not served
+func liveStream(sinks []recorder.Sink) *sink.LiveStream {
+	for _, s := range sinks {
+		if live, ok := s.(*sink.LiveStream); ok {
+			return live
+		}
+	}
+	return nil
+}
+
+// newGRPCSink creates the gRPC sink of the collector at addr, with the TLS
+// settings of an HTTP based integration
+func newGRPCSink(addr string) (*sink.GRPC, error) {
+	tlsConfig, err := httpTLS("grpc").Config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC TLS settings: %w", err)
+	}
+	return sink.NewGRPC(addr, setting("grpc-token"), tlsConfig)
+}
+
+// queued configures the queue of a sink with the settings <name>-queue-size,
+// <name>-queue-memory and <name>-backpressure
+func queued(name string, s recorder.Sink) (recorder.Sink, error) {
+	q := recorder.QueuedSink{Sink: s}
+	if v := setting(name + "-queue-size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s-queue-size %q, expected a number of chunks", name, v)
+		}
+		q.QueueSize = n
+	}
+	size, err := parseSize(setting(name + "-queue-memory"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s-queue-memory: %w", name, err)
+	}
+	q.QueueBytes = int(size)
+	q.Drop = setting(name+"-backpressure") == "drop"
+	return q, nil
+}
+
+// newUploaders creates the uploaders enabled through environment variables
+func newUploaders() ([]upload.Uploader, error) {
+	var uploaders []upload.Uploader
+	if bucket := setting("s3-bucket"); bucket != "" {
+		s3, err := newS3Uploader(bucket)
+		if err != nil {
+			return nil, err
+		}
+		uploaders = append(uploaders, s3)
+	}
+	if host := setting("sftp-host"); host != "" {
+		uploaders = append(uploaders, &upload.SFTP{
+			Host: host,
+			User: setting("sftp-user"),
+			Port: setting("sftp-port"),
+			Key:  setting("sftp-key"),
+			Path: setting("sftp-path"),
+		})
+	}
+	if url := setting("webdav-url"); url != "" {
+		uploaders = append(uploaders, &upload.WebDAV{
+			URL:      url,
+			User:     setting("webdav-user"),
+			Password: setting("webdav-password"),
+			Token:    setting("webdav-token"),
+			Path:     setting("webdav-path"),
+			TLS:      httpTLS("webdav"),
+		})
+	}
+	if url := setting("http-url"); url != "" {
+		headers, err := upload.ParseHeaders(setting("http-headers"))
+		if err != nil {
+			return nil, err
+		}
+		uploaders = append(uploaders, &upload.HTTP{
+			URL:     url,
+			Method:  setting("http-method"),
+			Token:   setting("http-token"),
+			Headers: headers,
+			TLS:     httpTLS("http"),
+		})
+	}
+	return uploaders, nil
+}
+
+// newS3Uploader creates the S3 uploader of a bucket with the s3-* settings
+func newS3Uploader(bucket string) (*upload.S3, error) {
+	routes, err := upload.ParseS3Routes(setting("s3-routes"))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := upload.ParseTags(setting("s3-tags"))
+	if err != nil {
+		return nil, err
+	}
+	// S3-compatible storages such as MinIO are addressed by path unless
+	// disabled
+	pathStyle := setting("s3-endpoint") != ""
+	if v := setting("s3-path-style"); v != "" {
+		pathStyle = isTrue(v)
+	}
+	storageClass, err := upload.ParseStorageClass(setting("s3-storage-class"))
+	if err != nil {
+		return nil, err
+	}
+	lockMode := strings.ToUpper(setting("s3-object-lock-mode"))
+	var retention time.Duration
+	var until time.Time
+	switch lockMode {
+	case "":
+	case "GOVERNANCE", "COMPLIANCE":
+		if retention, until, err = upload.ParseRetention(setting("s3-object-lock-retention")); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid S3 Object Lock mode %q, expected governance or compliance", lockMode)
+	}
+	return &upload.S3{
+		Bucket:             bucket,
+		Endpoint:           setting("s3-endpoint"),
+		PathStyle:          pathStyle,
+		CABundle:           setting("s3-ca-bundle"),
+		InsecureSkipVerify: isTrue(setting("s3-insecure-skip-verify")),
+		Path:               setting("s3-path"),
+		Routes:             routes,
+
+		StorageClass: storageClass,
+
+		Profile:    setting("s3-profile"),
+		Region:     setting("s3-region"),
+		RoleARN:    setting("s3-role-arn"),
+		ExternalID: setting("s3-external-id"),
+
+		Tags:    tags,
+		Tagging: isTrue(setting("s3-tagging")),
+
+		LockMode:      lockMode,
+		LockRetention: retention,
+		LockUntil:     until,
+
+		ContentAddressed: isTrue(setting("s3-content-addressed")),
+	}, nil
+}
+
+// httpTLS returns the TLS settings of an HTTP based integration:
+// <name>-ca-bundle, <name>-client-cert, <name>-client-key and
+// <name>-insecure-skip-verify
+func httpTLS(name string) upload.TLS {
+	return upload.TLS{
+		CABundle:           setting(name + "-ca-bundle"),
+		ClientCert:         setting(name + "-client-cert"),
+		ClientKey:          setting(name + "-client-key"),
+		InsecureSkipVerify: isTrue(setting(name + "-insecure-skip-verify")),
+	}
+}
+
+// checkLockdown rejects the sessions bypassing the audit pipeline when
+// lockdown is set in the config file, e.g. by the provisioning of a bastion:
+// the session must be recorded and sent to a sink or uploaded
+func checkLockdown(opts recorder.Options, sinks []recorder.Sink, uploaders []upload.Uploader) error {
+	if !config.locked {
+		return nil
+	}
+	if opts.NoRecord != "" {
+		return fmt.Errorf("--no-record is not allowed in lockdown mode")
+	}
+	// the live stream is not kept anywhere
+	delivered := len(sinks)
+	if liveStream(sinks) != nil {
+		delivered--
+	}
+	if delivered == 0 && len(uploaders) == 0 {
+		return fmt.Errorf("lockdown mode requires a sink or an upload, the session would only be kept locally")
+	}
+	return nil
+}
+
+// checkSessionLockdown runs checkLockdown with the sinks and uploaders of a
+// session, the sinks are closed as every session connects its own
+func checkSessionLockdown(o *options, opts recorder.Options) error {
+	sinks, err := o.sinks()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range sinks {
+			_ = s.Close()
+		}
+	}()
+	uploaders, err := o.uploaders()
+	if err != nil {
+		return err
+	}
+	return checkLockdown(opts, sinks, uploaders)
+}
+
+// checkLockdownFlags rejects the flags dropping output from the session in
+// lockdown mode, the values of the config file still apply
+func checkLockdownFlags(flags flagValues, opts recorder.Options) error {
+	if !config.locked {
+		return nil
+	}
+	for _, name := range []string{"max-output-rate", "redact-ruleset"} {
+		if _, ok := flags[name]; ok {
+			return fmt.Errorf("--%s is not allowed in lockdown mode", name)
+		}
+	}
+	_, size := flags["max-log-size"]
+	_, policy := flags["max-log-size-policy"]
+	if (size || policy) && opts.MaxLogSize > 0 && opts.MaxLogSizePolicy == recorder.SizePolicyStop {
+		return fmt.Errorf("--max-log-size with the stop policy is not allowed in lockdown mode")
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// boolSettings are the settings that are true or false, besides the boolean
+// flags and the *-insecure-skip-verify settings
+var boolSettings = []string{
+	"plain-text", "record-input", "command-summary", "prompt-markers", "detect-binary", "redact-secrets",
+	"require-impersonation-reason", "pod-snapshot", "pod-metadata", "lockdown",
+	"s3-path-style", "s3-tagging", "s3-content-addressed",
+}
+
+// pathSettings are the settings that are remote path templates
+var pathSettings = []string{"s3-path", "sftp-path", "webdav-path"}
+
+func newConfigCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Validate or create the config file",
+		Args:  cobra.NoArgs,
+		// the config file is read by the subcommands, it may not be valid
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	cmd.AddCommand(newConfigValidateCmd(streams, o))
+	cmd.AddCommand(newConfigInitCmd(streams))
+	return cmd
+}
+
+// configFilePath returns the config file of the config subcommands: path,
+// or else the file of KUBECTL_EXECREC_CONFIG or the default one
+func configFilePath(path string) (string, error) {
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return "", fmt.Errorf("no user config directory, give the path of the config file")
+	}
+	return path, nil
+}
+
+func newConfigValidateCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var offline bool
+	var color *colorFlags
+	cmd := &cobra.Command{
+		Use:   "validate [FILE]",
+		Short: "Validate a config file",
+		Long: `Validate a config file, the one of KUBECTL_EXECREC_CONFIG or the default one if FILE is not given: its syntax and keys, the values of the settings outside of the profiles and of every profile, the path templates, the secret references and the sinks, which are connected to unless --offline is given.
+
+The environment variables take precedence over the config file as for a session.
+
+Examples:
+  kubectl execrec config validate
+  kubectl execrec config validate ./config --offline`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath(strings.Join(args, ""))
+			if err != nil {
+				return err
+			}
+			// the colors are chosen before the config file is read
+			c, err := color.colors(streams.Out)
+			if err != nil {
+				return err
+			}
+			checks := validateConfig(o, path, offline)
+			failed, err := printChecks(streams.Out, c, checks)
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			fmt.Fprintf(streams.Out, "%s is valid\n", path)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&offline, "offline", false, "Do not connect to the sinks")
+	color = addColorFlags(cmd)
+	return cmd
+}
+
+// validateConfig validates a config file, the settings outside of the
+// profiles and the ones of every profile are checked on their own
+func validateConfig(o *options, path string, offline bool) []selfCheck {
+	c, err := readConfig(path)
+	if err != nil {
+		return []selfCheck{{name: "syntax", err: err}}
+	}
+	checks := []selfCheck{{name: "syntax", detail: path}}
+
+	saved := config
+	defer func() { config = saved }()
+	config = c
+	// the errors of the settings inherited by the profiles are reported once
+	var reported []string
+	scopes := append([]*profile{nil}, c.profiles...)
+	for _, p := range scopes {
+		scope, detail, values := "settings", "outside of the profiles", c.values
+		if p != nil {
+			scope, detail, values = "profile "+p.name, "contexts "+strings.Join(p.contexts, ", "), p.values
+		}
+		config.active = p
+		errs := validateSettings(o, values)
+		if len(errs) == 0 {
+			checks = append(checks, selfCheck{name: scope, detail: detail})
+		}
+		for _, err := range errs {
+			if !slices.Contains(reported, err.Error()) {
+				reported = append(reported, err.Error())
+				checks = append(checks, selfCheck{name: scope, err: err})
+			}
+		}
+
+		if offline {
+			continue
+		}
+		sinks, err := o.sinks()
+		var names []string
+		for _, s := range sinks {
+			names = append(names, describeSink(s))
+			_ = s.Close()
+		}
+		if err == nil && len(sinks) == 0 {
+			err = fmt.Errorf("%w: no sink configured", errSkipped)
+		}
+		checks = append(checks, selfCheck{name: scope + " sinks", detail: strings.Join(names, ", "), err: err})
+	}
+	return checks
+}
+
+// validateSettings validates the settings of a scope of the config file,
+// values, with the ones it inherits, config.active being the scope
+func validateSettings(o *options, values map[string]string) []error {
+	var errs []error
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := validateSetting(key, values[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := config.resolveSecrets(); err != nil {
+		return append(errs, err)
+	}
+	opts, err := recorderOptions(flagValues{})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	ev := recorder.Event{Context: "context", Cluster: "cluster", Namespace: "namespace", User: "user", SessionID: "id", LogFile: "session.log"}
+	for _, key := range pathSettings {
+		if _, err := upload.RenderPath(setting(key), ev); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", key, err))
+		}
+	}
+	uploaders, err := o.uploaders()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// the sinks are connected to separately, lockdown only needs one
+	if setting("nats-url") == "" && setting("fluentd-addr") == "" && setting("grpc-url") == "" {
+		if err := checkLockdown(opts, nil, uploaders); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateSetting validates the value of a setting whose format is not
+// checked when it is used
+func validateSetting(key, value string) error {
+	if strings.HasPrefix(value, secretPrefix) {
+		return nil
+	}
+	f, isFlag := lookupFlag(key)
+	switch {
+	case isFlag && f.isBool, slices.Contains(boolSettings, key), strings.HasSuffix(key, "-insecure-skip-verify"):
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid %s %q, expected true or false", key, value)
+		}
+	case strings.HasSuffix(key, "-queue-size"):
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q, expected a number of chunks", key, value)
+		}
+	case strings.HasSuffix(key, "-queue-memory"):
+		if _, err := parseSize(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case key == "color":
+		if !slices.Contains(colorModes, value) {
+			return fmt.Errorf("invalid %s %q, expected auto, always or never", key, value)
+		}
+	case key == "color-theme":
+		if _, ok := colorThemes[value]; !ok {
+			return fmt.Errorf("invalid %s %q, expected dark, light or mono", key, value)
+		}
+	case strings.HasSuffix(key, "-backpressure"):
+		if value != "block" && value != "drop" {
+			return fmt.Errorf("invalid %s %q, expected block or drop", key, value)
+		}
+	}
+	return nil
+}
+
+// configBackend is a backend offered by config init, its settings are
+// asked for with their defaults, the first one enables the backend
+type configBackend struct {
+	name     string
+	settings [][2]string
+}
+
+var configBackends = []configBackend{
+	{"s3", [][2]string{{"s3-bucket", ""}, {"s3-region", ""}, {"s3-endpoint", ""}, {"s3-path", upload.DefaultPath}}},
+	{"sftp", [][2]string{{"sftp-host", ""}, {"sftp-user", ""}, {"sftp-key", ""}, {"sftp-path", upload.DefaultPath}}},
+	{"webdav", [][2]string{{"webdav-url", ""}, {"webdav-user", ""}, {"webdav-password", ""}, {"webdav-path", upload.DefaultPath}}},
+	{"http", [][2]string{{"http-url", ""}, {"http-token", ""}}},
+	{"nats", [][2]string{{"nats-url", "nats://localhost:4222"}, {"nats-subject", ""}, {"nats-creds", ""}}},
+	{"fluentd", [][2]string{{"fluentd-addr", "localhost:24224"}, {"fluentd-tag", ""}, {"fluentd-shared-key", ""}}},
+	{"grpc", [][2]string{{"grpc-url", ""}, {"grpc-token", ""}}},
+	{"none", nil},
+}
+
+func newConfigInitCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var backend string
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "init [FILE]",
+		Short: "Create a config file interactively",
+		Long: `Create a config file, the one of KUBECTL_EXECREC_CONFIG or the default one if FILE is not given, for a backend: s3, sftp, webdav, http, nats, fluentd, grpc or none to keep the log files locally. The settings of the backend are asked for, an empty answer keeps the default shown in brackets or leaves the setting out. Secrets can be answered with a reference such as vault:secret/data/execrec#token.
+
+The file also enables the redaction of secrets, gzip compression, a 1G log size limit and a 100M free space margin.
+
+Examples:
+  kubectl execrec config init
+  kubectl execrec config init --backend s3 ./config`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath(strings.Join(args, ""))
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err == nil && !force {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+			} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			content, err := configWizard(bufio.NewReader(streams.In), streams.Out, backend)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			// the file may hold secrets
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				return err
+			}
+			fmt.Fprintf(streams.Out, "Config file written to %s, check it with kubectl execrec config validate\n", path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&backend, "backend", "", "Backend of the sessions, asked for if not given")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite an existing config file")
+	return cmd
+}
+
+// configWizard asks for the backend and its settings and returns the content
+// of the config file
+func configWizard(in *bufio.Reader, out io.Writer, backend string) (string, error) {
+	var names []string
+	for _, b := range configBackends {
+		names = append(names, b.name)
+	}
+	ask := func(question, def string) (string, error) {
+		if def != "" {
+			fmt.Fprintf(out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(out, "%s: ", question)
+		}
+		// the defaults are kept once the input ends, e.g. with --backend
+		// and no terminal
+		line, err := in.ReadString('\n')
+		if err == io.EOF {
+			fmt.Fprintln(out)
+		} else if err != nil {
+			return "", err
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			return def, nil
+		}
+		return line, nil
+	}
+
+	var err error
+	if backend == "" {
+		if backend, err = ask("Backend ("+strings.Join(names, ", ")+")", "s3"); err != nil {
+			return "", err
+		}
+	}
+	i := slices.IndexFunc(configBackends, func(b configBackend) bool { return b.name == backend })
+	if i < 0 {
+		return "", fmt.Errorf("invalid backend %q, expected %s", backend, strings.Join(names, ", "))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# kubectl execrec config, backend %s\n", backend)
+	b.WriteString("# see kubectl execrec config validate\n\n")
+	b.WriteString("redact-secrets = true\n")
+	b.WriteString("compress = gzip\n")
+	b.WriteString("max-log-size = 1G\n")
+	b.WriteString("min-free-space = 100M\n")
+	if backend != "none" {
+		b.WriteString("\n")
+	}
+	for _, s := range configBackends[i].settings {
+		key, def := s[0], s[1]
+		value, err := ask(key, def)
+		if err != nil {
+			return "", err
+		}
+		if value == "" && key == configBackends[i].settings[0][0] {
+			return "", fmt.Errorf("%s is required for the %s backend", key, backend)
+		}
+		if value == "" || (value == def && strings.HasSuffix(key, "-path")) {
+			continue
+		}
+		if err := validateSetting(key, value); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, value)
+	}
+	return b.String(), nil
+}
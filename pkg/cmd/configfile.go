@@ -0,0 +1,418 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+// settingKeys are the settings of kubectl execrec that are not flags. Every
+// setting and flag named key is bound to the environment variable
+// KUBECTL_EXECREC_<KEY> and to the key of the config file.
+var settingKeys = []string{
+	"plain-text", "record-input", "command-summary", "prompt-markers", "prompt-regex", "detect-binary",
+	"redact-secrets", "require-impersonation-reason", "signals", "idle-warning", "heartbeat-interval",
+	"pod-snapshot", "pod-metadata", "pod-metadata-labels", "pod-metadata-annotations",
+	"compress", "compression-level", "lockdown", "pre-session-hook", "post-session-hook",
+	"vault-transit-key", "vault-transit-mount",
+	"s3-bucket", "s3-endpoint", "s3-path", "s3-routes", "s3-storage-class",
+	"s3-path-style", "s3-ca-bundle", "s3-insecure-skip-verify",
+	"s3-profile", "s3-region", "s3-role-arn", "s3-external-id", "s3-tags", "s3-tagging",
+	"s3-object-lock-mode", "s3-object-lock-retention", "s3-content-addressed",
+	"sftp-host", "sftp-user", "sftp-port", "sftp-key", "sftp-path",
+	"webdav-url", "webdav-user", "webdav-password", "webdav-token", "webdav-path",
+	"webdav-ca-bundle", "webdav-client-cert", "webdav-client-key", "webdav-insecure-skip-verify",
+	"http-url", "http-method", "http-token", "http-headers",
+	"http-ca-bundle", "http-client-cert", "http-client-key", "http-insecure-skip-verify",
+	"asciinema-url", "asciinema-token",
+	"asciinema-ca-bundle", "asciinema-client-cert", "asciinema-client-key", "asciinema-insecure-skip-verify",
+	"nats-url", "nats-subject", "nats-creds", "nats-queue-size", "nats-queue-memory", "nats-backpressure",
+	"fluentd-addr", "fluentd-tag", "fluentd-shared-key", "fluentd-queue-size", "fluentd-queue-memory", "fluentd-backpressure",
+	"grpc-url", "grpc-token", "grpc-queue-size", "grpc-queue-memory", "grpc-backpressure",
+	"grpc-ca-bundle", "grpc-client-cert", "grpc-client-key", "grpc-insecure-skip-verify",
+	"live-stream-addr", "live-stream-token",
+	"audit-journal", "bundle-signing-key", "color", "color-theme", "pager",
+}
+
+// envName returns the environment variable of a setting or flag
+func envName(key string) string {
+	return "KUBECTL_EXECREC_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// knownSetting tells if key is a setting or a flag that can be set in the
+// config file
+func knownSetting(key string) bool {
+	for _, k := range settingKeys {
+		if k == key {
+			return true
+		}
+	}
+	// the config file cannot point to another config file
+	_, ok := lookupFlag(key)
+	return ok && key != "config"
+}
+
+// defaultConfigPath returns the path of the config file read when neither
+// --config nor KUBECTL_EXECREC_CONFIG is given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kubectl-execrec", "config")
+}
+
+// config is the config file loaded by loadConfig
+var config configFile
+
+// configFile holds the settings of the config file and its profiles
+type configFile struct {
+	path     string
+	values   map[string]string
+	profiles []*profile
+	// active is the profile of the kube-context of the session
+	active *profile
+	// rulesets are the named sets of redaction rules, activated with
+	// redact-ruleset
+	rulesets []*ruleset
+	// secrets are the values of the secret references resolved, by
+	// reference
+	secrets map[string]string
+	// locked is set by lockdown in the system config file, the
+	// environment variables are then ignored
+	locked bool
+}
+
+// profile is a named set of settings applied to the kube-contexts matching
+// one of its glob patterns, e.g. "prod-*"
+type profile struct {
+	name     string
+	contexts []string
+	values   map[string]string
+}
+
+// ruleset is a named set of redaction rules, e.g. "pci"
+type ruleset struct {
+	name  string
+	rules []recorder.RedactRule
+}
+
+// rule returns the rule named name, added to the set if it is new
+func (s *ruleset) rule(name string) *recorder.RedactRule {
+	for i := range s.rules {
+		if s.rules[i].Name == name {
+			return &s.rules[i]
+		}
+	}
+	s.rules = append(s.rules, recorder.RedactRule{Set: s.name, Name: name, Replacement: defaultReplacement, Input: true, Output: true})
+	return &s.rules[len(s.rules)-1]
+}
+
+// builtinRulesets are the rule sets that are not defined in the config file
+var builtinRulesets = map[string][]recorder.RedactRule{
+	"pii": recorder.PIIRules,
+}
+
+// defaultReplacement replaces the matches of the redaction rules without a
+// replacement
+const defaultReplacement = "[REDACTED]"
+
+// redactRules returns the rules of the rule sets named in a comma-separated
+// list
+func (c *configFile) redactRules(names string) ([]recorder.RedactRule, error) {
+	var rules []recorder.RedactRule
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if builtin, ok := builtinRulesets[name]; ok {
+			rules = append(rules, builtin...)
+			continue
+		}
+		i := slices.IndexFunc(c.rulesets, func(s *ruleset) bool { return s.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown redaction rule set %q", name)
+		}
+		rules = append(rules, c.rulesets[i].rules...)
+	}
+	return rules, nil
+}
+
+// useContext activates the first profile matching a kube-context and returns
+// its name, or deactivates the profile if none matches. The secrets of the
+// settings are resolved.
+func (c *configFile) useContext(context string) (string, error) {
+	c.active = nil
+	for _, p := range c.profiles {
+		for _, pattern := range p.contexts {
+			if upload.MatchGlob(pattern, context) {
+				c.active = p
+				return p.name, c.resolveSecrets()
+			}
+		}
+	}
+	return "", c.resolveSecrets()
+}
+
+// secretPrefix starts the values referring to a secret in Vault, e.g.
+// "vault:secret/data/execrec#webdav_password"
+const secretPrefix = "vault:"
+
+// resolveSecrets reads the secrets referred to by the settings from Vault
+func (c *configFile) resolveSecrets() error {
+	var client *vault.Client
+	keys := slices.Clone(settingKeys)
+	for _, f := range execrecFlags {
+		keys = append(keys, f.name)
+	}
+	for _, key := range keys {
+		ref := c.get(key)
+		if !strings.HasPrefix(ref, secretPrefix) {
+			continue
+		}
+		if _, ok := c.secrets[ref]; ok {
+			continue
+		}
+		path, field, ok := strings.Cut(strings.TrimPrefix(ref, secretPrefix), "#")
+		if !ok || path == "" || field == "" {
+			return fmt.Errorf("invalid secret reference %q for %s, expected vault:PATH#FIELD", ref, key)
+		}
+		if client == nil {
+			var err error
+			if client, err = vault.NewClient(); err != nil {
+				return fmt.Errorf("failed to read the secret of %s: %w", key, err)
+			}
+		}
+		secret, err := client.Secret(path, field)
+		if err != nil {
+			return fmt.Errorf("failed to read the secret of %s: %w", key, err)
+		}
+		if c.secrets == nil {
+			c.secrets = map[string]string{}
+		}
+		c.secrets[ref] = secret
+	}
+	return nil
+}
+
+// get returns the value of a setting or flag: its environment variable
+// takes precedence over the active profile, which takes precedence over the
+// rest of the config file. The environment is ignored in lockdown mode.
+func (c *configFile) get(key string) string {
+	if v, ok := os.LookupEnv(envName(key)); ok && !c.locked {
+		return v
+	}
+	v, _ := c.lookup(key)
+	return v
+}
+
+// lookup returns the value of a setting in the active profile, or else
+// outside of the profiles
+func (c *configFile) lookup(key string) (string, bool) {
+	if c.active != nil {
+		if v, ok := c.active.values[key]; ok {
+			return v, true
+		}
+	}
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// readConfig reads a config file of "key = value" lines, blank lines and
+// lines starting with # are ignored and values can be quoted. A
+// "[profile NAME]" line starts a profile, its "contexts" key lists the
+// kube-context patterns it applies to, separated by commas. A
+// "[ruleset NAME]" line starts a set of redaction rules, see
+// readRulesetLine.
+func readConfig(path string) (configFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return configFile{}, err
+	}
+	defer f.Close()
+
+	config := configFile{path: path, values: map[string]string{}}
+	// current is the profile being read, nil before the first one, and
+	// rules the rule set being read instead
+	var current *profile
+	var rules *ruleset
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			kind, name, _ := strings.Cut(strings.TrimSpace(line[1:len(line)-1]), " ")
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "" || (kind != "profile" && kind != "ruleset"):
+				return configFile{}, fmt.Errorf("%s:%d: expected [profile NAME] or [ruleset NAME]", path, n)
+			case kind == "ruleset":
+				if slices.ContainsFunc(config.rulesets, func(s *ruleset) bool { return s.name == name }) {
+					return configFile{}, fmt.Errorf("%s:%d: duplicate rule set %s", path, n, name)
+				}
+				if _, ok := builtinRulesets[name]; ok {
+					return configFile{}, fmt.Errorf("%s:%d: the rule set %s is built in", path, n, name)
+				}
+				current, rules = nil, &ruleset{name: name}
+				config.rulesets = append(config.rulesets, rules)
+			default:
+				current, rules = &profile{name: name, values: map[string]string{}}, nil
+				config.profiles = append(config.profiles, current)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return configFile{}, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return configFile{}, fmt.Errorf("%s:%d: invalid quoted value for %s", path, n, key)
+			}
+		}
+		if rules != nil {
+			if err := readRulesetLine(rules, key, value); err != nil {
+				return configFile{}, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			continue
+		}
+		if current != nil && key == "contexts" {
+			for _, pattern := range strings.Split(value, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern != "" {
+					current.contexts = append(current.contexts, pattern)
+				}
+			}
+			continue
+		}
+		if !knownSetting(key) {
+			return configFile{}, fmt.Errorf("%s:%d: unknown key %q", path, n, key)
+		}
+		if current != nil {
+			if key == "lockdown" {
+				return configFile{}, fmt.Errorf("%s:%d: lockdown cannot be set in a profile", path, n)
+			}
+			current.values[key] = value
+		} else {
+			config.values[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return configFile{}, err
+	}
+	for _, p := range config.profiles {
+		if len(p.contexts) == 0 {
+			return configFile{}, fmt.Errorf("%s: the profile %s has no contexts", path, p.name)
+		}
+	}
+	for _, s := range config.rulesets {
+		for _, rule := range s.rules {
+			if rule.Pattern == nil {
+				return configFile{}, fmt.Errorf("%s: the rule %s of the rule set %s has no pattern", path, rule.Name, s.name)
+			}
+		}
+	}
+	config.locked = isTrue(config.values["lockdown"])
+	return config, nil
+}
+
+// readRulesetLine reads a line of a rule set: "NAME = REGEX" is the pattern
+// of the rule NAME, "NAME.replacement" its replacement, [REDACTED] by
+// default, and "NAME.applies-to" the streams it applies to, input, output
+// or both by default
+func readRulesetLine(s *ruleset, key, value string) error {
+	name, attr, _ := strings.Cut(key, ".")
+	if name == "" {
+		return fmt.Errorf("expected RULE = REGEX")
+	}
+	rule := s.rule(name)
+	switch attr {
+	case "":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for %s: %w", key, err)
+		}
+		rule.Pattern = re
+	case "replacement":
+		rule.Replacement = value
+	case "applies-to":
+		rule.Input, rule.Output = false, false
+		for _, stream := range strings.Split(value, ",") {
+			switch strings.TrimSpace(stream) {
+			case "input":
+				rule.Input = true
+			case "output":
+				rule.Output = true
+			default:
+				return fmt.Errorf("invalid %s %q, expected input, output or both separated by a comma", key, value)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown key %q, expected RULE, RULE.replacement or RULE.applies-to", key)
+	}
+	return nil
+}
+
+// loadConfig reads the config file, path is the value of --config. The
+// default config file may not exist. Lockdown mode is only turned on by the
+// system config file, which is then the only config file read.
+func loadConfig(path string) error {
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	system, err := readConfig(systemConfigPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read the config file: %w", err)
+	}
+	if system.locked {
+		if path != "" {
+			return fmt.Errorf("--config and %s are not allowed in lockdown mode", envName("config"))
+		}
+		config = system
+		return nil
+	}
+
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return nil
+	}
+	c, err := readConfig(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the config file: %w", err)
+	}
+	// the files of the user cannot turn lockdown mode on
+	c.locked = false
+	config = c
+	return nil
+}
+
+// setting returns the value of a setting or flag, see configFile.get, with
+// the secret it refers to
+func setting(key string) string {
+	v := config.get(key)
+	if strings.HasPrefix(v, secretPrefix) {
+		return config.secrets[v]
+	}
+	return v
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file in a temporary directory and returns its
+// path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetEnv unsets an environment variable until the end of a test
+func unsetEnv(t *testing.T, key string) {
+	t.Helper()
+	// t.Setenv restores the variable after the test
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		values  map[string]string
+		locked  bool
+		err     string
+	}{
+		{
+			name:    "settings",
+			content: "# comment\n\ns3-bucket = audit\nreason = \"INC-1 \\\"db\\\"\"\n",
+			values:  map[string]string{"s3-bucket": "audit", "reason": `INC-1 "db"`},
+		},
+		{
+			name:    "lockdown",
+			content: "lockdown = true\n",
+			values:  map[string]string{"lockdown": "true"},
+			locked:  true,
+		},
+		{name: "unknown key", content: "s3-buckit = audit\n", err: `:1: unknown key "s3-buckit"`},
+		{name: "no value", content: "s3-bucket\n", err: ":1: expected key = value"},
+		{name: "invalid quoted value", content: "reason = \"INC-1\n", err: ":1: invalid quoted value for reason"},
+		{name: "invalid section", content: "[context prod]\n", err: ":1: expected [profile NAME] or [ruleset NAME]"},
+		{name: "profile without contexts", content: "[profile prod]\ns3-bucket = audit\n", err: "the profile prod has no contexts"},
+		{name: "lockdown in a profile", content: "[profile prod]\ncontexts = prod-*\nlockdown = true\n", err: ":3: lockdown cannot be set in a profile"},
+		{name: "duplicate rule set", content: "[ruleset pci]\npan = [0-9]{16}\n[ruleset pci]\n", err: ":3: duplicate rule set pci"},
+		{name: "rule without pattern", content: "[ruleset pci]\npan.replacement = [PAN]\n", err: "the rule pan of the rule set pci has no pattern"},
+		{name: "invalid rule pattern", content: "[ruleset pci]\npan = [0-9\n", err: ":2: invalid pattern for pan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := readConfig(writeConfig(t, tt.content))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("readConfig error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readConfig: %v", err)
+			}
+			if len(c.values) != len(tt.values) {
+				t.Errorf("values = %v, want %v", c.values, tt.values)
+			}
+			for k, v := range tt.values {
+				if c.values[k] != v {
+					t.Errorf("%s = %q, want %q", k, c.values[k], v)
+				}
+			}
+			if c.locked != tt.locked {
+				t.Errorf("locked = %v, want %v", c.locked, tt.locked)
+			}
+		})
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	c, err := readConfig(writeConfig(t, `s3-bucket = audit
+reason = none
+
+[profile prod]
+contexts = prod-*, arn:aws:eks:*:cluster/prod
+s3-bucket = prod-audit
+
+[profile dev]
+contexts = dev-*
+reason = testing
+`))
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+	unsetEnv(t, envName("s3-bucket"))
+	unsetEnv(t, envName("reason"))
+
+	tests := []struct {
+		context string
+		profile string
+		bucket  string
+		reason  string
+	}{
+		{context: "prod-eu", profile: "prod", bucket: "prod-audit", reason: "none"},
+		{context: "arn:aws:eks:eu-west-1:cluster/prod", profile: "prod", bucket: "prod-audit", reason: "none"},
+		{context: "dev-1", profile: "dev", bucket: "audit", reason: "testing"},
+		{context: "staging", profile: "", bucket: "audit", reason: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			profile, err := c.useContext(tt.context)
+			if err != nil {
+				t.Fatalf("useContext: %v", err)
+			}
+			if profile != tt.profile {
+				t.Errorf("profile = %q, want %q", profile, tt.profile)
+			}
+			if got := c.get("s3-bucket"); got != tt.bucket {
+				t.Errorf("s3-bucket = %q, want %q", got, tt.bucket)
+			}
+			if got := c.get("reason"); got != tt.reason {
+				t.Errorf("reason = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestConfigEnvironment(t *testing.T) {
+	c, err := readConfig(writeConfig(t, "s3-bucket = audit\n"))
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+	t.Setenv(envName("s3-bucket"), "mine")
+	// the environment takes precedence over the config file, except in
+	// lockdown mode
+	if got := c.get("s3-bucket"); got != "mine" {
+		t.Errorf("s3-bucket = %q, want mine", got)
+	}
+	c.locked = true
+	if got := c.get("s3-bucket"); got != "audit" {
+		t.Errorf("s3-bucket in lockdown mode = %q, want audit", got)
+	}
+}
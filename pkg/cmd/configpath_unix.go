@@ -0,0 +1,7 @@
+//go:build !windows
+
+package cmd
+
+// systemConfigPath is the config file provisioned by the administrator of a
+// bastion, only root can write it and no environment variable moves it
+var systemConfigPath = "/etc/kubectl-execrec/config"
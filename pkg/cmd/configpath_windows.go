@@ -0,0 +1,6 @@
+package cmd
+
+// systemConfigPath is the config file provisioned by the administrator of a
+// bastion, only administrators can write it and no environment variable
+// moves it
+var systemConfigPath = `C:\ProgramData\kubectl-execrec\config`
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// console prints the messages of kubectl execrec around the session
+type console struct {
+	out    io.Writer
+	errOut io.Writer
+	// quiet suppresses the informational messages such as the log location
+	quiet bool
+	// log logs the internal steps, see newLogger, nothing is logged if nil
+	log *slog.Logger
+	// closeLog closes the log file of --log-file
+	closeLog func() error
+}
+
+// newConsole creates a console from the --quiet, --verbose, --log-level and
+// --log-file flags
+func newConsole(out, errOut io.Writer, flags flagValues) (*console, error) {
+	c := &console{out: out, errOut: errOut, quiet: flags.bool("quiet")}
+	var err error
+	if c.log, c.closeLog, err = newLogger(errOut, flags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// infof prints an informational message unless quiet
+func (c *console) infof(format string, args ...any) {
+	if !c.quiet {
+		fmt.Fprintf(c.out, format, args...)
+	}
+}
+
+// logger returns the logger of a component, see logComponents
+func (c *console) logger(component string) *slog.Logger {
+	if c.log == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.log.With("component", component)
+}
+
+// close closes the log file
+func (c *console) close() error {
+	if c.closeLog == nil {
+		return nil
+	}
+	return c.closeLog()
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/playback"
+)
+
+func newConvertCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var to, output, context string
+	cmd := &cobra.Command{
+		Use:   "convert SOURCE",
+		Short: "Convert a log file to another version of the log file format",
+		Long: `Convert a log file to another version of the log file format, e.g. to migrate an archive recorded by an older kubectl execrec, or for a tool reading an older format. The source is a local log file, an s3://bucket/key object or an http(s) URL, read like the source of replay, and the log file is written to stdout or to the file of -o, uncompressed and unencrypted.
+
+The format version is the format field of the log file header, the log files without it are of version 1. A log file of a newer format than the ones below is not read, kubectl execrec needs an upgrade.
+
+Versions:
+  1  log files written before the format version was recorded
+  2  the format field is in the header
+
+Examples:
+  kubectl execrec convert old.log --to latest -o new.log
+  kubectl execrec convert session.log --to 1 > session.v1.log
+  kubectl execrec convert s3://my-bucket/kubectl-execrec/prod/session.log.gz --context prod -o session.log`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := playback.ParseFormat(to)
+			if err != nil {
+				return err
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			rc, err := openRecording(args[0])
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			if output == "" {
+				return playback.Convert(streams.Out, rc, version)
+			}
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			if err := playback.Convert(f, rc, version); err != nil {
+				f.Close()
+				_ = os.Remove(output)
+				return err
+			}
+			return f.Close()
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "latest", "Format version to convert to, 1 to the current one or latest")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to a file instead of stdout")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
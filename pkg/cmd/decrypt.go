@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+func newDecryptCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "decrypt FILE",
+		Short: "Decrypt a log file encrypted with Vault",
+		Long: `Decrypt a log file encrypted with the Vault transit engine and print it. The data key of the file is decrypted by Vault, so the Vault token must be allowed to decrypt with the transit key, and the request is recorded in the Vault audit log.
+
+Vault is configured with VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE, VAULT_CACERT and VAULT_SKIP_VERIFY like the vault cli.
+
+Examples:
+  kubectl execrec decrypt alice_20250810T143332+0900_01K2B3QZ7YHX4N6R8TVA2C5DEF.log.vault
+  kubectl execrec decrypt session.log.vault -o session.log`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := vault.NewClient()
+			if err != nil {
+				return err
+			}
+			in, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+
+			if output == "" {
+				return vault.Decrypt(client, streams.Out, in)
+			}
+			out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			if err := vault.Decrypt(client, out, in); err != nil {
+				out.Close()
+				_ = os.Remove(output)
+				return err
+			}
+			return out.Close()
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the decrypted log to a file instead of stdout")
+	return cmd
+}
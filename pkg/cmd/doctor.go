@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+func newDoctorCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var namespace, context string
+	var color *colorFlags
+	cmd := &cobra.Command{
+		Use:   "doctor [POD]",
+		Short: "Diagnose the environment of the recorded sessions",
+		Long: `Check what kubectl execrec needs to record a session and print how to fix what is missing: kubectl and its version, the connection to the cluster, the permission to exec into pods, or into POD if given, the log directory and the credentials of the sinks and uploads.
+
+Nothing is recorded or uploaded, see selftest to record a test session.
+
+Examples:
+  kubectl execrec doctor
+  kubectl execrec doctor my-pod -n prod --context prod`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			c, err := color.colors(streams.Out)
+			if err != nil {
+				return err
+			}
+			pod := ""
+			if len(args) == 1 {
+				pod = strings.TrimPrefix(args[0], "pod/")
+			}
+			checks := doctor(o, context, namespace, pod)
+			failed, err := printChecks(streams.Out, c, checks)
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			fmt.Fprintf(streams.Out, "All %d checks passed, run kubectl execrec selftest to record a test session\n", len(checks))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the pod, the one of the context by default")
+	cmd.Flags().StringVar(&context, "context", "", "Kube-context to check, the current one by default")
+	color = addColorFlags(cmd)
+	return cmd
+}
+
+// doctor checks the environment of the sessions, a failed check skips the
+// checks depending on it
+func doctor(o *options, context, namespace, pod string) []selfCheck {
+	var checks []selfCheck
+	add := func(name, detail string, err error, fix string) bool {
+		checks = append(checks, selfCheck{name: name, detail: detail, err: err, fix: fix})
+		return err == nil
+	}
+	kubectl := func(args ...string) (string, error) {
+		if context != "" {
+			args = append([]string{"--context", context}, args...)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd := o.command("kubectl", args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if stderr.Len() > 0 {
+				return stdout.String(), fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+			}
+			return stdout.String(), err
+		}
+		return stdout.String(), nil
+	}
+
+	// kubectl and the cluster
+	var version struct {
+		ClientVersion struct{ GitVersion string } `json:"clientVersion"`
+		ServerVersion struct{ GitVersion string } `json:"serverVersion"`
+	}
+	out, err := kubectl("version", "--client", "-o", "json")
+	if err == nil {
+		err = json.Unmarshal([]byte(out), &version)
+	}
+	if !add("kubectl", version.ClientVersion.GitVersion, err, "install kubectl and add it to the PATH, see https://kubernetes.io/docs/tasks/tools/") {
+		return append(checks, doctorLocal(o, context)...)
+	}
+
+	if context == "" {
+		context, err = detectContext(nil)
+	}
+	if !add("context", context, err, "select a context with kubectl config use-context or --context") {
+		return append(checks, doctorLocal(o, context)...)
+	}
+	out, err = kubectl("version", "-o", "json", "--request-timeout=10s")
+	if err == nil {
+		err = json.Unmarshal([]byte(out), &version)
+	}
+	cluster := fmt.Sprintf("%s, server %s", detectCluster(context, target{}), version.ServerVersion.GitVersion)
+	connected := add("cluster", cluster, err, "check the network, VPN or proxy to the API server and that the credentials of the context are valid, e.g. with kubectl get --raw /readyz")
+
+	namespace = detectNamespace(context, target{Namespace: namespace})
+	resource := "pods"
+	if pod != "" {
+		resource = "pods/" + pod
+	}
+	if connected {
+		answer, err := kubectl("auth", "can-i", "create", resource, "--subresource=exec", "-n", namespace)
+		answer = strings.TrimSpace(answer)
+		if err != nil && answer == "no" {
+			err = fmt.Errorf("%s is not allowed to exec into %s in namespace %s", whoami(), resource, namespace)
+		}
+		add("exec permission", fmt.Sprintf("%s in namespace %s", resource, namespace), err,
+			fmt.Sprintf("ask an administrator for a Role granting the create verb on pods/exec in namespace %s", namespace))
+	}
+	if connected && pod != "" {
+		phase, err := kubectl("get", "pod", pod, "-n", namespace, "-o", "jsonpath={.status.phase}")
+		if err == nil && phase != "Running" {
+			err = fmt.Errorf("pod %s is %s, not Running", pod, phase)
+		}
+		add("pod", pod+" is Running", err, fmt.Sprintf("check the pod with kubectl describe pod %s -n %s", pod, namespace))
+	}
+	return append(checks, doctorLocal(o, context)...)
+}
+
+// doctorLocal checks the log directory, the sinks and the uploads, which do
+// not need kubectl
+func doctorLocal(o *options, context string) []selfCheck {
+	var checks []selfCheck
+	add := func(name, detail string, err error, fix string) {
+		checks = append(checks, selfCheck{name: name, detail: detail, err: err, fix: fix})
+	}
+
+	dir := o.logDir(context)
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		var f *os.File
+		if f, err = os.CreateTemp(dir, "doctor-*"); err == nil {
+			f.Close()
+			err = os.Remove(f.Name())
+		}
+	}
+	fix := fmt.Sprintf("make %s writable by %s, or set TMPDIR to a writable directory", dir, whoami())
+	if err == nil && runtime.GOOS != "windows" {
+		if info, statErr := os.Stat(dir); statErr == nil && info.Mode().Perm()&0o022 != 0 {
+			err = fmt.Errorf("%w: %s is %s, other users may replace or delete the log files", errWarning, dir, info.Mode().Perm())
+			fix = fmt.Sprintf("chmod go-w %s", dir)
+		}
+	}
+	add("log directory", dir, err, fix)
+
+	sinks, err := o.sinks()
+	var names []string
+	for _, s := range sinks {
+		names = append(names, describeSink(s))
+		_ = s.Close()
+	}
+	if err == nil && len(sinks) == 0 {
+		err = fmt.Errorf("%w: no sink configured", errSkipped)
+	}
+	add("sinks", strings.Join(names, ", "), err, "check the address and the credentials of the sink settings")
+
+	uploaders, err := o.uploaders()
+	if err == nil && len(uploaders) == 0 {
+		err = fmt.Errorf("%w: no upload configured", errSkipped)
+	}
+	if err != nil {
+		add("uploads", "", err, "check the upload settings")
+		return checks
+	}
+	for _, u := range uploaders {
+		name := "upload " + strings.TrimPrefix(fmt.Sprintf("%T", u), "*upload.")
+		c, ok := u.(upload.Checker)
+		if !ok {
+			add(name, "", fmt.Errorf("%w: not checked without uploading, see selftest --with-upload", errSkipped), "")
+			continue
+		}
+		err := c.Check()
+		fix := "check the credentials of the upload and that they can write to its destination"
+		if err != nil && strings.Contains(err.Error(), "not installed") {
+			fix = "install it and add it to the PATH"
+		}
+		add(name, "reachable", err, fix)
+	}
+	return checks
+}
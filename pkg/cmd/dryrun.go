@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// dryRun prints what a session would do without starting it. The sinks are
+// connected to check their configuration and closed, nothing is recorded or
+// uploaded and the hooks are not run.
+func dryRun(out io.Writer, o *options, opts recorder.Options) error {
+	ev, err := recorder.New(opts).Plan()
+	if err != nil {
+		return err
+	}
+	var errs []error
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Dry run, the session is not started.")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Command:\t%s\n", shellJoin(append([]string{opts.Name}, opts.Args...)))
+	fmt.Fprintf(w, "User:\t%s\n", opts.User)
+	fmt.Fprintf(w, "Context:\t%s\n", opts.Context)
+	fmt.Fprintf(w, "Cluster:\t%s\n", opts.Cluster)
+	fmt.Fprintf(w, "Namespace:\t%s\n", opts.Namespace)
+	if opts.Impersonation != nil {
+		fmt.Fprintf(w, "Impersonating:\t%s\n", opts.Impersonation)
+	}
+	if opts.Reason != "" {
+		fmt.Fprintf(w, "Reason:\t%s\n", opts.Reason)
+	}
+	if config.active != nil {
+		fmt.Fprintf(w, "Profile:\t%s (%s)\n", config.active.name, config.path)
+	}
+	fmt.Fprintf(w, "Session ID:\t%s\n", ev.SessionID)
+	if opts.NoRecord != "" {
+		fmt.Fprintf(w, "Log file:\tnone, not recorded: %s\n", opts.NoRecord)
+	} else {
+		fmt.Fprintf(w, "Log file:\t%s\n", ev.LogFile)
+		for _, f := range recordingFeatures(opts) {
+			fmt.Fprintf(w, "Recording:\t%s\n", f)
+		}
+	}
+
+	sinks, err := o.sinks()
+	if err != nil {
+		errs = append(errs, err)
+		fmt.Fprintf(w, "Sinks:\terror: %v\n", err)
+	}
+	for _, s := range sinks {
+		fmt.Fprintf(w, "Sink:\t%s\n", describeSink(s))
+		_ = s.Close()
+	}
+
+	var uploaders []upload.Uploader
+	if opts.NoRecord == "" {
+		if uploaders, err = o.uploaders(); err != nil {
+			errs = append(errs, err)
+			fmt.Fprintf(w, "Uploads:\terror: %v\n", err)
+		}
+	}
+	for _, u := range uploaders {
+		l, ok := u.(upload.Locator)
+		if !ok {
+			fmt.Fprintf(w, "Upload:\t%T\n", u)
+			continue
+		}
+		location, err := l.Location(ev)
+		if err != nil {
+			errs = append(errs, err)
+			fmt.Fprintf(w, "Upload:\terror: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(w, "Upload:\t%s\n", location)
+	}
+	if len(sinks) == 0 && len(uploaders) == 0 && len(errs) == 0 && opts.NoRecord == "" {
+		fmt.Fprintln(w, "Sinks and uploads:\tnone, the log file is only kept locally")
+	}
+	if len(errs) == 0 {
+		if err := checkLockdown(opts, sinks, uploaders); err != nil {
+			errs = append(errs, err)
+			fmt.Fprintf(w, "Lockdown:\terror: %v\n", err)
+		}
+	}
+
+	for _, key := range []string{"pre-session-hook", "post-session-hook"} {
+		if hook := setting(key); hook != "" {
+			fmt.Fprintf(w, "Hook:\t%s (%s)\n", hook, key)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// recordingFeatures describes the recording options that are enabled
+func recordingFeatures(opts recorder.Options) []string {
+	var features []string
+	if opts.PlainText {
+		features = append(features, "plain text transcript")
+	}
+	if opts.RecordInput {
+		features = append(features, "input recording")
+	}
+	if opts.Commands {
+		features = append(features, "command summary")
+	}
+	if opts.PromptRegex != nil {
+		features = append(features, fmt.Sprintf("prompt markers (%s)", opts.PromptRegex))
+	} else if opts.PromptMarkers {
+		features = append(features, "prompt markers (OSC 133)")
+	}
+	if opts.DetectBinary {
+		features = append(features, "binary output placeholders")
+	}
+	if len(opts.Redact) > 0 {
+		features = append(features, "redaction ("+describeRedaction(opts.Redact)+")")
+	}
+	if isTrue(setting("pod-snapshot")) {
+		features = append(features, "pod snapshot")
+	}
+	if isTrue(setting("pod-metadata")) {
+		features = append(features, "pod metadata")
+	}
+	if _, enabled, _ := compression(); enabled {
+		if level := setting("compression-level"); level != "" {
+			features = append(features, fmt.Sprintf("gzip compression (level %s)", level))
+		} else {
+			features = append(features, "gzip compression")
+		}
+	}
+	if opts.MinFreeSpace > 0 {
+		features = append(features, fmt.Sprintf("minimum free space %d bytes", opts.MinFreeSpace))
+	}
+	if opts.MaxLogSize > 0 {
+		features = append(features, fmt.Sprintf("maximum log size %d bytes (%s)", opts.MaxLogSize, opts.MaxLogSizePolicy))
+	}
+	if opts.BufferSize > 0 {
+		features = append(features, fmt.Sprintf("buffer size %d bytes", opts.BufferSize))
+	}
+	if opts.MaxOutputRate > 0 {
+		features = append(features, fmt.Sprintf("maximum output rate %d bytes per minute", opts.MaxOutputRate))
+	}
+	if opts.IdleTimeout > 0 {
+		features = append(features, fmt.Sprintf("idle timeout %s", opts.IdleTimeout))
+	}
+	if opts.Timeout > 0 {
+		features = append(features, fmt.Sprintf("timeout %s", opts.Timeout))
+	}
+	if slices.Contains(opts.Args, resumeScript) {
+		features = append(features, "resumable")
+	}
+	if opts.Retries > 0 {
+		features = append(features, fmt.Sprintf("%d retries on transient failures", opts.Retries))
+	}
+	return features
+}
+
+// describeRedaction describes the redaction rules by rule set, e.g.
+// "pci: card, iban"
+func describeRedaction(rules []recorder.RedactRule) string {
+	var sets []string
+	for i, rule := range rules {
+		if i == 0 || rules[i-1].Set != rule.Set {
+			sets = append(sets, rule.Set+": "+rule.Name)
+		} else {
+			sets[len(sets)-1] += ", " + rule.Name
+		}
+	}
+	return strings.Join(sets, "; ")
+}
+
+// describeSink describes a sink and its queue
+func describeSink(s recorder.Sink) string {
+	q, ok := s.(recorder.QueuedSink)
+	if !ok {
+		if n, ok := s.(fmt.Stringer); ok {
+			return n.String()
+		}
+		return fmt.Sprintf("%T", s)
+	}
+	size := q.QueueSize
+	if size <= 0 {
+		size = recorder.DefaultQueueSize
+	}
+	memory := q.QueueBytes
+	if memory <= 0 {
+		memory = recorder.DefaultQueueBytes
+	}
+	backpressure := "block"
+	if q.Drop {
+		backpressure = "drop"
+	}
+	return fmt.Sprintf("%s (queue %d, %d bytes, %s)", q, size, memory, backpressure)
+}
+
+// shellJoin joins a command line, quoting the arguments when needed
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`|&;<>()*?[]{}~#!") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+// sealSession encrypts the files of a finished session with the Vault
+// transit key set with vault-transit-key and returns the event referring to
+// the encrypted files. The files already encrypted are kept, so that a
+// session can be sealed again before every upload attempt.
+func sealSession(ev recorder.Event) (recorder.Event, error) {
+	key := setting("vault-transit-key")
+	if key == "" || ev.LogFile == "" {
+		return ev, nil
+	}
+	var e *vault.Encryptor
+	seal := func(path string) (string, error) {
+		if strings.HasSuffix(path, vault.Ext) {
+			return path, nil
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Stat(path + vault.Ext); err == nil {
+				return path + vault.Ext, nil
+			}
+		}
+		if e == nil {
+			client, err := vault.NewClient()
+			if err != nil {
+				return "", err
+			}
+			e = &vault.Encryptor{Client: client, Mount: setting("vault-transit-mount"), Key: key}
+		}
+		return e.EncryptFile(path)
+	}
+
+	logFile, err := seal(ev.LogFile)
+	if err != nil {
+		return ev, err
+	}
+	attachments := make([]string, len(ev.Attachments))
+	for i, path := range ev.Attachments {
+		if attachments[i], err = seal(path); err != nil {
+			return ev, err
+		}
+	}
+	ev.LogFile = logFile
+	ev.Attachments = attachments
+	return ev, nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/playback"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+func newExportCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var format, output, to, context, promptRegex string
+	cmd := &cobra.Command{
+		Use:   "export SOURCE",
+		Short: "Convert a recorded session to another format",
+		Long: `Convert a recorded session to another format. The source is a local log file, an s3://bucket/key object or an http(s) URL, read like the source of replay.
+
+Formats:
+  cast  asciicast v2, played by asciinema and its web player
+  raw   the log file itself, uncompressed and unencrypted
+  jsonl a JSON object per line: the header, every output and marker with
+        its time in seconds since the start of the session, and the footer,
+        e.g. for jq
+  ttyrec
+        ttyrec file, played by ttyplay, without the terminal size
+  svg   animated SVG image of the plain text of the session, for documents
+        where a player cannot be embedded, a GIF can be rendered from the
+        cast with agg
+  transcript
+        readable transcript for audit responses: the session details, then
+        every command as "$ command" followed by its output as plain text.
+        The commands follow the prompt markers, or else the lines matching
+        --prompt-regex, a shell prompt such as "user@host:~$ " by default.
+
+With --to asciinema the recording is shared on an asciinema server and its URL is printed: the server is the asciinema-url setting, https://asciinema.org by default, and asciinema-token is the install ID of the user, written to ~/.config/asciinema/install-id by asciinema auth.
+
+Examples:
+  kubectl execrec export session.log -o session.cast
+  kubectl execrec export session.log --format svg -o session.svg
+  kubectl execrec export session.log.gz --format jsonl | jq -r 'select(.type == "marker") | .marker'
+  kubectl execrec export session.log --format transcript --prompt-regex '^mysql> '
+  kubectl execrec export s3://my-bucket/kubectl-execrec/prod/session.log --to asciinema`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newEncoder, ok := playback.Encoders[format]
+			if !ok {
+				return fmt.Errorf("invalid format %q, expected %s", format, strings.Join(slices.Sorted(maps.Keys(playback.Encoders)), ", "))
+			}
+			if promptRegex != "" {
+				if format != "transcript" {
+					return fmt.Errorf("--prompt-regex only applies to the transcript format")
+				}
+				re, err := regexp.Compile(promptRegex)
+				if err != nil {
+					return fmt.Errorf("invalid --prompt-regex: %w", err)
+				}
+				newEncoder = func(w io.Writer) playback.Encoder {
+					return playback.NewTranscriptEncoder(w, re)
+				}
+			}
+			convert := func(w io.Writer, r *playback.Reader) error {
+				return playback.Encode(newEncoder(w), r)
+			}
+			switch to {
+			case "":
+			case "asciinema":
+				if format != "cast" {
+					return fmt.Errorf("asciinema only plays the cast format")
+				}
+				if output != "" {
+					return fmt.Errorf("--output and --to cannot be used together")
+				}
+			default:
+				return fmt.Errorf("invalid --to %q, expected asciinema", to)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+
+			rc, err := openRecording(args[0])
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			r, err := playback.NewReader(rc)
+			if err != nil {
+				return err
+			}
+
+			if to == "asciinema" {
+				pr, pw := io.Pipe()
+				go func() { pw.CloseWithError(convert(pw, r)) }()
+				u := &upload.Asciinema{
+					URL:   setting("asciinema-url"),
+					Token: setting("asciinema-token"),
+					TLS:   httpTLS("asciinema"),
+				}
+				url, err := u.Share(exportName(args[0], format), pr)
+				pr.Close()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(streams.Out, url)
+				return nil
+			}
+
+			if output == "" {
+				return convert(streams.Out, r)
+			}
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			if err := convert(f, r); err != nil {
+				f.Close()
+				_ = os.Remove(output)
+				return err
+			}
+			return f.Close()
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "cast", "Output format: cast, raw, jsonl, ttyrec, svg or transcript")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to a file instead of stdout")
+	cmd.Flags().StringVar(&to, "to", "", "Share the recording on a service: asciinema")
+	cmd.Flags().StringVar(&promptRegex, "prompt-regex", "", "Regular expression matching the shell prompt before the commands of a transcript")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+// exportName returns the file name of a source converted to a format
+func exportName(source, format string) string {
+	name := path.Base(source)
+	name = strings.TrimSuffix(name, vault.Ext)
+	return strings.TrimSuffix(name, ".log") + "." + format
+}
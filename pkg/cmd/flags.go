@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// execrecFlag is a flag of kubectl execrec itself, it is removed from the
+// arguments forwarded to kubectl exec and defaults to its setting, see
+// setting
+type execrecFlag struct {
+	name   string
+	isBool bool
+	// noOptValue is the value of a non-boolean flag given without a value
+	noOptValue string
+}
+
+// execrecFlags are the flags of kubectl execrec, they are given before "--"
+var execrecFlags = []execrecFlag{
+	{name: "config"},
+	{name: "dry-run", isBool: true},
+	{name: "quiet", isBool: true},
+	{name: "verbose", noOptValue: "1"},
+	{name: "log-level"},
+	{name: "log-file"},
+	{name: "no-record"},
+	{name: "detach-keys"},
+	{name: "utc", isBool: true},
+	{name: "time-format"},
+	{name: "file-time-format"},
+	{name: "log-name"},
+	{name: "min-free-space"},
+	{name: "max-log-size"},
+	{name: "max-log-size-policy"},
+	{name: "max-output-rate"},
+	{name: "buffer-size"},
+	{name: "idle-timeout"},
+	{name: "timeout"},
+	{name: "retry"},
+	{name: "resume", isBool: true},
+	{name: "redact-ruleset"},
+	{name: "reason"},
+	{name: "capture-context", noOptValue: defaultCaptureLines},
+	{name: "pods"},
+	{name: "selector"},
+	{name: "profile-session", isBool: true},
+	{name: "output"},
+}
+
+// flagValues are the kubectl execrec flags given on the command line
+type flagValues map[string]string
+
+// get returns the value of a flag, or of its setting if the flag was not
+// given
+func (v flagValues) get(name string) string {
+	if value, ok := v[name]; ok {
+		return value
+	}
+	return setting(name)
+}
+
+// bool returns the value of a boolean flag
+func (v flagValues) bool(name string) bool {
+	return isTrue(v.get(name))
+}
+
+// parseFlags extracts the kubectl execrec flags from args and returns the
+// arguments to forward to kubectl exec
+func parseFlags(args []string) (flagValues, []string, error) {
+	values := flagValues{}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		f, ok := lookupFlag(name)
+		if !strings.HasPrefix(arg, "--") || !ok {
+			rest = append(rest, arg)
+			continue
+		}
+
+		switch {
+		case hasValue:
+		case f.isBool:
+			value = "true"
+		case f.noOptValue != "":
+			value = f.noOptValue
+		case i+1 < len(args):
+			i++
+			value = args[i]
+		default:
+			return nil, nil, fmt.Errorf("flag needs an argument: --%s", name)
+		}
+		if f.isBool {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, nil, fmt.Errorf("invalid value %q for --%s", value, name)
+			}
+		}
+		values[name] = value
+	}
+	return values, rest, nil
+}
+
+func lookupFlag(name string) (execrecFlag, bool) {
+	for _, f := range execrecFlags {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return execrecFlag{}, false
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix, e.g.
+// "512K", "100MB" or "1GiB", an empty size is zero
+func parseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	if v == "" {
+		return 0, nil
+	}
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+
+	unit := int64(1)
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			v = v[:len(v)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// parseDuration parses a duration such as "15m", an empty duration is zero
+func parseDuration(s string) (time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// timeFormats are the named time layouts accepted by --time-format and
+// --file-time-format
+var timeFormats = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"compact":     recorder.DefaultFileTimeFormat,
+}
+
+// parseTimeFormat parses a named time layout or a Go time layout, e.g.
+// "2006-01-02 15:04:05", an empty layout is returned as is
+func parseTimeFormat(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if layout, ok := timeFormats[strings.ToLower(s)]; ok {
+		return layout, nil
+	}
+	// a layout without any time element formats to itself
+	if time.Unix(0, 0).Format(s) == s {
+		return "", fmt.Errorf("invalid time format %q, expected rfc3339, rfc3339nano, compact or a Go time layout", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		values flagValues
+		rest   []string
+		err    string
+	}{
+		{
+			name:   "kubectl arguments",
+			args:   []string{"-n", "default", "web", "-it", "--", "sh"},
+			values: flagValues{},
+			rest:   []string{"-n", "default", "web", "-it", "--", "sh"},
+		},
+		{
+			name:   "value after the flag",
+			args:   []string{"--reason", "INC-1", "web", "--", "sh"},
+			values: flagValues{"reason": "INC-1"},
+			rest:   []string{"web", "--", "sh"},
+		},
+		{
+			name:   "value with =",
+			args:   []string{"--max-log-size=100M", "--max-log-size-policy=rotate", "web"},
+			values: flagValues{"max-log-size": "100M", "max-log-size-policy": "rotate"},
+			rest:   []string{"web"},
+		},
+		{
+			name:   "bool flags",
+			args:   []string{"--quiet", "--utc=false", "web"},
+			values: flagValues{"quiet": "true", "utc": "false"},
+			rest:   []string{"web"},
+		},
+		{
+			name:   "flag without its optional value",
+			args:   []string{"--verbose", "--capture-context", "web"},
+			values: flagValues{"verbose": "1", "capture-context": defaultCaptureLines},
+			rest:   []string{"web"},
+		},
+		{
+			name:   "flags after -- go to the command",
+			args:   []string{"web", "--", "sh", "--quiet"},
+			values: flagValues{},
+			rest:   []string{"web", "--", "sh", "--quiet"},
+		},
+		{
+			name:   "kubectl profile",
+			args:   []string{"--profile-session", "--profile", "cpu", "web"},
+			values: flagValues{"profile-session": "true"},
+			rest:   []string{"--profile", "cpu", "web"},
+		},
+		{name: "missing value", args: []string{"web", "--reason"}, err: "flag needs an argument: --reason"},
+		{name: "invalid bool", args: []string{"--quiet=maybe", "web"}, err: `invalid value "maybe" for --quiet`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, rest, err := parseFlags(tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseFlags error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFlags: %v", err)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("values = %v, want %v", values, tt.values)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("kubectl arguments = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "", want: 0},
+		{in: "512", want: 512},
+		{in: "512K", want: 512 << 10},
+		{in: "100MB", want: 100 << 20},
+		{in: "1GiB", want: 1 << 30},
+		{in: "10X", err: true},
+		{in: "-1M", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
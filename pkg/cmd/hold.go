@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// legalHold is a session under legal hold, kept in a file of the holds
+// directory named after the session
+type legalHold struct {
+	SessionID string `json:"sessionId"`
+	User      string `json:"user"`
+	Time      string `json:"time"`
+	Reason    string `json:"reason,omitempty"`
+	// Locations are the S3 objects placed under legal hold
+	Locations []string `json:"locations,omitempty"`
+}
+
+// sessionIDPattern matches the session IDs, or the groups of the sessions of
+// a run on several pods
+var sessionIDPattern = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+
+func (o *options) holdPath(id string) string {
+	return filepath.Join(o.holdDir, id+".json")
+}
+
+// held tells if a session, or the run on several pods it belongs to, is under
+// legal hold, so that it must not be deleted
+func (o *options) held(ids ...string) bool {
+	for _, id := range ids {
+		if !sessionIDPattern.MatchString(id) {
+			continue
+		}
+		if _, err := os.Stat(o.holdPath(id)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func newHoldCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var reason, context string
+	var locations []string
+	cmd := &cobra.Command{
+		Use:   "hold SESSION_ID",
+		Short: "Place a session under legal hold",
+		Long: `Place a session under legal hold until it is released: it is flagged in reports and must never be deleted, locally or remotely, whatever the retention.
+
+The S3 copies of the session found in the session index, or given with --location, get an Object Lock legal hold, or a legal-hold tag if the bucket does not have Object Lock. The ID of a run on several pods holds the sessions of every pod.
+
+Examples:
+  kubectl execrec hold 01K2B3QZ7YHX4N6R8TVA2C5DEF --reason "case 2024-17"
+  kubectl execrec hold 01K2B3QZ7YHX4N6R8TVA2C5DEF --location s3://audit/kubectl-execrec/prod/alice_20250810T143332+0900_01K2B3QZ7YHX4N6R8TVA2C5DEF.log`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+			if !sessionIDPattern.MatchString(id) {
+				return fmt.Errorf("invalid session ID %q", id)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			found, err := sessionLocations(o, id)
+			if err != nil {
+				return err
+			}
+			if found == nil && len(locations) == 0 {
+				return fmt.Errorf("session %s is not in the session index, give its S3 location with --location", id)
+			}
+
+			hold := legalHold{SessionID: id, User: whoami(), Time: o.now().Format(time.RFC3339), Reason: reason}
+			// the local hold comes first, so that the session is not deleted
+			// while its copies are being held
+			if err := os.MkdirAll(o.holdDir, 0o755); err != nil {
+				return err
+			}
+			if err := writeJSON(o.holdPath(id), hold); err != nil {
+				return err
+			}
+			for _, location := range append(found, locations...) {
+				held, err := setLegalHold(location, true)
+				hold.Locations = append(hold.Locations, held...)
+				if err != nil {
+					_ = writeJSON(o.holdPath(id), hold)
+					return err
+				}
+			}
+			if err := writeJSON(o.holdPath(id), hold); err != nil {
+				return err
+			}
+			fmt.Fprintf(streams.Out, "Session %s is under legal hold\n", id)
+			for _, location := range hold.Locations {
+				fmt.Fprintf(streams.Out, "  %s\n", location)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "Reason of the legal hold, e.g. a case number")
+	cmd.Flags().StringArrayVar(&locations, "location", nil, "S3 location of the log file of the session, s3://bucket/key, repeatable")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+func newReleaseCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var context string
+	cmd := &cobra.Command{
+		Use:   "release SESSION_ID",
+		Short: "Release the legal hold of a session",
+		Long: `Release the legal hold of a session placed with hold, locally and on its S3 copies. The retention of the S3 objects applies again.
+
+Examples:
+  kubectl execrec release 01K2B3QZ7YHX4N6R8TVA2C5DEF`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+			if !sessionIDPattern.MatchString(id) {
+				return fmt.Errorf("invalid session ID %q", id)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			var hold legalHold
+			if err := readJSON(o.holdPath(id), &hold); errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("session %s is not under legal hold", id)
+			} else if err != nil {
+				return err
+			}
+			// the copies are released first, so that a failure can be retried
+			for _, location := range hold.Locations {
+				// the attachments are released with their log file
+				if !isLogObject(location) {
+					continue
+				}
+				if _, err := setLegalHold(location, false); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(o.holdPath(id)); err != nil {
+				return err
+			}
+			fmt.Fprintf(streams.Out, "Session %s is released\n", id)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+// sessionLocations returns the S3 locations of the log files of a session, or
+// of the sessions of a run on several pods, in the session index, nil if the
+// session is not in the index
+func sessionLocations(o *options, id string) ([]string, error) {
+	entries, err := readIndex(o.indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session index: %w", err)
+	}
+	for _, e := range entries {
+		group := e.SessionID == id
+		found := group
+		var uploads []string
+		if group {
+			uploads = e.Uploads
+		}
+		for _, s := range e.Sessions {
+			if group || s.SessionID == id {
+				uploads = append(uploads, s.Uploads...)
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		locations := []string{}
+		for _, u := range uploads {
+			if strings.HasPrefix(u, "s3://") {
+				locations = append(locations, u)
+			}
+		}
+		return locations, nil
+	}
+	return nil, nil
+}
+
+// setLegalHold places or releases the legal hold of the S3 objects of the
+// session whose log file is at location, with the s3-* settings
+func setLegalHold(location string, on bool) ([]string, error) {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	s3, err := newS3Uploader(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return s3.SetLegalHold(location, on)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// hookTimeout bounds the run time of a hook
+const hookTimeout = time.Minute
+
+// hookInput is the session metadata passed to hooks as JSON on stdin
+type hookInput struct {
+	recorder.Event
+	// Args are the arguments forwarded to kubectl exec
+	Args []string `json:"args"`
+	// ExitCode is the exit code of kubectl exec, post_session only
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Uploads are the remote locations of the log file, post_session only
+	Uploads []string `json:"uploads,omitempty"`
+}
+
+// runHook runs the hook executable at path, the value of the
+// pre-session-hook or post-session-hook setting, with the session metadata on
+// stdin, the hook output is shown on stderr. A hook exiting non-zero returns
+// an error.
+func runHook(path string, in hookInput, stderr io.Writer) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	hook := exec.CommandContext(ctx, path)
+	hook.Stdin = bytes.NewReader(data)
+	hook.Stdout = stderr
+	hook.Stderr = stderr
+
+	if err := hook.Run(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return fmt.Errorf("%s hook %s exited with code %d", in.Type, path, ee.ExitCode())
+		}
+		return fmt.Errorf("%s hook %s failed: %w", in.Type, path, err)
+	}
+	return nil
+}
+
+// exitCode returns the exit code of a kubectl exec error
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, recorder.ErrTimeout) {
+		return timeoutExitCode
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
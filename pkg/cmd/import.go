@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/playback"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// importMetadata are the fields of the imported sessions the recordings of
+// other tools do not have
+type importMetadata struct {
+	format    string
+	user      string
+	context   string
+	cluster   string
+	namespace string
+	pod       string
+	command   string
+}
+
+func newImportCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var m importMetadata
+	cmd := &cobra.Command{
+		Use:   "import FILE...",
+		Short: "Import the recordings of other tools into the session index",
+		Long: `Convert recordings made with other tools to log files and add them to the session index, so that an archive of sessions recorded before kubectl execrec is listed, reported, shown and uploaded with the other sessions.
+
+Formats:
+  script  typescript of script(1), with its start and end times, and with
+          util-linux its command, terminal size and exit code
+  cast    asciicast v1, v2 or v3 of asciinema, with its terminal size and
+          resizes, command or title, and start time if recorded
+  ttyrec  ttyrec file, with the time of every output
+
+The format is detected from the content of the file unless --format is given. A log file only has times on its markers, the times of the output itself are not kept: the session has the start and end times of the recording, or the modification time of the file when unknown. The user, context, cluster, namespace and pod the recordings do not have are given with flags, unknown by default.
+
+The log files are written like the ones of new sessions, with the log-name, file-time-format and time-format settings of the profile of --context, compressed and encrypted if set, and their header has imported and source fields telling the format and the name of the file they were imported from. A file already imported is skipped. The imported sessions are uploaded by sync.
+
+Examples:
+  kubectl execrec import ~/typescript
+  kubectl execrec import --user alice --context prod --namespace default --pod web-0 session.cast
+  kubectl execrec import --format ttyrec --user bob archive/*.tty`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, ok := playback.ImportFormats[m.format]; !ok && m.format != "auto" {
+				return fmt.Errorf("invalid format %q, expected auto, %s", m.format, strings.Join(slices.Sorted(maps.Keys(playback.ImportFormats)), ", "))
+			}
+			if _, err := config.useContext(m.context); err != nil {
+				return err
+			}
+			if m.cluster == "" {
+				m.cluster = m.context
+			}
+			entries, err := readIndex(o.indexPath)
+			if err != nil {
+				return fmt.Errorf("failed to read the session index: %w", err)
+			}
+			imported := map[string]string{}
+			for _, e := range entries {
+				if e.ImportedFrom != "" {
+					imported[e.ImportedFrom] = e.SessionID
+				}
+			}
+
+			var failed int
+			for _, file := range args {
+				path, err := filepath.Abs(file)
+				if err != nil {
+					return err
+				}
+				if id, ok := imported[path]; ok {
+					fmt.Fprintf(streams.Out, "%s: already imported as session %s\n", file, id)
+					continue
+				}
+				entry, err := importRecording(o, path, m, streams.ErrOut)
+				if err != nil {
+					failed++
+					fmt.Fprintf(streams.ErrOut, "%s: failed to import: %v\n", file, err)
+					continue
+				}
+				if err := appendIndex(o.indexPath, entry); err != nil {
+					return fmt.Errorf("failed to update the session index: %w", err)
+				}
+				imported[path] = entry.SessionID
+				fmt.Fprintf(streams.Out, "%s: imported as session %s to %s\n", file, entry.SessionID, entry.LogFile)
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to import %d of %d files", failed, len(args))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&m.format, "format", "auto", "Format of the files: auto, script, cast or ttyrec")
+	cmd.Flags().StringVar(&m.user, "user", "unknown", "User of the sessions")
+	cmd.Flags().StringVar(&m.context, "context", "unknown", "Kube-context of the sessions, whose profile settings are used")
+	cmd.Flags().StringVar(&m.cluster, "cluster", "", "Cluster of the sessions, the context by default")
+	cmd.Flags().StringVarP(&m.namespace, "namespace", "n", "", "Namespace of the sessions")
+	cmd.Flags().StringVar(&m.pod, "pod", "", "Pod of the sessions")
+	cmd.Flags().StringVar(&m.command, "command", "", "Command line of the sessions, the one of the recording by default")
+	return cmd
+}
+
+// importRecording converts the recording of another tool to a log file and
+// returns its session index entry
+func importRecording(o *options, path string, m importMetadata, errOut io.Writer) (indexEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return indexEntry{}, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	format := m.format
+	if format == "auto" {
+		format = playback.DetectFormat(data[:min(len(data), 512)])
+	}
+	rec, err := playback.ImportFormats[format](bytes.NewReader(data))
+	if err != nil {
+		return indexEntry{}, err
+	}
+	if m.command != "" {
+		rec.Command = m.command
+	}
+	if rec.Start.IsZero() {
+		rec.Start = st.ModTime()
+	}
+	if rec.End.IsZero() || rec.End.Before(rec.Start) {
+		rec.End = rec.Start
+		if st.ModTime().After(rec.Start) {
+			rec.End = st.ModTime()
+		}
+	}
+	if isTrue(setting("utc")) {
+		rec.Start, rec.End = rec.Start.UTC(), rec.End.UTC()
+	}
+
+	timeFormat, err := parseTimeFormat(setting("time-format"))
+	if err != nil {
+		return indexEntry{}, err
+	}
+	if timeFormat == "" {
+		timeFormat = time.RFC3339
+	}
+	fileTimeFormat, err := parseTimeFormat(setting("file-time-format"))
+	if err != nil {
+		return indexEntry{}, err
+	}
+	if fileTimeFormat == "" {
+		fileTimeFormat = recorder.DefaultFileTimeFormat
+	}
+	logName := setting("log-name")
+	if logName == "" {
+		logName = recorder.DefaultLogName
+	}
+	tmpl, err := recorder.ParseLogName(logName)
+	if err != nil {
+		return indexEntry{}, fmt.Errorf("invalid log-name: %w", err)
+	}
+	id := recorder.NewSessionID(rec.Start)
+	name, err := recorder.LogFileName(tmpl, recorder.LogNameData{
+		User:    recorder.SafeFileName(m.user),
+		Context: recorder.SafeFileName(m.context),
+		Cluster: recorder.SafeFileName(m.cluster),
+		Time:    rec.Start.Format(fileTimeFormat),
+		ID:      id,
+	})
+	if err != nil {
+		return indexEntry{}, err
+	}
+
+	dir := o.logDir(m.context)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return indexEntry{}, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	logFile := filepath.Join(dir, name)
+	out, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	fields := [][2]string{
+		{"start", rec.Start.Format(timeFormat)},
+		{"user", m.user},
+		{"context", m.context},
+		{"cluster", m.cluster},
+		{"version", o.version},
+		{"format", strconv.Itoa(recorder.FormatVersion)},
+		{"id", id},
+		{"imported", format},
+		{"source", filepath.Base(path)},
+	}
+	err = playback.WriteLog(out, rec, fields, timeFormat)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(logFile)
+		return indexEntry{}, err
+	}
+
+	ev := recorder.Event{
+		Type:      "end",
+		SessionID: id,
+		Command:   rec.Command,
+		User:      m.user,
+		Context:   m.context,
+		Cluster:   m.cluster,
+		Namespace: m.namespace,
+		Pod:       m.pod,
+		LogFile:   logFile,
+		Version:   o.version,
+		Start:     rec.Start.Format(time.RFC3339),
+		End:       rec.End.Format(time.RFC3339),
+	}
+	if compressed, err := compressSession(ev); err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to compress the session: %v\n", err)
+	} else {
+		ev = compressed
+	}
+	if sealed, err := sealSession(ev); err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to encrypt the session: %v\n", err)
+	} else {
+		ev = sealed
+	}
+	return indexEntry{
+		SessionID:    id,
+		User:         m.user,
+		Context:      m.context,
+		Cluster:      m.cluster,
+		Namespace:    m.namespace,
+		Pod:          m.pod,
+		Command:      rec.Command,
+		LogFile:      ev.LogFile,
+		SHA256:       logChecksum(ev.LogFile),
+		Start:        ev.Start,
+		End:          ev.End,
+		ExitCode:     rec.ExitCode,
+		ImportedFrom: path,
+	}, nil
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// indexEntry is a finished session in the session index
+type indexEntry struct {
+	SessionID string `json:"sessionId,omitempty"`
+	User      string `json:"user"`
+	Context   string `json:"context"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Command   string `json:"command"`
+	LogFile   string `json:"logFile"`
+	SHA256    string `json:"sha256,omitempty"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	ExitCode  int    `json:"exitCode"`
+	// ExecFailure is the failure of kubectl exec, nil if the command ran
+	ExecFailure *recorder.ExecFailure `json:"execFailure,omitempty"`
+	// Uploads are the remote locations of the log file and UploadFailures
+	// the number of uploaders that failed
+	Uploads        []string `json:"uploads,omitempty"`
+	UploadFailures int      `json:"uploadFailures,omitempty"`
+	// NoRecord is the reason the session was not recorded
+	NoRecord string `json:"noRecord,omitempty"`
+	// Impersonation is the identity the session acted as and Reason the
+	// reason given for the session
+	Impersonation *recorder.Impersonation `json:"impersonation,omitempty"`
+	Reason        string                  `json:"reason,omitempty"`
+	// ImageDigest is the digest of the image of the container, recorded
+	// with the pod metadata
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Sessions are the sessions of a command run on several pods at once,
+	// the entry is then the run and its SessionID the group of the sessions
+	Sessions []indexSession `json:"sessions,omitempty"`
+	// ImportedFrom is the recording of another tool the session was
+	// imported from by import
+	ImportedFrom string `json:"importedFrom,omitempty"`
+}
+
+// indexSession is the session of a pod in a run on several pods
+type indexSession struct {
+	Pod         string                `json:"pod"`
+	SessionID   string                `json:"sessionId"`
+	LogFile     string                `json:"logFile"`
+	SHA256      string                `json:"sha256,omitempty"`
+	ExitCode    int                   `json:"exitCode"`
+	ExecFailure *recorder.ExecFailure `json:"execFailure,omitempty"`
+	Uploads     []string              `json:"uploads,omitempty"`
+	// Image and ImageDigest are the ones of the container of the pod, the
+	// pods may run different versions during a rollout
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+}
+
+// podImage returns the image and the image digest of the container of a
+// session, recorded with its pod metadata, or else the image of the pod
+// created by kubectl run
+func podImage(ev recorder.Event) (string, string) {
+	if m := ev.PodMetadata; m != nil && m.Image != "" {
+		return m.Image, m.ImageDigest
+	}
+	return ev.Image, ""
+}
+
+// duration returns the duration of the session
+func (e indexEntry) duration() time.Duration {
+	start, err := time.Parse(time.RFC3339, e.Start)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse(time.RFC3339, e.End)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// logChecksum returns the SHA-256 of a log file, empty if it was not recorded
+func logChecksum(path string) string {
+	if path == "" {
+		return ""
+	}
+	sum, err := upload.FileSHA256(path)
+	if err != nil {
+		return ""
+	}
+	return sum
+}
+
+// appendIndex appends a session to the index, one JSON object per line
+func appendIndex(path string, e indexEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readIndex reads the sessions of the index, a missing index has no
+// sessions
+func readIndex(path string) ([]indexEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e indexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
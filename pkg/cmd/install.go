@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	// agentUnit is the name of the systemd user unit of the upload agent
+	agentUnit = "kubectl-execrec-agent.service"
+	// agentLabel is the label of the launchd agent of the upload agent
+	agentLabel = "io.github.keidarcy.kubectl-execrec.agent"
+)
+
+// agentEnvPrefixes are the environment variables configuring the uploads,
+// they are copied to the service of the upload agent
+var agentEnvPrefixes = []string{"KUBECTL_EXECREC_", "AWS_"}
+
+func newAgentInstallCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var interval, maxBackoff time.Duration
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the upload agent as a user service",
+		Long: `Install the upload agent as a systemd user unit on Linux or a launchd agent on macOS, enable it and start it.
+
+The KUBECTL_EXECREC_* and AWS_* environment variables of the current shell are copied to the service, so the agent uploads to the same storage as the sessions.
+
+Examples:
+  KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec agent install
+  kubectl execrec agent install --interval 10s`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			agentArgs := []string{exe, "agent", "--interval", interval.String(), "--max-backoff", maxBackoff.String()}
+
+			var path string
+			switch runtime.GOOS {
+			case "linux":
+				path, err = installSystemd(o, home, agentArgs)
+			case "darwin":
+				path, err = installLaunchd(o, home, agentArgs)
+			default:
+				return fmt.Errorf("installing the upload agent is not supported on %s", runtime.GOOS)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(streams.Out, "Upload agent installed and started: %s\n", path)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "How often the agent checks the spool directory")
+	cmd.Flags().DurationVar(&maxBackoff, "max-backoff", time.Hour, "Maximum delay between two attempts to upload a session")
+	return cmd
+}
+
+// agentEnv returns the environment variables of the upload agent
+func agentEnv() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		for _, prefix := range agentEnvPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				env[k] = v
+			}
+		}
+	}
+	// the spool directory is in the temporary directory
+	if tmp := os.Getenv("TMPDIR"); tmp != "" {
+		env["TMPDIR"] = tmp
+	}
+	return env
+}
+
+// sortedKeys returns the keys of env in order
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// installSystemd writes and enables the systemd user unit, the environment
+// is kept in a separate file readable only by the user as it may hold
+// credentials
+func installSystemd(o *options, home string, args []string) (string, error) {
+	dir := filepath.Join(home, ".config", "systemd", "user")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	envPath := filepath.Join(home, ".config", "kubectl-execrec", "agent.env")
+	if err := os.MkdirAll(filepath.Dir(envPath), 0o700); err != nil {
+		return "", err
+	}
+	var env bytes.Buffer
+	vars := agentEnv()
+	for _, k := range sortedKeys(vars) {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(vars[k])
+		fmt.Fprintf(&env, "%s=\"%s\"\n", k, v)
+	}
+	if err := os.WriteFile(envPath, env.Bytes(), 0o600); err != nil {
+		return "", err
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = fmt.Sprintf("%q", arg)
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=kubectl execrec upload agent
+
+[Service]
+ExecStart=%s
+EnvironmentFile=%s
+Restart=on-failure
+RestartSec=10
+
+[Install]
+WantedBy=default.target
+`, strings.Join(quoted, " "), envPath)
+	path := filepath.Join(dir, agentUnit)
+	if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := runService(o, "systemctl", "--user", "daemon-reload"); err != nil {
+		return "", err
+	}
+	return path, runService(o, "systemctl", "--user", "enable", "--now", agentUnit)
+}
+
+// installLaunchd writes and loads the launchd agent, the plist is readable
+// only by the user as it may hold credentials
+func installLaunchd(o *options, home string, args []string) (string, error) {
+	dir := filepath.Join(home, "Library", "LaunchAgents")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>Label</key>
+  <string>` + agentLabel + `</string>
+  <key>ProgramArguments</key>
+  <array>
+`)
+	for _, arg := range args {
+		b.WriteString("    <string>" + xmlEscape(arg) + "</string>\n")
+	}
+	b.WriteString("  </array>\n  <key>EnvironmentVariables</key>\n  <dict>\n")
+	vars := agentEnv()
+	for _, k := range sortedKeys(vars) {
+		b.WriteString("    <key>" + xmlEscape(k) + "</key>\n    <string>" + xmlEscape(vars[k]) + "</string>\n")
+	}
+	b.WriteString(`  </dict>
+  <key>RunAtLoad</key>
+  <true/>
+  <key>KeepAlive</key>
+  <true/>
+  <key>StandardErrorPath</key>
+  <string>` + xmlEscape(filepath.Join(home, "Library", "Logs", "kubectl-execrec-agent.log")) + `</string>
+</dict>
+</plist>
+`)
+	path := filepath.Join(dir, agentLabel+".plist")
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		return "", err
+	}
+
+	// reload the agent if it was already installed
+	_ = runService(o, "launchctl", "unload", path)
+	return path, runService(o, "launchctl", "load", "-w", path)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// runService runs a service manager command
+func runService(o *options, name string, args ...string) error {
+	out, err := o.command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
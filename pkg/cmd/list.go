@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+// listWatchInterval is the interval at which list --watch looks for new and
+// finished sessions
+const listWatchInterval = 2 * time.Second
+
+// listFilter selects the sessions printed by list, the user, namespace and
+// pod are globs
+type listFilter struct {
+	user      string
+	namespace string
+	pod       string
+	status    string
+	from      time.Time
+}
+
+// match tells if a session is selected
+func (f listFilter) match(row reportRow) bool {
+	for _, field := range [][2]string{{f.user, row.User}, {f.namespace, row.Namespace}, {f.pod, row.Pod}} {
+		if field[0] != "" && !upload.MatchGlob(field[0], field[1]) {
+			return false
+		}
+	}
+	if f.status != "" && f.status != row.Status {
+		return false
+	}
+	if start, err := time.Parse(time.RFC3339, row.Start); err == nil && start.Before(f.from) {
+		return false
+	}
+	return true
+}
+
+func newListCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var since, context string
+	var noPager, watch, remote bool
+	var filter listFilter
+	var output *outputFlags
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the sessions recorded on this machine",
+		Long: `List the sessions being recorded and the ones of the session index of this machine, oldest first, one row per session (per pod for a run on several pods).
+
+The table shows the session ID, user, context, namespace, pod, start, exit code and status, -o wide every field of report. --columns selects the fields, named as in the JSON output. On a terminal the list is shown in a pager, see show.
+
+The status of a session is active while it is recorded, failed-upload if its log file was not uploaded by every uploader, finished otherwise. --user, --namespace and --pod are globs such as 'web-*'.
+
+With --watch the sessions are listed, then the sessions that start or finish are printed as they do, one object per line with -o json, until interrupted.
+
+With --remote the sessions are listed from the S3 bucket of the uploads and its routes instead, with the s3-* settings, including the ones recorded on other machines. Their fields are read from the object keys with the s3-path template and the log-name and file-time-format settings, so they must be the ones of the machines that uploaded them; the fields of the keys not matching them are empty, see report --source s3 to read the metadata of the objects.
+
+Examples:
+  kubectl execrec list
+  kubectl execrec list --since 7d -o wide
+  kubectl execrec list --user alice --namespace 'prod-*' --status failed-upload
+  kubectl execrec list --watch
+  kubectl execrec list --remote --context prod --since 7d
+  kubectl execrec list -o json | jq -r '.[] | select(.exitCode != 0) | .logFile'
+  kubectl execrec list --columns sessionId,user,pod,logFile`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.validate(columnFields(reportColumns(nil))); err != nil {
+				return err
+			}
+			if filter.status != "" && !slices.Contains(sessionStatuses, filter.status) {
+				return fmt.Errorf("invalid status %q, expected %s", filter.status, strings.Join(sessionStatuses, ", "))
+			}
+			if since != "" {
+				d, err := parseSince(since)
+				if err != nil {
+					return err
+				}
+				filter.from = o.now().Add(-d)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+
+			list := listSessions
+			if remote {
+				if watch {
+					return fmt.Errorf("--watch cannot be used with --remote")
+				}
+				list = listRemoteSessions
+			}
+			rows, err := list(o, filter)
+			if err != nil {
+				return err
+			}
+			if watch {
+				return watchSessions(streams.Out, o, output, filter, rows)
+			}
+			out, wait := pageOutput(o, streams.Out, noPager)
+			defer wait()
+			return writeReport(out, output, rows)
+		},
+	}
+	cmd.Flags().BoolVar(&noPager, "no-pager", false, "Do not show the list in a pager")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "After listing the sessions, print the sessions that start or finish")
+	cmd.Flags().StringVar(&since, "since", "", "Only list the sessions started within this duration, e.g. 30d or 12h")
+	cmd.Flags().StringVar(&filter.user, "user", "", "Only list the sessions of the users matching this glob")
+	cmd.Flags().StringVarP(&filter.namespace, "namespace", "n", "", "Only list the sessions in the namespaces matching this glob")
+	cmd.Flags().StringVar(&filter.pod, "pod", "", "Only list the sessions of the pods matching this glob")
+	cmd.Flags().StringVar(&filter.status, "status", "", "Only list the sessions with this status: active, finished or failed-upload")
+	cmd.Flags().BoolVar(&remote, "remote", false, "List the sessions uploaded to the S3 bucket instead of the ones of this machine")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	output = addOutputFlags(cmd, "table", "table", "wide", "json", "yaml")
+	return cmd
+}
+
+// listSessions returns the finished sessions of the index and the sessions
+// being recorded selected by the filter, oldest first
+func listSessions(o *options, filter listFilter) ([]reportRow, error) {
+	entries, err := readIndex(o.indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session index: %w", err)
+	}
+	rows := indexReport(entries, time.Time{}, time.Time{})
+	active, err := spool{dir: o.spoolDir}.active()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the active sessions: %w", err)
+	}
+	for _, ev := range active {
+		rows = append(rows, reportRow{
+			SessionID: ev.SessionID,
+			Group:     ev.Group,
+			User:      ev.User,
+			Context:   ev.Context,
+			Cluster:   ev.Cluster,
+			Namespace: ev.Namespace,
+			Pod:       ev.Pod,
+			Command:   ev.Command,
+			Start:     ev.Start,
+			LogFile:   ev.LogFile,
+			Status:    "active",
+			Source:    "spool",
+		})
+	}
+	rows = slices.DeleteFunc(rows, func(row reportRow) bool { return !filter.match(row) })
+	for i := range rows {
+		rows[i].LegalHold = o.held(rows[i].SessionID, rows[i].Group)
+	}
+	sortReport(rows)
+	return rows, nil
+}
+
+// listRemoteSessions returns the sessions uploaded to the S3 bucket of the
+// uploads selected by the filter, oldest first
+func listRemoteSessions(o *options, filter listFilter) ([]reportRow, error) {
+	bucket := setting("s3-bucket")
+	if bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket, set KUBECTL_EXECREC_S3_BUCKET")
+	}
+	s3, err := newS3Uploader(bucket)
+	if err != nil {
+		return nil, err
+	}
+	logName := setting("log-name")
+	if logName == "" {
+		logName = recorder.DefaultLogName
+	}
+	timeFormat, err := parseTimeFormat(setting("file-time-format"))
+	if err != nil {
+		return nil, err
+	}
+	if timeFormat == "" {
+		timeFormat = recorder.DefaultFileTimeFormat
+	}
+	objects, err := s3.ListKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the S3 bucket: %w", err)
+	}
+
+	var rows []reportRow
+	for _, obj := range objects {
+		if !isLogObject(obj.Key) {
+			continue
+		}
+		fields, _ := s3.ParseKey(obj)
+		if fields == nil {
+			fields = map[string]string{}
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(path.Base(obj.Key), vault.Ext), compress.Ext), ".log")
+		if nameFields, ok := upload.MatchTemplate(logName, name); ok {
+			maps.Copy(fields, nameFields)
+		}
+		row := reportRow{
+			SessionID: fields["ID"],
+			User:      fields["User"],
+			Context:   fields["Context"],
+			Cluster:   fields["Cluster"],
+			Namespace: fields["Namespace"],
+			Locations: []string{obj.Location()},
+			Uploaded:  obj.LastModified.UTC().Format(time.RFC3339),
+			Status:    "finished",
+			Source:    "s3",
+		}
+		if t, err := time.Parse(timeFormat, fields["Time"]); err == nil {
+			row.Start = t.Format(time.RFC3339)
+		}
+		if filter.match(row) {
+			row.LegalHold = o.held(row.SessionID)
+			rows = append(rows, row)
+		}
+	}
+	sortReport(rows)
+	return rows, nil
+}
+
+// watchSessions prints the sessions, then the ones that start or finish
+// until the process is interrupted. The tables are printed without headers
+// after the first one, and JSON and YAML as a stream of objects.
+func watchSessions(out io.Writer, o *options, output *outputFlags, filter listFilter, rows []reportRow) error {
+	// statuses are the statuses of the sessions printed, by session ID or
+	// log file for the sessions without an ID
+	statuses := map[string]string{}
+	key := func(row reportRow) string {
+		if row.SessionID != "" {
+			return row.SessionID
+		}
+		return row.LogFile
+	}
+	write := func(rows []reportRow) error {
+		for _, row := range rows {
+			statuses[key(row)] = row.Status
+		}
+		switch output.format {
+		case "json", "yaml":
+			for _, row := range rows {
+				if output.format == "yaml" {
+					fmt.Fprintln(out, "---")
+				}
+				if err := output.encode(out, row); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		err := writeReport(out, output, rows)
+		output.noHeaders = true
+		return err
+	}
+
+	if err := write(rows); err != nil {
+		return err
+	}
+	for {
+		time.Sleep(listWatchInterval)
+		rows, err := listSessions(o, filter)
+		if err != nil {
+			return err
+		}
+		rows = slices.DeleteFunc(rows, func(row reportRow) bool { return statuses[key(row)] == row.Status })
+		if len(rows) > 0 {
+			if err := write(rows); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// logComponents are the components of the internal logs: the session, its
+// PTY and terminal, the sinks, the uploads and the policies such as lockdown
+var logComponents = []string{"session", "pty", "sink", "upload", "policy"}
+
+// logOff is the level of the components that are not logged
+const logOff = slog.LevelError + 4
+
+// logLevels are the levels of the components, def is the level of the
+// components without their own
+type logLevels struct {
+	def        slog.Level
+	components map[string]slog.Level
+}
+
+// parseLogLevels parses the value of --log-level: a level, a list of
+// component=level, or both such as "info,sink=debug,upload=off"
+func parseLogLevels(s string) (logLevels, error) {
+	levels := logLevels{def: logOff, components: map[string]slog.Level{}}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(item, "=")
+		if !ok {
+			component, name = "", item
+		}
+		component = strings.TrimSpace(component)
+		if ok && !slices.Contains(logComponents, component) {
+			return levels, fmt.Errorf("invalid --log-level component %q, expected %s", component, strings.Join(logComponents, ", "))
+		}
+		level, err := parseLogLevel(strings.TrimSpace(name))
+		if err != nil {
+			return levels, err
+		}
+		if ok {
+			levels.components[component] = level
+		} else {
+			levels.def = level
+		}
+	}
+	return levels, nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "off") {
+		return logOff, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn, error or off", s)
+	}
+	return level, nil
+}
+
+// newLogger creates the logger of the internal steps from --log-level, or
+// else --verbose, 1 for info and 2 for debug. The logs are written to the log
+// file of --log-file or to errOut. The returned func closes the log file.
+func newLogger(errOut io.Writer, flags flagValues) (*slog.Logger, func() error, error) {
+	spec := flags.get("log-level")
+	if spec == "" {
+		switch v := flags.get("verbose"); v {
+		case "", "0":
+		case "1":
+			spec = "info"
+		default:
+			if level, err := strconv.Atoi(v); err != nil || level < 0 {
+				return nil, nil, fmt.Errorf("invalid --verbose level %q", v)
+			}
+			spec = "debug"
+		}
+	}
+	levels, err := parseLogLevels(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeLog := func() error { return nil }
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var w io.Writer
+	if path := flags.get("log-file"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open the log file of --log-file: %w", err)
+		}
+		w, closeLog = f, f.Close
+	} else {
+		// the terminal may be in raw mode, and the time is noise there
+		w = rawWriter{errOut}
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+	h := componentHandler{Handler: slog.NewTextHandler(w, opts), levels: levels, level: levels.def}
+	return slog.New(h), closeLog, nil
+}
+
+// componentHandler logs the records whose level is at least the level of
+// their component, set with the component attribute of the logger
+type componentHandler struct {
+	slog.Handler
+	levels logLevels
+	level  slog.Level
+}
+
+func (h componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	level := h.level
+	for _, a := range attrs {
+		if a.Key != "component" {
+			continue
+		}
+		if l, ok := h.levels.components[a.Value.String()]; ok {
+			level = l
+		} else {
+			level = h.levels.def
+		}
+	}
+	return componentHandler{Handler: h.Handler.WithAttrs(attrs), levels: h.levels, level: level}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{Handler: h.Handler.WithGroup(name), levels: h.levels, level: h.level}
+}
+
+// rawWriter ends the lines with \r\n, as a terminal in raw mode does not
+// return to the start of the line
+type rawWriter struct {
+	w io.Writer
+}
+
+func (w rawWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,62 +1,35 @@
 package cmd
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"os/signal"
 	"os/user"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/creack/pty"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
 )
 
 const version = "v1.0.0"
 
-type ExecRec struct {
-	// io
-	stdin  io.Reader
-	stdout io.Writer
-	stderr io.Writer
-
-	// args to forward to kubectl exec
-	args []string
-
-	// runtime state
-	// logDir is the directory to store the log file
-	logDir string
-	// logPath is the path to the log file
-	logPath string
-	// logFile is the log file
-	logFile *os.File
-	// username is the username of the user running the command
-	username string
-	// context is the context of the user running the command
-	context string
-
-	// cmd is the kubectl exec command
-	cmd *exec.Cmd
-	// ptyFile is the PTY file
-	ptyFile *os.File
-	// restoreTTY restores the terminal to its original state
-	restoreTTY func() error
-	// stopSigs stops the signal handlers
-	stopSigs func()
-}
-
 // NewCmd creates a new cobra command
-func NewCmd(streams genericclioptions.IOStreams) *cobra.Command {
+func NewCmd(streams genericclioptions.IOStreams, opts ...Option) *cobra.Command {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	cmd := &cobra.Command{
 		Use:     "execrec [kubectl exec args...]",
-		Version: version,
+		Version: o.version,
 		Short:   "Wrapper around 'kubectl exec' with session recording",
 		Long: `kubectl execrec is a wrapper around 'kubectl exec' that records all session output to a file.
 
@@ -65,255 +38,460 @@ This command forwards all arguments to 'kubectl exec' and captures the session f
 Examples:
   kubectl execrec -n namespace pod-name -it -- bash
   kubectl execrec -n default my-pod -- ls -la
+  kubectl execrec --max-log-size=100M --max-log-size-policy=rotate -n default my-pod -it -- bash
+  kubectl execrec --quiet -n default my-pod -- cat /etc/hostname
+  kubectl execrec --no-record="customer card data, ticket OPS-123" -n payments my-pod -it -- sh
   KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec -n kube-system pod-name -it -- sh
-  KUBECTL_EXECREC_S3_ENDPOINT=https://my-endpoint.com KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec -n kube-system pod-name -it -- sh`,
+  KUBECTL_EXECREC_S3_ENDPOINT=https://my-endpoint.com KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec -n kube-system pod-name -it -- sh
+  KUBECTL_EXECREC_NATS_URL=nats://nats.example.com:4222 kubectl execrec -n default my-pod -it -- bash
+  KUBECTL_EXECREC_FLUENTD_ADDR=fluentd.example.com:24224 kubectl execrec -n default my-pod -it -- bash`,
 		Args:          cobra.ArbitraryArgs,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Detect current context
-			context, err := detectContext(args)
+			flags, kubectlArgs, err := parseFlags(args)
 			if err != nil {
-				// Log the error but continue with default context
-				fmt.Fprintf(streams.ErrOut, "Warning: failed to detect context: %v\n", err)
-				context = "default"
-			}
-
-			rec := &ExecRec{
-				stdin:    streams.In,
-				stdout:   streams.Out,
-				stderr:   streams.ErrOut,
-				args:     args,
-				username: whoami(),
-				context:  context,
-				logDir:   filepath.Join(os.TempDir(), "kubectl-execrec", context),
-			}
-			if err := rec.Prepare(); err != nil {
-				return err
-			}
-			defer rec.CloseLog()
-
-			if err := rec.Start(); err != nil {
-				return err
-			}
-
-			rec.Stream()
-
-			cmdErr := rec.cmd.Wait()
-
-			// Clean up TTY before writing final messages
-			rec.CleanupTTY()
-
-			// Always finish the session to ensure log file is properly closed
-			finishErr := rec.Finish()
-
-			// Handle command error first
-			if cmdErr != nil {
-				return rec.Propagate(cmdErr)
+				return auditFailure(streams, o, args, err)
 			}
-
-			// Then handle finish error
-			return rec.Propagate(finishErr)
+			return execute(streams, o, flags, session{verb: "exec", args: args, kubectlArgs: kubectlArgs})
 		},
 	}
 
+	// the root command loads the config file once its flags are parsed
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if !cmd.HasParent() {
+			return nil
+		}
+		return loadConfig("")
+	}
 	cmd.DisableFlagParsing = true
+	cmd.CompletionOptions.DisableDefaultCmd = true
+	cmd.AddCommand(newListCmd(streams, o))
+	cmd.AddCommand(newStatsCmd(streams, o))
+	cmd.AddCommand(newReportCmd(streams, o))
+	cmd.AddCommand(newHoldCmd(streams, o))
+	cmd.AddCommand(newReleaseCmd(streams, o))
+	cmd.AddCommand(newRmCmd(streams, o))
+	cmd.AddCommand(newRecoverCmd(streams, o))
+	cmd.AddCommand(newAgentCmd(streams, o))
+	cmd.AddCommand(newSyncCmd(streams, o))
+	cmd.AddCommand(newImportCmd(streams, o))
+	cmd.AddCommand(newDecryptCmd(streams))
+	cmd.AddCommand(newReplayCmd(streams))
+	cmd.AddCommand(newShowCmd(streams, o))
+	cmd.AddCommand(newExportCmd(streams))
+	cmd.AddCommand(newConvertCmd(streams))
+	cmd.AddCommand(newBundleCmd(streams, o))
+	cmd.AddCommand(newRunCmd(streams, o))
+	cmd.AddCommand(newSelftestCmd(streams, o))
+	cmd.AddCommand(newDoctorCmd(streams, o))
+	cmd.AddCommand(newConfigCmd(streams, o))
+	cmd.AddCommand(newAuditCmd(streams, o))
 	return cmd
 }
 
-// Prepare log file and write header
-func (r *ExecRec) Prepare() error {
-	// Check os.TempDir()/kubectl-execrec/context exists
-	if _, err := os.Stat(r.logDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(r.logDir, 0o755); err != nil {
-			return fmt.Errorf("failed to create log directory: %w", err)
-		}
-	}
-
-	timestamp := time.Now().Format(time.RFC3339)
-	logFileName := fmt.Sprintf("%s_%s.log", r.username, timestamp)
-	r.logPath = filepath.Join(r.logDir, logFileName)
+// session is the kubectl command recorded by a session
+type session struct {
+	// verb is the kubectl command, exec or run
+	verb string
+	// args are the arguments of kubectl execrec and kubectlArgs the ones
+	// given to kubectl after the verb
+	args        []string
+	kubectlArgs []string
+	// image is the image of the pod created by kubectl run, and deletePod
+	// deletes this pod once the session ended
+	image     string
+	deletePod bool
+	// audit is the entry of the invocation in the audit journal
+	audit *auditEntry
+	// status is the outcome of the session printed with --output json
+	status *sessionStatus
+}
 
-	f, err := os.Create(r.logPath)
-	if err != nil {
-		return fmt.Errorf("failed to create log file: %w", err)
+// execute records a session, appends the invocation to the audit journal
+// whatever its outcome and exits with the exit code of kubectl
+func execute(streams genericclioptions.IOStreams, o *options, flags flagValues, s session) error {
+	s.audit = newAuditEntry(o, s.args)
+	s.audit.DryRun = flags.bool("dry-run")
+	s.status = &sessionStatus{}
+	err := runSession(streams, o, flags, s)
+	s.audit.finish(err)
+	if auditErr := appendAudit(o.auditPath(), s.audit); auditErr != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to append to the audit journal: %v\n", auditErr)
 	}
-	r.logFile = f
-
-	// header
-	command := fmt.Sprintf("kubectl execrec %s", strings.Join(r.args, " "))
-	session := fmt.Sprintf("start=%s user=%s context=%s version=%s", timestamp, r.username, r.context, version)
-	_, err = r.logFile.WriteString(fmt.Sprintf("[command] %s\n[session] %s\n%s\n", command, session, strings.Repeat("=", 80)))
-	if err != nil {
+	if flags.get("output") != "json" {
+		return propagate(err)
+	}
+	// the status replaces the error message, with the same exit code
+	s.status.finish(err)
+	if err := s.status.write(streams.ErrOut); err != nil {
 		return err
 	}
-	return r.logFile.Sync()
+	if s.status.ExitCode != 0 {
+		os.Exit(s.status.ExitCode)
+	}
+	return nil
 }
 
-// Close log file
-func (r *ExecRec) CloseLog() {
-	if r.logFile != nil {
-		r.logFile.Close()
+// runSession records a session of kubectl exec or kubectl run
+func runSession(streams genericclioptions.IOStreams, o *options, flags flagValues, s session) error {
+	if err := loadConfig(flags.get("config")); err != nil {
+		return withStatus("invalid-config", categoryConfig, "check the config file with kubectl execrec config validate", err)
+	}
+	if output := flags.get("output"); output != "" && output != "json" {
+		return withStatus("invalid-flag", categoryConfig, "", fmt.Errorf("invalid --output %q, expected json", output))
 	}
-}
 
-// Start PTY and inherit terminal size
-func (r *ExecRec) Start() error {
-	// build kubectl exec
-	kargs := append([]string{"exec"}, r.args...)
-	r.cmd = exec.Command("kubectl", kargs...)
+	t := parseTarget(s.kubectlArgs)
 
-	// start PTY
-	ptmx, err := pty.Start(r.cmd)
+	// Detect current context
+	context, err := detectContext(s.kubectlArgs)
 	if err != nil {
-		return fmt.Errorf("failed to start PTY: %w", err)
+		// Log the error but continue with default context
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to detect context: %v\n", err)
+		context = "default"
 	}
-	r.ptyFile = ptmx
-
-	// inherit terminal size
-	if err := pty.InheritSize(os.Stdin, r.ptyFile); err != nil {
-		return fmt.Errorf("failed to inherit terminal size: %w", err)
+	// the settings of the profile of the context apply from now on
+	profile, err := config.useContext(context)
+	if err != nil {
+		return withStatus("secret-unavailable", categoryConfig, "check the Vault address and token, e.g. with vault token lookup", err)
 	}
 
-	// raw mode to keep tab works as before
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	recOpts, err := recorderOptions(flags)
 	if err != nil {
-		return fmt.Errorf("failed to put terminal in raw mode: %w", err)
-	}
-	r.restoreTTY = func() error { return term.Restore(int(os.Stdin.Fd()), oldState) }
-
-	// forward SIGINT/SIGTERM to kubectl
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	stop := make(chan struct{})
-
-	go func() {
-		for {
-			select {
-			case <-sigChan:
-				if r.cmd != nil && r.cmd.Process != nil {
-					_ = r.cmd.Process.Signal(syscall.SIGTERM)
-				}
-			case <-stop:
-				return
-			}
-		}
-	}()
-	r.stopSigs = func() {
-		close(stop)
-		signal.Stop(sigChan)
+		return withStatus("invalid-flag", categoryConfig, "", err)
 	}
-	return nil
-}
-
-// Cleanup TTY before writing final messages
-func (r *ExecRec) CleanupTTY() {
-	if r.stopSigs != nil {
-		r.stopSigs()
+	c, err := newConsole(streams.Out, streams.ErrOut, flags)
+	if err != nil {
+		return withStatus("invalid-flag", categoryConfig, "", err)
 	}
-
-	if r.restoreTTY != nil {
-		_ = r.restoreTTY()
+	defer c.close()
+	c.logger("session").Info("settings loaded", "config", config.path, "context", context, "profile", profile)
+
+	title := fmt.Sprintf("kubectl execrec %s", strings.Join(s.args, " "))
+	username := whoami()
+
+	recOpts.SessionID = recorder.NewSessionID(o.now())
+	recOpts.Name = "kubectl"
+	recOpts.Args = append([]string{s.verb}, s.kubectlArgs...)
+	recOpts.Title = title
+	recOpts.User = username
+	recOpts.Context = context
+	recOpts.Cluster = detectCluster(context, t)
+	recOpts.Namespace = detectNamespace(context, t)
+	recOpts.Pod = t.Pod
+	if recOpts.Pod == "" {
+		recOpts.Pod = t.Resource
 	}
-
-	if r.ptyFile != nil {
-		_ = r.ptyFile.Close()
+	recOpts.Image = s.image
+	recOpts.Impersonation = t.impersonation()
+	recOpts.Reason = strings.TrimSpace(flags.get("reason"))
+	if recOpts.Impersonation != nil && recOpts.Reason == "" && isTrue(setting("require-impersonation-reason")) {
+		c.logger("policy").Info("impersonation without a reason rejected", "impersonation", recOpts.Impersonation.String())
+		return withStatus("reason-required", categoryPolicy, "give the reason of the impersonation with --reason",
+			fmt.Errorf("impersonating %s requires a reason, give it with --reason", recOpts.Impersonation))
 	}
-}
-
-// Stream stdout and stderr to terminal and log file
-func (r *ExecRec) Stream() {
-	// PTY => (stdout + log)
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := r.ptyFile.Read(buf)
-			if err != nil {
-				return
-			}
-			if n > 0 {
-				_, _ = r.stdout.Write(buf[:n])
-				_, _ = r.logFile.Write(buf[:n])
-				_ = r.logFile.Sync()
-			}
+	captureLines, err := parseCaptureLines(flags.get("capture-context"))
+	if err != nil {
+		return withStatus("invalid-flag", categoryConfig, "", err)
+	}
+	recOpts.Version = o.version
+	recOpts.LogDir = o.logDir(context)
+	recOpts.Stdin = streams.In
+	recOpts.Stdout = streams.Out
+	recOpts.Stderr = streams.ErrOut
+	recOpts.Now = o.now
+	recOpts.Command = o.command
+	recOpts.FS = o.fs
+	recOpts.Logger = c.log
+	s.audit.SessionID = recOpts.SessionID
+	s.audit.Context = context
+	s.audit.Namespace = recOpts.Namespace
+	s.audit.Pod = recOpts.Pod
+	s.audit.NoRecord = recOpts.NoRecord
+	s.status.SessionID = recOpts.SessionID
+
+	var prof *sessionProfile
+	if flags.bool("profile-session") && !flags.bool("dry-run") {
+		if prof, err = startProfile(recOpts.LogDir, recOpts.SessionID); err != nil {
+			return fmt.Errorf("failed to start profiling: %w", err)
 		}
-	}()
-
-	// stdin => PTY
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := r.stdin.Read(buf)
+		defer func() {
+			files, err := prof.stop()
+			for _, f := range files {
+				c.infof("Profile written to %s\n", f)
+			}
 			if err != nil {
-				return
+				fmt.Fprintf(streams.ErrOut, "Warning: failed to write the profile: %v\n", err)
 			}
-			if n > 0 {
-				_, _ = r.ptyFile.Write(buf[:n])
+		}()
+	}
+	if flags.bool("resume") {
+		resumable, err := resumableArgs(s.kubectlArgs, recOpts.SessionID)
+		if err != nil {
+			return err
+		}
+		recOpts.Args = append([]string{s.verb}, resumable...)
+		if flags.get("retry") == "" {
+			recOpts.Retries = defaultResumeRetries
+		}
+	}
+	// lockdown is checked once for the session or the sessions of the pods,
+	// the dry run reports it instead
+	if config.locked {
+		c.logger("policy").Info("lockdown mode, the session must be sent to a sink or uploaded")
+		if err := checkLockdownFlags(flags, recOpts); err != nil {
+			return withStatus("lockdown", categoryPolicy, "remove the flag, lockdown mode only applies the config file", err)
+		}
+		if !flags.bool("dry-run") {
+			if err := checkSessionLockdown(o, recOpts); err != nil {
+				return withStatus("lockdown", categoryPolicy, "configure a sink or an upload, lockdown mode does not allow local sessions", err)
 			}
 		}
-	}()
-}
+	}
+	if flags.get("pods") != "" || flags.get("selector") != "" {
+		r := podRun{streams: streams, o: o, c: c, opts: recOpts, t: t, args: s.args, kubectlArgs: s.kubectlArgs, audit: s.audit, captureLines: captureLines}
+		return runPods(r, flags)
+	}
+	if flags.bool("dry-run") {
+		return dryRun(streams.Out, o, recOpts)
+	}
 
-// write footer and upload log file to S3 if KUBECTL_EXECREC_S3_BUCKET is set
-func (r *ExecRec) Finish() error {
-	// footer
-	end := fmt.Sprintf("end=%s", time.Now().Format(time.RFC3339))
-	_, err := r.logFile.WriteString(strings.Repeat("=", 80) + "\n")
+	// finish the sessions of a crashed kubectl execrec, they are
+	// uploaded after this session
+	sp := spool{dir: o.spoolDir}
+	recovered, err := sp.recover()
 	if err != nil {
-		return err
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to recover a session: %v\n", err)
 	}
-	_, err = r.logFile.WriteString(fmt.Sprintf("[session] %s\n", end))
-	if err != nil {
-		return err
+	for _, ev := range recovered {
+		fmt.Fprintf(streams.ErrOut, "Warning: the session %s terminated abnormally, it is queued for upload\n", ev.LogFile)
 	}
-	err = r.logFile.Sync()
+
+	// the pre_session hook can veto the session
+	pre := hookInput{
+		Event: recorder.Event{
+			Type:      "pre_session",
+			SessionID: recOpts.SessionID,
+			Command:   title,
+			User:      username,
+			Context:   context,
+			Cluster:   recOpts.Cluster,
+			Version:   o.version,
+			Start:     o.now().Format(time.RFC3339),
+
+			Impersonation: recOpts.Impersonation,
+			Reason:        recOpts.Reason,
+			Image:         recOpts.Image,
+		},
+		Args: s.args,
+	}
+	hook := setting("pre-session-hook")
+	if hook != "" {
+		c.logger("policy").Info("running the pre-session hook", "hook", hook)
+	}
+	if err := runHook(hook, pre, streams.ErrOut); err != nil {
+		return withStatus("session-rejected", categoryPolicy, "see the message of the pre-session hook", fmt.Errorf("session rejected: %w", err))
+	}
+
+	sinks, err := o.sinks()
 	if err != nil {
-		return err
+		return withStatus("sink-unavailable", categorySink, "check the sinks with kubectl execrec doctor", err)
+	}
+	for _, s := range sinks {
+		c.logger("sink").Info("sink configured", "sink", describeSink(s))
+	}
+	if live := liveStream(sinks); live != nil {
+		c.infof("Live stream: %s\n", live.URL())
 	}
+	if recOpts.Impersonation != nil {
+		c.infof("Impersonating %s, the session is recorded as such\n", recOpts.Impersonation)
+	}
+	recOpts.Sinks = sinks
+	rec := recorder.New(recOpts)
+	defer rec.Close()
 
-	if os.Getenv("KUBECTL_EXECREC_S3_BUCKET") != "" {
-		r.HandleS3Upload()
+	if err := rec.Prepare(); err != nil {
+		return withStatus("recording-failed", categoryRecording, "check the free space and the permissions of the log directory with kubectl execrec doctor", err)
+	}
+	s.audit.LogFile = rec.LogPath()
+	s.status.LogFile = rec.LogPath()
+	var running string
+	if recOpts.NoRecord != "" {
+		c.logger("policy").Info("recording skipped", "reason", recOpts.NoRecord)
 	} else {
-		fmt.Fprintf(r.stdout, "Session logged to: %s\n", r.logPath)
+		c.logger("session").Info("recording", "log", rec.LogPath())
+		if running, err = sp.begin(rec.Event("start")); err != nil {
+			fmt.Fprintf(streams.ErrOut, "Warning: failed to track the session for recovery: %v\n", err)
+		}
 	}
-	return nil
-}
 
-// Upload log file to S3 if KUBECTL_EXECREC_S3_BUCKET environment variable is set
-func (r *ExecRec) HandleS3Upload() {
-	// check aws cli is installed
-	if _, err := exec.LookPath("aws"); err != nil {
-		fmt.Fprintf(r.stderr, "aws cli is not installed\n")
-		return
+	// the pod created by kubectl run does not exist yet
+	if recOpts.NoRecord == "" && s.verb == "exec" {
+		capturePod(o.command, t, rec, streams.ErrOut)
+		if captureLines > 0 {
+			captureContext(o.command, t, rec, captureLines, streams.ErrOut)
+		}
 	}
 
-	s3Bucket := os.Getenv("KUBECTL_EXECREC_S3_BUCKET")
+	if err := rec.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return withStatus("kubectl-not-found", categoryKubectl, "install kubectl and add it to the PATH", err)
+		}
+		return withStatus("recording-failed", categoryRecording, "", err)
+	}
+	prof.phase("prepare")
 
-	s3Key := fmt.Sprintf("kubectl-execrec/%s/%s", r.context, filepath.Base(r.logPath))
-	s3Args := []string{"s3", "cp", r.logPath, fmt.Sprintf("s3://%s/%s", s3Bucket, s3Key)}
-	if s3Endpoint := os.Getenv("KUBECTL_EXECREC_S3_ENDPOINT"); s3Endpoint != "" {
-		s3Args = append([]string{"--endpoint-url", s3Endpoint}, s3Args...)
+	err = rec.Wait()
+	prof.phase("stream")
+	if running != "" {
+		_ = sp.done(running)
+	}
+	if s.deletePod {
+		if err := deletePod(o.command, t); err != nil {
+			fmt.Fprintf(streams.ErrOut, "Warning: failed to delete the pod: %v\n", err)
+		} else {
+			c.infof("Pod %s deleted\n", t.Pod)
+		}
+	}
+	ev := rec.Event("end")
+	if compressed, compressErr := compressSession(ev); compressErr != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to compress the session: %v\n", compressErr)
+	} else {
+		ev = compressed
+	}
+	if sealed, sealErr := sealSession(ev); sealErr != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to encrypt the session: %v\n", sealErr)
+	} else {
+		ev = sealed
+	}
+	prof.phase("finish")
+	locations, failures := deliverSession(c, o, sp, ev, streams.ErrOut)
+	if _, agentRunning := sp.agentPID(); !agentRunning {
+		if err := uploadPending(c, sp, o.uploaders); err != nil {
+			fmt.Fprintf(streams.ErrOut, "Warning: failed to upload the pending sessions: %v\n", err)
+		}
+	}
+	prof.phase("upload")
+
+	code := exitCode(err)
+	s.status.LogFile = ev.LogFile
+	s.status.Uploads = locations
+	s.status.UploadFailures = failures
+	s.status.execFailure = ev.ExecFailure
+	image, digest := podImage(ev)
+	entry := indexEntry{
+		SessionID:      ev.SessionID,
+		User:           username,
+		Context:        context,
+		Cluster:        recOpts.Cluster,
+		Namespace:      recOpts.Namespace,
+		Pod:            recOpts.Pod,
+		Container:      t.Container,
+		Image:          image,
+		Command:        title,
+		LogFile:        ev.LogFile,
+		SHA256:         logChecksum(ev.LogFile),
+		Start:          ev.Start,
+		End:            ev.End,
+		ExitCode:       code,
+		ExecFailure:    ev.ExecFailure,
+		Uploads:        locations,
+		UploadFailures: failures,
+		NoRecord:       ev.NoRecord,
+		Impersonation:  ev.Impersonation,
+		Reason:         ev.Reason,
+		ImageDigest:    digest,
+	}
+	if err := appendIndex(o.indexPath, entry); err != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: failed to update the session index: %v\n", err)
 	}
 
-	// Capture stderr to see what the error is
-	var stderr bytes.Buffer
-	uploadCmd := exec.Command("aws", s3Args...)
-	uploadCmd.Stdout = nil
-	uploadCmd.Stderr = &stderr
+	ev.Type = "post_session"
+	post := hookInput{Event: ev, Args: s.args, ExitCode: &code, Uploads: locations}
+	if setting("post-session-hook") != "" {
+		c.logger("session").Info("running the post-session hook", "hook", setting("post-session-hook"))
+	}
+	if hookErr := runHook(setting("post-session-hook"), post, streams.ErrOut); hookErr != nil {
+		fmt.Fprintf(streams.ErrOut, "Warning: %v\n", hookErr)
+	}
 
-	if uploadErr := uploadCmd.Run(); uploadErr == nil {
-		fmt.Fprintf(r.stdout, "\nLog file uploaded to s3://%s/%s\n", s3Bucket, s3Key)
-	} else {
-		fmt.Fprintf(r.stderr, "\nFailed to upload log file to s3://%s/%s\n", s3Bucket, s3Key)
-		if stderr.Len() > 0 {
-			fmt.Fprintf(r.stderr, "AWS CLI error: %s\n", stderr.String())
+	return err
+}
+
+// deliverSession uploads the log file of an ended session, or queues it for
+// the upload agent if it is running, and returns the remote locations and
+// the number of failures
+func deliverSession(c *console, o *options, sp spool, ev recorder.Event, errOut io.Writer) ([]string, int) {
+	if ev.NoRecord != "" {
+		c.infof("Session not recorded: %s\n", ev.NoRecord)
+		return nil, 0
+	}
+	agentPID, agentRunning := sp.agentPID()
+	if !agentRunning {
+		return uploadLog(c, o.uploaders, ev)
+	}
+	// the upload agent uploads the session in the background
+	if err := sp.enqueue(ev); err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to queue the session for upload: %v\n", err)
+		return uploadLog(c, o.uploaders, ev)
+	}
+	c.logger("upload").Info("queued for the upload agent", "pid", agentPID)
+	c.infof("Session logged to: %s\n", ev.LogFile)
+	return nil, 0
+}
+
+// uploadLog uploads the log file to every configured remote storage and
+// returns the remote locations and the number of failures, the local path is
+// printed if nothing is configured or an upload failed
+func uploadLog(c *console, newUploaders func() ([]upload.Uploader, error), ev recorder.Event) ([]string, int) {
+	var locations []string
+	failures := 0
+	// a session that could not be encrypted is never uploaded in plain text
+	ev, err := sealSession(ev)
+	if err != nil {
+		fmt.Fprintf(c.errOut, "Failed to encrypt the session: %v\n", err)
+		c.infof("Session logged to: %s\n", ev.LogFile)
+		return nil, 1
+	}
+	uploaders, err := newUploaders()
+	if err != nil {
+		failures++
+		fmt.Fprintf(c.errOut, "%v\n", err)
+	}
+	for _, u := range uploaders {
+		c.logger("upload").Info("uploading", "uploader", fmt.Sprintf("%T", u))
+		start := time.Now()
+		location, err := u.Upload(ev)
+		if err != nil {
+			failures++
+			if location != "" {
+				fmt.Fprintf(c.errOut, "\nFailed to upload log file to %s\n", location)
+			}
+			fmt.Fprintf(c.errOut, "%v\n", err)
+			continue
 		}
-		fmt.Fprintf(r.stdout, "Session logged to: %s\n", r.logPath)
+		c.logger("upload").Info("uploaded", "location", location, "duration", time.Since(start).Round(time.Millisecond))
+		c.infof("\nLog file uploaded to %s\n", location)
+		locations = append(locations, location)
+	}
+
+	if len(uploaders) == 0 || failures > 0 {
+		c.infof("Session logged to: %s\n", ev.LogFile)
 	}
+	return locations, failures
 }
 
+// timeoutExitCode is the exit code of a command terminated after --timeout,
+// as with timeout(1)
+const timeoutExitCode = 124
+
 // Handle graceful termination (Ctrl+C, Ctrl+D, etc.)
-func (r *ExecRec) Propagate(err error) error {
+func propagate(err error) error {
+	if errors.Is(err, recorder.ErrTimeout) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(timeoutExitCode)
+	}
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
 			// expected codes: 130 (SIGINT), 143 (SIGTERM), 0
@@ -339,6 +517,12 @@ func whoami() string {
 	return "unknown"
 }
 
+// isTrue reports whether an environment variable value enables an option
+func isTrue(v string) bool {
+	b, _ := strconv.ParseBool(v)
+	return b
+}
+
 // detectContext detects the current kubectl context from args or config
 func detectContext(args []string) (string, error) {
 	// First, try to extract context from --context flag in args
@@ -365,3 +549,48 @@ func detectContext(args []string) (string, error) {
 
 	return rawConfig.CurrentContext, nil
 }
+
+// detectCluster returns the cluster of the exec target: the --cluster flag,
+// or else the cluster of the context in the kubeconfig, the name of an EKS
+// cluster for its ARN. It is the context if the cluster is unknown.
+func detectCluster(context string, t target) string {
+	cluster := ""
+	for _, f := range t.KubeFlags {
+		if v, ok := strings.CutPrefix(f, "--cluster="); ok {
+			cluster = v
+		}
+	}
+	if cluster == "" {
+		configFlags := genericclioptions.NewConfigFlags(true)
+		if raw, err := configFlags.ToRawKubeConfigLoader().RawConfig(); err == nil {
+			if c, ok := raw.Contexts[context]; ok {
+				cluster = c.Cluster
+			}
+		}
+	}
+	if cluster == "" {
+		return context
+	}
+	// arn:aws:eks:REGION:ACCOUNT:cluster/NAME
+	if strings.HasPrefix(cluster, "arn:aws") {
+		if _, name, ok := strings.Cut(cluster, ":cluster/"); ok {
+			return name
+		}
+	}
+	return cluster
+}
+
+// detectNamespace returns the namespace of the exec target, from the args or
+// the kubeconfig context
+func detectNamespace(context string, t target) string {
+	if t.Namespace != "" {
+		return t.Namespace
+	}
+	configFlags := genericclioptions.NewConfigFlags(true)
+	configFlags.Context = &context
+	namespace, _, err := configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
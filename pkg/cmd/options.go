@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// Option customizes the command created by NewCmd
+type Option func(*options)
+
+// options holds the dependencies of the command, the defaults are the ones
+// of the kubectl plugin
+type options struct {
+	version   string
+	logDir    func(context string) string
+	indexPath string
+	auditFile string
+	spoolDir  string
+	holdDir   string
+	now       func() time.Time
+	command   func(name string, args ...string) *exec.Cmd
+	fs        recorder.FS
+	sinks     func() ([]recorder.Sink, error)
+	uploaders func() ([]upload.Uploader, error)
+}
+
+func defaultOptions() *options {
+	return &options{
+		version: version,
+		logDir: func(context string) string {
+			return filepath.Join(os.TempDir(), "kubectl-execrec", recorder.SafeFileName(context))
+		},
+		indexPath: filepath.Join(os.TempDir(), "kubectl-execrec", "index.jsonl"),
+		auditFile: filepath.Join(os.TempDir(), "kubectl-execrec", "audit.jsonl"),
+		spoolDir:  filepath.Join(os.TempDir(), "kubectl-execrec", "spool"),
+		holdDir:   filepath.Join(os.TempDir(), "kubectl-execrec", "holds"),
+		now:       time.Now,
+		command:   exec.Command,
+		fs:        recorder.OSFS{},
+		sinks:     newSinks,
+		uploaders: newUploaders,
+	}
+}
+
+// WithVersion sets the version reported by --version and written to the logs
+func WithVersion(v string) Option {
+	return func(o *options) { o.version = v }
+}
+
+// WithLogDir sets the function returning the log directory of a context
+func WithLogDir(f func(context string) string) Option {
+	return func(o *options) { o.logDir = f }
+}
+
+// WithIndexPath sets the path of the session index read by the stats
+// subcommand
+func WithIndexPath(path string) Option {
+	return func(o *options) { o.indexPath = path }
+}
+
+// WithAuditPath sets the path of the audit journal, unless the audit-journal
+// setting is set
+func WithAuditPath(path string) Option {
+	return func(o *options) { o.auditFile = path }
+}
+
+// WithSpoolDir sets the directory keeping the sessions until they are
+// finished and uploaded
+func WithSpoolDir(dir string) Option {
+	return func(o *options) { o.spoolDir = dir }
+}
+
+// WithHoldDir sets the directory of the sessions under legal hold
+func WithHoldDir(dir string) Option {
+	return func(o *options) { o.holdDir = dir }
+}
+
+// WithClock sets the function returning the current time
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithCommandRunner sets the function creating the kubectl command
+func WithCommandRunner(f func(name string, args ...string) *exec.Cmd) Option {
+	return func(o *options) { o.command = f }
+}
+
+// WithFS sets the file system the log files are created in
+func WithFS(fs recorder.FS) Option {
+	return func(o *options) { o.fs = fs }
+}
+
+// WithSinks sets the function creating the sinks of a session, by default the
+// sinks are configured through environment variables
+func WithSinks(f func() ([]recorder.Sink, error)) Option {
+	return func(o *options) { o.sinks = f }
+}
+
+// WithUploaders sets the function creating the uploaders of a session, by
+// default the uploaders are configured through environment variables
+func WithUploaders(f func() ([]upload.Uploader, error)) Option {
+	return func(o *options) { o.uploaders = f }
+}
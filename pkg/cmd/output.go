@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// outputFlags are the -o and --columns flags of the subcommands printing
+// sessions or statistics
+type outputFlags struct {
+	format  string
+	columns []string
+	// formats are the formats of the subcommand
+	formats []string
+	// color colors the headers of the tables
+	color *colorFlags
+	// noHeaders omits the headers of the tables and CSV, e.g. for the
+	// updates of list --watch
+	noHeaders bool
+}
+
+// addOutputFlags adds -o, with the formats of the subcommand and def by
+// default, and --columns
+func addOutputFlags(cmd *cobra.Command, def string, formats ...string) *outputFlags {
+	f := &outputFlags{formats: formats, color: addColorFlags(cmd)}
+	cmd.Flags().StringVarP(&f.format, "output", "o", def, "Output format: "+strings.Join(formats, ", "))
+	cmd.Flags().StringSliceVar(&f.columns, "columns", nil, "Fields to print, named as in the JSON output, e.g. sessionId,user,pod")
+	return f
+}
+
+// validate checks the format, the colors and that the columns are fields
+func (f *outputFlags) validate(fields []string) error {
+	if !slices.Contains(f.formats, f.format) {
+		return fmt.Errorf("invalid output format %q, expected %s", f.format, strings.Join(f.formats, ", "))
+	}
+	if _, err := f.color.colors(io.Discard); err != nil {
+		return err
+	}
+	for _, c := range f.columns {
+		if !slices.ContainsFunc(fields, func(field string) bool { return sameField(field, c) }) {
+			return fmt.Errorf("invalid column %q, expected %s", c, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
+
+// sameField tells if a column names a field, ignoring the case, "-", "_"
+// and spaces, so that session_id and "SESSION ID" name sessionId
+func sameField(field, column string) bool {
+	norm := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(s))
+	}
+	return norm(field) == norm(column)
+}
+
+// fieldWords splits the name of a field in words, e.g. sessionId in session
+// and id
+func fieldWords(field string) []string {
+	var words []string
+	start := 0
+	for i, r := range field {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(field[start:i]))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(field[start:]))
+}
+
+// encode writes v as JSON or YAML, with only the fields of the columns if
+// any, in their order. v is an object or a list of objects.
+func (f *outputFlags) encode(out io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(f.columns) > 0 {
+		if data, err = f.project(data); err != nil {
+			return err
+		}
+	}
+	if f.format == "yaml" {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(out)
+	return err
+}
+
+// project keeps the fields of the columns of a JSON object, or of the
+// objects of a JSON list
+func (f *outputFlags) project(data []byte) ([]byte, error) {
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &list); err == nil {
+		var b bytes.Buffer
+		b.WriteByte('[')
+		for i, obj := range list {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			f.writeObject(&b, obj)
+		}
+		b.WriteByte(']')
+		return b.Bytes(), nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	f.writeObject(&b, obj)
+	return b.Bytes(), nil
+}
+
+func (f *outputFlags) writeObject(b *bytes.Buffer, obj map[string]json.RawMessage) {
+	b.WriteByte('{')
+	n := 0
+	for _, c := range f.columns {
+		for key, value := range obj {
+			if !sameField(key, c) {
+				continue
+			}
+			if n > 0 {
+				b.WriteByte(',')
+			}
+			name, _ := json.Marshal(key)
+			b.Write(name)
+			b.WriteByte(':')
+			b.Write(value)
+			n++
+		}
+	}
+	b.WriteByte('}')
+}
+
+// tableColumn is a column of the table and CSV outputs, field is its name
+// in the JSON output
+type tableColumn struct {
+	field string
+	// wide columns are only printed with -o wide or --columns
+	wide  bool
+	value func(i int) string
+}
+
+// printedColumns returns the columns of --columns in their order, or else
+// every column but the wide ones of the table output
+func (f *outputFlags) printedColumns(columns []tableColumn) []tableColumn {
+	var printed []tableColumn
+	if len(f.columns) == 0 {
+		for _, c := range columns {
+			if !c.wide || f.format != "table" {
+				printed = append(printed, c)
+			}
+		}
+		return printed
+	}
+	for _, name := range f.columns {
+		for _, c := range columns {
+			if sameField(c.field, name) {
+				printed = append(printed, c)
+			}
+		}
+	}
+	return printed
+}
+
+// writeTable writes n rows as a table, its headers are the fields in upper
+// case, e.g. SESSION ID
+func (f *outputFlags) writeTable(out io.Writer, n int, columns []tableColumn) error {
+	c, err := f.color.colors(out)
+	if err != nil {
+		return err
+	}
+	columns = f.printedColumns(columns)
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	headers := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = strings.ToUpper(strings.Join(fieldWords(c.field), " "))
+	}
+	if !f.noHeaders {
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+	}
+	for i := 0; i < n; i++ {
+		values := make([]string, len(columns))
+		for j, c := range columns {
+			values[j] = c.value(i)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return c.paintLines(out, buf.Bytes(), func(i int, line string) string {
+		if i == 0 && !f.noHeaders {
+			return c.paint(colorHeader, line)
+		}
+		return line
+	})
+}
+
+// writeCSV writes n rows as CSV, its headers are the fields in snake case,
+// e.g. session_id
+func (f *outputFlags) writeCSV(out io.Writer, n int, columns []tableColumn) error {
+	columns = f.printedColumns(columns)
+	w := csv.NewWriter(out)
+	headers := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = strings.Join(fieldWords(c.field), "_")
+	}
+	if !f.noHeaders {
+		if err := w.Write(headers); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		values := make([]string, len(columns))
+		for j, c := range columns {
+			values[j] = c.value(i)
+		}
+		if err := w.Write(values); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// columnFields returns the fields of columns
+func columnFields(columns []tableColumn) []string {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = c.field
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// pager writes the output of a subcommand to a pager showing it on out
+type pager struct {
+	in  io.WriteCloser
+	out io.Writer
+}
+
+// Write writes to the pager, the output is dropped once the user quit it
+func (p *pager) Write(b []byte) (int, error) {
+	_, _ = p.in.Write(b)
+	return len(b), nil
+}
+
+// pageOutput returns the writer of the output of a subcommand and the
+// function to call once it is written. The output is piped through a pager
+// if out is a terminal, unless noPager is set: the pager setting, then
+// PAGER, less by default, with LESS=FRX if LESS is not set so that it exits
+// if the output fits on the screen and shows the colors. A pager set to ""
+// or cat, or that cannot be started, is not used.
+func pageOutput(o *options, out io.Writer, noPager bool) (io.Writer, func()) {
+	file, ok := out.(*os.File)
+	if noPager || !ok || !term.IsTerminal(int(file.Fd())) {
+		return out, func() {}
+	}
+	command := setting("pager")
+	if command == "" {
+		command = os.Getenv("PAGER")
+	}
+	if command == "" {
+		command = "less"
+		if runtime.GOOS == "windows" {
+			command = "more"
+		}
+	}
+	args := strings.Fields(command)
+	if len(args) == 0 || args[0] == "cat" {
+		return out, func() {}
+	}
+
+	cmd := o.command(args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return out, func() {}
+	}
+	if err := cmd.Start(); err != nil {
+		return out, func() {}
+	}
+	return &pager{in: in, out: out}, func() {
+		in.Close()
+		_ = cmd.Wait()
+	}
+}
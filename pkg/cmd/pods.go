@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// podRun runs a command on several pods at once, given with --pods or
+// --selector: the output of every pod is shown with a "[pod] " prefix and
+// every pod has its own session and log file, the sessions share a group ID
+// and a single entry of the session index
+type podRun struct {
+	streams genericclioptions.IOStreams
+	o       *options
+	c       *console
+	// opts are the recording options of the sessions, without their pod
+	opts recorder.Options
+	t    target
+	// args are the arguments of kubectl execrec and kubectlArgs the ones
+	// forwarded to kubectl exec, without the pod
+	args        []string
+	kubectlArgs []string
+	audit       *auditEntry
+	// captureLines are the log lines captured by --capture-context
+	captureLines int
+}
+
+// podSession is the session of a pod of a podRun
+type podSession struct {
+	ev  recorder.Event
+	err error
+}
+
+// runPods runs the command on the pods of --pods or --selector
+func runPods(r podRun, flags flagValues) error {
+	if r.t.Resource != "" {
+		return fmt.Errorf("the pod %s cannot be given with --pods or --selector", r.t.Resource)
+	}
+	if interactive(r.kubectlArgs) {
+		return fmt.Errorf("--pods and --selector do not support -i and -t")
+	}
+	if len(r.t.Command) == 0 {
+		return fmt.Errorf("--pods and --selector require a command after --")
+	}
+	pods, err := selectPods(r.o.command, r.t, flags.get("pods"), flags.get("selector"))
+	if err != nil {
+		return err
+	}
+
+	r.opts.Group = recorder.NewSessionID(r.o.now())
+	r.audit.SessionID = r.opts.Group
+	r.audit.Pod = strings.Join(pods, ",")
+	r.opts.NoPTY = true
+	r.opts.Stdin = strings.NewReader("")
+	if flags.bool("dry-run") {
+		fmt.Fprintf(r.streams.Out, "Pods: %s\n", strings.Join(pods, ", "))
+		return dryRun(r.streams.Out, r.o, r.podOptions(pods[0], nil, nil))
+	}
+
+	pre := hookInput{
+		Event: recorder.Event{
+			Type:      "pre_session",
+			SessionID: r.opts.Group,
+			Command:   r.opts.Title,
+			User:      r.opts.User,
+			Context:   r.opts.Context,
+			Cluster:   r.opts.Cluster,
+			Namespace: r.opts.Namespace,
+			Version:   r.opts.Version,
+			Start:     r.o.now().Format(time.RFC3339),
+			Group:     r.opts.Group,
+
+			Impersonation: r.opts.Impersonation,
+			Reason:        r.opts.Reason,
+		},
+		Args: r.args,
+	}
+	if err := runHook(setting("pre-session-hook"), pre, r.streams.ErrOut); err != nil {
+		return fmt.Errorf("session rejected: %w", err)
+	}
+	r.c.logger("session").Info("running on several pods", "pods", len(pods), "group", r.opts.Group)
+
+	// the lines of the pods are written whole to the terminal
+	var mu sync.Mutex
+	sp := spool{dir: r.o.spoolDir}
+	sessions := make([]podSession, len(pods))
+	outputs := make([]*prefixWriter, 0, 2*len(pods))
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		stdout := &prefixWriter{mu: &mu, w: r.streams.Out, prefix: "[" + pod + "] "}
+		stderr := &prefixWriter{mu: &mu, w: r.streams.ErrOut, prefix: "[" + pod + "] "}
+		outputs = append(outputs, stdout, stderr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sessions[i] = r.runPod(sp, r.podOptions(pod, stdout, stderr))
+		}()
+	}
+	wg.Wait()
+	for _, w := range outputs {
+		w.flush()
+	}
+
+	entry := indexEntry{
+		SessionID:     r.opts.Group,
+		User:          r.opts.User,
+		Context:       r.opts.Context,
+		Cluster:       r.opts.Cluster,
+		Namespace:     r.opts.Namespace,
+		Container:     r.t.Container,
+		Command:       r.opts.Title,
+		Impersonation: r.opts.Impersonation,
+		Reason:        r.opts.Reason,
+	}
+	var firstErr error
+	for i, s := range sessions {
+		if s.err != nil {
+			fmt.Fprintf(r.streams.ErrOut, "[%s] %v\n", pods[i], s.err)
+		}
+		var locations []string
+		var failures int
+		// a session that did not start has nothing to deliver
+		if s.ev.End != "" {
+			locations, failures = deliverSession(r.c, r.o, sp, s.ev, r.streams.ErrOut)
+		}
+		code := exitCode(s.err)
+		if firstErr == nil && s.err != nil {
+			firstErr = s.err
+			entry.ExitCode = code
+			entry.ExecFailure = s.ev.ExecFailure
+		}
+		entry.Uploads = append(entry.Uploads, locations...)
+		entry.UploadFailures += failures
+		if entry.Start == "" || (s.ev.Start != "" && s.ev.Start < entry.Start) {
+			entry.Start = s.ev.Start
+		}
+		entry.End = max(entry.End, s.ev.End)
+		image, digest := podImage(s.ev)
+		entry.Sessions = append(entry.Sessions, indexSession{
+			Pod:         pods[i],
+			SessionID:   s.ev.SessionID,
+			LogFile:     s.ev.LogFile,
+			SHA256:      logChecksum(s.ev.LogFile),
+			ExitCode:    code,
+			ExecFailure: s.ev.ExecFailure,
+			Uploads:     locations,
+			Image:       image,
+			ImageDigest: digest,
+		})
+
+		ev := s.ev
+		ev.Type = "post_session"
+		post := hookInput{Event: ev, Args: r.args, ExitCode: &code, Uploads: locations}
+		if hookErr := runHook(setting("post-session-hook"), post, r.streams.ErrOut); hookErr != nil {
+			fmt.Fprintf(r.streams.ErrOut, "Warning: %v\n", hookErr)
+		}
+	}
+	if _, agentRunning := sp.agentPID(); !agentRunning {
+		if err := uploadPending(r.c, sp, r.o.uploaders); err != nil {
+			fmt.Fprintf(r.streams.ErrOut, "Warning: failed to upload the pending sessions: %v\n", err)
+		}
+	}
+	if err := appendIndex(r.o.indexPath, entry); err != nil {
+		fmt.Fprintf(r.streams.ErrOut, "Warning: failed to update the session index: %v\n", err)
+	}
+	return firstErr
+}
+
+// podOptions returns the recording options of the session of a pod
+func (r podRun) podOptions(pod string, stdout, stderr io.Writer) recorder.Options {
+	opts := r.opts
+	opts.SessionID = recorder.NewSessionID(r.o.now())
+	opts.Pod = pod
+	opts.Args = append([]string{"exec"}, withPod(r.kubectlArgs, pod)...)
+	if stdout != nil {
+		opts.Stdout = stdout
+		opts.Stderr = stderr
+	}
+	return opts
+}
+
+// runPod records the session of a pod
+func (r podRun) runPod(sp spool, opts recorder.Options) podSession {
+	sinks, err := r.o.sinks()
+	if err != nil {
+		return podSession{err: err}
+	}
+	if live := liveStream(sinks); live != nil {
+		fmt.Fprintf(opts.Stderr, "Live stream: %s\n", live.URL())
+	}
+	opts.Sinks = sinks
+	rec := recorder.New(opts)
+	defer rec.Close()
+	if err := rec.Prepare(); err != nil {
+		return podSession{ev: rec.Event("end"), err: err}
+	}
+	running := ""
+	if opts.NoRecord == "" {
+		if running, err = sp.begin(rec.Event("start")); err != nil {
+			fmt.Fprintf(opts.Stderr, "Warning: failed to track the session for recovery: %v\n", err)
+		}
+	}
+	if opts.NoRecord == "" {
+		t := r.t
+		t.Pod, t.Resource = opts.Pod, opts.Pod
+		capturePod(r.o.command, t, rec, opts.Stderr)
+		if r.captureLines > 0 {
+			captureContext(r.o.command, t, rec, r.captureLines, opts.Stderr)
+		}
+	}
+
+	if err = rec.Start(); err == nil {
+		err = rec.Wait()
+	}
+	if running != "" {
+		_ = sp.done(running)
+	}
+	ev := rec.Event("end")
+	if compressed, compressErr := compressSession(ev); compressErr != nil {
+		fmt.Fprintf(opts.Stderr, "Warning: failed to compress the session: %v\n", compressErr)
+	} else {
+		ev = compressed
+	}
+	if sealed, sealErr := sealSession(ev); sealErr != nil {
+		fmt.Fprintf(opts.Stderr, "Warning: failed to encrypt the session: %v\n", sealErr)
+	} else {
+		ev = sealed
+	}
+	return podSession{ev: ev, err: err}
+}
+
+// selectPods returns the pods of a comma-separated list, or else the running
+// pods matching a label selector
+func selectPods(command func(string, ...string) *exec.Cmd, t target, list, selector string) ([]string, error) {
+	var pods []string
+	for _, pod := range strings.Split(list, ",") {
+		if pod = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(pod), "pod/")); pod != "" {
+			pods = append(pods, pod)
+		}
+	}
+	if selector == "" {
+		if len(pods) == 0 {
+			return nil, fmt.Errorf("--pods lists no pod")
+		}
+		return pods, nil
+	}
+	if len(pods) > 0 {
+		return nil, fmt.Errorf("--pods and --selector cannot be used together")
+	}
+
+	args := append([]string{"get", "pods", "--selector", selector, "--field-selector", "status.phase=Running", "-o", "name"}, t.KubeFlags...)
+	var stdout, stderr bytes.Buffer
+	get := command("kubectl", args...)
+	get.Stdout = &stdout
+	get.Stderr = &stderr
+	if err := get.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("kubectl get pods: %s", strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("kubectl get pods: %w", err)
+	}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if pod := strings.TrimPrefix(strings.TrimSpace(line), "pod/"); pod != "" {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no running pod matches the selector %s", selector)
+	}
+	return pods, nil
+}
+
+// withPod returns the kubectl exec arguments with the pod before the command
+func withPod(args []string, pod string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return append(append(append([]string(nil), args[:i]...), pod), args[i:]...)
+		}
+	}
+	return append(append([]string(nil), args...), pod)
+}
+
+// prefixWriter writes the complete lines of a pod with a prefix, the lines
+// of all the pods are written under mu
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	// line is the incomplete last line
+	line []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = append(p.line, b...)
+	end := bytes.LastIndexByte(p.line, '\n') + 1
+	if end > 0 {
+		p.write(p.line[:end])
+		p.line = append(p.line[:0], p.line[end:]...)
+	}
+	return len(b), nil
+}
+
+// flush writes the incomplete last line
+func (p *prefixWriter) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.line) > 0 {
+		p.write(append(p.line, '\n'))
+		p.line = nil
+	}
+}
+
+// write writes complete lines with the prefix, p.mu must be held
+func (p *prefixWriter) write(lines []byte) {
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) > 0 {
+			b.WriteString(p.prefix)
+			b.Write(line)
+		}
+	}
+	_, _ = p.w.Write(b.Bytes())
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionProfile profiles kubectl execrec during a session for
+// --profile-session: a CPU profile of the whole session, a heap profile at
+// its end and the time spent in each phase, written next to the log files
+type sessionProfile struct {
+	// prefix is the path of the files without their extension
+	prefix string
+	cpu    *os.File
+	// last is the end of the last phase
+	start  time.Time
+	last   time.Time
+	phases []profilePhase
+}
+
+type profilePhase struct {
+	name     string
+	duration time.Duration
+}
+
+// startProfile starts the CPU profile of a session
+func startProfile(dir, sessionID string) (*sessionProfile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p := &sessionProfile{prefix: filepath.Join(dir, "profile_"+sessionID), start: now, last: now}
+	f, err := os.Create(p.prefix + ".cpu.pprof")
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	p.cpu = f
+	return p, nil
+}
+
+// phase ends a phase of the session, a nil profile does nothing
+func (p *sessionProfile) phase(name string) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	p.phases = append(p.phases, profilePhase{name, now.Sub(p.last)})
+	p.last = now
+}
+
+// stop stops the CPU profile, writes the heap profile and the timings, and
+// returns the files written
+func (p *sessionProfile) stop() ([]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+	pprof.StopCPUProfile()
+	errs := []error{p.cpu.Close()}
+	files := []string{p.cpu.Name()}
+
+	heap, err := os.Create(p.prefix + ".heap.pprof")
+	if err == nil {
+		runtime.GC()
+		err = errors.Join(pprof.WriteHeapProfile(heap), heap.Close())
+		files = append(files, heap.Name())
+	}
+	errs = append(errs, err)
+
+	timing, err := os.Create(p.prefix + ".timing.txt")
+	if err == nil {
+		w := tabwriter.NewWriter(timing, 0, 0, 2, ' ', 0)
+		for _, phase := range p.phases {
+			fmt.Fprintf(w, "%s\t%s\n", phase.name, phase.duration.Round(time.Microsecond))
+		}
+		fmt.Fprintf(w, "total\t%s\n", time.Since(p.start).Round(time.Microsecond))
+		err = errors.Join(w.Flush(), timing.Close())
+		files = append(files, timing.Name())
+	}
+	errs = append(errs, err)
+	return files, errors.Join(errs...)
+}
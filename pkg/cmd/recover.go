@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func newRecoverCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "recover",
+		Short: "Finish and upload the sessions that terminated abnormally",
+		Long: `Finish the sessions whose kubectl execrec process crashed or was killed with a "terminated abnormally" footer, then upload them and the other sessions waiting to be uploaded.
+
+Orphaned sessions are also recovered when the next session starts and uploaded once it ends.
+
+Examples:
+  kubectl execrec recover
+  KUBECTL_EXECREC_S3_BUCKET=my-bucket kubectl execrec recover`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := &console{out: streams.Out, errOut: streams.ErrOut}
+			sp := spool{dir: o.spoolDir}
+			recovered, err := sp.recover()
+			for _, ev := range recovered {
+				fmt.Fprintf(streams.Out, "Recovered the session %s\n", ev.LogFile)
+			}
+			if err != nil {
+				fmt.Fprintf(streams.ErrOut, "Warning: failed to recover a session: %v\n", err)
+			}
+			if pid, ok := sp.agentPID(); ok {
+				fmt.Fprintf(streams.Out, "The upload agent with pid %d uploads the recovered sessions\n", pid)
+				return nil
+			}
+			return uploadPending(c, sp, o.uploaders)
+		},
+	}
+}
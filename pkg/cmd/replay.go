@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/playback"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+func newReplayCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var (
+		speed   float64
+		maxWait time.Duration
+		context string
+		color   *colorFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "replay SOURCE",
+		Short: "Replay a recorded session",
+		Long: `Replay a recorded session in the terminal. The source is a local log file, an s3://bucket/key object or an http(s) URL, remote recordings are streamed with the credentials of the uploads: the s3-* settings for S3 objects, and the settings of the WebDAV or HTTP upload for the URLs under their URL. Log files encrypted with Vault are decrypted.
+
+The log file has the times of the prompts and of the terminal resizes, the replay waits between them for the time that elapsed divided by --speed, at most --max-wait.
+
+The session, its user, context, start and command, is printed on stderr before the replay, colored on a terminal. The recorded output is replayed as recorded, with its own colors.
+
+Examples:
+  kubectl execrec replay /tmp/kubectl-execrec/prod/alice_20250810T143332+0900_01K2B3QZ7YHX4N6R8TVA2C5DEF.log
+  kubectl execrec replay s3://my-bucket/kubectl-execrec/prod/alice_20250810T143332+0900_01K2B3QZ7YHX4N6R8TVA2C5DEF.log
+  kubectl execrec replay --context prod --speed 4 s3://my-bucket/kubectl-execrec/prod/session.log.vault`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if speed <= 0 {
+				return fmt.Errorf("invalid --speed %v, it must be positive", speed)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			c, err := color.colors(streams.ErrOut)
+			if err != nil {
+				return err
+			}
+			rc, err := openRecording(args[0])
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			r, err := playback.NewReader(rc)
+			if err != nil {
+				return err
+			}
+			if header := replayHeader(c, r.Header); header != "" {
+				fmt.Fprintln(streams.ErrOut, header)
+			}
+			return replay(streams.Out, r, speed, maxWait)
+		},
+	}
+	cmd.Flags().Float64Var(&speed, "speed", 1, "Replay speed factor")
+	cmd.Flags().DurationVar(&maxWait, "max-wait", 2*time.Second, "Maximum wait between two prompts or resizes")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	color = addColorFlags(cmd)
+	return cmd
+}
+
+// replayHeader describes the session replayed, e.g. "Session 01K2B3QZ7Y of
+// alice on prod at 2025-08-10T14:33:32+09:00: kubectl exec -n default web-0
+// -it -- bash", empty for a part of a rotated log file which has no header
+func replayHeader(c colors, h playback.Header) string {
+	if h.Command == "" && len(h.Fields) == 0 {
+		return ""
+	}
+	header := c.paint(colorHeader, strings.TrimSpace("Session "+h.Fields["id"]))
+	for _, field := range [][2]string{{"of", "user"}, {"on", "context"}, {"at", "start"}} {
+		if v := h.Fields[field[1]]; v != "" {
+			header += " " + field[0] + " " + v
+		}
+	}
+	if h.Command != "" {
+		header += ": " + c.paint(colorDim, h.Command)
+	}
+	return header
+}
+
+// replay writes the output of a log file, waiting between the markers with
+// a time for the time that elapsed
+func replay(w io.Writer, r *playback.Reader, speed float64, maxWait time.Duration) error {
+	last, timed := r.Header.Start()
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.Marker == "" {
+			if _, err := w.Write(f.Output); err != nil {
+				return err
+			}
+			continue
+		}
+		t, ok := f.Time()
+		if !ok {
+			continue
+		}
+		if timed {
+			time.Sleep(min(time.Duration(float64(t.Sub(last))/speed), maxWait))
+		}
+		last, timed = t, true
+	}
+}
+
+// openRecording opens a log file to replay or export: a local file, an
+// s3://bucket/key object or an http(s) URL, read with the settings of the
+// uploads. Log files encrypted with Vault are decrypted.
+func openRecording(source string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	var err error
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(source, "s3://"), "/")
+		if bucket == "" || key == "" {
+			return nil, fmt.Errorf("invalid S3 object %q, expected s3://bucket/key", source)
+		}
+		s3, err := newS3Uploader(bucket)
+		if err != nil {
+			return nil, err
+		}
+		rc, err = s3.Open(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(source, "https://"), strings.HasPrefix(source, "http://"):
+		if rc, err = openURL(source); err != nil {
+			return nil, err
+		}
+	default:
+		if rc, err = os.Open(source); err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasSuffix(source, vault.Ext) {
+		client, err := vault.NewClient()
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func(rc io.ReadCloser) {
+			err := vault.Decrypt(client, pw, rc)
+			rc.Close()
+			pw.CloseWithError(err)
+		}(rc)
+		rc = pr
+	}
+	if !strings.HasSuffix(strings.TrimSuffix(source, vault.Ext), compress.Ext) {
+		return rc, nil
+	}
+	zr, err := compress.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("invalid compressed log file: %w", err)
+	}
+	return readCloser{zr, rc}, nil
+}
+
+// readCloser reads a decompressed recording and closes its source
+type readCloser struct {
+	io.Reader
+	source io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.source.Close()
+}
+
+// openURL opens a remote log file, the credentials of the WebDAV or HTTP
+// upload are only sent to the URLs under their URL
+func openURL(rawURL string) (io.ReadCloser, error) {
+	if base := setting("webdav-url"); base != "" && underURL(rawURL, base) {
+		u := &upload.WebDAV{
+			User:     setting("webdav-user"),
+			Password: setting("webdav-password"),
+			Token:    setting("webdav-token"),
+			TLS:      httpTLS("webdav"),
+		}
+		return u.Open(rawURL)
+	}
+	if base := setting("http-url"); base != "" && underURL(rawURL, base) {
+		headers, err := upload.ParseHeaders(setting("http-headers"))
+		if err != nil {
+			return nil, err
+		}
+		u := &upload.HTTP{Token: setting("http-token"), Headers: headers, TLS: httpTLS("http")}
+		return u.Open(rawURL)
+	}
+	return (&upload.HTTP{}).Open(rawURL)
+}
+
+// underURL tells if rawURL has the scheme and host of base and is under the
+// static part of its path, base may be a template such as
+// "https://audit.example.com/api/{{.Context}}/{{.File}}"
+func underURL(rawURL, base string) bool {
+	if i := strings.Index(base, "{{"); i >= 0 {
+		base = base[:i]
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == b.Scheme && u.Host == b.Host && strings.HasPrefix(u.Path, b.Path)
+}
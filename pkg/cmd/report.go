@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// reportRow is a session in a compliance report
+type reportRow struct {
+	SessionID string `json:"sessionId"`
+	// Group is the run of the session when run on several pods
+	Group     string `json:"group,omitempty"`
+	User      string `json:"user"`
+	Context   string `json:"context"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Start     string `json:"start,omitempty"`
+	End       string `json:"end,omitempty"`
+	// ExitCode is unknown for the sessions only found in S3
+	ExitCode *int   `json:"exitCode,omitempty"`
+	NoRecord string `json:"noRecord,omitempty"`
+	LogFile  string `json:"logFile,omitempty"`
+	// Locations are the remote copies of the log file
+	Locations []string `json:"locations,omitempty"`
+	SHA256    string   `json:"sha256,omitempty"`
+	// ChecksumMismatch is set if an S3 copy has another checksum than the
+	// one recorded in the index
+	ChecksumMismatch bool `json:"checksumMismatch,omitempty"`
+	// LegalHold is set if the session is under legal hold
+	LegalHold bool `json:"legalHold,omitempty"`
+	// Uploaded is the time the log file was uploaded to S3
+	Uploaded string `json:"uploaded,omitempty"`
+	// Status is active for a session being recorded, failed-upload for a
+	// finished session whose log file was not uploaded by every uploader,
+	// finished otherwise
+	Status string `json:"status"`
+	// Source is index, s3 or both, or spool for an active session
+	Source string `json:"source"`
+}
+
+// sessionStatuses are the statuses of the sessions
+var sessionStatuses = []string{"active", "finished", "failed-upload"}
+
+// reportColumns are the columns of the sessions printed by report and
+// list, the wide ones are only in the CSV and wide outputs
+func reportColumns(rows []reportRow) []tableColumn {
+	flag := func(b bool) string {
+		if b {
+			return "true"
+		}
+		return ""
+	}
+	return []tableColumn{
+		{field: "sessionId", value: func(i int) string { return rows[i].SessionID }},
+		{field: "group", wide: true, value: func(i int) string { return rows[i].Group }},
+		{field: "user", value: func(i int) string { return rows[i].User }},
+		{field: "context", value: func(i int) string { return rows[i].Context }},
+		{field: "cluster", wide: true, value: func(i int) string { return rows[i].Cluster }},
+		{field: "namespace", value: func(i int) string { return rows[i].Namespace }},
+		{field: "pod", value: func(i int) string { return rows[i].Pod }},
+		{field: "container", wide: true, value: func(i int) string { return rows[i].Container }},
+		{field: "command", wide: true, value: func(i int) string { return rows[i].Command }},
+		{field: "start", value: func(i int) string { return rows[i].Start }},
+		{field: "end", wide: true, value: func(i int) string { return rows[i].End }},
+		{field: "exitCode", value: func(i int) string {
+			if rows[i].ExitCode == nil {
+				return ""
+			}
+			return strconv.Itoa(*rows[i].ExitCode)
+		}},
+		{field: "noRecord", wide: true, value: func(i int) string { return rows[i].NoRecord }},
+		{field: "logFile", wide: true, value: func(i int) string { return rows[i].LogFile }},
+		{field: "locations", wide: true, value: func(i int) string { return strings.Join(rows[i].Locations, " ") }},
+		{field: "sha256", wide: true, value: func(i int) string { return rows[i].SHA256 }},
+		{field: "checksumMismatch", wide: true, value: func(i int) string { return flag(rows[i].ChecksumMismatch) }},
+		{field: "legalHold", wide: true, value: func(i int) string { return flag(rows[i].LegalHold) }},
+		{field: "uploaded", wide: true, value: func(i int) string { return rows[i].Uploaded }},
+		{field: "status", value: func(i int) string { return rows[i].Status }},
+		{field: "source", wide: true, value: func(i int) string { return rows[i].Source }},
+	}
+}
+
+// writeReport writes the sessions of report or list in the output format
+func writeReport(out io.Writer, output *outputFlags, rows []reportRow) error {
+	switch output.format {
+	case "json", "yaml":
+		if rows == nil {
+			rows = []reportRow{}
+		}
+		return output.encode(out, rows)
+	case "csv":
+		return output.writeCSV(out, len(rows), reportColumns(rows))
+	}
+	return output.writeTable(out, len(rows), reportColumns(rows))
+}
+
+func newReportCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var since, until, source, context string
+	var noPager bool
+	var output *outputFlags
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report the recorded sessions of a period for audits",
+		Long: `Report every session of a period: who ran what, where and when, where the log file is stored and its SHA-256.
+
+The sessions are read from the session index of this machine, from the S3 bucket of the uploads with the s3-* settings, or both. The sessions only found in S3 are dated by their upload and have no exit code. With both sources, a session whose S3 copy has another checksum than the one recorded in the index is reported as a checksum mismatch.
+
+--since and --until are dates such as 2024-01-01, both included, times such as 2024-01-01T09:00:00Z or durations before now such as 30d.
+
+On a terminal the report is shown in a pager, the pager setting or PAGER, less by default, unless --no-pager is given.
+
+Examples:
+  kubectl execrec report --since 2024-01-01 --until 2024-03-31
+  kubectl execrec report --since 90d -o json
+  kubectl execrec report --since 30d -o wide --columns sessionId,user,pod,legalHold
+  kubectl execrec report --since 2024-01-01 --until 2024-03-31 --source all --context prod`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.validate(columnFields(reportColumns(nil))); err != nil {
+				return err
+			}
+			if source != "index" && source != "s3" && source != "all" {
+				return fmt.Errorf("invalid source %q, expected index, s3 or all", source)
+			}
+			from, err := parseReportTime(since, o.now(), false)
+			if err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
+			}
+			to, err := parseReportTime(until, o.now(), true)
+			if err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+
+			var rows []reportRow
+			if source != "s3" {
+				entries, err := readIndex(o.indexPath)
+				if err != nil {
+					return fmt.Errorf("failed to read the session index: %w", err)
+				}
+				rows = indexReport(entries, from, to)
+			}
+			if source != "index" {
+				bucket := setting("s3-bucket")
+				if bucket == "" {
+					return fmt.Errorf("no S3 bucket, set KUBECTL_EXECREC_S3_BUCKET")
+				}
+				s3, err := newS3Uploader(bucket)
+				if err != nil {
+					return err
+				}
+				objects, err := s3.List(func(obj upload.S3Object) bool {
+					return isLogObject(obj.Key) && inPeriod(obj.LastModified, from, to)
+				})
+				if err != nil {
+					return fmt.Errorf("failed to list the S3 bucket: %w", err)
+				}
+				rows = mergeS3Report(rows, objects)
+			}
+			for i := range rows {
+				rows[i].LegalHold = o.held(rows[i].SessionID, rows[i].Group)
+			}
+			sortReport(rows)
+			out, wait := pageOutput(o, streams.Out, noPager)
+			defer wait()
+			return writeReport(out, output, rows)
+		},
+	}
+	output = addOutputFlags(cmd, "csv", "csv", "json", "yaml", "table", "wide")
+	cmd.Flags().BoolVar(&noPager, "no-pager", false, "Do not show the report in a pager")
+	cmd.Flags().StringVar(&since, "since", "", "Only report the sessions started at or after this date, time or duration before now")
+	cmd.Flags().StringVar(&until, "until", "", "Only report the sessions started up to this date, time or duration before now")
+	cmd.Flags().StringVar(&source, "source", "index", "Where to read the sessions: index, s3 or all")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+// parseReportTime parses a bound of the period of a report: a date, a time
+// or a duration before now. A date ends the period at the end of its day if
+// end is set. The zero time is no bound.
+func parseReportTime(s string, now time.Time, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := parseSince(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected a date such as 2024-01-01, a time or a duration such as 30d", s)
+	}
+	return now.Add(-d), nil
+}
+
+// inPeriod tells if t is in the period from, to, to excluded
+func inPeriod(t, from, to time.Time) bool {
+	return !t.Before(from) && (to.IsZero() || t.Before(to))
+}
+
+// isLogObject tells if an object is a log file rather than one of its
+// attachments
+func isLogObject(key string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(strings.TrimSuffix(key, vault.Ext), compress.Ext), ".log")
+}
+
+// indexReport returns the sessions of the index started in the period, a run
+// on several pods is a row per pod
+func indexReport(entries []indexEntry, from, to time.Time) []reportRow {
+	var rows []reportRow
+	for _, e := range entries {
+		if start, err := time.Parse(time.RFC3339, e.Start); err == nil && !inPeriod(start, from, to) {
+			continue
+		}
+		row := reportRow{
+			SessionID: e.SessionID,
+			User:      e.User,
+			Context:   e.Context,
+			Cluster:   e.Cluster,
+			Namespace: e.Namespace,
+			Pod:       e.Pod,
+			Container: e.Container,
+			Command:   e.Command,
+			Start:     e.Start,
+			End:       e.End,
+			ExitCode:  &e.ExitCode,
+			NoRecord:  e.NoRecord,
+			LogFile:   e.LogFile,
+			Locations: e.Uploads,
+			SHA256:    e.SHA256,
+			Status:    "finished",
+			Source:    "index",
+		}
+		if e.UploadFailures > 0 {
+			row.Status = "failed-upload"
+		}
+		if len(e.Sessions) == 0 {
+			rows = append(rows, row)
+			continue
+		}
+		for _, s := range e.Sessions {
+			pod := row
+			pod.Group = e.SessionID
+			pod.SessionID = s.SessionID
+			pod.Pod = s.Pod
+			pod.ExitCode = &s.ExitCode
+			pod.LogFile = s.LogFile
+			pod.Locations = s.Uploads
+			pod.SHA256 = s.SHA256
+			rows = append(rows, pod)
+		}
+	}
+	return rows
+}
+
+// mergeS3Report adds the S3 copies of the log files to the sessions of the
+// index, and the sessions only found in S3
+func mergeS3Report(rows []reportRow, objects []upload.S3Object) []reportRow {
+	sessions := map[string]int{}
+	for i, row := range rows {
+		if row.SessionID != "" {
+			sessions[row.SessionID] = i
+		}
+	}
+	for _, obj := range objects {
+		m := obj.Metadata
+		uploaded := obj.LastModified.UTC().Format(time.RFC3339)
+		if i, ok := sessions[m["session-id"]]; ok && m["session-id"] != "" {
+			row := &rows[i]
+			if !slices.Contains(row.Locations, obj.Location()) {
+				row.Locations = append(row.Locations, obj.Location())
+			}
+			if row.SHA256 == "" {
+				row.SHA256 = m["sha256"]
+			} else if m["sha256"] != "" && m["sha256"] != row.SHA256 {
+				row.ChecksumMismatch = true
+			}
+			row.Uploaded = uploaded
+			row.Source = "both"
+			continue
+		}
+		rows = append(rows, reportRow{
+			SessionID: m["session-id"],
+			User:      m["user"],
+			Context:   m["context"],
+			Cluster:   m["cluster"],
+			Namespace: m["namespace"],
+			Pod:       m["pod"],
+			Locations: []string{obj.Location()},
+			SHA256:    m["sha256"],
+			Uploaded:  uploaded,
+			Status:    "finished",
+			Source:    "s3",
+		})
+		if m["session-id"] != "" {
+			sessions[m["session-id"]] = len(rows) - 1
+		}
+	}
+	return rows
+}
+
+// sortReport sorts the sessions by start, or upload for the sessions only
+// found in S3
+func sortReport(rows []reportRow) {
+	at := func(r reportRow) string {
+		if r.Start != "" {
+			return r.Start
+		}
+		return r.Uploaded
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return at(rows[i]) < at(rows[j]) })
+}
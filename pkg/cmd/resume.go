@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// defaultResumeRetries is the number of reconnections of a resumable session
+// when --retry is not given
+const defaultResumeRetries = 5
+
+// resumeScript runs the remote command in a tmux or screen session named
+// after its first argument, or attaches to it if it is still running, so
+// that a session whose connection dropped resumes into the same shell. The
+// command runs as is if neither is installed in the container.
+const resumeScript = `name=$1; shift; ` +
+	`if command -v tmux >/dev/null 2>&1; then exec tmux new-session -A -s "$name" "$@"; fi; ` +
+	`if command -v screen >/dev/null 2>&1; then exec screen -D -R -S "$name" "$@"; fi; ` +
+	`echo "execrec: tmux or screen is not installed in the container, the session cannot be resumed" >&2; ` +
+	`exec "$@"`
+
+// resumableArgs wraps the remote command of the kubectl exec arguments in
+// resumeScript, the multiplexer session is named after the session ID
+func resumableArgs(kubectlArgs []string, sessionID string) ([]string, error) {
+	if !interactive(kubectlArgs) {
+		return nil, fmt.Errorf("--resume requires an interactive session, with -i and -t")
+	}
+	i := slices.Index(kubectlArgs, "--")
+	if i < 0 || i == len(kubectlArgs)-1 {
+		return nil, fmt.Errorf("--resume requires a command after --, e.g. -- bash")
+	}
+	name := "execrec-" + strings.ToLower(sessionID)
+	args := append(slices.Clone(kubectlArgs[:i+1]), "sh", "-c", resumeScript, "sh", name)
+	return append(args, kubectlArgs[i+1:]...), nil
+}
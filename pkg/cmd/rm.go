@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+func newRmCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var remote, yes bool
+	var reason, context string
+	var locations []string
+	cmd := &cobra.Command{
+		Use:   "rm SESSION_ID",
+		Short: "Delete the recordings of a session",
+		Long: `Delete the log file of a session and its attachments, e.g. for a GDPR deletion request, and with --remote their S3 copies with every version of them. The ID of a run on several pods deletes the sessions of every pod.
+
+The local files are found in the session index. The S3 copies are the ones in the session index, the ones given with --location, or else the log files of the bucket of the uploads whose name has the session ID. With content-addressed storage, the content the copies point to is deleted with them.
+
+The files and objects are listed and deleted once confirmed, or with --yes. A session under legal hold is not deleted. The deletion is recorded in the audit journal with its reason and the deleted files and objects, the session index is kept.
+
+Examples:
+  kubectl execrec rm 01K2B3QZ7YHX4N6R8TVA2C5DEF
+  kubectl execrec rm 01K2B3QZ7YHX4N6R8TVA2C5DEF --remote --reason "GDPR request 2024-31"
+  kubectl execrec rm 01K2B3QZ7YHX4N6R8TVA2C5DEF --remote --context prod --yes`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+			if !sessionIDPattern.MatchString(id) {
+				return fmt.Errorf("invalid session ID %q", id)
+			}
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			files, groups, err := sessionFiles(o, id)
+			if err != nil {
+				return err
+			}
+			if o.held(append(groups, id)...) {
+				return fmt.Errorf("session %s is under legal hold, it must not be deleted", id)
+			}
+
+			objects := map[string][]upload.S3Object{}
+			var count int
+			if remote {
+				if objects, err = sessionObjects(o, id, locations); err != nil {
+					return err
+				}
+				for _, objs := range objects {
+					count += len(objs)
+				}
+			}
+			if len(files) == 0 && count == 0 {
+				return fmt.Errorf("no recording of session %s found", id)
+			}
+
+			fmt.Fprintf(streams.Out, "The recordings of session %s to delete:\n", id)
+			for _, f := range files {
+				fmt.Fprintf(streams.Out, "  %s\n", f)
+			}
+			for _, objs := range objects {
+				for _, obj := range objs {
+					fmt.Fprintf(streams.Out, "  %s\n", obj.Location())
+				}
+			}
+			if !yes && !confirm(streams.In, streams.Out, fmt.Sprintf("Delete these %d files and objects?", len(files)+count)) {
+				fmt.Fprintln(streams.Out, "Nothing was deleted")
+				return nil
+			}
+
+			entry := newAuditEntry(o, os.Args[1:])
+			entry.SessionID = id
+			entry.Reason = reason
+			err = deleteRecordings(files, objects, entry)
+			entry.finish(err)
+			if auditErr := appendAudit(o.auditPath(), entry); auditErr != nil {
+				fmt.Fprintf(streams.ErrOut, "Warning: failed to append to the audit journal: %v\n", auditErr)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(streams.Out, "Deleted %d files and objects of session %s\n", len(entry.Deleted), id)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&remote, "remote", false, "Also delete the S3 copies of the session")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Delete without asking for confirmation")
+	cmd.Flags().StringVar(&reason, "reason", "", "Reason of the deletion recorded in the audit journal, e.g. a request number")
+	cmd.Flags().StringArrayVar(&locations, "location", nil, "S3 location of the log file of the session, s3://bucket/key, repeatable")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+// sessionFiles returns the local log files and attachments of a session, or
+// of the sessions of a run on several pods, in the session index, and the
+// runs the session is part of
+func sessionFiles(o *options, id string) ([]string, []string, error) {
+	entries, err := readIndex(o.indexPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the session index: %w", err)
+	}
+	var files, groups []string
+	for _, row := range indexReport(entries, time.Time{}, time.Time{}) {
+		if row.SessionID != id && row.Group != id {
+			continue
+		}
+		if row.Group != "" {
+			groups = append(groups, row.Group)
+		}
+		if row.LogFile == "" {
+			continue
+		}
+		// the log file may have been compressed or encrypted, its
+		// attachments share its name up to its extension
+		logFile := strings.TrimSuffix(strings.TrimSuffix(row.LogFile, vault.Ext), compress.Ext)
+		if _, err := os.Stat(logFile); err == nil {
+			files = append(files, logFile)
+		}
+		files = append(files, sidecarFiles(logFile)...)
+	}
+	return files, groups, nil
+}
+
+// sessionObjects returns the S3 objects of a session by bucket: the ones of
+// its copies in the session index or at locations, or else of the log files
+// of the bucket of the uploads whose name has the session ID
+func sessionObjects(o *options, id string, locations []string) (map[string][]upload.S3Object, error) {
+	found, err := sessionLocations(o, id)
+	if err != nil {
+		return nil, err
+	}
+	locations = append(found, locations...)
+	if len(locations) == 0 {
+		bucket := setting("s3-bucket")
+		if bucket == "" {
+			return nil, fmt.Errorf("session %s has no S3 copy in the session index and no S3 bucket is set, give its S3 location with --location", id)
+		}
+		s3, err := newS3Uploader(bucket)
+		if err != nil {
+			return nil, err
+		}
+		listed, err := s3.ListKeys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the S3 bucket: %w", err)
+		}
+		for _, obj := range listed {
+			if isLogObject(obj.Key) && strings.Contains(path.Base(obj.Key), id) {
+				locations = append(locations, obj.Location())
+			}
+		}
+	}
+
+	objects := map[string][]upload.S3Object{}
+	for _, location := range locations {
+		// the attachments are found with their log file
+		if !isLogObject(location) {
+			continue
+		}
+		bucket, _, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		s3, err := newS3Uploader(bucket)
+		if err != nil {
+			return nil, err
+		}
+		objs, err := s3.SessionObjects(location)
+		if err != nil {
+			return nil, err
+		}
+		objects[bucket] = append(objects[bucket], objs...)
+	}
+	return objects, nil
+}
+
+// deleteRecordings deletes the local files, then the S3 objects by bucket,
+// and records what was deleted in the audit entry
+func deleteRecordings(files []string, objects map[string][]upload.S3Object, entry *auditEntry) error {
+	var errs []error
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		entry.Deleted = append(entry.Deleted, f)
+	}
+	for bucket, objs := range objects {
+		s3, err := newS3Uploader(bucket)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted, err := s3.Delete(objs)
+		entry.Deleted = append(entry.Deleted, deleted...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// confirm asks a yes or no question, no by default or without an answer
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// runValueFlags are the kubectl run flags taking a value, so that their
+// value is not taken for the pod name
+var runValueFlags = map[string]bool{
+	"--image":               true,
+	"--image-pull-policy":   true,
+	"--env":                 true,
+	"--labels":              true,
+	"--annotations":         true,
+	"--port":                true,
+	"--overrides":           true,
+	"--restart":             true,
+	"--override-type":       true,
+	"--field-manager":       true,
+	"--pod-running-timeout": true,
+}
+
+func newRunCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "run [NAME] --image=IMAGE [kubectl run args...] [-- COMMAND [args...]]",
+		Short: "Record a session in a throwaway pod created with 'kubectl run'",
+		Long: `Create a pod with 'kubectl run' and record the session attached to it like kubectl execrec does for kubectl exec, e.g. for a debug pod. The pod is named execrec-<id> if no name is given, and its name and image are recorded in the session metadata. With --rm the pod is deleted once the session ended, even if kubectl could not attach to it.
+
+The kubectl execrec flags are given before the kubectl run arguments, the pod restarts Never unless --restart is given.
+
+Examples:
+  kubectl execrec run --image=nicolaka/netshoot --rm -it -n default -- bash
+  kubectl execrec run debug --image=busybox -n default -it -- sh
+  kubectl execrec run --timeout 5m --image=curlimages/curl --rm -- curl -sS http://web`,
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runArgs := append([]string{"run"}, args...)
+			flags, kubectlArgs, err := parseFlags(args)
+			if err != nil {
+				return auditFailure(streams, o, runArgs, err)
+			}
+			if flags.get("pods") != "" || flags.get("selector") != "" {
+				return auditFailure(streams, o, runArgs, fmt.Errorf("--pods and --selector cannot be used with run"))
+			}
+			s, err := runSessionArgs(kubectlArgs, recorder.NewSessionID(o.now()))
+			if err != nil {
+				return auditFailure(streams, o, runArgs, err)
+			}
+			s.args = runArgs
+			return execute(streams, o, flags, s)
+		},
+	}
+}
+
+// runSessionArgs returns the session of the kubectl run arguments: --rm is
+// handled by kubectl execrec, the pod is named after id if no name is given
+// and restarts Never unless --restart is given
+func runSessionArgs(args []string, id string) (session, error) {
+	s := session{verb: "run"}
+	var rest []string
+	name, restart := "", false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		flag, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case flag == "--rm":
+			s.deletePod = !hasValue || isTrue(value)
+			continue
+		case flag == "--image":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			s.image = value
+			continue
+		case flag == "--restart":
+			restart = true
+		case !strings.HasPrefix(arg, "-") && name == "":
+			name = arg
+			continue
+		}
+		rest = append(rest, arg)
+		// the value of a flag given as "--flag value"
+		if !hasValue && i+1 < len(args) && (runValueFlags[flag] || kubeFlags[flag] || (len(flag) == 2 && flag[0] == '-' && shortValueFlags[flag[1]] != "")) {
+			i++
+			rest = append(rest, args[i])
+		}
+	}
+	if s.image == "" {
+		return s, fmt.Errorf("run requires the image of the pod, with --image")
+	}
+	if name == "" {
+		name = "execrec-" + strings.ToLower(id[len(id)-8:])
+	}
+	s.kubectlArgs = []string{name, "--image=" + s.image}
+	if !restart {
+		s.kubectlArgs = append(s.kubectlArgs, "--restart=Never")
+	}
+	s.kubectlArgs = append(s.kubectlArgs, rest...)
+	return s, nil
+}
+
+// deletePod deletes the pod created by kubectl run without waiting for it to
+// terminate
+func deletePod(command func(string, ...string) *exec.Cmd, t target) error {
+	args := append([]string{"delete", "pod", t.Pod, "--wait=false", "--ignore-not-found"}, t.KubeFlags...)
+	var stderr bytes.Buffer
+	del := command("kubectl", args...)
+	del.Stderr = &stderr
+	if err := del.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("kubectl delete pod %s: %s", t.Pod, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("kubectl delete pod %s: %w", t.Pod, err)
+	}
+	return nil
+}
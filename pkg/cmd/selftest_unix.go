@@ -0,0 +1,70 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// openTestPTY opens a PTY as the terminal of the selftest session and
+// returns what it displays
+func openTestPTY(opts *recorder.Options) (*testScreen, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	// a non-blocking PTY is closed while it is read
+	fd, err := syscall.Dup(int(ptmx.Fd()))
+	ptmx.Close()
+	if err == nil {
+		err = syscall.SetNonblock(fd, true)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+	ptmx = os.NewFile(uintptr(fd), "/dev/ptmx")
+	if err := pty.Setsize(ptmx, &pty.Winsize{Cols: 80, Rows: 24}); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := ptmx.Read(buf)
+			mu.Lock()
+			out.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	opts.Terminal, opts.Stdin, opts.Stdout = tty, tty, tty
+	s := &testScreen{
+		read: func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return out.String()
+		},
+		close: func() {
+			ptmx.Close()
+			<-done
+			tty.Close()
+		},
+	}
+	return s, nil
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// openTestPTY fails, the selftest session is recorded through pipes on
+// Windows
+func openTestPTY(opts *recorder.Options) (*testScreen, error) {
+	return nil, fmt.Errorf("%w: PTYs are not supported on Windows", errSkipped)
+}
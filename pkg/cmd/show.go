@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/playback"
+)
+
+func newShowCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var raw, noPager bool
+	var context string
+	cmd := &cobra.Command{
+		Use:   "show SESSION",
+		Short: "Show a recorded session",
+		Long: `Show a recorded session at once as a readable transcript: the session details, then every command followed by its output as plain text. With --raw the recorded output is printed as recorded, with its colors and escape sequences.
+
+SESSION is the ID of a session of the session index of this machine, whose log file is read locally or else from its uploads, or a source of replay: a local log file, an s3://bucket/key object or an http(s) URL.
+
+On a terminal the output is shown in a pager, the pager setting or PAGER, less by default, unless --no-pager is given.
+
+Examples:
+  kubectl execrec show 01K2B3QZ7YHX4N6R8TVA2C5DEF
+  kubectl execrec show --raw /tmp/kubectl-execrec/prod/alice_20250810T143332+0900_01K2B3QZ7YHX4N6R8TVA2C5DEF.log
+  kubectl execrec show --context prod s3://my-bucket/kubectl-execrec/prod/session.log.gz`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := config.useContext(context); err != nil {
+				return err
+			}
+			source, err := sessionSource(o, args[0])
+			if err != nil {
+				return err
+			}
+			rc, err := openRecording(source)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			r, err := playback.NewReader(rc)
+			if err != nil {
+				return err
+			}
+
+			out, wait := pageOutput(o, streams.Out, noPager)
+			defer wait()
+			if raw {
+				return replay(out, r, 1, 0)
+			}
+			return playback.WriteTranscript(out, r, nil)
+		},
+	}
+	cmd.Flags().BoolVar(&raw, "raw", false, "Print the recorded output as recorded instead of a transcript")
+	cmd.Flags().BoolVar(&noPager, "no-pager", false, "Do not show the output in a pager")
+	cmd.Flags().StringVar(&context, "context", "", "Use the settings of the profile of this kube-context")
+	return cmd
+}
+
+// sessionSource returns the log file of a session of the index, or its first
+// upload that can be read if the log file was removed, or else session
+// itself, a source of replay
+func sessionSource(o *options, session string) (string, error) {
+	if _, err := os.Stat(session); err == nil || !sessionIDPattern.MatchString(session) {
+		return session, nil
+	}
+	entries, err := readIndex(o.indexPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the session index: %w", err)
+	}
+	var group []string
+	for _, row := range indexReport(entries, time.Time{}, time.Time{}) {
+		if row.SessionID == session {
+			return readableCopy(row.LogFile, row.Locations)
+		}
+		if row.Group == session {
+			group = append(group, row.SessionID)
+		}
+	}
+	if len(group) > 0 {
+		return "", fmt.Errorf("%s is a run on several pods, show one of its sessions: %s", session, strings.Join(group, ", "))
+	}
+	return "", fmt.Errorf("session %s not found in the session index %s", session, o.indexPath)
+}
+
+// readableCopy returns the local log file if it still exists, or else its
+// first copy that openRecording reads
+func readableCopy(logFile string, locations []string) (string, error) {
+	if logFile == "" {
+		return "", errors.New("the session was not recorded")
+	}
+	if _, err := os.Stat(logFile); err == nil {
+		return logFile, nil
+	}
+	for _, l := range locations {
+		if strings.HasPrefix(l, "s3://") || strings.HasPrefix(l, "https://") || strings.HasPrefix(l, "http://") {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("%s was removed and has no copy in S3 or on a web server", logFile)
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// capturePod fetches the exec target when the session starts and stores its
+// snapshot and metadata next to the log file, as enabled by the pod-snapshot
+// and pod-metadata settings. The pod is fetched once for both.
+func capturePod(command func(string, ...string) *exec.Cmd, t target, rec *recorder.Recorder, errOut io.Writer) {
+	snapshot, metadata := isTrue(setting("pod-snapshot")), isTrue(setting("pod-metadata"))
+	if !snapshot && !metadata {
+		return
+	}
+	pod, err := getPod(command, t)
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to fetch pod: %v\n", err)
+		return
+	}
+	if snapshot {
+		if path, err := snapshotPod(pod, rec.LogPath()); err != nil {
+			fmt.Fprintf(errOut, "Warning: failed to snapshot pod: %v\n", err)
+		} else {
+			rec.Attach(path)
+		}
+	}
+	if metadata {
+		m, err := parsePodMetadata(pod, t.Container, settingList("pod-metadata-labels", "*"), settingList("pod-metadata-annotations", ""))
+		if err != nil {
+			fmt.Fprintf(errOut, "Warning: failed to read pod metadata: %v\n", err)
+			return
+		}
+		path := sidecarPath(rec.LogPath(), ".meta.json")
+		if err := writeJSON(path, m); err != nil {
+			fmt.Fprintf(errOut, "Warning: failed to write pod metadata: %v\n", err)
+		} else {
+			rec.Attach(path)
+		}
+		rec.SetPodMetadata(m)
+	}
+}
+
+// getPod returns the output of 'kubectl get -o json' for the exec target
+func getPod(command func(string, ...string) *exec.Cmd, t target) ([]byte, error) {
+	if t.Resource == "" {
+		return nil, fmt.Errorf("no exec target found in arguments")
+	}
+
+	resource := t.Resource
+	if t.Pod != "" {
+		resource = "pod/" + t.Pod
+	}
+	args := append([]string{"get", resource, "-o", "json"}, t.KubeFlags...)
+
+	var stdout, stderr bytes.Buffer
+	get := command("kubectl", args...)
+	get.Stdout = &stdout
+	get.Stderr = &stderr
+	if err := get.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("kubectl get %s: %s", resource, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("kubectl get %s: %w", resource, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// snapshotPod stores the pod next to the log file and returns its path, so
+// the pod spec and status (image digests, node, service account, labels...)
+// at session start are kept with the recording
+func snapshotPod(pod []byte, logPath string) (string, error) {
+	path := sidecarPath(logPath, ".pod.json")
+	if err := os.WriteFile(path, pod, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write pod snapshot: %w", err)
+	}
+	return path, nil
+}
+
+// podContainer and podContainerStatus are the fields of the containers of a
+// pod and of their status read for the pod metadata
+type (
+	podContainer struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	}
+	podContainerStatus struct {
+		Name    string `json:"name"`
+		ImageID string `json:"imageID"`
+	}
+)
+
+// podObject are the fields of a pod read for its metadata
+type podObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		OwnerReferences []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			Controller bool   `json:"controller"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName            string         `json:"nodeName"`
+		ServiceAccountName  string         `json:"serviceAccountName"`
+		Containers          []podContainer `json:"containers"`
+		InitContainers      []podContainer `json:"initContainers"`
+		EphemeralContainers []podContainer `json:"ephemeralContainers"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses          []podContainerStatus `json:"containerStatuses"`
+		InitContainerStatuses      []podContainerStatus `json:"initContainerStatuses"`
+		EphemeralContainerStatuses []podContainerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+// defaultContainerAnnotation names the container kubectl exec runs in when
+// no container is given, the first one otherwise
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// parsePodMetadata reads the metadata of a pod and of the container of the
+// session, the default one of kubectl exec if empty, with its labels and
+// annotations matching the glob patterns of the allowlists
+func parsePodMetadata(data []byte, container string, labels, annotations []string) (*recorder.PodMetadata, error) {
+	var pod podObject
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, err
+	}
+	if pod.Kind != "Pod" {
+		return nil, fmt.Errorf("the exec target is a %s, not a pod", pod.Kind)
+	}
+	m := &recorder.PodMetadata{
+		Labels:         allowed(pod.Metadata.Labels, labels),
+		Annotations:    allowed(pod.Metadata.Annotations, annotations),
+		Node:           pod.Spec.NodeName,
+		ServiceAccount: pod.Spec.ServiceAccountName,
+	}
+	for _, ref := range pod.Metadata.OwnerReferences {
+		owner := ref.Kind + "/" + ref.Name
+		m.Owners = append(m.Owners, owner)
+		if !ref.Controller {
+			continue
+		}
+		m.Workload = owner
+		// the ReplicaSets of a Deployment are named after it and the
+		// pod-template-hash label of their pods
+		if hash := pod.Metadata.Labels["pod-template-hash"]; ref.Kind == "ReplicaSet" && hash != "" {
+			if name, ok := strings.CutSuffix(ref.Name, "-"+hash); ok {
+				m.Workload = "Deployment/" + name
+			}
+		}
+	}
+
+	if container == "" {
+		container = pod.Metadata.Annotations[defaultContainerAnnotation]
+	}
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	m.Container = container
+	for _, c := range slices.Concat(pod.Spec.Containers, pod.Spec.InitContainers, pod.Spec.EphemeralContainers) {
+		if c.Name == container {
+			m.Image = c.Image
+		}
+	}
+	for _, s := range slices.Concat(pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses, pod.Status.EphemeralContainerStatuses) {
+		// the image ID is the image reference with its digest, e.g.
+		// docker.io/library/nginx@sha256:..., or the digest of a local
+		// image, e.g. docker://sha256:...
+		if s.Name == container {
+			digest := s.ImageID[strings.LastIndex(s.ImageID, "@")+1:]
+			if _, after, ok := strings.Cut(digest, "://"); ok {
+				digest = after
+			}
+			m.ImageDigest = digest
+		}
+	}
+	return m, nil
+}
+
+// allowed returns the entries of a map whose key matches one of the glob
+// patterns, nil if none does
+func allowed(values map[string]string, patterns []string) map[string]string {
+	var kept map[string]string
+	for k, v := range values {
+		for _, pattern := range patterns {
+			if upload.MatchGlob(pattern, k) {
+				if kept == nil {
+					kept = map[string]string{}
+				}
+				kept[k] = v
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// settingList returns the comma separated values of a setting, or the ones
+// of def if it is not set
+func settingList(key, def string) []string {
+	value := setting(key)
+	if value == "" {
+		value = def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// sidecarPath returns the path of a file stored next to the log file
+func sidecarPath(logPath, suffix string) string {
+	return strings.TrimSuffix(logPath, ".log") + suffix
+}
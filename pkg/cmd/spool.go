@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+)
+
+// spool keeps the sessions on disk until they are finished and uploaded, so
+// that the sessions of a kubectl execrec that crashed or was killed are not
+// lost. The running directory holds a file per session being recorded and
+// the pending directory the sessions waiting to be uploaded.
+type spool struct {
+	dir string
+}
+
+// runningSession is a session being recorded by the process PID
+type runningSession struct {
+	PID   int            `json:"pid"`
+	Event recorder.Event `json:"event"`
+}
+
+// pendingUpload is a session waiting to be uploaded
+type pendingUpload struct {
+	path  string
+	event recorder.Event
+}
+
+func (s spool) runningDir() string { return filepath.Join(s.dir, "running") }
+func (s spool) pendingDir() string { return filepath.Join(s.dir, "pending") }
+
+// begin records that the current process started a session and returns the
+// path of its running file, to remove with done once the log file is finished
+func (s spool) begin(ev recorder.Event) (string, error) {
+	if err := os.MkdirAll(s.runningDir(), 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%d-%s.json", os.Getpid(), strings.TrimSuffix(filepath.Base(ev.LogFile), ".log"))
+	path := filepath.Join(s.runningDir(), name)
+	if err := writeJSON(path, runningSession{PID: os.Getpid(), Event: ev}); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// done removes the running file of a finished session
+func (s spool) done(path string) error {
+	return os.Remove(path)
+}
+
+// active returns the sessions being recorded, by a process still running
+func (s spool) active() ([]recorder.Event, error) {
+	paths, err := filepath.Glob(filepath.Join(s.runningDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var active []recorder.Event
+	var errs []error
+	for _, path := range paths {
+		var running runningSession
+		if err := readJSON(path, &running); err != nil {
+			// the session may have ended since the glob
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if processAlive(running.PID) {
+			active = append(active, running.Event)
+		}
+	}
+	return active, errors.Join(errs...)
+}
+
+// recover finishes the sessions whose process is gone with a "terminated
+// abnormally" footer and adds them to the pending uploads
+func (s spool) recover() ([]recorder.Event, error) {
+	paths, err := filepath.Glob(filepath.Join(s.runningDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var recovered []recorder.Event
+	var errs []error
+	for _, path := range paths {
+		var running runningSession
+		if err := readJSON(path, &running); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if processAlive(running.PID) {
+			continue
+		}
+
+		ev := running.Event
+		ev.Type = "end"
+		ev.End = time.Now().Format(time.RFC3339)
+		if st, err := os.Stat(ev.LogFile); err == nil {
+			// the last write is the closest to the end of the session
+			ev.End = st.ModTime().Format(time.RFC3339)
+		}
+		if err := recorder.Abandon(ev.LogFile, ev.End); err != nil {
+			errs = append(errs, fmt.Errorf("failed to finish %s: %w", ev.LogFile, err))
+			continue
+		}
+		ev.Attachments = sidecarFiles(ev.LogFile)
+
+		if err := s.enqueue(ev); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+		recovered = append(recovered, ev)
+	}
+	return recovered, errors.Join(errs...)
+}
+
+// enqueue adds a session to the pending uploads
+func (s spool) enqueue(ev recorder.Event) error {
+	if err := os.MkdirAll(s.pendingDir(), 0o755); err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(ev.LogFile), ".log") + ".json"
+	return writeJSON(filepath.Join(s.pendingDir(), name), ev)
+}
+
+// pending returns the pending uploads, oldest first
+func (s spool) pending() ([]pendingUpload, error) {
+	paths, err := filepath.Glob(filepath.Join(s.pendingDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var uploads []pendingUpload
+	var errs []error
+	for _, path := range paths {
+		var ev recorder.Event
+		if err := readJSON(path, &ev); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		uploads = append(uploads, pendingUpload{path: path, event: ev})
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].event.Start < uploads[j].event.Start })
+	return uploads, errors.Join(errs...)
+}
+
+// uploadPending uploads the pending sessions with the settings of the
+// profile of their context, a session is removed from the spool once every
+// uploader succeeded
+func uploadPending(c *console, s spool, newUploaders func() ([]upload.Uploader, error)) error {
+	active := config.active
+	defer func() { config.active = active }()
+
+	uploads, err := s.pending()
+	for _, u := range uploads {
+		if _, ctxErr := config.useContext(u.event.Context); ctxErr != nil {
+			err = errors.Join(err, ctxErr)
+			continue
+		}
+		c.logger("upload").Info("uploading a pending session", "log", u.event.LogFile)
+		if _, failures := uploadLog(c, newUploaders, u.event); failures > 0 {
+			continue
+		}
+		if rmErr := os.Remove(u.path); rmErr != nil {
+			err = errors.Join(err, rmErr)
+		}
+	}
+	return err
+}
+
+// sidecarFiles returns the files stored next to a log file, such as its
+// rotated parts and plain text transcript
+func sidecarFiles(logPath string) []string {
+	base := strings.TrimSuffix(logPath, ".log")
+	matches, _ := filepath.Glob(base + ".*")
+	var files []string
+	for _, m := range matches {
+		if m != logPath {
+			files = append(files, m)
+		}
+	}
+	return files
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// write then rename so that a crash never leaves a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (s spool) agentPIDFile() string { return filepath.Join(s.dir, "agent.pid") }
+
+// agentPID returns the process ID of the running upload agent
+func (s spool) agentPID() (int, bool) {
+	data, err := os.ReadFile(s.agentPIDFile())
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || !processAlive(pid) {
+		return 0, false
+	}
+	return pid, true
+}
+
+// lockAgent records the current process as the upload agent, it fails if
+// another agent is running
+func (s spool) lockAgent() (func(), error) {
+	if pid, ok := s.agentPID(); ok {
+		return nil, fmt.Errorf("the upload agent is already running with pid %d", pid)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.agentPIDFile(), []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return func() { _ = os.Remove(s.agentPIDFile()) }, nil
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// topPods is the number of target pods listed by stats
+const topPods = 10
+
+// sessionStats aggregates the sessions of the index
+type sessionStats struct {
+	Sessions        int     `json:"sessions"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Uploads is the number of upload attempts and UploadFailures the
+	// number of failed ones
+	Uploads           int     `json:"uploads"`
+	UploadFailures    int     `json:"uploadFailures"`
+	UploadFailureRate float64 `json:"uploadFailureRate"`
+
+	Users      []statsGroup `json:"users"`
+	Namespaces []statsGroup `json:"namespaces"`
+	Contexts   []statsGroup `json:"contexts"`
+	Pods       []statsGroup `json:"topPods"`
+}
+
+// statsGroup aggregates the sessions sharing a user, namespace...
+type statsGroup struct {
+	Name            string  `json:"name"`
+	Sessions        int     `json:"sessions"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+func newStatsCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var since string
+	var output *outputFlags
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show statistics of the recorded sessions",
+		Long: `Show statistics of the sessions recorded on this machine: sessions per user, namespace and context, total duration, top target pods and upload failure rate.
+
+Examples:
+  kubectl execrec stats
+  kubectl execrec stats --since 30d
+  kubectl execrec stats --since 720h -o json
+  kubectl execrec stats -o wide --columns sessions,users`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.validate(statsFields); err != nil {
+				return err
+			}
+			var from time.Time
+			if since != "" {
+				d, err := parseSince(since)
+				if err != nil {
+					return err
+				}
+				from = o.now().Add(-d)
+			}
+
+			entries, err := readIndex(o.indexPath)
+			if err != nil {
+				return fmt.Errorf("failed to read the session index: %w", err)
+			}
+			stats := aggregateStats(entries, from)
+
+			if output.format == "json" || output.format == "yaml" {
+				return output.encode(streams.Out, stats)
+			}
+			return printStats(streams.Out, output, stats)
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "Only count the sessions started within this duration, e.g. 30d or 12h")
+	output = addOutputFlags(cmd, "table", "table", "wide", "json", "yaml")
+	return cmd
+}
+
+// parseSince parses a duration, with the d (days) and w (weeks) units on top
+// of the ones of time.ParseDuration
+func parseSince(s string) (time.Duration, error) {
+	for unit, d := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, unit); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v) * d, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// aggregateStats aggregates the sessions started after from
+func aggregateStats(entries []indexEntry, from time.Time) sessionStats {
+	var stats sessionStats
+	users := map[string]*statsGroup{}
+	namespaces := map[string]*statsGroup{}
+	contexts := map[string]*statsGroup{}
+	pods := map[string]*statsGroup{}
+
+	for _, e := range entries {
+		if start, err := time.Parse(time.RFC3339, e.Start); err == nil && start.Before(from) {
+			continue
+		}
+		d := e.duration().Seconds()
+		stats.Sessions += max(len(e.Sessions), 1)
+		stats.DurationSeconds += d
+		stats.Uploads += len(e.Uploads) + e.UploadFailures
+		stats.UploadFailures += e.UploadFailures
+
+		addStats(users, e.User, d)
+		addStats(namespaces, e.Namespace, d)
+		addStats(contexts, e.Context, d)
+		if e.Pod != "" {
+			addStats(pods, e.Namespace+"/"+e.Pod, d)
+		}
+		for _, s := range e.Sessions {
+			addStats(pods, e.Namespace+"/"+s.Pod, d)
+		}
+	}
+	if stats.Uploads > 0 {
+		stats.UploadFailureRate = float64(stats.UploadFailures) / float64(stats.Uploads)
+	}
+
+	stats.Users = sortedStats(users)
+	stats.Namespaces = sortedStats(namespaces)
+	stats.Contexts = sortedStats(contexts)
+	stats.Pods = sortedStats(pods)
+	if len(stats.Pods) > topPods {
+		stats.Pods = stats.Pods[:topPods]
+	}
+	return stats
+}
+
+func addStats(groups map[string]*statsGroup, name string, seconds float64) {
+	if name == "" {
+		name = "unknown"
+	}
+	g, ok := groups[name]
+	if !ok {
+		g = &statsGroup{Name: name}
+		groups[name] = g
+	}
+	g.Sessions++
+	g.DurationSeconds += seconds
+}
+
+// sortedStats returns the groups with the most sessions first
+func sortedStats(groups map[string]*statsGroup) []statsGroup {
+	sorted := make([]statsGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Sessions != sorted[j].Sessions {
+			return sorted[i].Sessions > sorted[j].Sessions
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// statsFields are the fields of the statistics, named as in the JSON
+// output, for --columns
+var statsFields = []string{
+	"sessions", "durationSeconds", "uploads", "uploadFailures", "uploadFailureRate",
+	"users", "namespaces", "contexts", "topPods",
+}
+
+// printStats prints the totals and the groups of the fields of --columns, or
+// all of them. The wide output also prints the share of the sessions of each
+// group.
+func printStats(out io.Writer, output *outputFlags, stats sessionStats) error {
+	printed := func(fields ...string) bool {
+		if len(output.columns) == 0 {
+			return true
+		}
+		return slices.ContainsFunc(output.columns, func(c string) bool {
+			return slices.ContainsFunc(fields, func(f string) bool { return sameField(f, c) })
+		})
+	}
+	c, err := output.color.colors(out)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	newline := false
+	if printed("sessions", "durationSeconds", "uploads", "uploadFailures", "uploadFailureRate") {
+		fmt.Fprintln(w, "SESSIONS\tDURATION\tUPLOAD FAILURES")
+		fmt.Fprintf(w, "%d\t%s\t%d/%d (%.1f%%)\n", stats.Sessions, formatSeconds(stats.DurationSeconds),
+			stats.UploadFailures, stats.Uploads, stats.UploadFailureRate*100)
+		newline = true
+	}
+
+	for _, section := range []struct {
+		field  string
+		title  string
+		groups []statsGroup
+	}{
+		{"users", "USER", stats.Users},
+		{"namespaces", "NAMESPACE", stats.Namespaces},
+		{"contexts", "CONTEXT", stats.Contexts},
+		{"topPods", "POD", stats.Pods},
+	} {
+		if !printed(section.field) {
+			continue
+		}
+		if newline {
+			fmt.Fprintln(w)
+		}
+		newline = true
+		if output.format == "wide" {
+			fmt.Fprintf(w, "%s\tSESSIONS\tDURATION\tSHARE\n", section.title)
+		} else {
+			fmt.Fprintf(w, "%s\tSESSIONS\tDURATION\n", section.title)
+		}
+		for _, g := range section.groups {
+			fmt.Fprintf(w, "%s\t%d\t%s", g.Name, g.Sessions, formatSeconds(g.DurationSeconds))
+			if output.format == "wide" {
+				fmt.Fprintf(w, "\t%.1f%%", 100*float64(g.Sessions)/float64(max(stats.Sessions, 1)))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// the headers are the first line and the lines after the blank ones
+	previous := ""
+	return c.paintLines(out, buf.Bytes(), func(i int, line string) string {
+		defer func() { previous = line }()
+		if i == 0 || previous == "" {
+			return c.paint(colorHeader, line)
+		}
+		return line
+	})
+}
+
+func formatSeconds(s float64) string {
+	return (time.Duration(s) * time.Second).String()
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// sessionStatus is the outcome of a session printed to stderr with
+// --output json, for the scripts running kubectl execrec
+type sessionStatus struct {
+	// Status is ok, or error if the session or its upload failed
+	Status string `json:"status"`
+	// Code is the machine-readable error, e.g. forbidden or upload-failed,
+	// and Category the step it happened in, see statusError
+	Code     string `json:"code,omitempty"`
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message,omitempty"`
+	// Hint tells how to fix the error
+	Hint     string `json:"hint,omitempty"`
+	ExitCode int    `json:"exitCode"`
+
+	SessionID      string   `json:"sessionId,omitempty"`
+	LogFile        string   `json:"logFile,omitempty"`
+	Uploads        []string `json:"uploads,omitempty"`
+	UploadFailures int      `json:"uploadFailures,omitempty"`
+
+	// execFailure is the failure of kubectl exec of the session
+	execFailure *recorder.ExecFailure
+}
+
+// Categories of the errors of --output json
+const (
+	categoryConfig    = "config"
+	categoryPolicy    = "policy"
+	categoryKubectl   = "kubectl"
+	categoryCommand   = "command"
+	categoryRecording = "recording"
+	categorySink      = "sink"
+	categoryUpload    = "upload"
+	categoryInternal  = "internal"
+)
+
+// statusError is an error with its code, category and hint for --output
+// json
+type statusError struct {
+	code     string
+	category string
+	hint     string
+	err      error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// withStatus adds a code, a category and a hint to an error, nil stays nil
+func withStatus(code, category, hint string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &statusError{code: code, category: category, hint: hint, err: err}
+}
+
+// execFailureHints tell how to fix the failures of kubectl exec
+var execFailureHints = map[string]string{
+	recorder.FailurePodNotFound:         "check the pod name and the namespace, e.g. with kubectl get pods -n NAMESPACE",
+	recorder.FailureContainerNotFound:   "check the container name, e.g. with kubectl get pod POD -o jsonpath={.spec.containers[*].name}",
+	recorder.FailurePodNotRunning:       "wait for the pod to be running, see kubectl describe pod POD",
+	recorder.FailureContainerNotRunning: "wait for the container to be running, see kubectl describe pod POD",
+	recorder.FailureForbidden:           "ask for a Role granting the create verb on pods/exec, check it with kubectl execrec doctor POD",
+	recorder.FailureUnauthorized:        "log in to the cluster again, the credentials are missing or expired",
+	recorder.FailureConnection:          "check the network, VPN or proxy to the API server, see kubectl execrec doctor",
+	recorder.FailureUpgrade:             "check that the proxies to the API server allow the upgrade of the exec stream",
+}
+
+// finish sets the outcome of the session from its error, the error of
+// kubectl or of the command run in the pod takes precedence over the upload
+// failures
+func (s *sessionStatus) finish(err error) {
+	s.Status, s.ExitCode = "ok", 0
+	var se *statusError
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.Is(err, recorder.ErrTimeout):
+		s.set("timeout", categoryCommand, "", err, timeoutExitCode)
+	case errors.As(err, &se):
+		s.set(se.code, se.category, se.hint, err, 1)
+	case errors.As(err, &ee) && s.execFailure != nil:
+		s.set(s.execFailure.Reason, categoryKubectl, execFailureHints[s.execFailure.Reason], errors.New(s.execFailure.Message), ee.ExitCode())
+	case errors.As(err, &ee):
+		// the command was interrupted, as for propagate
+		if code := ee.ExitCode(); code != 130 && code != 143 && code != 0 {
+			s.set("command-failed", categoryCommand, "", err, code)
+		}
+	default:
+		s.set("error", categoryInternal, "", err, 1)
+	}
+	if s.Status == "ok" && s.UploadFailures > 0 {
+		s.Status, s.Code, s.Category = "error", "upload-failed", categoryUpload
+		s.Message = "the log file could not be uploaded"
+		s.Hint = "the log file is kept locally, check the upload settings and credentials with kubectl execrec doctor"
+	}
+}
+
+func (s *sessionStatus) set(code, category, hint string, err error, exitCode int) {
+	s.Status, s.Code, s.Category, s.Hint = "error", code, category, hint
+	s.Message = err.Error()
+	s.ExitCode = exitCode
+}
+
+// write writes the status as a JSON line
+func (s *sessionStatus) write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/compress"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/upload"
+	"github.com/keidarcy/kubectl-execrec/pkg/vault"
+)
+
+// syncSession is a finished session whose log file is still on this machine
+type syncSession struct {
+	event recorder.Event
+	// uploads are the remote locations its log file is known to be at
+	uploads []string
+	// pending is its file in the pending uploads of the spool, if any
+	pending string
+}
+
+// syncResult is the outcome of the sync of a session
+type syncResult struct {
+	session syncSession
+	// locations are the locations of the log file once synced, uploaded the
+	// ones it was uploaded to
+	locations []string
+	uploaded  []string
+	err       error
+}
+
+func newSyncCmd(streams genericclioptions.IOStreams, o *options) *cobra.Command {
+	var since string
+	var parallel int
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "sync [SESSION_ID...]",
+		Short: "Upload the local recordings missing from the uploads",
+		Long: `Upload every session of the session index and of the pending uploads whose log file is still on this machine and is not found at the location of an upload, e.g. on a laptop that was offline when its sessions ended. The sessions are uploaded with the settings of the profile of their context, as when they ended.
+
+A log file is found at the location of an upload if the session index or a previous sync recorded it there, or for S3 and WebDAV if the object exists. An interrupted sync resumes where it stopped, the sessions already uploaded are not uploaded again. A session uploaded by every upload is removed from the pending uploads.
+
+The sessions are uploaded --parallel at a time and a line is printed for each one as it is done. SESSION_ID only syncs these sessions, or the sessions of these runs on several pods.
+
+Examples:
+  kubectl execrec sync
+  kubectl execrec sync --since 7d --dry-run
+  kubectl execrec sync --parallel 8
+  kubectl execrec sync 01K2B3QZ7YHX4N6R8TVA2C5DEF`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				if !sessionIDPattern.MatchString(id) {
+					return fmt.Errorf("invalid session ID %q", id)
+				}
+			}
+			if parallel < 1 {
+				return fmt.Errorf("invalid --parallel %d, expected at least 1", parallel)
+			}
+			var from time.Time
+			if since != "" {
+				d, err := parseSince(since)
+				if err != nil {
+					return err
+				}
+				from = o.now().Add(-d)
+			}
+			sessions, err := localSessions(o, from, args)
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				fmt.Fprintln(streams.Out, "No local recording to sync")
+				return nil
+			}
+			return syncSessions(streams, o, sessions, parallel, dryRun)
+		},
+	}
+	cmd.Flags().IntVarP(&parallel, "parallel", "p", 4, "Number of sessions uploaded at a time")
+	cmd.Flags().StringVar(&since, "since", "", "Only sync the sessions started within this duration, e.g. 30d or 12h")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the sessions that would be uploaded without uploading them")
+	return cmd
+}
+
+// syncStatePath is the file recording the locations sync uploaded the log
+// files to, by log file
+func (s spool) syncStatePath() string { return filepath.Join(s.dir, "sync.json") }
+
+// localSessions returns the finished sessions of the index and the pending
+// uploads whose log file exists, started after from, with the given IDs if
+// any, oldest first
+func localSessions(o *options, from time.Time, ids []string) ([]syncSession, error) {
+	entries, err := readIndex(o.indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session index: %w", err)
+	}
+	sp := spool{dir: o.spoolDir}
+	state := map[string][]string{}
+	if err := readJSON(sp.syncStatePath(), &state); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	selected := func(ev recorder.Event) bool {
+		if len(ids) > 0 && !slices.Contains(ids, ev.SessionID) && (ev.Group == "" || !slices.Contains(ids, ev.Group)) {
+			return false
+		}
+		// the log file may have been encrypted by a previous sync
+		if _, err := os.Stat(ev.LogFile); err != nil {
+			if _, err := os.Stat(ev.LogFile + vault.Ext); err != nil {
+				return false
+			}
+		}
+		start, err := time.Parse(time.RFC3339, ev.Start)
+		return err != nil || !start.Before(from)
+	}
+
+	var sessions []syncSession
+	byLog := map[string]int{}
+	for _, row := range indexReport(entries, time.Time{}, time.Time{}) {
+		if row.NoRecord != "" || row.LogFile == "" {
+			continue
+		}
+		ev := recorder.Event{
+			Type:        "end",
+			SessionID:   row.SessionID,
+			Group:       row.Group,
+			Command:     row.Command,
+			User:        row.User,
+			Context:     row.Context,
+			Cluster:     row.Cluster,
+			Namespace:   row.Namespace,
+			Pod:         row.Pod,
+			LogFile:     row.LogFile,
+			Version:     o.version,
+			Start:       row.Start,
+			End:         row.End,
+			Attachments: logAttachments(row.LogFile),
+		}
+		if !selected(ev) {
+			continue
+		}
+		byLog[ev.LogFile] = len(sessions)
+		sessions = append(sessions, syncSession{event: ev, uploads: slices.Concat(row.Locations, state[ev.LogFile])})
+	}
+	pending, err := sp.pending()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pending uploads: %w", err)
+	}
+	for _, u := range pending {
+		if !selected(u.event) {
+			continue
+		}
+		// the pending event has the attachments and commands of the session
+		if i, ok := byLog[u.event.LogFile]; ok {
+			sessions[i].event = u.event
+			sessions[i].pending = u.path
+			continue
+		}
+		sessions = append(sessions, syncSession{event: u.event, uploads: state[u.event.LogFile], pending: u.path})
+	}
+	slices.SortStableFunc(sessions, func(a, b syncSession) int { return strings.Compare(a.event.Start, b.event.Start) })
+	return sessions, nil
+}
+
+// logAttachments returns the files stored next to a log file that may have
+// been compressed or encrypted, without the log file itself
+func logAttachments(logFile string) []string {
+	plain := strings.TrimSuffix(strings.TrimSuffix(logFile, vault.Ext), compress.Ext)
+	var files []string
+	for _, f := range sidecarFiles(plain) {
+		if strings.TrimSuffix(strings.TrimSuffix(f, vault.Ext), compress.Ext) != plain && !strings.HasSuffix(f, ".tmp") {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// syncSessions uploads the sessions, parallel at a time with the settings of
+// the profile of each context, prints their progress and records their
+// locations so that an interrupted sync resumes where it stopped
+func syncSessions(streams genericclioptions.IOStreams, o *options, sessions []syncSession, parallel int, dryRun bool) error {
+	active := config.active
+	defer func() { config.active = active }()
+
+	sp := spool{dir: o.spoolDir}
+	state := map[string][]string{}
+	if err := readJSON(sp.syncStatePath(), &state); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var contexts []string
+	byContext := map[string][]syncSession{}
+	for _, s := range sessions {
+		if _, ok := byContext[s.event.Context]; !ok {
+			contexts = append(contexts, s.event.Context)
+		}
+		byContext[s.event.Context] = append(byContext[s.event.Context], s)
+	}
+
+	done, uploaded, skipped, failed := 0, 0, 0, 0
+	for _, context := range contexts {
+		group := byContext[context]
+		// the settings are switched between the contexts only, the workers
+		// read them
+		if _, err := config.useContext(context); err != nil {
+			fmt.Fprintf(streams.ErrOut, "Warning: skipping the %d sessions of context %s: %v\n", len(group), context, err)
+			done += len(group)
+			skipped += len(group)
+			continue
+		}
+		if uploaders, err := o.uploaders(); err == nil && len(uploaders) == 0 {
+			fmt.Fprintf(streams.ErrOut, "Warning: skipping the %d sessions of context %s, no upload is configured\n", len(group), context)
+			done += len(group)
+			skipped += len(group)
+			continue
+		}
+
+		jobs := make(chan syncSession)
+		results := make(chan syncResult)
+		for range min(parallel, len(group)) {
+			go func() {
+				// every worker has its own uploaders, they are not safe for
+				// concurrent use
+				uploaders, err := o.uploaders()
+				for s := range jobs {
+					if err != nil {
+						results <- syncResult{session: s, err: err}
+						continue
+					}
+					results <- syncLog(uploaders, s, dryRun)
+				}
+			}()
+		}
+		go func() {
+			for _, s := range group {
+				jobs <- s
+			}
+			close(jobs)
+		}()
+
+		for range group {
+			r := <-results
+			done++
+			name := r.session.event.SessionID
+			if name == "" {
+				name = r.session.event.LogFile
+			}
+			progress := fmt.Sprintf("[%d/%d] %s", done, len(sessions), name)
+			switch {
+			case r.err != nil:
+				failed++
+				fmt.Fprintf(streams.ErrOut, "%s: failed to upload: %v\n", progress, r.err)
+			case len(r.uploaded) == 0:
+				fmt.Fprintf(streams.Out, "%s: already uploaded\n", progress)
+			case dryRun:
+				uploaded++
+				fmt.Fprintf(streams.Out, "%s: would be uploaded to %s\n", progress, strings.Join(r.uploaded, ", "))
+			default:
+				uploaded++
+				fmt.Fprintf(streams.Out, "%s: uploaded to %s\n", progress, strings.Join(r.uploaded, ", "))
+			}
+			if dryRun {
+				continue
+			}
+
+			logFile := r.session.event.LogFile
+			for _, l := range r.locations {
+				if !slices.Contains(state[logFile], l) {
+					state[logFile] = append(state[logFile], l)
+				}
+			}
+			if err := writeJSON(sp.syncStatePath(), state); err != nil {
+				fmt.Fprintf(streams.ErrOut, "Warning: failed to record the progress of the sync: %v\n", err)
+			}
+			if r.err == nil && r.session.pending != "" {
+				if err := os.Remove(r.session.pending); err != nil && !errors.Is(err, os.ErrNotExist) {
+					fmt.Fprintf(streams.ErrOut, "Warning: failed to remove %s from the pending uploads: %v\n", logFile, err)
+				}
+			}
+		}
+	}
+
+	verb := "uploaded"
+	if dryRun {
+		verb = "to upload"
+	}
+	fmt.Fprintf(streams.Out, "%d sessions %s, %d already uploaded, %d skipped, %d failed\n", uploaded, verb, len(sessions)-uploaded-skipped-failed, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to upload %d sessions, run sync again to retry them", failed)
+	}
+	return nil
+}
+
+// syncLog uploads a session with the uploaders its log file is not found
+// with. With dryRun nothing is uploaded, the locations it would be uploaded
+// to are returned as uploaded.
+func syncLog(uploaders []upload.Uploader, s syncSession, dryRun bool) syncResult {
+	r := syncResult{session: s}
+	ev := s.event
+	if dryRun {
+		// the files are encrypted to the same name with the vault extension
+		if setting("vault-transit-key") != "" && !strings.HasSuffix(ev.LogFile, vault.Ext) {
+			ev.LogFile += vault.Ext
+		}
+	} else {
+		// a session that could not be encrypted is never uploaded in plain
+		// text
+		sealed, err := sealSession(ev)
+		if err != nil {
+			r.err = fmt.Errorf("failed to encrypt the session: %w", err)
+			return r
+		}
+		ev = sealed
+	}
+
+	var errs []error
+	for _, u := range uploaders {
+		var location string
+		if l, ok := u.(upload.Locator); ok {
+			var err error
+			if location, err = l.Location(ev); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if slices.Contains(s.uploads, location) {
+				r.locations = append(r.locations, location)
+				continue
+			}
+		}
+		if e, ok := u.(upload.Exister); ok {
+			found, err := e.Exists(ev)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if found {
+				r.locations = append(r.locations, location)
+				continue
+			}
+		}
+		if dryRun {
+			r.uploaded = append(r.uploaded, location)
+			continue
+		}
+		location, err := u.Upload(ev)
+		if err != nil {
+			if location != "" {
+				err = fmt.Errorf("%s: %w", location, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		r.locations = append(r.locations, location)
+		r.uploaded = append(r.uploaded, location)
+	}
+	r.err = errors.Join(errs...)
+	return r
+}
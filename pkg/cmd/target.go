@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// target is the exec target parsed from the kubectl exec arguments
+type target struct {
+	// Resource is the target as given, e.g. "my-pod", "pod/my-pod" or
+	// "deploy/web"
+	Resource string
+	// Pod is the pod name, empty if the target is not a pod
+	Pod       string
+	Namespace string
+	Container string
+	// KubeFlags are the global kubectl flags (context, kubeconfig,
+	// namespace, impersonation...) to reuse in other kubectl commands
+	KubeFlags []string
+	// Command is the remote command
+	Command []string
+	// As, AsGroups and AsUID are the impersonation flags
+	As       string
+	AsGroups []string
+	AsUID    string
+}
+
+// impersonation returns the identity the exec acts as, nil without
+// impersonation flags
+func (t target) impersonation() *recorder.Impersonation {
+	if t.As == "" && len(t.AsGroups) == 0 && t.AsUID == "" {
+		return nil
+	}
+	return &recorder.Impersonation{User: t.As, Groups: t.AsGroups, UID: t.AsUID}
+}
+
+// kubeFlags are the global kubectl flags taking a value, they are kept in
+// target.KubeFlags
+var kubeFlags = map[string]bool{
+	"--context":               true,
+	"--kubeconfig":            true,
+	"--cluster":               true,
+	"--user":                  true,
+	"--namespace":             true,
+	"--as":                    true,
+	"--as-group":              true,
+	"--as-uid":                true,
+	"--token":                 true,
+	"--server":                true,
+	"--certificate-authority": true,
+	"--client-certificate":    true,
+	"--client-key":            true,
+	"--tls-server-name":       true,
+	"--request-timeout":       true,
+	"--cache-dir":             true,
+}
+
+// kubeBoolFlags are the global kubectl flags without value kept in
+// target.KubeFlags
+var kubeBoolFlags = map[string]bool{
+	"--insecure-skip-tls-verify": true,
+}
+
+// execFlags are the kubectl exec and other global flags taking a value
+var execFlags = map[string]bool{
+	"--container":           true,
+	"--filename":            true,
+	"--pod-running-timeout": true,
+	"--profile":             true,
+	"--profile-output":      true,
+	"--log-dir":             true,
+	"--log-file":            true,
+	"--v":                   true,
+	"--vmodule":             true,
+}
+
+// shortValueFlags are the short flags taking a value and their long names
+var shortValueFlags = map[byte]string{
+	'n': "--namespace",
+	'c': "--container",
+	'f': "--filename",
+	's': "--server",
+	'v': "--v",
+}
+
+// interactive tells if the kubectl exec arguments pass stdin or allocate a
+// TTY, with -i, -t or their long forms
+func interactive(args []string) bool {
+	for _, arg := range args {
+		switch {
+		case arg == "--":
+			return false
+		case strings.HasPrefix(arg, "--"):
+			name, value, _ := strings.Cut(arg, "=")
+			if (name == "--stdin" || name == "--tty") && value != "false" {
+				return true
+			}
+		case strings.HasPrefix(arg, "-"):
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'i' || arg[j] == 't' {
+					return true
+				}
+				if _, ok := shortValueFlags[arg[j]]; ok {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseTarget parses the kubectl exec arguments
+func parseTarget(args []string) target {
+	var t target
+	set := func(name, value string) {
+		switch name {
+		case "--namespace":
+			t.Namespace = value
+		case "--container":
+			t.Container = value
+		case "--as":
+			t.As = value
+		case "--as-group":
+			t.AsGroups = append(t.AsGroups, value)
+		case "--as-uid":
+			t.AsUID = value
+		}
+		if kubeFlags[name] {
+			t.KubeFlags = append(t.KubeFlags, name+"="+value)
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			t.Command = args[i+1:]
+			i = len(args)
+		case strings.HasPrefix(arg, "--"):
+			name, value, ok := strings.Cut(arg, "=")
+			if kubeBoolFlags[name] {
+				t.KubeFlags = append(t.KubeFlags, arg)
+				continue
+			}
+			if !kubeFlags[name] && !execFlags[name] {
+				continue
+			}
+			if !ok && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			set(name, value)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// short flags can be combined, e.g. -it or -itc sidecar
+			for j := 1; j < len(arg); j++ {
+				name, ok := shortValueFlags[arg[j]]
+				if !ok {
+					continue
+				}
+				value := strings.TrimPrefix(arg[j+1:], "=")
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				set(name, value)
+				break
+			}
+		case t.Resource == "":
+			t.Resource = arg
+		default:
+			// deprecated form: kubectl exec POD COMMAND
+			t.Command = append(t.Command, arg)
+		}
+	}
+
+	kind, name, ok := strings.Cut(t.Resource, "/")
+	switch {
+	case !ok:
+		t.Pod = t.Resource
+	case kind == "pod" || kind == "pods" || kind == "po":
+		t.Pod = name
+	}
+	return t
+}
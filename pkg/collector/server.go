@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// gRPC status codes
+const (
+	statusOK              = 0
+	statusInvalidArgument = 3
+	statusUnimplemented   = 12
+	statusInternal        = 13
+	statusUnauthenticated = 16
+)
+
+// Server is a reference collector: it stores the sessions streamed by the
+// gRPC sink in Dir, every session in a directory named after its ID with its
+// events in events.jsonl, its output in output.log and its recorded input in
+// input.log
+type Server struct {
+	Dir string
+	// Token is the bearer token required from the clients, none if empty
+	Token string
+	// Logf logs the sessions received, nothing is logged if nil
+	Logf func(format string, args ...any)
+}
+
+// ServeHTTP serves the Record method over HTTP/2
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	switch {
+	case r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc"):
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	case r.URL.Path != RecordPath:
+		writeStatus(w, statusUnimplemented, "unknown method "+r.URL.Path)
+		return
+	case s.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1:
+		writeStatus(w, statusUnauthenticated, "invalid token")
+		return
+	}
+
+	session, chunks, code, err := s.record(r)
+	if session != nil {
+		session.close()
+	}
+	if err != nil {
+		s.logf("%v", err)
+		writeStatus(w, code, err.Error())
+		return
+	}
+	if session != nil {
+		s.logf("session %s: %d chunks, ended=%t", session.id, chunks, session.ended)
+	}
+	if err := WriteFrame(w, (&RecordResponse{Chunks: chunks}).Marshal()); err != nil {
+		return
+	}
+	writeStatus(w, statusOK, "")
+}
+
+// record stores the messages of a Record call, the gRPC status code of an
+// error is returned with it
+func (s *Server) record(r *http.Request) (*storedSession, uint64, int, error) {
+	var session *storedSession
+	var chunks uint64
+	for {
+		frame, err := ReadFrame(r.Body)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return session, chunks, statusOK, nil
+			}
+			return session, chunks, statusInvalidArgument, fmt.Errorf("failed to read the stream: %w", err)
+		}
+		var m Message
+		if err := m.Unmarshal(frame); err != nil {
+			return session, chunks, statusInvalidArgument, err
+		}
+		if session == nil {
+			if m.SessionID == "" || recorder.SafeFileName(m.SessionID) != m.SessionID {
+				return nil, chunks, statusInvalidArgument, fmt.Errorf("invalid session ID %q", m.SessionID)
+			}
+			if session, err = s.open(m.SessionID); err != nil {
+				return nil, chunks, statusInternal, err
+			}
+			s.logf("session %s started", m.SessionID)
+		} else if m.SessionID != session.id {
+			return session, chunks, statusInvalidArgument, fmt.Errorf("session %s: message of session %s", session.id, m.SessionID)
+		}
+		if m.Chunk != nil {
+			chunks++
+		}
+		if err := session.store(m); err != nil {
+			return session, chunks, statusInternal, fmt.Errorf("session %s: %w", session.id, err)
+		}
+	}
+}
+
+// storedSession is a session stored by Server
+type storedSession struct {
+	id     string
+	dir    string
+	events *os.File
+	output *os.File
+	input  *os.File
+	// ended is set once the end event was received
+	ended bool
+}
+
+// open opens the files of a session, appending to them if it was already
+// stored
+func (s *Server) open(id string) (*storedSession, error) {
+	session := &storedSession{id: id, dir: filepath.Join(s.Dir, id)}
+	if err := os.MkdirAll(session.dir, 0o750); err != nil {
+		return nil, err
+	}
+	var err error
+	if session.events, err = openAppend(filepath.Join(session.dir, "events.jsonl")); err != nil {
+		return nil, err
+	}
+	if session.output, err = openAppend(filepath.Join(session.dir, "output.log")); err != nil {
+		session.close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+}
+
+// store writes a message to the files of the session
+func (s *storedSession) store(m Message) error {
+	switch {
+	case m.Event != nil:
+		if m.Event.Type == "end" {
+			s.ended = true
+		}
+		_, err := s.events.Write(append(m.Event.JSON, '\n'))
+		return err
+	case m.Chunk != nil && m.Chunk.Stream == recorder.StreamStdin:
+		if s.input == nil {
+			var err error
+			if s.input, err = openAppend(filepath.Join(s.dir, "input.log")); err != nil {
+				return err
+			}
+		}
+		_, err := s.input.Write(m.Chunk.Data)
+		return err
+	case m.Chunk != nil:
+		_, err := s.output.Write(m.Chunk.Data)
+		return err
+	}
+	return nil
+}
+
+func (s *storedSession) close() {
+	for _, f := range []*os.File{s.events, s.output, s.input} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// writeStatus writes the gRPC status in the trailers
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+}
@@ -0,0 +1,226 @@
+// Package collector implements the gRPC protocol streaming sessions to a
+// collector, see collector.proto, and a reference collector storing them.
+// The protocol buffers and the gRPC framing are encoded by hand as only a
+// few messages are needed.
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RecordPath is the HTTP/2 path of the Record method
+const RecordPath = "/execrec.collector.v1.Collector/Record"
+
+// MaxFrameSize is the size of the largest message accepted
+const MaxFrameSize = 4 << 20
+
+// Message is a SessionMessage: an event or a chunk of a session
+type Message struct {
+	SessionID string
+	// Event or Chunk is set
+	Event *Event
+	Chunk *Chunk
+}
+
+// Event is a session lifecycle event, JSON is the recorder.Event
+type Event struct {
+	Type string
+	JSON []byte
+}
+
+// Chunk is a chunk of the recorded session
+type Chunk struct {
+	Seq    uint64
+	Stream string
+	Data   []byte
+}
+
+// RecordResponse is the response to a Record call
+type RecordResponse struct {
+	Chunks uint64
+}
+
+// wire types of protocol buffers
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+// Marshal returns the protocol buffers encoding of m
+func (m *Message) Marshal() []byte {
+	b := appendBytesField(nil, 1, []byte(m.SessionID))
+	switch {
+	case m.Event != nil:
+		e := appendBytesField(nil, 1, []byte(m.Event.Type))
+		e = appendBytesField(e, 2, m.Event.JSON)
+		// an empty message is still present in a oneof
+		b = appendTag(b, 2, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(e)))
+		b = append(b, e...)
+	case m.Chunk != nil:
+		c := appendVarintField(nil, 1, m.Chunk.Seq)
+		c = appendBytesField(c, 2, []byte(m.Chunk.Stream))
+		c = appendBytesField(c, 3, m.Chunk.Data)
+		b = appendTag(b, 3, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// Unmarshal decodes the protocol buffers encoding of a message
+func (m *Message) Unmarshal(b []byte) error {
+	*m = Message{}
+	return decodeFields(b, func(field int, v uint64, p []byte) error {
+		switch field {
+		case 1:
+			m.SessionID = string(p)
+		case 2:
+			m.Event, m.Chunk = &Event{}, nil
+			return decodeFields(p, func(field int, _ uint64, p []byte) error {
+				switch field {
+				case 1:
+					m.Event.Type = string(p)
+				case 2:
+					m.Event.JSON = p
+				}
+				return nil
+			})
+		case 3:
+			m.Chunk, m.Event = &Chunk{}, nil
+			return decodeFields(p, func(field int, v uint64, p []byte) error {
+				switch field {
+				case 1:
+					m.Chunk.Seq = v
+				case 2:
+					m.Chunk.Stream = string(p)
+				case 3:
+					m.Chunk.Data = p
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// Marshal returns the protocol buffers encoding of r
+func (r *RecordResponse) Marshal() []byte {
+	return appendVarintField(nil, 1, r.Chunks)
+}
+
+// Unmarshal decodes the protocol buffers encoding of a response
+func (r *RecordResponse) Unmarshal(b []byte) error {
+	*r = RecordResponse{}
+	return decodeFields(b, func(field int, v uint64, _ []byte) error {
+		if field == 1 {
+			r.Chunks = v
+		}
+		return nil
+	})
+}
+
+var errTruncated = errors.New("truncated protocol buffers message")
+
+// decodeFields calls f with every field of a message: its varint value or
+// its bytes, the fields of other wire types are skipped
+func decodeFields(b []byte, f func(field int, v uint64, p []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var p []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errTruncated
+			}
+			p = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case 1:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+			continue
+		case 5:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protocol buffers wire type %d", wire)
+		}
+		if err := f(field, v, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFrame writes a message with the gRPC framing: an uncompressed flag and
+// its size
+func WriteFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// ReadFrame reads a message with the gRPC framing, io.EOF at the end of the
+// stream
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTruncated
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("gRPC message of %d bytes is too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errTruncated
+	}
+	return msg, nil
+}
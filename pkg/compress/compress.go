@@ -0,0 +1,140 @@
+// Package compress compresses session logs with gzip, splitting them in
+// blocks compressed in parallel so that logs of several GB are compressed in
+// seconds
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Ext is the extension added to compressed files
+const Ext = ".gz"
+
+// blockSize is the size of the blocks compressed independently, each one is
+// a gzip member of the file, which gzip and Go read as a single stream
+const blockSize = 1 << 20
+
+// ParseLevel parses a compression level from 1, the fastest, to 9, the
+// smallest, empty for the default level
+func ParseLevel(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return gzip.DefaultCompression, nil
+	}
+	level, err := strconv.Atoi(s)
+	if err != nil || level < gzip.BestSpeed || level > gzip.BestCompression {
+		return 0, fmt.Errorf("invalid compression level %q, expected 1 (fastest) to 9 (smallest)", s)
+	}
+	return level, nil
+}
+
+// CompressFile compresses a file into the file with the Ext extension,
+// removes the original and returns the compressed one
+func CompressFile(path string, level int) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+Ext, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := Compress(out, in, level); err != nil {
+		out.Close()
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	in.Close()
+	return out.Name(), os.Remove(path)
+}
+
+// block is the result of the compression of a block
+type block struct {
+	data []byte
+	err  error
+}
+
+// Compress compresses r to w, the blocks of r are compressed on every CPU
+// and written in order
+func Compress(w io.Writer, r io.Reader, level int) error {
+	// the blocks being compressed, in order, bounded by the number of CPUs
+	blocks := make(chan chan block, runtime.GOMAXPROCS(0))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(blocks)
+		for {
+			buf := make([]byte, blockSize)
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF {
+				return
+			}
+			res := make(chan block, 1)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				res <- block{err: err}
+			} else {
+				go func() { res <- compressBlock(buf[:n], level) }()
+			}
+			select {
+			case blocks <- res:
+			case <-done:
+				return
+			}
+			// the last block is shorter
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	empty := true
+	for res := range blocks {
+		b := <-res
+		if b.err != nil {
+			return b.err
+		}
+		if _, err := w.Write(b.data); err != nil {
+			return err
+		}
+		empty = false
+	}
+	if empty {
+		// an empty file is a single empty member
+		b := compressBlock(nil, level)
+		_, err := w.Write(b.data)
+		return err
+	}
+	return nil
+}
+
+func compressBlock(p []byte, level int) block {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return block{err: err}
+	}
+	if _, err := zw.Write(p); err != nil {
+		return block{err: err}
+	}
+	if err := zw.Close(); err != nil {
+		return block{err: err}
+	}
+	return block{data: buf.Bytes()}
+}
+
+// NewReader decompresses a compressed file
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -0,0 +1,124 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default terminal size of the sessions recorded without a resize marker,
+// e.g. without a PTY
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// castHeader is the header line of an asciicast v2 file
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+// Size returns the terminal size of a resize marker
+func (f Frame) Size() (cols, rows int, ok bool) {
+	if f.Marker != MarkerResize || len(f.Args) == 0 {
+		return 0, 0, false
+	}
+	c, r, ok := strings.Cut(f.Args[0], "x")
+	if !ok {
+		return 0, 0, false
+	}
+	cols, err1 := strconv.Atoi(c)
+	rows, err2 := strconv.Atoi(r)
+	return cols, rows, err1 == nil && err2 == nil
+}
+
+// WriteCast converts a log file to an asciicast v2 recording, the format of
+// asciinema. The log file only has the times of its markers, the output
+// following a marker is played at its time.
+func WriteCast(w io.Writer, r *Reader) error {
+	return Encode(&castEncoder{w: w}, r)
+}
+
+// castEncoder writes the asciicast v2 format
+type castEncoder struct {
+	w io.Writer
+	// events is the number of frames written, the first resize is the size
+	// of the header
+	events int
+}
+
+func (e *castEncoder) Header(h Header, cols, rows int) error {
+	ch := castHeader{Version: 2, Width: cols, Height: rows, Title: h.Command}
+	if start, ok := h.Start(); ok {
+		ch.Timestamp = start.Unix()
+	}
+	return writeCastLine(e.w, ch)
+}
+
+func (e *castEncoder) Event(f Frame, elapsed time.Duration) error {
+	e.events++
+	switch {
+	case f.Output != nil:
+		return writeCastEvent(e.w, elapsed, "o", string(f.Output))
+	case f.Marker == MarkerResize && e.events > 1:
+		if cols, rows, ok := f.Size(); ok {
+			return writeCastEvent(e.w, elapsed, "r", fmt.Sprintf("%dx%d", cols, rows))
+		}
+	}
+	return nil
+}
+
+func (e *castEncoder) Footer(footer map[string]string) error {
+	return nil
+}
+
+// timeline follows the time elapsed since the start of a session through
+// the times of the markers
+type timeline struct {
+	start   time.Time
+	timed   bool
+	elapsed time.Duration
+}
+
+func newTimeline(r *Reader) *timeline {
+	start, timed := r.Header.Start()
+	return &timeline{start: start, timed: timed}
+}
+
+// update moves the timeline to the time of a marker, it never goes back
+func (tl *timeline) update(f Frame) {
+	if t, ok := f.Time(); ok && tl.timed && t.After(tl.start) {
+		tl.elapsed = max(tl.elapsed, t.Sub(tl.start))
+	}
+}
+
+// initialSize returns the terminal size of a session, recorded by the first
+// frame of its log file
+func initialSize(first Frame) (cols, rows int) {
+	if cols, rows, ok := first.Size(); ok {
+		return cols, rows
+	}
+	return defaultCols, defaultRows
+}
+
+// writeCastEvent writes an event line of an asciicast v2 file
+func writeCastEvent(w io.Writer, elapsed time.Duration, code, data string) error {
+	return writeCastLine(w, []any{elapsed.Seconds(), code, data})
+}
+
+// writeCastLine writes a JSON line of an asciicast v2 file
+func writeCastLine(w io.Writer, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", line)
+	return err
+}
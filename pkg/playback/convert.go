@@ -0,0 +1,106 @@
+package playback
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// formatField and versionField match the format and version fields of the
+// session line of a log file header, their values are never quoted
+var (
+	formatField  = regexp.MustCompile(`(^| )format=\S*`)
+	versionField = regexp.MustCompile(`(^| )version=\S*`)
+)
+
+// upgrades convert the session line of a log file header from a format
+// version to the next one, and downgrades to the previous one, by version
+var (
+	upgrades = map[int]func(session string) string{
+		// version 2 records the format after the version of kubectl execrec
+		1: func(session string) string {
+			if loc := versionField.FindStringIndex(session); loc != nil {
+				return session[:loc[1]] + " format=2" + session[loc[1]:]
+			}
+			return session + " format=2"
+		},
+	}
+	downgrades = map[int]func(session string) string{
+		2: func(session string) string {
+			return formatField.ReplaceAllString(session, "")
+		},
+	}
+)
+
+// checkFormat checks that a log file format version can be read
+func checkFormat(format int) error {
+	switch {
+	case format == 0:
+		return fmt.Errorf("%w: invalid format version", ErrNotLog)
+	case format > recorder.FormatVersion:
+		return fmt.Errorf("log file format %d is newer than the supported format %d, upgrade kubectl execrec", format, recorder.FormatVersion)
+	}
+	return nil
+}
+
+// Convert copies a log file converted to the format version to, upgraded or
+// downgraded one version at a time. The output is copied as is, the format
+// versions only differ by their header. The parts of a rotated log file have
+// no header and are copied as is.
+func Convert(w io.Writer, r io.Reader, to int) error {
+	if to < 1 || to > recorder.FormatVersion {
+		return fmt.Errorf("invalid format version %d, expected 1 to %d", to, recorder.FormatVersion)
+	}
+	br := bufio.NewReaderSize(r, 64<<10)
+	if b, _ := br.Peek(len(MarkerRotated) + 2); string(b) == "["+MarkerRotated+"]" {
+		_, err := io.Copy(w, br)
+		return err
+	}
+
+	var lines [3]string
+	for i := range lines {
+		var err error
+		if lines[i], err = br.ReadString('\n'); err == io.EOF {
+			return ErrNotLog
+		} else if err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(lines[0], "[command] ") || !strings.HasPrefix(lines[1], "[session] ") || lines[2] != separator {
+		return ErrNotLog
+	}
+	session := strings.TrimSuffix(strings.TrimPrefix(lines[1], "[session] "), "\n")
+	from := Header{Fields: parseFields(session, nil)}.Format()
+	if err := checkFormat(from); err != nil {
+		return err
+	}
+	for v := from; v < to; v++ {
+		session = upgrades[v](session)
+	}
+	for v := from; v > to; v-- {
+		session = downgrades[v](session)
+	}
+
+	if _, err := io.WriteString(w, lines[0]+"[session] "+session+"\n"+lines[2]); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// ParseFormat parses a format version, "latest" being the current one
+func ParseFormat(s string) (int, error) {
+	if s == "latest" {
+		return recorder.FormatVersion, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 || v > recorder.FormatVersion {
+		return 0, fmt.Errorf("invalid format version %q, expected 1 to %d or latest", s, recorder.FormatVersion)
+	}
+	return v, nil
+}
@@ -0,0 +1,218 @@
+package playback
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Encoder writes a log file in another format. Encode calls Header once,
+// Event for every frame and Footer once.
+type Encoder interface {
+	// Header starts the output with the header of the log file and the
+	// terminal size the session started with
+	Header(h Header, cols, rows int) error
+	// Event writes a frame shown at the time elapsed since the start of the
+	// session, as far as the markers tell
+	Event(f Frame, elapsed time.Duration) error
+	// Footer ends the output with the fields of the footer of the log file,
+	// nil if the session did not end
+	Footer(footer map[string]string) error
+}
+
+// Encoders create the encoders of the formats a log file is exported to, by
+// name
+var Encoders = map[string]func(w io.Writer) Encoder{
+	"raw":    func(w io.Writer) Encoder { return &rawEncoder{w: bufio.NewWriter(w), lastByte: '\n'} },
+	"cast":   func(w io.Writer) Encoder { return &castEncoder{w: w} },
+	"jsonl":  func(w io.Writer) Encoder { return &jsonlEncoder{enc: json.NewEncoder(w)} },
+	"ttyrec": func(w io.Writer) Encoder { return &ttyrecEncoder{w: bufio.NewWriter(w)} },
+	"svg":    func(w io.Writer) Encoder { return &svgEncoder{w: w} },
+	"transcript": func(w io.Writer) Encoder {
+		return NewTranscriptEncoder(w, nil)
+	},
+}
+
+// Encode reads a log file and writes it with an encoder
+func Encode(e Encoder, r *Reader) error {
+	tl := newTimeline(r)
+	first, err := r.Next()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	cols, rows := initialSize(first)
+	if err := e.Header(r.Header, cols, rows); err != nil {
+		return err
+	}
+	for f := first; err != io.EOF; {
+		tl.update(f)
+		if err := e.Event(f, tl.elapsed); err != nil {
+			return err
+		}
+		if f, err = r.Next(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return e.Footer(r.Footer)
+}
+
+// headerOrder is the order the recorder writes the fields of the session
+// line of the header in, the other fields follow in alphabetical order
+var headerOrder = []string{"start", "user", "context", "cluster", "version", "format", "id", "group", "as", "as-groups", "as-uid", "reason", "image"}
+
+// footerOrder is the order of the fields of the session line of the footer,
+// "terminated abnormally" if the recorder was killed
+var footerOrder = []string{"end", "terminated", "abnormally"}
+
+// rawEncoder writes the log file format itself, e.g. to read a compressed or
+// encrypted log file as plain text. The fields are written in the order of
+// the recorder, the ones it does not know in alphabetical order.
+type rawEncoder struct {
+	w        *bufio.Writer
+	lastByte byte
+}
+
+func (e *rawEncoder) Header(h Header, cols, rows int) error {
+	// the parts of a rotated log file have no header
+	if h.Fields == nil {
+		return nil
+	}
+	fmt.Fprintf(e.w, "[command] %s\n[session] %s\n%s", h.Command, strings.TrimPrefix(formatFields(h.Fields, headerOrder), " "), separator)
+	return nil
+}
+
+func (e *rawEncoder) Event(f Frame, elapsed time.Duration) error {
+	if f.Output != nil {
+		if len(f.Output) > 0 {
+			e.w.Write(f.Output)
+			e.lastByte = f.Output[len(f.Output)-1]
+		}
+		return nil
+	}
+	e.newLine()
+	line := "[" + f.Marker + "]"
+	for _, arg := range f.Args {
+		line += " " + arg
+	}
+	// the time is the last field of the markers
+	keys := slices.DeleteFunc(slices.Sorted(maps.Keys(f.Fields)), func(k string) bool { return k == "time" })
+	line += formatFields(f.Fields, append(keys, "time"))
+	_, err := e.w.WriteString(line + "\n")
+	return err
+}
+
+func (e *rawEncoder) Footer(footer map[string]string) error {
+	if footer != nil {
+		e.newLine()
+		fmt.Fprintf(e.w, "%s[session] %s\n", separator, strings.TrimPrefix(formatFields(footer, footerOrder), " "))
+	}
+	return e.w.Flush()
+}
+
+// newLine starts a new line before a marker
+func (e *rawEncoder) newLine() {
+	if e.lastByte != '\n' {
+		e.w.WriteByte('\n')
+	}
+	e.lastByte = '\n'
+}
+
+// formatFields formats the fields of a line as the recorder does, the ones
+// of order first in this order, then the others in alphabetical order. The
+// fields without value are written as a word.
+func formatFields(fields map[string]string, order []string) string {
+	keys := slices.DeleteFunc(slices.Sorted(maps.Keys(fields)), func(k string) bool { return slices.Contains(order, k) })
+	var b strings.Builder
+	for _, k := range append(slices.Clone(order), keys...) {
+		v, ok := fields[k]
+		switch {
+		case !ok:
+		case v == "":
+			b.WriteString(" " + k)
+		default:
+			b.WriteString(headerField(k, v))
+		}
+	}
+	return b.String()
+}
+
+// jsonlEncoder writes a JSON object per line: the header, every output and
+// marker with its time in seconds since the start of the session, and the
+// footer, e.g. to process a session with jq
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+// jsonlLine is a line of the JSONL format, its type is header, output,
+// marker or footer
+type jsonlLine struct {
+	Type    string            `json:"type"`
+	Time    *float64          `json:"time,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Cols    int               `json:"cols,omitempty"`
+	Rows    int               `json:"rows,omitempty"`
+	Data    string            `json:"data,omitempty"`
+	Marker  string            `json:"marker,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *jsonlEncoder) Header(h Header, cols, rows int) error {
+	return e.enc.Encode(jsonlLine{Type: "header", Command: h.Command, Cols: cols, Rows: rows, Fields: h.Fields})
+}
+
+func (e *jsonlEncoder) Event(f Frame, elapsed time.Duration) error {
+	t := elapsed.Seconds()
+	if f.Output != nil {
+		return e.enc.Encode(jsonlLine{Type: "output", Time: &t, Data: string(f.Output)})
+	}
+	return e.enc.Encode(jsonlLine{Type: "marker", Time: &t, Marker: f.Marker, Args: f.Args, Fields: f.Fields})
+}
+
+func (e *jsonlEncoder) Footer(footer map[string]string) error {
+	if footer == nil {
+		return nil
+	}
+	return e.enc.Encode(jsonlLine{Type: "footer", Fields: footer})
+}
+
+// ttyrecEncoder writes the ttyrec format of ttyplay, a record per output at
+// the start time of the session plus its time. The markers are dropped,
+// ttyrec has no terminal size.
+type ttyrecEncoder struct {
+	w     *bufio.Writer
+	start time.Time
+}
+
+func (e *ttyrecEncoder) Header(h Header, cols, rows int) error {
+	// only the times between the records matter to ttyplay
+	start, ok := h.Start()
+	if !ok {
+		start = time.Unix(0, 0)
+	}
+	e.start = start
+	return nil
+}
+
+func (e *ttyrecEncoder) Event(f Frame, elapsed time.Duration) error {
+	if len(f.Output) == 0 {
+		return nil
+	}
+	t := e.start.Add(elapsed)
+	header := [3]uint32{uint32(t.Unix()), uint32(t.Nanosecond() / 1000), uint32(len(f.Output))}
+	if err := binary.Write(e.w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	_, err := e.w.Write(f.Output)
+	return err
+}
+
+func (e *ttyrecEncoder) Footer(footer map[string]string) error {
+	return e.w.Flush()
+}
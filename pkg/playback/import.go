@@ -0,0 +1,313 @@
+package playback
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recording is a session recorded by another tool, read by the readers of
+// ImportFormats to be written as a log file with WriteLog
+type Recording struct {
+	// Command is the command line or the title of the session, if recorded
+	Command string
+	// Start and End are the times of the session, zero if not recorded
+	Start time.Time
+	End   time.Time
+	// Cols and Rows are the terminal size when the session started, zero
+	// if not recorded
+	Cols int
+	Rows int
+	// ExitCode is the exit code of the command, 0 if not recorded
+	ExitCode int
+	Chunks   []Chunk
+}
+
+// Chunk is output of a recording, or a resize of its terminal if Output is
+// nil
+type Chunk struct {
+	// Time is the time the output was shown, zero if not recorded
+	Time   time.Time
+	Output []byte
+	Cols   int
+	Rows   int
+}
+
+// ImportFormats are the readers of the recordings of other tools, by name:
+// script(1) typescripts, asciinema casts v1 to v3 and ttyrec files
+var ImportFormats = map[string]func(r io.Reader) (*Recording, error){
+	"script": ReadScript,
+	"cast":   ReadCast,
+	"ttyrec": ReadTtyrec,
+}
+
+// DetectFormat returns the format of ImportFormats of a recording from its
+// first bytes, ttyrec if it is neither a typescript nor a cast since ttyrec
+// files have no signature
+func DetectFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("Script started on ")):
+		return "script"
+	case bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")):
+		return "cast"
+	}
+	if len(head) >= 12 {
+		// the length of the first record of a ttyrec file is the rest of a
+		// small file or less
+		if n := binary.LittleEndian.Uint32(head[8:12]); n > 0 && n < 1<<24 {
+			return "ttyrec"
+		}
+	}
+	return "script"
+}
+
+// scriptTimeLayouts are the layouts of the times of the first and last lines
+// of a typescript: util-linux 2.35 and later, older util-linux in the C
+// locale, and BSD and macOS
+var scriptTimeLayouts = []string{
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"Mon 02 Jan 2006 03:04:05 PM MST",
+	"Mon Jan _2 15:04:05 2006",
+	time.UnixDate,
+}
+
+// ReadScript reads a typescript of script(1). Its first and last lines have
+// the start and end times, and with util-linux the command, the terminal
+// size and the exit code. The times of the output are not recorded.
+func ReadScript(r io.Reader) (*Recording, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recording{}
+	if rest, ok := bytes.CutPrefix(data, []byte("Script started on ")); ok {
+		line, body, _ := bytes.Cut(rest, []byte("\n"))
+		var fields map[string]string
+		rec.Start, fields = parseScriptLine(string(line))
+		rec.Command = fields["COMMAND"]
+		rec.Cols, _ = strconv.Atoi(fields["COLUMNS"])
+		rec.Rows, _ = strconv.Atoi(fields["LINES"])
+		data = body
+	}
+	if i := bytes.LastIndex(data, []byte("\nScript done on ")); i >= 0 {
+		line := strings.TrimSpace(string(data[i+len("\nScript done on "):]))
+		var fields map[string]string
+		rec.End, fields = parseScriptLine(line)
+		rec.ExitCode, _ = strconv.Atoi(fields["COMMAND_EXIT_CODE"])
+		data = data[:i+1]
+	}
+	if len(data) > 0 {
+		rec.Chunks = []Chunk{{Output: data}}
+	}
+	return rec, nil
+}
+
+// parseScriptLine parses the time and the [KEY="value" ...] fields of the
+// first or last line of a typescript
+func parseScriptLine(line string) (time.Time, map[string]string) {
+	date, fields, _ := strings.Cut(line, " [")
+	date = strings.TrimSpace(date)
+	var t time.Time
+	for _, layout := range scriptTimeLayouts {
+		var err error
+		if t, err = time.Parse(layout, date); err == nil {
+			break
+		}
+	}
+	return t, parseFields(strings.TrimSuffix(fields, "]"), nil)
+}
+
+// castFile is the header of asciicast v1 to v3 files, v3 has the terminal
+// size in term, and the whole recording for v1
+type castFile struct {
+	Version   int     `json:"version"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Timestamp float64 `json:"timestamp"`
+	Command   string  `json:"command"`
+	Title     string  `json:"title"`
+	Term      struct {
+		Cols int `json:"cols"`
+		Rows int `json:"rows"`
+	} `json:"term"`
+	// Stdout are the frames of v1, their delay since the previous one and
+	// their output
+	Stdout [][2]any `json:"stdout"`
+}
+
+// ReadCast reads an asciicast v1, v2 or v3 recording of asciinema, its
+// output and resize events. The times are relative to the start of the
+// session, which is only known if the cast has a timestamp.
+func ReadCast(r io.Reader) (*Recording, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	dec := json.NewDecoder(br)
+	var h castFile
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if h.Version < 1 || h.Version > 3 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	rec := &Recording{Command: h.Command, Cols: h.Width, Rows: h.Height}
+	if rec.Command == "" {
+		rec.Command = h.Title
+	}
+	if h.Version == 3 {
+		rec.Cols, rec.Rows = h.Term.Cols, h.Term.Rows
+	}
+	var start time.Time
+	if h.Timestamp > 0 {
+		sec, frac := math.Modf(h.Timestamp)
+		start = time.Unix(int64(sec), int64(frac*1e9))
+		rec.Start = start
+	}
+	at := func(elapsed float64) time.Time {
+		if start.IsZero() {
+			return time.Time{}
+		}
+		return start.Add(time.Duration(elapsed * float64(time.Second)))
+	}
+
+	var elapsed float64
+	if h.Version == 1 {
+		for _, frame := range h.Stdout {
+			delay, _ := frame[0].(float64)
+			output, _ := frame[1].(string)
+			elapsed += delay
+			rec.Chunks = append(rec.Chunks, Chunk{Time: at(elapsed), Output: []byte(output)})
+		}
+	}
+	for h.Version > 1 {
+		var event []any
+		if err := dec.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid asciicast event: %w", err)
+		}
+		if len(event) != 3 {
+			return nil, fmt.Errorf("invalid asciicast event %v", event)
+		}
+		t, _ := event[0].(float64)
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		// the times of v3 are intervals since the previous event
+		if h.Version == 3 {
+			elapsed += t
+		} else {
+			elapsed = t
+		}
+		switch code {
+		case "o":
+			rec.Chunks = append(rec.Chunks, Chunk{Time: at(elapsed), Output: []byte(data)})
+		case "r":
+			c, r, _ := strings.Cut(data, "x")
+			cols, err1 := strconv.Atoi(c)
+			rows, err2 := strconv.Atoi(r)
+			if err1 == nil && err2 == nil {
+				rec.Chunks = append(rec.Chunks, Chunk{Time: at(elapsed), Cols: cols, Rows: rows})
+			}
+		case "x":
+			rec.ExitCode, _ = strconv.Atoi(data)
+		}
+	}
+	if !start.IsZero() {
+		rec.End = at(elapsed)
+	}
+	return rec, nil
+}
+
+// ReadTtyrec reads a ttyrec recording, records of output with their time.
+// It has no command nor terminal size.
+func ReadTtyrec(r io.Reader) (*Recording, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	rec := &Recording{}
+	for {
+		var h [3]uint32
+		if err := binary.Read(br, binary.LittleEndian, &h); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid ttyrec record: %w", err)
+		}
+		output := make([]byte, h[2])
+		if _, err := io.ReadFull(br, output); err != nil {
+			return nil, fmt.Errorf("invalid ttyrec record: %w", err)
+		}
+		t := time.Unix(int64(h[0]), int64(h[1])*1000)
+		if rec.Start.IsZero() {
+			rec.Start = t
+		}
+		rec.End = t
+		rec.Chunks = append(rec.Chunks, Chunk{Time: t, Output: output})
+	}
+	if len(rec.Chunks) == 0 {
+		return nil, errors.New("empty ttyrec file")
+	}
+	return rec, nil
+}
+
+// WriteLog writes a recording as a log file: the header with the command and
+// the fields of the session line, in order, the output with a resize marker
+// for the terminal size and every resize, and the footer with the end time.
+// The times of the output itself are not kept, the log file only has times
+// on its markers.
+func WriteLog(w io.Writer, rec *Recording, fields [][2]string, timeFormat string) error {
+	bw := bufio.NewWriter(w)
+	session := ""
+	for _, f := range fields {
+		session += headerField(f[0], f[1])
+	}
+	fmt.Fprintf(bw, "[command] %s\n[session] %s\n%s", rec.Command, strings.TrimPrefix(session, " "), separator)
+
+	lastByte := byte('\n')
+	marker := func(s string) {
+		if lastByte != '\n' {
+			bw.WriteByte('\n')
+		}
+		bw.WriteString(s)
+		lastByte = '\n'
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			t = rec.Start
+		}
+		return t.Format(timeFormat)
+	}
+	if rec.Cols > 0 && rec.Rows > 0 {
+		marker(fmt.Sprintf("[%s] %dx%d time=%s\n", MarkerResize, rec.Cols, rec.Rows, formatTime(rec.Start)))
+	}
+	for _, c := range rec.Chunks {
+		if c.Output == nil {
+			marker(fmt.Sprintf("[%s] %dx%d time=%s\n", MarkerResize, c.Cols, c.Rows, formatTime(c.Time)))
+			continue
+		}
+		if len(c.Output) > 0 {
+			bw.Write(c.Output)
+			lastByte = c.Output[len(c.Output)-1]
+		}
+	}
+	marker(separator)
+	fmt.Fprintf(bw, "[session] end=%s\n", formatTime(rec.End))
+	return bw.Flush()
+}
+
+// headerField formats a field of the session line of a log file header as
+// the recorder does, the values with spaces or quotes are quoted and empty
+// values are omitted
+func headerField(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.ContainsAny(value, " \t\"") {
+		value = strconv.Quote(value)
+	}
+	return " " + key + "=" + value
+}
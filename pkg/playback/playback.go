@@ -0,0 +1,224 @@
+// Package playback reads the log files of recorded sessions to replay or
+// export them
+package playback
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markers are the names of the marker lines of a log file
+const (
+	MarkerResize    = "resize"
+	MarkerPrompt    = "prompt"
+	MarkerStdout    = "stdout"
+	MarkerStderr    = "stderr"
+	MarkerRotated   = "rotated"
+	MarkerStopped   = "recording stopped"
+	MarkerIdle      = "idle"
+	MarkerReconnect = "reconnect"
+	MarkerTimeout   = "timeout"
+)
+
+var markers = []string{MarkerResize, MarkerPrompt, MarkerStdout, MarkerStderr, MarkerRotated, MarkerStopped, MarkerIdle, MarkerReconnect, MarkerTimeout}
+
+// separator is the line between the header or the footer and the output
+var separator = strings.Repeat("=", 80) + "\n"
+
+// ErrNotLog is returned when a file is not a log file of kubectl execrec,
+// e.g. a file that is still encrypted
+var ErrNotLog = errors.New("not a kubectl execrec log file")
+
+// Header is the header of a log file
+type Header struct {
+	// Command is the command line of the session
+	Command string
+	// Fields are the fields of the session line: start, user, context,
+	// version, format and id
+	Fields map[string]string
+}
+
+// Format returns the format version of the log file, 1 for the log files
+// written before it was recorded and 0 if it is invalid
+func (h Header) Format() int {
+	v, ok := h.Fields["format"]
+	if !ok {
+		return 1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// Start returns the start time of the session, if it was written in the
+// default RFC 3339 format
+func (h Header) Start() (time.Time, bool) {
+	return parseTime(h.Fields["start"])
+}
+
+// Frame is a chunk of output or a marker line of a log file
+type Frame struct {
+	// Output is a chunk of recorded output, nil for a marker
+	Output []byte
+	// Marker is the name of a marker, e.g. MarkerPrompt
+	Marker string
+	// Args are the words of the marker line and Fields its key=value
+	// fields, e.g. [120x40] and time for a resize marker
+	Args   []string
+	Fields map[string]string
+}
+
+// Time returns the time of a marker, if it was written in the default
+// RFC 3339 format
+func (f Frame) Time() (time.Time, bool) {
+	return parseTime(f.Fields["time"])
+}
+
+func parseTime(s string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
+
+// Reader reads the frames of a log file
+type Reader struct {
+	// Header is the header of the log file, empty for a part of a rotated
+	// log file
+	Header Header
+	// Footer are the fields of the footer: end, and detached or terminated
+	// for a session that ended abnormally and error for a session that was
+	// not fully recorded, nil until Next returned io.EOF or
+	// if the session did not end
+	Footer map[string]string
+
+	br *bufio.Reader
+	// lineStart is set when the next read starts a line
+	lineStart bool
+	done      bool
+}
+
+// NewReader reads the header of a log file
+func NewReader(r io.Reader) (*Reader, error) {
+	lr := &Reader{br: bufio.NewReaderSize(r, 64<<10), lineStart: true}
+	b, err := lr.br.Peek(len(MarkerRotated) + 2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(b) == "["+MarkerRotated+"]" {
+		return lr, nil
+	}
+
+	var lines [3]string
+	for i := range lines {
+		if lines[i], err = lr.br.ReadString('\n'); err == io.EOF {
+			return nil, ErrNotLog
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	command, session := lines[0], lines[1]
+	if !strings.HasPrefix(command, "[command] ") || !strings.HasPrefix(session, "[session] ") || lines[2] != separator {
+		return nil, ErrNotLog
+	}
+	lr.Header = Header{
+		Command: strings.TrimSuffix(strings.TrimPrefix(command, "[command] "), "\n"),
+		Fields:  parseFields(strings.TrimPrefix(session, "[session] "), nil),
+	}
+	if err := checkFormat(lr.Header.Format()); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// Next returns the next frame, or io.EOF after the last one. Long lines are
+// returned in several frames.
+func (r *Reader) Next() (Frame, error) {
+	if r.done {
+		return Frame{}, io.EOF
+	}
+	line, err := r.br.ReadSlice('\n')
+	complete := err == nil
+	switch {
+	case err == io.EOF && len(line) == 0:
+		// a session that did not end has no footer
+		r.done = true
+		return Frame{}, io.EOF
+	case err != nil && err != io.EOF && err != bufio.ErrBufferFull:
+		return Frame{}, err
+	}
+	// the line is only valid until the next read
+	line = bytes.Clone(line)
+
+	if r.lineStart && complete {
+		if f, ok := parseMarker(line); ok {
+			return f, nil
+		}
+		if string(line) == separator {
+			if b, _ := r.br.Peek(len("[session] ")); string(b) == "[session] " {
+				footer, err := r.br.ReadString('\n')
+				if err != nil && err != io.EOF {
+					return Frame{}, err
+				}
+				r.Footer = parseFields(strings.TrimPrefix(footer, "[session] "), nil)
+				r.done = true
+				return Frame{}, io.EOF
+			}
+		}
+	}
+	r.lineStart = complete
+	return Frame{Output: line}, nil
+}
+
+// parseMarker parses a marker line such as "[prompt] n=3 time=..."
+func parseMarker(line []byte) (Frame, bool) {
+	if len(line) < 3 || line[0] != '[' {
+		return Frame{}, false
+	}
+	s := strings.TrimSuffix(string(line[1:]), "\n")
+	for _, name := range markers {
+		rest, ok := strings.CutPrefix(s, name+"]")
+		if !ok || (rest != "" && rest[0] != ' ') {
+			continue
+		}
+		f := Frame{Marker: name}
+		f.Fields = parseFields(rest, &f.Args)
+		return f, true
+	}
+	return Frame{}, false
+}
+
+// parseFields parses the key=value fields of a line, the other words are
+// appended to args if not nil, or else are fields without value. A value
+// can be quoted, e.g. reason="incident 42".
+func parseFields(s string, args *[]string) map[string]string {
+	fields := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		word := s
+		if i := strings.IndexAny(s, " \t"); i >= 0 {
+			word = s[:i]
+		}
+		k, v, ok := strings.Cut(word, "=")
+		if ok && strings.HasPrefix(v, `"`) {
+			if quoted, err := strconv.QuotedPrefix(s[len(k)+1:]); err == nil {
+				word = k + "=" + quoted
+				v, _ = strconv.Unquote(quoted)
+			}
+		}
+		switch {
+		case ok:
+			fields[k] = v
+		case args != nil:
+			*args = append(*args, word)
+		default:
+			fields[word] = ""
+		}
+		s = s[len(word):]
+	}
+	return fields
+}
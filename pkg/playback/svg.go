@@ -0,0 +1,121 @@
+package playback
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// geometry of the SVG rendering, in pixels
+const (
+	svgFontSize   = 14
+	svgCharWidth  = 8.4
+	svgLineHeight = 18
+	svgPadding    = 12
+)
+
+// svgHold is how long the last screen is shown before the animation loops
+const svgHold = 3 * time.Second
+
+// WriteSVG renders a log file to an animated SVG image, e.g. for documents
+// where a player cannot be embedded. The output is rendered as plain text,
+// without colors and with full screen applications replaced by a marker,
+// and every line is shown at the time of the marker preceding it.
+func WriteSVG(w io.Writer, r *Reader) error {
+	return Encode(&svgEncoder{w: w}, r)
+}
+
+// svgEncoder renders the animated SVG image once every frame was added
+type svgEncoder struct {
+	w    io.Writer
+	text *textRenderer
+}
+
+func (e *svgEncoder) Header(h Header, cols, rows int) error {
+	e.text = newTextRenderer(cols, rows)
+	return nil
+}
+
+func (e *svgEncoder) Event(f Frame, elapsed time.Duration) error {
+	return e.text.add(f, elapsed)
+}
+
+func (e *svgEncoder) Footer(footer map[string]string) error {
+	text, err := e.text.finish()
+	if err != nil {
+		return err
+	}
+	return renderSVG(e.w, text.lines, text.cols, text.rows, text.elapsed+svgHold)
+}
+
+// renderSVG writes the lines in a terminal of cols and rows: every line
+// appears at its time and the lines scroll up once the terminal is full,
+// the animation lasts total and loops
+func renderSVG(w io.Writer, lines []textLine, cols, rows int, total time.Duration) error {
+	width := float64(cols)*svgCharWidth + 2*svgPadding
+	height := rows*svgLineHeight + 2*svgPadding
+	percent := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f%%", 100*d.Seconds()/total.Seconds())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%d" viewBox="0 0 %.0f %d">`+"\n", width, height, width, height)
+	b.WriteString("<style>\n")
+	fmt.Fprintf(&b, "text { font-family: Menlo, Consolas, 'DejaVu Sans Mono', monospace; font-size: %dpx; fill: #e5e5e5; white-space: pre; }\n", svgFontSize)
+	duration := fmt.Sprintf("%.3fs", total.Seconds())
+
+	// a class per time lines appear at, and the scroll position at every
+	// time
+	var scroll strings.Builder
+	times := map[time.Duration]int{}
+	for i, l := range lines {
+		if _, ok := times[l.at]; ok || l.at == 0 {
+			continue
+		}
+		n := len(times)
+		times[l.at] = n
+		fmt.Fprintf(&b, ".t%d { visibility: hidden; animation: t%d %s step-end infinite; }\n", n, n, duration)
+		fmt.Fprintf(&b, "@keyframes t%d { 0%% { visibility: hidden; } %s, 100%% { visibility: visible; } }\n", n, percent(l.at))
+		fmt.Fprintf(&scroll, "%s { transform: translateY(%dpx); } ", percent(l.at), -scrollTop(lines, i, rows)*svgLineHeight)
+	}
+	fmt.Fprintf(&b, ".s { animation: s %s step-end infinite; }\n", duration)
+	fmt.Fprintf(&b, "@keyframes s { 0%% { transform: translateY(%dpx); } %s100%% { transform: translateY(%dpx); } }\n",
+		-scrollTop(lines, -1, rows)*svgLineHeight, scroll.String(), -scrollTop(lines, len(lines)-1, rows)*svgLineHeight)
+	b.WriteString("</style>\n")
+
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" rx="6" fill="#1e1e1e"/>`+"\n")
+	fmt.Fprintf(&b, `<svg x="%d" y="%d" width="%.0f" height="%d">`+"\n", svgPadding, svgPadding, width-2*svgPadding, rows*svgLineHeight)
+	b.WriteString(`<g class="s">` + "\n")
+	for i, l := range lines {
+		class := ""
+		if n, ok := times[l.at]; ok {
+			class = fmt.Sprintf(` class="t%d"`, n)
+		}
+		fmt.Fprintf(&b, `<text x="0" y="%d"%s>`, (i+1)*svgLineHeight-4, class)
+		if err := xml.EscapeText(&b, []byte(l.text)); err != nil {
+			return err
+		}
+		b.WriteString("</text>\n")
+	}
+	b.WriteString("</g>\n</svg>\n</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// scrollTop returns the first line shown once the line first appeared, or
+// at the start of the animation if first is negative
+func scrollTop(lines []textLine, first, rows int) int {
+	var at time.Duration
+	if first >= 0 {
+		at = lines[first].at
+	}
+	shown := 0
+	for _, l := range lines {
+		if l.at <= at {
+			shown++
+		}
+	}
+	return max(shown-rows, 0)
+}
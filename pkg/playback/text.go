@@ -0,0 +1,113 @@
+package playback
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// textLine is a line of the plain text rendering of a log file
+type textLine struct {
+	text string
+	// at is the time the line was shown
+	at time.Duration
+	// prompt is set for the first line following a prompt marker
+	prompt bool
+}
+
+// renderedText is the plain text rendering of a log file
+type renderedText struct {
+	lines []textLine
+	// cols and rows are the terminal size when the session started
+	cols, rows int
+	// elapsed is the time of the last marker
+	elapsed time.Duration
+}
+
+// lineCollector collects the lines rendered by a text writer
+type lineCollector struct {
+	lines []textLine
+	buf   []byte
+	// at is the time of the last marker and prompt is set after a prompt
+	// marker until the next line
+	at     time.Duration
+	prompt bool
+}
+
+func (c *lineCollector) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		c.lines = append(c.lines, textLine{text: expandTabs(string(c.buf[:i])), at: c.at, prompt: c.prompt})
+		c.prompt = false
+		c.buf = c.buf[i+1:]
+	}
+}
+
+// textRenderer renders the frames of a log file as plain text like the plain
+// text transcript, the markers are applied to the lines
+type textRenderer struct {
+	text  renderedText
+	lines *lineCollector
+	w     io.Writer
+}
+
+// newTextRenderer renders a session started in a terminal of cols and rows
+func newTextRenderer(cols, rows int) *textRenderer {
+	lines := &lineCollector{}
+	return &textRenderer{text: renderedText{cols: cols, rows: rows}, lines: lines, w: recorder.NewTextWriter(lines)}
+}
+
+// add renders a frame shown at elapsed
+func (t *textRenderer) add(f Frame, elapsed time.Duration) error {
+	t.lines.at = elapsed
+	t.text.elapsed = elapsed
+	if f.Marker == MarkerPrompt {
+		t.lines.prompt = true
+	}
+	if f.Output != nil {
+		_, err := t.w.Write(f.Output)
+		return err
+	}
+	return nil
+}
+
+// finish returns the text once every frame was added
+func (t *textRenderer) finish() (renderedText, error) {
+	// the last line, e.g. the prompt the session was left at
+	if _, err := t.w.Write([]byte("\n")); err != nil {
+		return renderedText{}, err
+	}
+	lines := t.lines.lines
+	if n := len(lines); n > 0 && lines[n-1].text == "" && !lines[n-1].prompt {
+		lines = lines[:n-1]
+	}
+	t.text.lines = lines
+	return t.text, nil
+}
+
+// expandTabs replaces the tabs of a line with spaces up to the next tab stop
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
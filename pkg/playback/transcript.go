@@ -0,0 +1,146 @@
+package playback
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPrompt matches the prompts of common shells before the command: a
+// word such as user@host:dir followed by $, # or %, e.g. "root@web-0:/app# "
+// or "/ # "
+var DefaultPrompt = regexp.MustCompile(`^[^\s$#%]*\s?[$#%](\s|$)`)
+
+// WriteTranscript writes a readable transcript of a log file for audit
+// responses: the session details, then every command as "$ command"
+// followed by its output rendered as plain text. The commands are on the
+// lines following the prompt markers, or else on the lines matching
+// prompt, and prompt is removed from them. prompt is DefaultPrompt if nil.
+func WriteTranscript(w io.Writer, r *Reader, prompt *regexp.Regexp) error {
+	return Encode(NewTranscriptEncoder(w, prompt), r)
+}
+
+// NewTranscriptEncoder returns the encoder of the transcripts of
+// WriteTranscript
+func NewTranscriptEncoder(w io.Writer, prompt *regexp.Regexp) Encoder {
+	if prompt == nil {
+		prompt = DefaultPrompt
+	}
+	return &transcriptEncoder{w: w, prompt: prompt}
+}
+
+// transcriptEncoder writes the transcript once every frame was added
+type transcriptEncoder struct {
+	w      io.Writer
+	prompt *regexp.Regexp
+	header Header
+	text   *textRenderer
+}
+
+func (e *transcriptEncoder) Header(h Header, cols, rows int) error {
+	e.header = h
+	e.text = newTextRenderer(cols, rows)
+	return nil
+}
+
+func (e *transcriptEncoder) Event(f Frame, elapsed time.Duration) error {
+	return e.text.add(f, elapsed)
+}
+
+func (e *transcriptEncoder) Footer(footer map[string]string) error {
+	text, err := e.text.finish()
+	if err != nil {
+		return err
+	}
+	h, prompt := e.header, e.prompt
+
+	var b strings.Builder
+	for _, field := range []struct{ name, value string }{
+		{"Session", h.Fields["id"]},
+		{"User", h.Fields["user"]},
+		{"As", impersonation(h.Fields)},
+		{"Reason", h.Fields["reason"]},
+		{"Context", h.Fields["context"]},
+		{"Cluster", h.Fields["cluster"]},
+		{"Command", h.Command},
+		{"Start", h.Fields["start"]},
+		{"End", sessionEnd(footer)},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "%-9s %s\n", field.name+":", field.value)
+		}
+	}
+
+	marked := false
+	for _, l := range text.lines {
+		marked = marked || l.prompt
+	}
+	// output holds the output of the current command, its blank lines are
+	// written once it continues
+	var output []string
+	blank, commands := 0, 0
+	flush := func() {
+		// e.g. the message of the day before the first prompt
+		if commands == 0 && len(output) > 0 {
+			b.WriteString("\n")
+		}
+		for _, line := range output {
+			b.WriteString(line + "\n")
+		}
+		output = output[:0]
+		blank = 0
+	}
+	for _, l := range text.lines {
+		isPrompt := l.prompt || (!marked && prompt.MatchString(l.text))
+		if !isPrompt {
+			if strings.TrimSpace(l.text) == "" {
+				blank++
+				continue
+			}
+			// successive blank lines are collapsed
+			if blank > 0 && len(output) > 0 {
+				output = append(output, "")
+			}
+			blank = 0
+			output = append(output, l.text)
+			continue
+		}
+		flush()
+		command := l.text
+		if loc := prompt.FindStringIndex(command); loc != nil && loc[0] == 0 {
+			command = command[loc[1]:]
+		}
+		if command = strings.TrimSpace(command); command != "" {
+			fmt.Fprintf(&b, "\n$ %s\n", command)
+			commands++
+		}
+	}
+	flush()
+
+	_, err = io.WriteString(e.w, b.String())
+	return err
+}
+
+// impersonation describes the identity a session acted as from the fields
+// of its header
+func impersonation(fields map[string]string) string {
+	s := fields["as"]
+	if groups := fields["as-groups"]; groups != "" {
+		s = strings.TrimSpace(s + " (groups " + groups + ")")
+	}
+	return s
+}
+
+// sessionEnd describes the end of a session from the fields of the footer
+func sessionEnd(footer map[string]string) string {
+	end := footer["end"]
+	if _, ok := footer["detached"]; ok {
+		end += " (detached)"
+	}
+	if _, ok := footer["terminated"]; ok {
+		end += " (terminated abnormally)"
+	}
+	return end
+}
@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"unicode/utf8"
+)
+
+const (
+	// binaryMinChunk is the size from which a chunk starts a binary run, so
+	// that a few control characters typed or echoed are never replaced
+	binaryMinChunk = 64
+	// binaryRatio is the share of non-text bytes from which a chunk is binary
+	binaryRatio = 0.1
+)
+
+// binaryFilter replaces runs of binary output with a placeholder marker.
+//
+// The text before the first binary byte of a run is recorded, and the text
+// lines after the last binary byte of a chunk are held back until the next
+// chunk tells whether the run continues.
+type binaryFilter struct {
+	// n is the size of the current binary run, hash its digest
+	n    int
+	hash hash.Hash
+	// tail is the text held back after the last binary byte
+	tail []byte
+}
+
+// filter returns what to record for a chunk of output, last is the last
+// byte recorded so far
+func (f *binaryFilter) filter(p []byte, last byte) []byte {
+	first, end := nonTextBounds(p)
+	if !isBinary(p, f.n > 0) {
+		if f.n == 0 {
+			return p
+		}
+		return append(f.flush(last), p...)
+	}
+
+	var out []byte
+	if f.n == 0 {
+		// keep the text lines before the binary data
+		cut := bytes.LastIndexByte(p[:first], '\n') + 1
+		out = p[:cut]
+		p = p[cut:]
+		end -= cut
+		f.hash = sha256.New()
+	} else {
+		f.add(f.tail)
+	}
+
+	tail := len(p)
+	if i := bytes.IndexByte(p[end:], '\n'); i >= 0 {
+		tail = end + i + 1
+	}
+	f.add(p[:tail])
+	f.tail = append(f.tail[:0], p[tail:]...)
+	return out
+}
+
+// flush ends the current binary run and returns its marker followed by the
+// held back text
+func (f *binaryFilter) flush(last byte) []byte {
+	if f.n == 0 {
+		return nil
+	}
+	marker := fmt.Sprintf("[binary data: %d bytes, sha256=%s]\n", f.n, hex.EncodeToString(f.hash.Sum(nil)))
+	if last != '\n' {
+		marker = "\n" + marker
+	}
+	out := append([]byte(marker), f.tail...)
+	f.n = 0
+	f.tail = f.tail[:0]
+	return out
+}
+
+func (f *binaryFilter) add(p []byte) {
+	f.n += len(p)
+	f.hash.Write(p)
+}
+
+// isBinary reports whether a chunk of output looks like binary data rather
+// than terminal output, small chunks are only binary within a binary run
+func isBinary(p []byte, inRun bool) bool {
+	if len(p) < binaryMinChunk && !inRun {
+		return false
+	}
+	if bytes.IndexByte(p, 0) >= 0 {
+		return true
+	}
+	n := 0
+	for i := 0; i < len(p); {
+		nonText, size := nonTextAt(p, i)
+		if nonText {
+			n++
+		}
+		i += size
+	}
+	return float64(n) > binaryRatio*float64(len(p))
+}
+
+// nonTextBounds returns the index of the first non-text byte of p and the
+// index following the last one
+func nonTextBounds(p []byte) (int, int) {
+	first, end := len(p), 0
+	for i := 0; i < len(p); {
+		nonText, size := nonTextAt(p, i)
+		if nonText {
+			first = min(first, i)
+			end = i + size
+		}
+		i += size
+	}
+	return first, end
+}
+
+// nonTextAt reports whether the character at p[i] is not terminal output
+// and returns its size
+func nonTextAt(p []byte, i int) (bool, int) {
+	b := p[i]
+	if b >= utf8.RuneSelf {
+		r, size := utf8.DecodeRune(p[i:])
+		// a rune split across chunks is text
+		return r == utf8.RuneError && size == 1 && utf8.FullRune(p[i:]), size
+	}
+	switch b {
+	case '\t', '\n', '\r', '\b', 0x07, 0x1b, 0x0e, 0x0f:
+		// tab, newlines, backspace, bell, escape and charset shifts
+		return false, 1
+	}
+	return b < 0x20 || b == 0x7f, 1
+}
@@ -0,0 +1,112 @@
+package recorder
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBufferSize is the size of the buffers reading the output of the
+	// command and the terminal input
+	DefaultBufferSize = 32 << 10
+	// MinBufferSize and MaxBufferSize bound Options.BufferSize
+	MinBufferSize = 256
+	MaxBufferSize = 16 << 20
+)
+
+const (
+	// logFlushInterval is how long the output written to the log file may
+	// be held back, so that small chunks are written together
+	logFlushInterval = 100 * time.Millisecond
+	// logSyncInterval is how often the log file is synced to the disk
+	logSyncInterval = time.Second
+	// maxPooledChunk is the size of the largest chunk buffer kept for
+	// reuse, a larger one, e.g. the output of a rotated log, is released
+	maxPooledChunk = 1 << 20
+)
+
+// chunk is a copy of a chunk of output or input shared by the sink workers,
+// its buffer is reused once every worker delivered it
+type chunk struct {
+	data []byte
+	refs atomic.Int32
+}
+
+var chunkPool = sync.Pool{New: func() any { return new(chunk) }}
+
+// newChunk copies p into a pooled chunk released by refs workers
+func newChunk(p []byte, refs int) *chunk {
+	c := chunkPool.Get().(*chunk)
+	c.data = append(c.data[:0], p...)
+	c.refs.Store(int32(refs))
+	return c
+}
+
+// release gives the chunk back to the pool once the last worker released it
+func (c *chunk) release() {
+	if c == nil || c.refs.Add(-1) > 0 {
+		return
+	}
+	if cap(c.data) > maxPooledChunk {
+		c.data = nil
+	}
+	chunkPool.Put(c)
+}
+
+// bufferSize returns the size of the read buffers
+func (r *Recorder) bufferSize() int {
+	if r.opts.BufferSize > 0 {
+		return r.opts.BufferSize
+	}
+	return DefaultBufferSize
+}
+
+// flushPeriodically writes the output held back for the log file every
+// logFlushInterval until the returned function is called
+func (r *Recorder) flushPeriodically() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(logFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.mu.Lock()
+				r.flushLog()
+				r.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// flushLog writes the output held back to the log file and the plain text
+// transcript, and syncs the log file at most every logSyncInterval, r.mu
+// must be held
+func (r *Recorder) flushLog() {
+	if len(r.pending) == 0 {
+		return
+	}
+	p := r.pending
+	r.pending = r.pending[:0]
+	if _, err := r.logFile.Write(p); err != nil {
+		r.failLog(err)
+		return
+	}
+	if now := r.opts.Now(); now.Sub(r.synced) >= logSyncInterval {
+		_ = r.logFile.Sync()
+		r.synced = now
+	}
+	if r.text != nil {
+		_, _ = r.text.Write(p)
+	}
+}
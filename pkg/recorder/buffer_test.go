@@ -0,0 +1,97 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discardSink is a Sink dropping everything it receives
+type discardSink struct{}
+
+func (discardSink) Start(Event) error    { return nil }
+func (discardSink) Write(p []byte) error { return nil }
+func (discardSink) End(Event) error      { return nil }
+func (discardSink) Close() error         { return nil }
+
+func discardSinks(n int) []Sink {
+	sinks := make([]Sink, n)
+	for i := range sinks {
+		sinks[i] = discardSink{}
+	}
+	return sinks
+}
+
+func BenchmarkNewChunk(b *testing.B) {
+	p := make([]byte, DefaultBufferSize)
+	b.SetBytes(int64(len(p)))
+	b.ReportAllocs()
+	for b.Loop() {
+		newChunk(p, 1).release()
+	}
+}
+
+func BenchmarkTeeWrite(b *testing.B) {
+	p := make([]byte, DefaultBufferSize)
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("sinks=%d", n), func(b *testing.B) {
+			t := newTee(discardSinks(n), io.Discard, time.Now)
+			t.start(Event{Type: "start"})
+			b.SetBytes(int64(len(p)))
+			b.ReportAllocs()
+			for b.Loop() {
+				t.write(p, "")
+			}
+			t.end(Event{Type: "end"})
+			t.close()
+		})
+	}
+}
+
+func BenchmarkCopyOutput(b *testing.B) {
+	p := make([]byte, DefaultBufferSize)
+	for _, n := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("sinks=%d", n), func(b *testing.B) {
+			r := New(Options{
+				Name:   "kubectl",
+				Args:   []string{"exec", "pod"},
+				LogDir: b.TempDir(),
+				Sinks:  discardSinks(n),
+				Stdout: io.Discard,
+				Stderr: io.Discard,
+			})
+			if err := r.Prepare(); err != nil {
+				b.Fatal(err)
+			}
+			defer r.logFile.Close()
+			r.tee.start(r.Event("start"))
+
+			output, w, err := os.Pipe()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer output.Close()
+			go func() {
+				defer w.Close()
+				for range b.N {
+					if _, err := w.Write(p); err != nil {
+						return
+					}
+				}
+			}()
+			b.SetBytes(int64(len(p)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			r.copyOutput(&wg, output, io.Discard, "")
+			b.StopTimer()
+
+			r.tee.end(r.Event("end"))
+			r.tee.close()
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Command is a command typed in the session
+type Command struct {
+	Time    string `json:"time"`
+	Command string `json:"command"`
+}
+
+// commandLog extracts the commands typed in the session.
+//
+// The typed line is rebuilt from the input and kept only if the terminal
+// echoed it, so that input typed at a password prompt is never kept. When
+// the line was edited with keys whose effect is unknown, such as the history
+// or completion, the line shown on the terminal is kept instead.
+type commandLog struct {
+	now func() time.Time
+
+	editor lineEditor
+	// screen renders the output to know the lines shown on the terminal
+	screen *textWriter
+	// pending are the entered lines waiting for the terminal line ending
+	pending []typedLine
+	// prompt is the last prompt the typed commands were shown after
+	prompt   string
+	commands []Command
+	// redact applies the redaction rules of the input to the commands
+	redact *redactor
+}
+
+// typedLine is a line entered in the terminal
+type typedLine struct {
+	text      string
+	uncertain bool
+	time      string
+}
+
+func newCommandLog(now func() time.Time) *commandLog {
+	c := &commandLog{now: now, screen: newTextWriter(io.Discard)}
+	c.screen.onLine = c.shown
+	return c
+}
+
+// output follows the session output, r.mu must be held
+func (c *commandLog) output(p []byte) {
+	_, _ = c.screen.Write(p)
+}
+
+// input follows the session input, r.mu must be held. The keys typed in
+// full screen applications such as vim are not commands.
+func (c *commandLog) input(p []byte) {
+	if altScreen(c.screen.modes) {
+		return
+	}
+	for _, line := range c.editor.write(p) {
+		line.time = c.now().Format(time.RFC3339)
+		c.pending = append(c.pending, line)
+	}
+}
+
+// shown is called with every line of the terminal to resolve the pending
+// line entered on it
+func (c *commandLog) shown(line string) {
+	shown := strings.TrimSpace(line)
+	for len(c.pending) > 0 {
+		typed := c.pending[0]
+		c.pending = c.pending[1:]
+
+		command := strings.TrimSpace(typed.text)
+		switch {
+		case typed.uncertain:
+			command = strings.TrimSpace(strings.TrimPrefix(shown, c.prompt))
+		case strings.Contains(command, "\n"):
+			// a pasted multi-line command is redrawn by the shell on several
+			// lines, it is kept as typed
+		case !strings.HasSuffix(shown, command):
+			// not echoed, the line may belong to a line entered after it
+			continue
+		default:
+			if prompt := strings.TrimSpace(strings.TrimSuffix(shown, command)); prompt != "" {
+				c.prompt = prompt
+			}
+		}
+
+		if command != "" {
+			c.commands = append(c.commands, Command{Time: typed.time, Command: c.redact.redactString(command)})
+		}
+		return
+	}
+}
+
+// flush resolves the lines entered on the current terminal line
+func (c *commandLog) flush() {
+	for len(c.pending) > 0 {
+		c.shown(string(c.screen.line))
+	}
+}
+
+// writeTo writes the commands, one per line with their time, the lines of a
+// multi-line command after the first one are indented with a tab
+func (c *commandLog) writeTo(w io.Writer) error {
+	for _, cmd := range c.commands {
+		command := strings.ReplaceAll(cmd.Command, "\n", "\n\t")
+		if _, err := fmt.Fprintf(w, "%s %s\n", cmd.Time, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// states of the escape sequence parser of lineEditor
+const (
+	editGround = iota
+	editEscape
+	editCSI
+)
+
+// lineEditor rebuilds the lines typed in a terminal from the keys, applying
+// the basic line editing keys of shells
+type lineEditor struct {
+	state  int
+	params []byte
+	line   []rune
+	col    int
+	// partial is an incomplete UTF-8 sequence
+	partial []byte
+	// uncertain is set when a key with an unknown effect was typed, such as
+	// the history, completion or search
+	uncertain bool
+	// paste is set within a bracketed paste, its line endings are part of
+	// the line
+	paste bool
+}
+
+// write applies keys and returns the entered lines
+func (e *lineEditor) write(p []byte) []typedLine {
+	var lines []typedLine
+	for _, b := range p {
+		switch e.state {
+		case editEscape:
+			switch b {
+			case '[', 'O':
+				e.state = editCSI
+				e.params = e.params[:0]
+			default:
+				// Alt+key
+				e.uncertain = true
+				e.state = editGround
+			}
+		case editCSI:
+			if b >= 0x40 && b <= 0x7e {
+				e.csi(b)
+				e.state = editGround
+			} else {
+				e.params = append(e.params, b)
+			}
+		default:
+			if line, ok := e.key(b); ok {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// key applies a key, it returns the line if it was entered
+func (e *lineEditor) key(b byte) (typedLine, bool) {
+	switch b {
+	case '\r', '\n':
+		if e.paste {
+			e.insert('\n')
+			return typedLine{}, false
+		}
+		line := typedLine{text: string(e.line), uncertain: e.uncertain}
+		e.reset()
+		e.uncertain = false
+		return line, true
+	case 0x1b:
+		e.state = editEscape
+	case 0x7f, '\b':
+		if e.col > 0 {
+			e.line = append(e.line[:e.col-1], e.line[e.col:]...)
+			e.col--
+		}
+	case 0x01: // Ctrl+A
+		e.col = 0
+	case 0x05: // Ctrl+E
+		e.col = len(e.line)
+	case 0x02: // Ctrl+B
+		e.col = max(e.col-1, 0)
+	case 0x06: // Ctrl+F
+		e.col = min(e.col+1, len(e.line))
+	case 0x0b: // Ctrl+K
+		e.line = e.line[:e.col]
+	case 0x15: // Ctrl+U
+		e.line = append(e.line[:0], e.line[e.col:]...)
+		e.col = 0
+	case 0x17: // Ctrl+W
+		start := e.col
+		for start > 0 && unicode.IsSpace(e.line[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+			start--
+		}
+		e.line = append(e.line[:start], e.line[e.col:]...)
+		e.col = start
+	case 0x03: // Ctrl+C
+		e.reset()
+		e.uncertain = false
+	case '\t', 0x12, 0x10, 0x0e: // completion, search and history
+		e.uncertain = true
+	default:
+		if b < 0x20 {
+			return typedLine{}, false
+		}
+		e.partial = append(e.partial, b)
+		if utf8.FullRune(e.partial) {
+			r, _ := utf8.DecodeRune(e.partial)
+			e.partial = e.partial[:0]
+			e.insert(r)
+		}
+	}
+	return typedLine{}, false
+}
+
+// csi applies the cursor keys
+func (e *lineEditor) csi(final byte) {
+	switch final {
+	case 'C':
+		e.col = min(e.col+1, len(e.line))
+	case 'D':
+		e.col = max(e.col-1, 0)
+	case 'H':
+		e.col = 0
+	case 'F':
+		e.col = len(e.line)
+	case '~':
+		switch string(e.params) {
+		case "3": // Delete
+			if e.col < len(e.line) {
+				e.line = append(e.line[:e.col], e.line[e.col+1:]...)
+			}
+		case "1", "7":
+			e.col = 0
+		case "4", "8":
+			e.col = len(e.line)
+		case "200", "201":
+			// bracketed paste
+			e.paste = string(e.params) == "200"
+		default:
+			e.uncertain = true
+		}
+	default:
+		// history and other keys
+		e.uncertain = true
+	}
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.col+1:], e.line[e.col:])
+	e.line[e.col] = r
+	e.col++
+}
+
+func (e *lineEditor) reset() {
+	e.line = e.line[:0]
+	e.col = 0
+	e.partial = e.partial[:0]
+}
@@ -0,0 +1,62 @@
+package recorder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// detachFilter finds the detach key sequence in the terminal input. The keys
+// matching the start of the sequence are held back until the sequence is
+// complete or broken, so they are only sent to the command if they are not a
+// detach.
+type detachFilter struct {
+	keys []byte
+	// n is the number of keys of the sequence already typed
+	n int
+}
+
+// filter returns the input to send to the command and whether the sequence
+// was typed, the input after the sequence is dropped
+func (d *detachFilter) filter(p []byte) ([]byte, bool) {
+	var out []byte
+	for _, b := range p {
+		if b == d.keys[d.n] {
+			d.n++
+			if d.n == len(d.keys) {
+				return out, true
+			}
+			continue
+		}
+		// the sequence is broken, send the held keys
+		out = append(out, d.keys[:d.n]...)
+		d.n = 0
+		if b == d.keys[0] {
+			d.n = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// DefaultDetachKeys is the default detach key sequence, Ctrl-P Ctrl-Q
+var DefaultDetachKeys = []byte{0x10, 0x11}
+
+// ParseDetachKeys parses a detach key sequence in the format of docker, a
+// comma separated list of keys such as "ctrl-p,ctrl-q" where a key is a
+// character or ctrl- followed by a letter or one of @[\]^_
+func ParseDetachKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, k := range strings.Split(s, ",") {
+		if len(k) == 1 {
+			keys = append(keys, k[0])
+			continue
+		}
+		c, ok := strings.CutPrefix(strings.ToLower(k), "ctrl-")
+		if !ok || len(c) != 1 || !strings.Contains("abcdefghijklmnopqrstuvwxyz@[\\]^_", c) {
+			return nil, fmt.Errorf("invalid detach keys %q, expected e.g. ctrl-p,ctrl-q", s)
+		}
+		keys = append(keys, strings.ToUpper(c)[0]-'@')
+	}
+	return keys, nil
+}
@@ -0,0 +1,16 @@
+package recorder
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the space available to the user in the file system of dir
+func freeSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
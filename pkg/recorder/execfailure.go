@@ -0,0 +1,102 @@
+package recorder
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Reasons of a kubectl exec failure
+const (
+	FailurePodNotFound         = "pod-not-found"
+	FailureContainerNotFound   = "container-not-found"
+	FailureContainerNotRunning = "container-not-running"
+	FailurePodNotRunning       = "pod-not-running"
+	FailureForbidden           = "forbidden"
+	FailureUnauthorized        = "unauthorized"
+	FailureConnection          = "connection-failed"
+	FailureUpgrade             = "upgrade-failed"
+	FailureOther               = "other"
+)
+
+const (
+	// failureTailSize is the size of the last output kept to classify a
+	// failure, and failureLines the number of its last lines searched
+	failureTailSize = 4 << 10
+	failureLines    = 5
+	// failureMessageSize is the maximum size of the message of a failure
+	failureMessageSize = 512
+)
+
+// ExecFailure is the failure of kubectl exec itself, e.g. the pod was not
+// found, as opposed to the command run in the pod exiting with an error
+type ExecFailure struct {
+	// Reason is one of the Failure* reasons
+	Reason string `json:"reason"`
+	// Message is the error printed by kubectl
+	Message string `json:"message"`
+}
+
+// failurePatterns classify the errors printed by kubectl, the first match
+// wins
+var failurePatterns = []struct {
+	reason string
+	re     *regexp.Regexp
+}{
+	{FailureUnauthorized, regexp.MustCompile(`(?i)\(Unauthorized\)|must be logged in`)},
+	{FailureForbidden, regexp.MustCompile(`(?i)\(Forbidden\)|is forbidden`)},
+	{FailureContainerNotFound, regexp.MustCompile(`(?i)container (not found|\S+ not found|\S+ is not valid)`)},
+	{FailurePodNotFound, regexp.MustCompile(`(?i)\(NotFound\)|pods? "[^"]*" not found`)},
+	{FailurePodNotRunning, regexp.MustCompile(`(?i)completed pod|current phase is|pod \S+ is not running|pod is not running`)},
+	{FailureContainerNotRunning, regexp.MustCompile(`(?i)container (is )?not running|container \S+ is not running|ContainerCreating|CrashLoopBackOff`)},
+	{FailureConnection, regexp.MustCompile(`(?i)unable to connect to the server|error dialing backend|lost connection to pod|TLS handshake timeout|connection refused|no such host|i/o timeout|context deadline exceeded`)},
+	{FailureUpgrade, regexp.MustCompile(`(?i)unable to upgrade connection`)},
+}
+
+// kubectlError matches the lines kubectl prints when it fails
+var kubectlError = regexp.MustCompile(`^(error: |Error from server|Unable to connect to the server)`)
+
+// ClassifyExecFailure finds the error printed by kubectl in the last lines of
+// the output of a session that exited with an error, nil if there is none,
+// i.e. the command run in the pod failed
+func ClassifyExecFailure(output []byte) *ExecFailure {
+	var text strings.Builder
+	t := newTextWriter(&text)
+	_, _ = t.Write(output)
+	lines := strings.Split(text.String()+t.take(), "\n")
+	var messages []string
+	for i := len(lines) - 1; i >= 0 && len(lines)-i <= failureLines; i-- {
+		line := strings.TrimSpace(lines[i])
+		if kubectlError.MatchString(line) {
+			messages = append([]string{line}, messages...)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	message := strings.Join(messages, "\n")
+	if len(message) > failureMessageSize {
+		message = strings.ToValidUTF8(message[:failureMessageSize], "")
+	}
+	reason := FailureOther
+	for _, p := range failurePatterns {
+		if p.re.MatchString(message) {
+			reason = p.reason
+			break
+		}
+	}
+	return &ExecFailure{Reason: reason, Message: message}
+}
+
+// keepTail appends p to the last output kept to classify a failure
+func keepTail(tail, p []byte) []byte {
+	tail = append(tail, p...)
+	if n := len(tail) - failureTailSize; n > 0 {
+		// keep whole lines
+		if i := bytes.IndexByte(tail[n:], '\n'); i >= 0 {
+			n += i + 1
+		}
+		tail = append(tail[:0], tail[n:]...)
+	}
+	return tail
+}
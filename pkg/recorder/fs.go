@@ -0,0 +1,33 @@
+package recorder
+
+import (
+	"io"
+	"os"
+)
+
+// FS creates the log file, it can be replaced to keep recordings off the
+// local disk or in tests. Create never overwrites a file, it fails with an
+// error wrapping fs.ErrExist if name already exists
+type FS interface {
+	MkdirAll(path string, perm os.FileMode) error
+	Create(name string) (File, error)
+}
+
+// File is a log file
+type File interface {
+	io.Writer
+	io.StringWriter
+	Sync() error
+	Close() error
+}
+
+// OSFS is the FS of the operating system
+type OSFS struct{}
+
+func (OSFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OSFS) Create(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+}
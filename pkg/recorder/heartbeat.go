@@ -0,0 +1,39 @@
+package recorder
+
+import "time"
+
+// heartbeat sends a heartbeat event to the sinks every HeartbeatInterval
+// until the returned function is called, so that a collector can tell a
+// running session from one that died without an end event
+func (r *Recorder) heartbeat() func() {
+	if r.opts.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ev := r.Event("heartbeat")
+				ev.Time = r.opts.Now().Format(time.RFC3339)
+				ev.Bytes = r.outputBytes.Load()
+				last := max(r.lastInput.Load(), r.lastOutput.Load())
+				ev.LastActivity = time.Unix(0, last).UTC().Format(time.RFC3339)
+				r.mu.Lock()
+				r.tee.event(ev)
+				r.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
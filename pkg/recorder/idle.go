@@ -0,0 +1,102 @@
+package recorder
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultIdleWarning is how long before the idle timeout the user is warned
+const DefaultIdleWarning = time.Minute
+
+// idleCheckInterval is how often the time since the last input is checked
+const idleCheckInterval = time.Second
+
+// idleLastWarning is the time left when the warning is shown a last time
+const idleLastWarning = 10 * time.Second
+
+// watchIdle terminates the session once nothing was typed for IdleTimeout,
+// until the returned function is called. The user is warned IdleWarning
+// before, and again shortly before the end, on the terminal only: the
+// warnings are recorded as markers and idle_warning events but are not
+// output of the command.
+func (r *Recorder) watchIdle() func() {
+	if r.opts.IdleTimeout <= 0 {
+		return func() {}
+	}
+	warning := r.opts.IdleWarning
+	if warning <= 0 {
+		warning = DefaultIdleWarning
+	}
+	warning = min(warning, r.opts.IdleTimeout)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		// warned is the time left shown by the last warning, zero if the
+		// user was not warned since the last input
+		var warned time.Duration
+		for {
+			select {
+			case <-ticker.C:
+				left := r.opts.IdleTimeout - r.opts.Now().Sub(time.Unix(0, r.lastInput.Load()))
+				switch {
+				case left <= 0:
+					r.idleTimeout()
+					return
+				case left > warning:
+					warned = 0
+				case warned == 0 || (left <= idleLastWarning && warned > idleLastWarning):
+					warned = left.Round(time.Second)
+					r.idleWarning(warned)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// idleWarning warns the user that the session terminates in left without
+// input
+func (r *Recorder) idleWarning(left time.Duration) {
+	fmt.Fprintf(r.opts.Stderr, "\r\n[execrec] the session will terminate in %s due to inactivity, press any key to keep it\r\n", left)
+	r.markIdle("warning", left)
+}
+
+// idleTimeout terminates the session after IdleTimeout without input
+func (r *Recorder) idleTimeout() {
+	fmt.Fprintf(r.opts.Stderr, "\r\n[execrec] the session was terminated after %s of inactivity\r\n", r.opts.IdleTimeout)
+	r.markIdle("timeout", 0)
+	r.mu.Lock()
+	r.idleTimedOut = true
+	r.mu.Unlock()
+	r.terminate()
+}
+
+// markIdle records an idle marker and sends an idle_warning or idle_timeout
+// event
+func (r *Recorder) markIdle(what string, left time.Duration) {
+	if r.opts.NoRecord != "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushRedacted()
+	ev := r.Event("idle_" + what)
+	now := r.opts.Now()
+	ev.Time = now.Format(time.RFC3339)
+	marker := "[idle] " + what
+	if left > 0 {
+		ev.Remaining = int(left.Seconds())
+		marker += fmt.Sprintf(" remaining=%s", left)
+	}
+	r.writeLog([]byte(r.marker(fmt.Sprintf("%s time=%s\n", marker, now.Format(r.opts.TimeFormat)))))
+	r.tee.event(ev)
+}
@@ -0,0 +1,158 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+)
+
+// SizePolicy is what happens when the log file reaches its maximum size
+type SizePolicy string
+
+const (
+	// SizePolicyStop stops recording to the log file, the session continues
+	SizePolicyStop SizePolicy = "stop"
+	// SizePolicyRotate continues the log in <log>.1, <log>.2...
+	SizePolicyRotate SizePolicy = "rotate"
+	// SizePolicyTerminate terminates the session
+	SizePolicyTerminate SizePolicy = "terminate"
+)
+
+// ParseSizePolicy parses a size policy, SizePolicyStop if empty
+func ParseSizePolicy(s string) (SizePolicy, error) {
+	switch p := SizePolicy(s); p {
+	case "":
+		return SizePolicyStop, nil
+	case SizePolicyStop, SizePolicyRotate, SizePolicyTerminate:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid log size policy %q, expected stop, rotate or terminate", s)
+}
+
+// limitLog applies the size policy once the log file is full, it reports
+// whether the output can still be written, r.mu must be held
+func (r *Recorder) limitLog() bool {
+	switch r.opts.MaxLogSizePolicy {
+	case SizePolicyRotate:
+		if err := r.rotate(); err != nil {
+			r.fail(fmt.Errorf("failed to rotate the log file: %w", err))
+			r.stopLog(fmt.Sprintf("failed to rotate the log file: %v", err))
+			return false
+		}
+		return true
+	case SizePolicyTerminate:
+		r.stopLog("log size limit reached, terminating the session")
+		r.terminate()
+		return false
+	default:
+		r.stopLog("log size limit reached, recording stopped")
+		return false
+	}
+}
+
+// writeRotated writes to the log file with the rotate policy, the output
+// that does not fit in the current part goes to the next ones. A part ends on
+// a complete line unless a line is longer than a part and keeps room for the
+// marker closing it, r.mu must be held
+func (r *Recorder) writeRotated(p []byte) {
+	for len(p) > 0 {
+		room := r.opts.MaxLogSize - r.logSize - int64(len(r.rotatedMarker(r.parts+1)))
+		empty := r.logSize == r.headerSize
+		if int64(len(p)) <= room || (empty && room <= 0) {
+			// a limit smaller than the rotation markers cannot be kept,
+			// the output goes to the new part anyway
+			r.appendLog(p)
+			return
+		}
+		if room > 0 {
+			n := -1
+			if i := bytes.LastIndexByte(p[:room], '\n'); i >= 0 {
+				n = i + 1
+			} else if empty {
+				// a line longer than a part is cut
+				n = int(room)
+			}
+			if n > 0 {
+				r.appendLog(p[:n])
+				p = p[n:]
+			}
+		}
+		if !r.limitLog() {
+			return
+		}
+	}
+}
+
+// rotatedMarker returns the marker closing a part continued in part n, with
+// the newline it starts with when the part does not end with one
+func (r *Recorder) rotatedMarker(n int) string {
+	return fmt.Sprintf("\n[rotated] continued in %s.%d\n", filepath.Base(r.logPath), n)
+}
+
+// stopLog writes a marker and stops recording to the log file
+func (r *Recorder) stopLog(reason string) {
+	r.appendLog([]byte(r.marker(fmt.Sprintf("[recording stopped] %s\n", reason))))
+	r.logStopped = true
+	fmt.Fprintf(r.opts.Stderr, "\r\nWarning: %s\r\n", reason)
+}
+
+// rotate continues the log in the next part, the parts are attached to the
+// session
+func (r *Recorder) rotate() error {
+	path := fmt.Sprintf("%s.%d", r.logPath, r.parts+1)
+	f, err := r.opts.FS.Create(path)
+	if err != nil {
+		return err
+	}
+	r.parts++
+
+	r.flushLog()
+	marker := r.rotatedMarker(r.parts)
+	if r.lastByte == '\n' {
+		marker = marker[1:]
+	}
+	_, _ = r.logFile.WriteString(marker)
+	_ = r.logFile.Close()
+
+	previous := r.logPath
+	if r.parts > 1 {
+		previous = fmt.Sprintf("%s.%d", r.logPath, r.parts-1)
+	}
+	r.logFile = f
+	header := fmt.Sprintf("[rotated] continued from %s\n", filepath.Base(previous))
+	_, _ = r.logFile.WriteString(header)
+	r.logSize = int64(len(header))
+	r.headerSize = r.logSize
+	r.lastByte = '\n'
+	r.Attach(path)
+	return nil
+}
+
+// failLog stops recording to the log file after a write error such as a full
+// disk, the sinks keep receiving the session, r.mu must be held
+func (r *Recorder) failLog(err error) {
+	r.logStopped = true
+	r.logFailed = true
+	r.fail(fmt.Errorf("failed to write the log file: %w", err))
+
+	if len(r.tee.workers) == 0 {
+		fmt.Fprintf(r.opts.Stderr, "\r\nWarning: failed to write the log file: %v, the rest of the session is not recorded\r\n", err)
+		return
+	}
+	fmt.Fprintf(r.opts.Stderr, "\r\nWarning: failed to write the log file: %v, the session is only sent to the sinks\r\n", err)
+	r.tee.write([]byte(fmt.Sprintf("\n[recording stopped] failed to write the log file: %v\n", err)), "")
+}
+
+// formatSize formats a size in bytes for messages
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
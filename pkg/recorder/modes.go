@@ -0,0 +1,94 @@
+package recorder
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resetModes are the DEC private modes that change how the terminal behaves
+// for the user: the alternate screen, mouse reporting and bracketed paste.
+// They are reset when the session ends in case the command left them
+// enabled, e.g. because it was killed.
+var resetModes = map[int]bool{
+	47: true, 1047: true, 1049: true,
+	1000: true, 1001: true, 1002: true, 1003: true, 1004: true, 1005: true, 1006: true, 1015: true,
+	2004: true,
+}
+
+// setPrivateModes applies a CSI ? Pm h (set) or CSI ? Pm l (reset) sequence
+// to modes
+func setPrivateModes(modes map[int]bool, params []byte, final byte) {
+	if (final != 'h' && final != 'l') || len(params) == 0 || params[0] != '?' {
+		return
+	}
+	for _, p := range strings.Split(string(params[1:]), ";") {
+		if n, err := strconv.Atoi(p); err == nil {
+			modes[n] = final == 'h'
+		}
+	}
+}
+
+// altScreen reports whether the alternate screen of full screen applications
+// such as vim or less is enabled
+func altScreen(modes map[int]bool) bool {
+	return modes[1049] || modes[1047] || modes[47]
+}
+
+// states of the escape sequence parser of modeTracker
+const (
+	modeGround = iota
+	modeEscape
+	modeCSI
+)
+
+// modeTracker follows the DEC private modes set by the output
+type modeTracker struct {
+	state  int
+	params []byte
+	modes  map[int]bool
+}
+
+func newModeTracker() *modeTracker {
+	return &modeTracker{modes: map[int]bool{}}
+}
+
+func (m *modeTracker) write(p []byte) {
+	for _, b := range p {
+		switch m.state {
+		case modeGround:
+			if b == 0x1b {
+				m.state = modeEscape
+			}
+		case modeEscape:
+			m.state = modeGround
+			if b == '[' {
+				m.state = modeCSI
+				m.params = m.params[:0]
+			}
+		case modeCSI:
+			switch {
+			case b >= 0x40 && b <= 0x7e:
+				setPrivateModes(m.modes, m.params, b)
+				m.state = modeGround
+			case len(m.params) < 64:
+				m.params = append(m.params, b)
+			}
+		}
+	}
+}
+
+// reset returns the sequence resetting the modes left enabled
+func (m *modeTracker) reset() []byte {
+	var enabled []string
+	for mode, on := range m.modes {
+		if on && resetModes[mode] {
+			enabled = append(enabled, strconv.Itoa(mode))
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+	sort.Strings(enabled)
+	return []byte("\x1b[?" + strings.Join(enabled, ";") + "l")
+}
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultLogName is the default template of the log file name, without its
+// .log extension
+const DefaultLogName = "{{.User}}_{{.Cluster}}_{{.Time}}_{{.ID}}"
+
+// LogNameData is the data available to the log file name template
+type LogNameData struct {
+	// User is the user running the session
+	User string
+	// Context is the kubectl context of the session
+	Context string
+	// Cluster is the cluster of the context
+	Cluster string
+	// Time is the session start time formatted with FileTimeFormat
+	Time string
+	// ID is the session ID
+	ID string
+}
+
+// ParseLogName parses a log file name template, see LogNameData for the data
+// available to it
+func ParseLogName(tmpl string) (*template.Template, error) {
+	return template.New("log name").Option("missingkey=error").Parse(tmpl)
+}
+
+// LogFileName renders the name of the log file of a session with a log file
+// name template, with its .log extension
+func LogFileName(tmpl *template.Template, d LogNameData) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, d); err != nil {
+		return "", fmt.Errorf("invalid log file name template: %w", err)
+	}
+	if name.Len() == 0 || strings.ContainsAny(name.String(), `/\`) {
+		return "", fmt.Errorf("invalid log file name %q", name.String())
+	}
+	return name.String() + ".log", nil
+}
+
+// SafeFileName replaces the characters that are not allowed in file names on
+// some file systems, such as path separators and the characters reserved on
+// NTFS, with "-"
+func SafeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+// crockford is the Crockford base32 alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewSessionID returns a new session ID, a ULID: 26 characters sorting by
+// the creation time, with 80 random bits telling apart the sessions started
+// in the same millisecond
+func NewSessionID(now time.Time) string {
+	var b [16]byte
+	ms := uint64(now.UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	_, _ = rand.Read(b[6:])
+
+	// 128 bits in 26 characters of 5 bits, the first one has 3 bits
+	id := make([]byte, 26)
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 | uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 | uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id)
+}
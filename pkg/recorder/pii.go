@@ -0,0 +1,52 @@
+package recorder
+
+import "regexp"
+
+// PIIRules are the built-in redaction rules of cardholder data and personal
+// information in the output: card numbers with a valid Luhn check digit,
+// email addresses and national identification numbers
+var PIIRules = []RedactRule{
+	piiRule("card-number", `\b\d(?:[ -]?\d){12,18}\b`, luhn),
+	piiRule("email", `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`, nil),
+	// US social security numbers, area 000, 666 and 9xx, group 00 and
+	// serial 0000 are never issued
+	piiRule("us-ssn", `\b\d{3}-\d{2}-\d{4}\b`, validSSN),
+	// UK national insurance numbers
+	piiRule("uk-nino", `\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`, nil),
+}
+
+// piiRule returns a built-in rule of the pii rule set
+func piiRule(name, pattern string, validate func([]byte) bool) RedactRule {
+	return RedactRule{
+		Set: "pii", Name: name,
+		Pattern: regexp.MustCompile(pattern), Validate: validate,
+		Replacement: "[REDACTED]", Output: true,
+	}
+}
+
+// luhn tells if the digits of a number have a valid Luhn check digit,
+// separators are ignored
+func luhn(number []byte) bool {
+	sum, n := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		d := int(number[i] - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// validSSN tells if a number formatted as a US social security number may
+// have been issued
+func validSSN(ssn []byte) bool {
+	area, group, serial := string(ssn[:3]), string(ssn[4:6]), string(ssn[7:])
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
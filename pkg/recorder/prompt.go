@@ -0,0 +1,57 @@
+package recorder
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// osc133PromptStart is the OSC 133 sequence shells with semantic prompt
+// integration print at the start of the prompt
+var osc133PromptStart = []byte("\x1b]133;A")
+
+// promptDetector finds the shell prompts in the output, marked by the OSC 133
+// semantic prompt sequences or matched by a regular expression
+type promptDetector struct {
+	re *regexp.Regexp
+	// osc is set once the shell printed OSC 133 sequences, the regular
+	// expression is not used anymore
+	osc bool
+	// screen renders the output to match the current line
+	screen *textWriter
+	// marked is set once the current line was found to be a prompt
+	marked bool
+}
+
+func newPromptDetector(re *regexp.Regexp) *promptDetector {
+	d := &promptDetector{re: re, screen: newTextWriter(io.Discard)}
+	d.screen.onLine = func(string) { d.marked = false }
+	return d
+}
+
+// find returns the positions of p where a prompt starts
+func (d *promptDetector) find(p []byte) []int {
+	var positions []int
+	for i := 0; ; {
+		j := bytes.Index(p[i:], osc133PromptStart)
+		if j < 0 {
+			break
+		}
+		d.osc = true
+		positions = append(positions, i+j)
+		i += j + len(osc133PromptStart)
+	}
+	if d.osc || d.re == nil {
+		return positions
+	}
+
+	_, _ = d.screen.Write(p)
+	line := strings.TrimRight(string(d.screen.line), " ")
+	if d.marked || line == "" || !d.re.MatchString(line) {
+		return nil
+	}
+	d.marked = true
+	// the prompt starts on the current line
+	return []int{bytes.LastIndexByte(p, '\n') + 1}
+}
@@ -0,0 +1,1375 @@
+// Package recorder runs a command behind a PTY and records its output to a
+// log file and to any number of sinks, the terminal is passed through so the
+// command behaves as if it was run directly.
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// outputDrainTimeout bounds the wait for the remaining PTY output after the
+// child exited, in case a background process keeps the PTY open
+const outputDrainTimeout = 2 * time.Second
+
+// detachTimeout bounds the wait for the command to exit after a detach
+// before it is killed
+const detachTimeout = 2 * time.Second
+
+// StreamStdout and StreamStderr are the output streams of a command running
+// without a PTY
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	// StreamStdin is the stream of the input recorded with RecordInput
+	StreamStdin = "stdin"
+)
+
+// FormatVersion is the version of the format of the log files, written in
+// their header as format. The log files without it are of version 1.
+const FormatVersion = 2
+
+// DefaultFileTimeFormat is the layout of the session start time in the log
+// file name, it sorts in order and has no characters some file systems
+// reject such as colons
+const DefaultFileTimeFormat = "20060102T150405Z0700"
+
+// Options configures a Recorder
+type Options struct {
+	// Name is the program to run, e.g. "kubectl"
+	Name string
+	// Args are the arguments of the program
+	Args []string
+	// Title is the command line written to the log header and events
+	Title string
+
+	// SessionID identifies the session, a new ID is generated if empty
+	SessionID string
+	// User is the user running the session
+	User string
+	// Context is the kubectl context of the session
+	Context string
+	// Cluster is the cluster of the context, e.g. the name of an EKS
+	// cluster, to tell apart the recordings of many clusters
+	Cluster string
+	// Namespace is the namespace of the pod
+	Namespace string
+	// Pod is the pod, or the resource of the pod such as "deploy/web"
+	Pod string
+	// Version is the version written to the log header and events
+	Version string
+
+	// LogDir is the directory to store the log file
+	LogDir string
+
+	// Stdin, Stdout and Stderr are the terminal streams, Terminal is put in
+	// raw mode and gives its size to the PTY. If Terminal is not a terminal,
+	// e.g. piped or a heredoc, the command reads Stdin directly and its
+	// stdout and stderr are recorded without a PTY as separate streams.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Terminal is the terminal of the session, os.Stdin if nil
+	Terminal *os.File
+
+	// Sinks receive the session events and output besides the log file,
+	// wrap a sink in a QueuedSink to configure its queue
+	Sinks []Sink
+
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+	// UTC records the times in UTC instead of the local time zone
+	UTC bool
+	// TimeFormat is the layout of the times written to the log file header,
+	// footer and markers, time.RFC3339 if empty. The times of the events are
+	// always RFC 3339.
+	TimeFormat string
+	// FileTimeFormat is the layout of the session start time in the log file
+	// name, DefaultFileTimeFormat if empty
+	FileTimeFormat string
+	// LogName is the template of the log file name without its .log
+	// extension, DefaultLogName if nil
+	LogName *template.Template
+	// Command creates the command to run, exec.Command if nil
+	Command func(name string, args ...string) *exec.Cmd
+	// FS creates the log file, OSFS if nil
+	FS FS
+
+	// DetectBinary replaces binary output such as a cat of an executable
+	// with a "[binary data: N bytes, sha256=...]" marker in the log file and
+	// the sinks, the terminal still receives the output
+	DetectBinary bool
+
+	// MaxOutputRate is the maximum output recorded in bytes per minute, the
+	// output over it is replaced by a "[... N bytes omitted ...]" marker in
+	// the log file and the sinks, unlimited if zero
+	MaxOutputRate int64
+
+	// MinFreeSpace is the free space in bytes required in LogDir to start
+	// the session, not checked if zero
+	MinFreeSpace int64
+
+	// MaxLogSize is the maximum size of the log file in bytes, unlimited if
+	// zero, and MaxLogSizePolicy what happens when it is reached
+	MaxLogSize       int64
+	MaxLogSizePolicy SizePolicy
+
+	// Signals are the actions of the signals received during the session,
+	// DefaultSignals if nil
+	Signals map[syscall.Signal]SignalAction
+
+	// PromptMarkers writes a "[prompt]" marker with its time to the log file
+	// and sends a prompt event to the sinks before every shell prompt, the
+	// prompts are found with the OSC 133 sequences of shells supporting them
+	// or with PromptRegex, matched against the current line
+	PromptMarkers bool
+	PromptRegex   *regexp.Regexp
+
+	// Commands extracts the commands typed in the session to the end event
+	// and to a .commands.txt file next to the log file
+	Commands bool
+
+	// DetachKeys is the key sequence that detaches from the session: the
+	// command is terminated and the session finished as detached, no key
+	// sequence if empty
+	DetachKeys []byte
+
+	// IdleTimeout terminates the session when nothing was typed for this
+	// long, never if zero, and IdleWarning is how long before the user is
+	// warned, DefaultIdleWarning if zero. Only a session with a PTY is
+	// idle.
+	IdleTimeout time.Duration
+	IdleWarning time.Duration
+
+	// HeartbeatInterval is how often a heartbeat event is sent to the
+	// sinks during the session, with the size of the output so far and the
+	// time of the last input or output, no heartbeat if zero
+	HeartbeatInterval time.Duration
+
+	// BufferSize is the size of the buffers reading the output of the
+	// command and the terminal input, DefaultBufferSize if zero
+	BufferSize int
+
+	// Timeout is how long the command may run, it is terminated after it
+	// and Wait returns ErrTimeout, no timeout if zero
+	Timeout time.Duration
+
+	// Retries is the number of times the command is started again when
+	// kubectl exec fails with a transient error, e.g. the API server could
+	// not dial the kubelet. The session goes on in the same log file after a
+	// reconnect marker.
+	Retries int
+
+	// Logger logs the internal steps of the recording with the component
+	// attribute session, pty or sink, nothing is logged if nil
+	Logger *slog.Logger
+
+	// NoRecord is the reason the session is not recorded, if set no log
+	// file is created and the output is passed through without being
+	// recorded, the sinks only receive the start and end events
+	NoRecord string
+
+	// PlainText also writes the session without escape sequences to a .txt
+	// file next to the log file, it is attached to the session
+	PlainText bool
+
+	// RecordInput also writes the input of the session as is to a .in file
+	// next to the log file, it is attached to the session and sent to the
+	// sinks telling streams apart, so that what was typed can be told from
+	// what was displayed
+	RecordInput bool
+
+	// NoPTY runs the command without a PTY even if stdin is a terminal, e.g.
+	// when several commands share the terminal
+	NoPTY bool
+	// Group is the ID of the run the session is part of when a command runs
+	// on several pods at once, it is recorded in the log file header and
+	// the events
+	Group string
+
+	// Impersonation is the identity the command acts as, it is recorded in
+	// the log file header and the events, nil without impersonation
+	Impersonation *Impersonation
+	// Reason is the reason given for the session, recorded like
+	// Impersonation
+	Reason string
+	// Image is the image of the pod created for the session by kubectl run,
+	// recorded like Impersonation
+	Image string
+
+	// Redact are the redaction rules applied to the recorded session: the
+	// log file, the plain text transcript, the input file, the command
+	// summary and the sinks. The current line is recorded once it ends, so
+	// that a match split between two chunks is still redacted.
+	Redact []RedactRule
+}
+
+// Recorder runs a command behind a PTY and records the session
+type Recorder struct {
+	opts Options
+
+	// logPath is the path to the log file
+	logPath string
+	// logFile is the log file
+	logFile File
+	// textFile is the plain text transcript rendered by text
+	textFile File
+	text     *textWriter
+	// inputFile is the input recorded when RecordInput is set
+	inputFile File
+	// start is the session start timestamp
+	start string
+	// end is the session end timestamp
+	end string
+	// attachments are files stored alongside the log file
+	attachments []string
+	// podMetadata describes the pod of the session
+	podMetadata *PodMetadata
+	// tee delivers the session to the sinks
+	tee *tee
+
+	// mu serializes the output and the markers written to the log file and
+	// the sinks
+	mu sync.Mutex
+	// lastByte is the last byte written to the log file
+	lastByte byte
+	// pending is the output held back for the log file, written by flushLog,
+	// and synced the time the log file was last synced
+	pending []byte
+	synced  time.Time
+	// partial is an incomplete UTF-8 sequence at the end of the output, it
+	// is recorded with the next chunk so that a character is never split
+	// between two writes to the log file and the sinks
+	partial []byte
+	// binary replaces binary output when DetectBinary is set
+	binary *binaryFilter
+	// throttle caps the output recorded when MaxOutputRate is set
+	throttle *throttle
+	// commands extracts the typed commands when Commands is set
+	commands *commandLog
+	// redactOutput and redactInput apply the Redact rules of the output and
+	// the input, nil if no rule applies
+	redactOutput *redactor
+	redactInput  *redactor
+	// prompts finds the prompts when PromptMarkers is set, and prompt is
+	// the number of prompts found
+	prompts *promptDetector
+	prompt  int
+	// logSize is the size of the current log file and headerSize the size
+	// of its header, parts the number of files the log was rotated to and
+	// logStopped is set once the log file no longer records output
+	logSize    int64
+	headerSize int64
+	parts      int
+	logStopped bool
+	// logFailed is set once writing the log file failed
+	logFailed bool
+	// failure is the first error that kept the session from being fully
+	// recorded, e.g. reading the output or writing the log file failed, it
+	// is written in the footer and returned by Wait
+	failure error
+	// detach finds DetachKeys in the input and detached is set once they
+	// were typed
+	detach   *detachFilter
+	detached bool
+	// lastInput is the time of the last input in Unix nanoseconds, and
+	// idleTimedOut is set once the session was terminated for inactivity
+	lastInput    atomic.Int64
+	idleTimedOut bool
+	// timedOut is set once the command was terminated after Timeout
+	timedOut bool
+	// tail is the last output, to classify the failure of kubectl exec in
+	// execFailure once the session exited with an error
+	tail        []byte
+	execFailure *ExecFailure
+	// outputBytes is the size of the output of the command so far and
+	// lastOutput the time of its last chunk in Unix nanoseconds
+	outputBytes atomic.Int64
+	lastOutput  atomic.Int64
+
+	// cmd is the recorded command and proc its process, the command may be
+	// started again while signals are sent to it
+	cmd  *exec.Cmd
+	proc atomic.Pointer[os.Process]
+	// terminated is set once the command was terminated by the recorder,
+	// it is not started again then
+	terminated atomic.Bool
+	// ptyFile is the PTY file, nil if the command runs without a PTY
+	ptyFile *os.File
+	// output is the output of the command, the PTY or a pipe, and
+	// errOutput the stderr of the command when it runs without a PTY
+	output    *os.File
+	errOutput *os.File
+	// outStream is the stream of the output recorded last, StreamStdout or
+	// StreamStderr without a PTY, r.mu must be held
+	outStream string
+	// restoreTTY restores the terminal to its original state
+	restoreTTY func() error
+	// stopSigs stops the signal handlers, stopHeartbeat the heartbeat,
+	// stopTimeout the timeout and stopFlush the flushes of the log file
+	stopSigs      func()
+	stopHeartbeat func()
+	stopTimeout   func()
+	stopFlush     func()
+	// outputDone is closed once all PTY output has been recorded
+	outputDone chan struct{}
+	// modes follows the terminal modes set by the command output to reset
+	// the ones it left enabled
+	modes *modeTracker
+}
+
+// New creates a Recorder
+func New(opts Options) *Recorder {
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.Terminal == nil {
+		opts.Terminal = os.Stdin
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Command == nil {
+		opts.Command = exec.Command
+	}
+	if opts.Signals == nil {
+		opts.Signals = DefaultSignals
+	}
+	if opts.FS == nil {
+		opts.FS = OSFS{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	if opts.UTC {
+		now := opts.Now
+		opts.Now = func() time.Time { return now().UTC() }
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = time.RFC3339
+	}
+	if opts.FileTimeFormat == "" {
+		opts.FileTimeFormat = DefaultFileTimeFormat
+	}
+	if opts.LogName == nil {
+		opts.LogName = template.Must(ParseLogName(DefaultLogName))
+	}
+	if opts.Title == "" {
+		opts.Title = strings.Join(append([]string{opts.Name}, opts.Args...), " ")
+	}
+	if opts.SessionID == "" {
+		opts.SessionID = NewSessionID(opts.Now())
+	}
+	r := &Recorder{opts: opts, tee: newTee(opts.Sinks, opts.Stderr, opts.Now), modes: newModeTracker()}
+	if len(opts.DetachKeys) > 0 {
+		r.detach = &detachFilter{keys: opts.DetachKeys}
+	}
+	if opts.NoRecord != "" {
+		return r
+	}
+	if opts.DetectBinary {
+		r.binary = &binaryFilter{}
+	}
+	if opts.PromptMarkers {
+		r.prompts = newPromptDetector(opts.PromptRegex)
+	}
+	r.redactOutput = newRedactor(opts.Redact, func(rule RedactRule) bool { return rule.Output })
+	r.redactInput = newRedactor(opts.Redact, func(rule RedactRule) bool { return rule.Input })
+	if opts.Commands {
+		r.commands = newCommandLog(opts.Now)
+		r.commands.redact = r.redactInput
+	}
+	if opts.MaxOutputRate > 0 {
+		r.throttle = &throttle{rate: opts.MaxOutputRate, now: opts.Now}
+	}
+	return r
+}
+
+// log returns the logger of a component of the recording
+func (r *Recorder) log(component string) *slog.Logger {
+	return r.opts.Logger.With("component", component)
+}
+
+// LogPath returns the path to the log file, it is set once Start was called
+func (r *Recorder) LogPath() string {
+	return r.logPath
+}
+
+// Event creates a session event of the given type
+func (r *Recorder) Event(typ string) Event {
+	return Event{
+		Type:      typ,
+		SessionID: r.opts.SessionID,
+		Command:   r.opts.Title,
+		User:      r.opts.User,
+		Context:   r.opts.Context,
+		Cluster:   r.opts.Cluster,
+		Namespace: r.opts.Namespace,
+		Pod:       r.opts.Pod,
+		LogFile:   r.logPath,
+		Version:   r.opts.Version,
+		Start:     r.start,
+		End:       r.end,
+
+		Attachments: r.attachments,
+		Commands:    r.sessionCommands(),
+		NoRecord:    r.opts.NoRecord,
+		Detached:    r.detached,
+		IdleTimeout: r.idleTimedOut,
+		TimedOut:    r.timedOut,
+		ExecFailure: r.execFailure,
+
+		Group:         r.opts.Group,
+		Impersonation: r.opts.Impersonation,
+		Reason:        r.opts.Reason,
+		Image:         r.opts.Image,
+		PodMetadata:   r.podMetadata,
+	}
+}
+
+// sessionCommands returns the typed commands once the session ended
+func (r *Recorder) sessionCommands() []Command {
+	if r.commands == nil || r.end == "" {
+		return nil
+	}
+	return r.commands.commands
+}
+
+// Plan returns the start event of a session started now without creating
+// anything, e.g. to show what a session would do
+func (r *Recorder) Plan() (Event, error) {
+	ev := r.Event("start")
+	now := r.opts.Now()
+	ev.Start = now.Format(time.RFC3339)
+	if r.opts.NoRecord == "" {
+		logPath, err := r.logFilePath(now)
+		if err != nil {
+			return ev, err
+		}
+		ev.LogFile = logPath
+	}
+	return ev, nil
+}
+
+// Attach adds a file stored alongside the log file to the session events,
+// uploaders upload attachments with the log file
+func (r *Recorder) Attach(path string) {
+	r.attachments = append(r.attachments, path)
+}
+
+// SetPodMetadata records the metadata of the pod of the session in the
+// events, it is called before Start
+func (r *Recorder) SetPodMetadata(m *PodMetadata) {
+	r.podMetadata = m
+}
+
+// Prepare creates the log file and writes the header, it is called by Start
+// if it was not called before. Nothing is created if NoRecord is set.
+func (r *Recorder) Prepare() error {
+	if r.start != "" {
+		return nil
+	}
+	if r.opts.NoRecord != "" {
+		r.start = r.opts.Now().Format(time.RFC3339)
+		return nil
+	}
+	return r.prepare()
+}
+
+// Start creates the log file, notifies the sinks and starts the command
+func (r *Recorder) Start() error {
+	if err := r.Prepare(); err != nil {
+		return err
+	}
+	r.tee.start(r.Event("start"))
+	r.log("sink").Debug("start event sent", "sinks", len(r.opts.Sinks))
+	start := r.startPTY
+	if r.opts.NoPTY || !term.IsTerminal(int(r.opts.Terminal.Fd())) {
+		start = r.startPipe
+	} else if err := checkPTY(); err != nil {
+		// e.g. /dev/ptmx is missing in some containers and CI runners
+		fmt.Fprintf(r.opts.Stderr, "Warning: failed to allocate a PTY, the session runs without one: %v\n", err)
+		start = r.startPipe
+	}
+	r.lastInput.Store(r.opts.Now().UnixNano())
+	if err := start(); err != nil {
+		return err
+	}
+	r.stopHeartbeat = r.heartbeat()
+	r.stopTimeout = r.watchTimeout()
+	if r.logFile != nil {
+		r.stopFlush = r.flushPeriodically()
+	}
+	r.stream()
+	return nil
+}
+
+// Wait waits for the command to exit, restores the terminal and finishes the
+// recording. ErrTimeout is returned first if the command timed out, then the
+// command error, the finish error and the error that kept the session from
+// being fully recorded, the error of a command terminated by a detach is
+// ignored.
+func (r *Recorder) Wait() error {
+	cmdErr := r.retry(r.cmd.Wait())
+
+	// Clean up TTY before writing final messages
+	r.cleanupTTY()
+	r.mu.Lock()
+	detached := r.detached
+	timedOut := r.timedOut
+	// a session ended by execrec did not fail
+	var exitErr *exec.ExitError
+	if errors.As(cmdErr, &exitErr) && !detached && !r.idleTimedOut && !timedOut {
+		r.execFailure = ClassifyExecFailure(r.tail)
+	}
+	r.mu.Unlock()
+	if detached {
+		fmt.Fprintln(r.opts.Stderr, "Detached from the session")
+	}
+
+	// Always finish the session to ensure log file is properly closed
+	finishErr := r.finish()
+
+	if timedOut {
+		return fmt.Errorf("%w after %s", ErrTimeout, r.opts.Timeout)
+	}
+	if cmdErr != nil && !detached {
+		return cmdErr
+	}
+	if finishErr == nil && r.failure != nil {
+		return fmt.Errorf("the session was not fully recorded: %w", r.failure)
+	}
+	return finishErr
+}
+
+// Close closes the log file and the sinks
+func (r *Recorder) Close() error {
+	r.tee.close()
+	if r.textFile != nil {
+		_ = r.textFile.Close()
+	}
+	if r.inputFile != nil {
+		_ = r.inputFile.Close()
+	}
+	if r.logFile != nil {
+		r.mu.Lock()
+		r.flushLog()
+		r.mu.Unlock()
+		return r.logFile.Close()
+	}
+	return nil
+}
+
+// logFilePath returns the path of the log file of a session started at start
+func (r *Recorder) logFilePath(start time.Time) (string, error) {
+	name, err := LogFileName(r.opts.LogName, LogNameData{
+		User:    SafeFileName(r.opts.User),
+		Context: SafeFileName(r.opts.Context),
+		Cluster: SafeFileName(r.opts.Cluster),
+		Time:    start.Format(r.opts.FileTimeFormat),
+		ID:      r.opts.SessionID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(r.opts.LogDir, name), nil
+}
+
+// headerField formats a field of the session line of the log file header,
+// the values with spaces or quotes are quoted, empty values are omitted
+func headerField(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.ContainsAny(value, " \t\"") {
+		value = strconv.Quote(value)
+	}
+	return " " + key + "=" + value
+}
+
+// maxLogSuffix is the number of suffixes tried when the log file of another
+// session has the same name
+const maxLogSuffix = 100
+
+// createLog creates the log file, a log name template without {{.ID}} can
+// give two sessions the same name so the log of the later one gets a -2, -3,
+// ... suffix instead of overwriting the first
+func (r *Recorder) createLog() (File, error) {
+	base := strings.TrimSuffix(r.logPath, ".log")
+	for n := 2; ; n++ {
+		f, err := r.opts.FS.Create(r.logPath)
+		if !errors.Is(err, fs.ErrExist) || n > maxLogSuffix {
+			return f, err
+		}
+		r.logPath = fmt.Sprintf("%s-%d.log", base, n)
+	}
+}
+
+// prepare log file and write header
+func (r *Recorder) prepare() error {
+	if err := r.opts.FS.MkdirAll(r.opts.LogDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if r.opts.MinFreeSpace > 0 {
+		free, err := freeSpace(r.opts.LogDir)
+		if err != nil {
+			return fmt.Errorf("failed to check free space: %w", err)
+		}
+		if free < uint64(r.opts.MinFreeSpace) {
+			return fmt.Errorf("not enough free space in %s: %s available, %s required", r.opts.LogDir, formatSize(int64(free)), formatSize(r.opts.MinFreeSpace))
+		}
+	}
+
+	now := r.opts.Now()
+	logPath, err := r.logFilePath(now)
+	if err != nil {
+		return err
+	}
+	r.logPath = logPath
+
+	f, err := r.createLog()
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	r.logFile = f
+	r.start = now.Format(time.RFC3339)
+
+	// header
+	session := fmt.Sprintf("start=%s user=%s context=%s", now.Format(r.opts.TimeFormat), r.opts.User, r.opts.Context)
+	session += headerField("cluster", r.opts.Cluster)
+	session += fmt.Sprintf(" version=%s format=%d id=%s", r.opts.Version, FormatVersion, r.opts.SessionID)
+	session += headerField("group", r.opts.Group)
+	if i := r.opts.Impersonation; i != nil {
+		session += headerField("as", i.User) + headerField("as-groups", strings.Join(i.Groups, ",")) + headerField("as-uid", i.UID)
+	}
+	session += headerField("reason", r.opts.Reason)
+	session += headerField("image", r.opts.Image)
+	header := fmt.Sprintf("[command] %s\n[session] %s\n%s\n", r.opts.Title, session, strings.Repeat("=", 80))
+	_, err = r.logFile.WriteString(header)
+	if err != nil {
+		return err
+	}
+	r.lastByte = '\n'
+	r.logSize = int64(len(header))
+	r.headerSize = r.logSize
+	r.synced = r.opts.Now()
+
+	if r.opts.PlainText {
+		if err := r.prepareText(header); err != nil {
+			return err
+		}
+	}
+	if r.opts.RecordInput {
+		path := strings.TrimSuffix(r.logPath, ".log") + ".in"
+		if r.inputFile, err = r.opts.FS.Create(path); err != nil {
+			return fmt.Errorf("failed to create input file: %w", err)
+		}
+		r.Attach(path)
+	}
+	return r.logFile.Sync()
+}
+
+// prepareText creates the plain text transcript and writes the header
+func (r *Recorder) prepareText(header string) error {
+	path := strings.TrimSuffix(r.logPath, ".log") + ".txt"
+	f, err := r.opts.FS.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plain text transcript: %w", err)
+	}
+	r.textFile = f
+	r.text = newTextWriter(f)
+	r.Attach(path)
+	_, err = r.textFile.WriteString(header)
+	return err
+}
+
+// startPTY starts the command behind a PTY and puts the terminal in raw mode
+func (r *Recorder) startPTY() error {
+	if err := r.spawnPTY(); err != nil {
+		return err
+	}
+
+	// raw mode to keep tab works as before
+	oldState, err := term.MakeRaw(int(r.opts.Terminal.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to put terminal in raw mode: %w", err)
+	}
+	r.restoreTTY = func() error { return term.Restore(int(r.opts.Terminal.Fd()), oldState) }
+	r.log("pty").Debug("terminal in raw mode")
+
+	stopSigs := r.forwardSignals()
+	stopResize := r.watchResize()
+	stopSuspend := r.watchSuspend()
+	stopIdle := r.watchIdle()
+	r.stopSigs = func() {
+		stopSigs()
+		stopResize()
+		stopSuspend()
+		stopIdle()
+	}
+	return nil
+}
+
+// spawnPTY starts the command in a new PTY and inherits the terminal size
+func (r *Recorder) spawnPTY() error {
+	cmd := r.opts.Command(r.opts.Name, r.opts.Args...)
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start PTY: %w", err)
+	}
+	r.cmd = cmd
+	r.proc.Store(cmd.Process)
+	// the input is written to the current PTY
+	r.mu.Lock()
+	r.ptyFile = ptmx
+	r.mu.Unlock()
+	r.output = ptmx
+	r.log("pty").Debug("command started in a PTY", "command", r.opts.Name, "pid", cmd.Process.Pid)
+
+	// inherit terminal size
+	if err := r.resize(); err != nil {
+		return fmt.Errorf("failed to inherit terminal size: %w", err)
+	}
+	return nil
+}
+
+// checkPTY tells if a PTY can be allocated
+func checkPTY() error {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	_ = tty.Close()
+	return ptmx.Close()
+}
+
+// startPipe starts the command without a PTY when the input is not a
+// terminal or no PTY can be allocated: the command reads the input directly
+// so that it gets its end, and its output is recorded through a pipe
+func (r *Recorder) startPipe() error {
+	if err := r.spawnPipe(); err != nil {
+		return err
+	}
+	r.stopSigs = r.forwardSignals()
+	return nil
+}
+
+// spawnPipe starts the command with its output going to new pipes
+func (r *Recorder) spawnPipe() error {
+	cmd := r.opts.Command(r.opts.Name, r.opts.Args...)
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	epr, epw, err := os.Pipe()
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return err
+	}
+	cmd.Stdin = r.opts.Stdin
+	if r.inputFile != nil {
+		cmd.Stdin = io.TeeReader(r.opts.Stdin, inputRecorder{r})
+		// the input is copied by a goroutine that may be blocked reading
+		// it once the command exited
+		cmd.WaitDelay = outputDrainTimeout
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = epw
+	if err := cmd.Start(); err != nil {
+		for _, f := range []*os.File{pr, pw, epr, epw} {
+			f.Close()
+		}
+		return fmt.Errorf("failed to start %s: %w", r.opts.Name, err)
+	}
+	// the command and its children hold the write ends until they exit
+	pw.Close()
+	epw.Close()
+	r.cmd = cmd
+	r.proc.Store(cmd.Process)
+	r.output = pr
+	r.errOutput = epr
+	r.log("pty").Debug("command started without a PTY", "command", r.opts.Name, "pid", cmd.Process.Pid)
+	return nil
+}
+
+// resize applies the terminal size to the PTY and records a resize marker
+func (r *Recorder) resize() error {
+	size, err := pty.GetsizeFull(r.opts.Terminal)
+	if err != nil {
+		return err
+	}
+	if err := pty.Setsize(r.ptyFile, size); err != nil {
+		return err
+	}
+	if r.opts.NoRecord != "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushRedacted()
+	ev := r.Event("resize")
+	now := r.opts.Now()
+	ev.Time = now.Format(time.RFC3339)
+	ev.Cols = int(size.Cols)
+	ev.Rows = int(size.Rows)
+	r.writeLog([]byte(r.marker(fmt.Sprintf("[resize] %dx%d time=%s\n", ev.Cols, ev.Rows, now.Format(r.opts.TimeFormat)))))
+	r.tee.event(ev)
+	return nil
+}
+
+// marker returns a marker line starting on a new line of the log file
+func (r *Recorder) marker(s string) string {
+	if r.lastByte != '\n' {
+		return "\n" + s
+	}
+	return s
+}
+
+// cleanupTTY restores the terminal before writing final messages
+func (r *Recorder) cleanupTTY() {
+	if r.stopSigs != nil {
+		r.stopSigs()
+	}
+	if r.stopHeartbeat != nil {
+		r.stopHeartbeat()
+	}
+	if r.stopTimeout != nil {
+		r.stopTimeout()
+	}
+
+	if r.restoreTTY != nil {
+		_ = r.restoreTTY()
+		r.log("pty").Debug("terminal restored")
+	}
+	r.drainOutput()
+
+	// e.g. the alternate screen of a killed vim or a detach from it
+	if reset := r.modes.reset(); reset != nil {
+		_, _ = r.opts.Stdout.Write(reset)
+		r.log("pty").Debug("terminal modes reset", "sequence", string(reset))
+	}
+}
+
+// drainOutput records the remaining output of the command once it exited and
+// closes it
+func (r *Recorder) drainOutput() {
+	// reading the PTY fails once the child exited
+	if r.outputDone != nil {
+		select {
+		case <-r.outputDone:
+		case <-time.After(outputDrainTimeout):
+		}
+	}
+
+	if r.output != nil {
+		_ = r.output.Close()
+	}
+	if r.errOutput != nil {
+		_ = r.errOutput.Close()
+	}
+
+	if r.outputDone != nil {
+		<-r.outputDone
+		r.log("pty").Debug("output drained")
+	}
+}
+
+// stream copies the PTY output to the terminal, log file and sinks, and the
+// terminal input to the PTY
+func (r *Recorder) stream() {
+	r.copyOutputs()
+	if r.ptyFile == nil {
+		// the command reads the input directly
+		return
+	}
+
+	// stdin => PTY
+	go func() {
+		buf := make([]byte, r.bufferSize())
+		for {
+			n, err := r.opts.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			r.lastInput.Store(r.opts.Now().UnixNano())
+			p := buf[:n]
+			detached := false
+			if r.detach != nil {
+				p, detached = r.detach.filter(p)
+			}
+			if len(p) > 0 {
+				r.mu.Lock()
+				if r.commands != nil {
+					r.commands.input(p)
+				}
+				r.recordInput(p)
+				ptyFile := r.ptyFile
+				r.mu.Unlock()
+				_, _ = ptyFile.Write(p)
+			}
+			if detached {
+				r.detachSession()
+				return
+			}
+		}
+	}()
+}
+
+// copyOutputs copies the output of the command to the terminal, log file and
+// sinks until it ends
+func (r *Recorder) copyOutputs() {
+	// PTY => (stdout + log + sinks)
+	r.outputDone = make(chan struct{})
+	var wg sync.WaitGroup
+	if r.errOutput == nil {
+		wg.Add(1)
+		go r.copyOutput(&wg, r.output, r.opts.Stdout, "")
+	} else {
+		wg.Add(2)
+		go r.copyOutput(&wg, r.output, r.opts.Stdout, StreamStdout)
+		go r.copyOutput(&wg, r.errOutput, r.opts.Stderr, StreamStderr)
+	}
+	done := r.outputDone
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// copyOutput copies the output of the command to the terminal, log file and
+// sinks, stream is the stream of the output without a PTY
+func (r *Recorder) copyOutput(wg *sync.WaitGroup, output *os.File, w io.Writer, stream string) {
+	defer wg.Done()
+	buf := make([]byte, r.bufferSize())
+	kernelTee := newOutputTee(output, w)
+	if kernelTee != nil {
+		r.log("pty").Debug("output duplicated with tee(2)", "stream", stream)
+	}
+	for {
+		var n int
+		var err error
+		teed := false
+		if kernelTee != nil {
+			var disabled bool
+			if n, teed, disabled = kernelTee.tee(len(buf)); teed {
+				// the duplicated output is still in the pipe
+				n, err = io.ReadFull(output, buf[:n])
+			} else if disabled {
+				kernelTee = nil
+			}
+		}
+		if !teed {
+			n, err = output.Read(buf)
+		}
+		if err != nil {
+			if !outputEnded(err) {
+				r.mu.Lock()
+				r.fail(fmt.Errorf("failed to read the output: %w", err))
+				r.mu.Unlock()
+				fmt.Fprintf(r.opts.Stderr, "\r\nWarning: failed to read the output: %v\r\n", err)
+			}
+			return
+		}
+		if n > 0 {
+			r.outputBytes.Add(int64(n))
+			r.lastOutput.Store(r.opts.Now().UnixNano())
+			if !teed {
+				_, _ = w.Write(buf[:n])
+			}
+			r.modes.write(buf[:n])
+			if r.opts.NoRecord != "" {
+				continue
+			}
+			r.mu.Lock()
+			if r.commands != nil {
+				r.commands.output(buf[:n])
+			}
+			r.switchStream(stream)
+			r.record(buf[:n])
+			r.mu.Unlock()
+		}
+	}
+}
+
+// outputEnded tells if an error reading the output is its expected end: EOF
+// for a pipe, EIO for a PTY on Linux once the command exited, or the output
+// closed by cleanupTTY once the drain timed out
+func outputEnded(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed)
+}
+
+// fail keeps the first error that kept the session from being fully
+// recorded, r.mu must be held
+func (r *Recorder) fail(err error) {
+	if r.failure == nil {
+		r.failure = err
+	}
+}
+
+// switchStream records a "[stderr]" marker before the stderr of the command
+// and a "[stdout]" marker when its stdout follows, the output held back for
+// the previous stream is recorded first, r.mu must be held
+func (r *Recorder) switchStream(stream string) {
+	if stream == r.outStream {
+		return
+	}
+	previous := r.outStream
+	if len(r.partial) > 0 {
+		r.emit(r.partial)
+		r.partial = nil
+	}
+	if r.binary != nil {
+		r.emit(r.binary.flush(r.lastByte))
+	}
+	r.flushRedacted()
+	r.outStream = stream
+	if previous == "" && stream == StreamStdout {
+		return
+	}
+	r.writeLog([]byte(r.marker(fmt.Sprintf("[%s]\n", stream))))
+}
+
+// recordInput writes a chunk of input to the input file and the sinks
+// telling streams apart, r.mu must be held
+func (r *Recorder) recordInput(p []byte) {
+	if r.inputFile == nil {
+		return
+	}
+	if r.redactInput != nil {
+		if p = r.redactInput.filter(p); len(p) == 0 {
+			return
+		}
+	}
+	_, _ = r.inputFile.Write(p)
+	r.tee.input(p)
+}
+
+// inputRecorder records the input read by a command running without a PTY
+type inputRecorder struct {
+	r *Recorder
+}
+
+func (w inputRecorder) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	w.r.recordInput(p)
+	return len(p), nil
+}
+
+// detachSession terminates the command after the detach keys were typed
+func (r *Recorder) detachSession() {
+	r.mu.Lock()
+	r.detached = true
+	r.mu.Unlock()
+	r.log("session").Debug("detach keys typed, terminating the command", "command", r.opts.Name)
+	r.terminate()
+	// kill the command if it ignores SIGTERM, Kill fails once it exited
+	time.AfterFunc(detachTimeout, func() { r.signal(os.Kill) })
+}
+
+// terminate sends SIGTERM to the command and keeps it from being started
+// again
+func (r *Recorder) terminate() {
+	r.terminated.Store(true)
+	r.signal(syscall.SIGTERM)
+}
+
+// signal sends a signal to the running command
+func (r *Recorder) signal(sig os.Signal) {
+	if p := r.proc.Load(); p != nil {
+		_ = p.Signal(sig)
+	}
+}
+
+// record writes a chunk of output to the log file and the sinks, r.mu must
+// be held
+func (r *Recorder) record(p []byte) {
+	if len(r.partial) > 0 {
+		p = append(r.partial, p...)
+		r.partial = nil
+	}
+	if n := incompleteRune(p); n > 0 {
+		r.partial = append([]byte(nil), p[len(p)-n:]...)
+		p = p[:len(p)-n]
+	}
+	if r.binary != nil {
+		p = r.binary.filter(p, r.lastByte)
+	}
+	if r.throttle != nil && len(p) > 0 {
+		p = r.throttle.filter(p, r.lastByte)
+	}
+	if r.prompts != nil && len(p) > 0 {
+		start := 0
+		for _, i := range r.prompts.find(p) {
+			r.emit(p[start:i])
+			r.markPrompt()
+			start = i
+		}
+		p = p[start:]
+	}
+	r.emit(p)
+}
+
+// incompleteRune returns the length of the incomplete UTF-8 sequence at the
+// end of p
+func incompleteRune(p []byte) int {
+	for n := 1; n < utf8.UTFMax && n <= len(p); n++ {
+		b := p[len(p)-n]
+		if !utf8.RuneStart(b) {
+			continue
+		}
+		if b >= utf8.RuneSelf && !utf8.FullRune(p[len(p)-n:]) {
+			return n
+		}
+		return 0
+	}
+	return 0
+}
+
+// markPrompt records the start of a prompt, r.mu must be held
+func (r *Recorder) markPrompt() {
+	r.flushRedacted()
+	r.prompt++
+	ev := r.Event("prompt")
+	now := r.opts.Now()
+	ev.Time = now.Format(time.RFC3339)
+	r.writeLog([]byte(r.marker(fmt.Sprintf("[prompt] n=%d time=%s\n", r.prompt, now.Format(r.opts.TimeFormat)))))
+	r.tee.event(ev)
+}
+
+// emit writes recorded output to the log file and the sinks, r.mu must be
+// held
+func (r *Recorder) emit(p []byte) {
+	if r.redactOutput != nil && len(p) > 0 {
+		p = r.redactOutput.filter(p)
+	}
+	if len(p) > 0 {
+		r.tail = keepTail(r.tail, p)
+		r.writeLog(p)
+		r.tee.write(p, r.outStream)
+	}
+}
+
+// flushRedacted records the output held back by the redaction rules before
+// a marker, r.mu must be held
+func (r *Recorder) flushRedacted() {
+	if r.redactOutput == nil {
+		return
+	}
+	if p := r.redactOutput.flush(); len(p) > 0 {
+		r.writeLog(p)
+		r.tee.write(p, r.outStream)
+	}
+}
+
+// writeLog writes to the log file within its size limit, r.mu must be held
+func (r *Recorder) writeLog(p []byte) {
+	if r.logStopped {
+		return
+	}
+	if r.opts.MaxLogSize > 0 && r.opts.MaxLogSizePolicy == SizePolicyRotate {
+		r.writeRotated(p)
+		return
+	}
+	if r.opts.MaxLogSize > 0 && r.logSize+int64(len(p)) > r.opts.MaxLogSize {
+		if !r.limitLog() {
+			return
+		}
+	}
+	r.appendLog(p)
+}
+
+// appendLog writes to the log file and the plain text transcript, the small
+// chunks are held back until a buffer is full or flushPeriodically writes
+// them, r.mu must be held
+func (r *Recorder) appendLog(p []byte) {
+	r.logSize += int64(len(p))
+	r.lastByte = p[len(p)-1]
+	r.pending = append(r.pending, p...)
+	if len(r.pending) >= r.bufferSize() {
+		r.flushLog()
+	}
+}
+
+// finish writes the footer and sends the end event to the sinks
+func (r *Recorder) finish() error {
+	if r.commands != nil {
+		if err := r.writeCommands(); err != nil {
+			fmt.Fprintf(r.opts.Stderr, "Warning: failed to write the command summary: %v\n", err)
+		}
+	}
+
+	// the periodic flushes take r.mu, they are stopped before the last
+	// output is written under it
+	if r.stopFlush != nil {
+		r.stopFlush()
+	}
+
+	// the input read after the command exited is not part of the session
+	r.mu.Lock()
+	if r.inputFile != nil {
+		if r.redactInput != nil {
+			if p := r.redactInput.flush(); len(p) > 0 {
+				_, _ = r.inputFile.Write(p)
+				r.tee.input(p)
+			}
+		}
+		_ = r.inputFile.Close()
+		r.inputFile = nil
+	}
+
+	end := r.opts.Now()
+	r.end = end.Format(time.RFC3339)
+	if len(r.partial) > 0 {
+		r.emit(r.partial)
+		r.partial = nil
+	}
+	if r.throttle != nil {
+		r.emit(r.throttle.flush(r.lastByte))
+	}
+	if r.binary != nil {
+		r.emit(r.binary.flush(r.lastByte))
+	}
+	r.flushRedacted()
+	r.flushLog()
+	r.mu.Unlock()
+	err := r.writeFooter(end)
+	if err := r.writeRedactions(); err != nil {
+		fmt.Fprintf(r.opts.Stderr, "Warning: failed to write the redaction report: %v\n", err)
+	}
+
+	r.tee.end(r.Event("end"))
+	r.log("sink").Debug("end event sent", "sinks", len(r.opts.Sinks))
+	return err
+}
+
+// writeCommands writes the typed commands next to the log file
+func (r *Recorder) writeCommands() error {
+	r.mu.Lock()
+	r.commands.flush()
+	r.mu.Unlock()
+
+	path := strings.TrimSuffix(r.logPath, ".log") + ".commands.txt"
+	f, err := r.opts.FS.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := r.commands.writeTo(f); err != nil {
+		return err
+	}
+	r.Attach(path)
+	return nil
+}
+
+// writeFooter writes the footer to the log file
+func (r *Recorder) writeFooter(end time.Time) error {
+	if r.logFile == nil || r.logFailed {
+		return nil
+	}
+	session := "end=" + end.Format(r.opts.TimeFormat)
+	if r.detached {
+		session += " detached"
+	}
+	if r.idleTimedOut {
+		session += " idle-timeout"
+	}
+	if r.timedOut {
+		session += " timeout"
+	}
+	if r.execFailure != nil {
+		session += headerField("exec-failure", r.execFailure.Reason)
+	}
+	if r.failure != nil {
+		session += headerField("error", r.failure.Error())
+	}
+	if r.text != nil {
+		footer := fmt.Sprintf("%s\n[session] %s\n", strings.Repeat("=", 80), session)
+		if r.lastByte != '\n' {
+			footer = "\n" + footer
+		}
+		_, _ = r.text.Write([]byte(footer))
+	}
+
+	footer := fmt.Sprintf("%s\n[session] %s\n", strings.Repeat("=", 80), session)
+	if r.opts.MaxLogSize > 0 && r.opts.MaxLogSizePolicy == SizePolicyRotate && r.logSize+int64(len(footer)) > r.opts.MaxLogSize {
+		r.mu.Lock()
+		err := r.rotate()
+		r.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to rotate the log file: %w", err)
+		}
+	}
+	if _, err := r.logFile.WriteString(footer); err != nil {
+		return err
+	}
+	return r.logFile.Sync()
+}
+
+// Abandon appends the footer of a session that ended abnormally, e.g. because
+// the process recording it crashed, to the log file at path and to its plain
+// text transcript. The footer goes to the last part of a rotated log file.
+func Abandon(path, end string) error {
+	last := path
+	for n := 1; ; n++ {
+		part := fmt.Sprintf("%s.%d", path, n)
+		if _, err := os.Stat(part); err != nil {
+			break
+		}
+		last = part
+	}
+	footer := fmt.Sprintf("%s\n[session] end=%s terminated abnormally\n", strings.Repeat("=", 80), end)
+	if err := appendFooter(last, footer); err != nil {
+		return err
+	}
+	text := strings.TrimSuffix(path, ".log") + ".txt"
+	if _, err := os.Stat(text); err == nil {
+		return appendFooter(text, footer)
+	}
+	return nil
+}
+
+// appendFooter appends a footer to a file, starting on a new line
+func appendFooter(path, footer string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil && st.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, st.Size()-1); err == nil && last[0] != '\n' {
+			footer = "\n" + footer
+		}
+	}
+	if _, err := f.WriteString(footer); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,272 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RedactRule replaces the matches of a regular expression in the recorded
+// session, the terminal still shows them
+type RedactRule struct {
+	// Set is the name of the rule set of the rule and Name its name
+	Set  string
+	Name string
+	// Pattern matches the text to redact within a line
+	Pattern *regexp.Regexp
+	// End makes the rule redact a block of lines, e.g. a PEM private key:
+	// from the start of a match of Pattern to the end of the next match of
+	// End, or at most redactMaxBlock bytes
+	End *regexp.Regexp
+	// Validate tells if a match of Pattern is redacted, e.g. to check the
+	// digit of a card number, every match is if nil
+	Validate func(match []byte) bool
+	// Replacement replaces the matches, $1 and ${name} refer to the groups
+	// of Pattern
+	Replacement string
+	// Input and Output are the streams the rule applies to
+	Input  bool
+	Output bool
+}
+
+// redactMaxLine is the length of a line held back by redactor past which it
+// is redacted without waiting for its end
+const redactMaxLine = 16 << 10
+
+// redactMaxBlock is the size of a block redacted by a rule with an End
+// past which the output is recorded again, e.g. if the end of a private key
+// was never shown
+const redactMaxBlock = 64 << 10
+
+// redactor applies the redaction rules of a stream. The text of the current
+// line is held back until the line ends so that a match split between two
+// chunks is still redacted.
+type redactor struct {
+	rules []RedactRule
+	// pending is the text of the current line
+	pending []byte
+	// blocks is, by rule, the size of the block redacted so far by the
+	// rules with an End, -1 outside of a block
+	blocks []int
+	// offset is the size of the stream redacted so far, and hits the
+	// matches of the rules, nil if none matched
+	offset int64
+	hits   []redactHits
+}
+
+// redactHits are the matches of a rule in a stream
+type redactHits struct {
+	count int
+	// offsets are the offsets of the first matches in the stream, at most
+	// redactMaxOffsets
+	offsets []int64
+}
+
+// redactMaxOffsets is the number of offsets of the matches of a rule kept
+// for the redaction report
+const redactMaxOffsets = 1000
+
+// newRedactor returns the redactor of the rules applying to a stream, nil if
+// none applies
+func newRedactor(rules []RedactRule, applies func(RedactRule) bool) *redactor {
+	var d redactor
+	for _, rule := range rules {
+		if applies(rule) {
+			d.rules = append(d.rules, rule)
+		}
+	}
+	if len(d.rules) == 0 {
+		return nil
+	}
+	d.blocks = make([]int, len(d.rules))
+	for i := range d.blocks {
+		d.blocks[i] = -1
+	}
+	return &d
+}
+
+// filter returns the redacted complete lines of a chunk
+func (d *redactor) filter(p []byte) []byte {
+	d.pending = append(d.pending, p...)
+	end := bytes.LastIndexAny(d.pending, "\r\n") + 1
+	if len(d.pending) > redactMaxLine {
+		end = len(d.pending)
+	}
+	if end == 0 {
+		return nil
+	}
+	out := d.redact(d.pending[:end])
+	d.pending = append(d.pending[:0], d.pending[end:]...)
+	return out
+}
+
+// flush returns the redacted text held back
+func (d *redactor) flush() []byte {
+	if len(d.pending) == 0 {
+		return nil
+	}
+	out := d.redact(d.pending)
+	d.pending = d.pending[:0]
+	return out
+}
+
+// redactMatch is a match of a rule in a chunk: p[start:end] is replaced by
+// replacement
+type redactMatch struct {
+	start, end  int
+	rule        int
+	replacement []byte
+	// continued is set for the rest of a block started in a previous chunk
+	continued bool
+}
+
+// redact applies the rules to p, the result does not share memory with p.
+// The matches of the rules are found in p, a match overlapping a previous
+// one is ignored.
+func (d *redactor) redact(p []byte) []byte {
+	var matches []redactMatch
+	for i, rule := range d.rules {
+		if rule.End != nil {
+			matches = append(matches, d.blockMatches(i, p)...)
+			continue
+		}
+		for _, loc := range rule.Pattern.FindAllSubmatchIndex(p, -1) {
+			if loc[1] > loc[0] && (rule.Validate == nil || rule.Validate(p[loc[0]:loc[1]])) {
+				replacement := rule.Pattern.Expand(nil, []byte(rule.Replacement), p, loc)
+				matches = append(matches, redactMatch{start: loc[0], end: loc[1], rule: i, replacement: replacement})
+			}
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b redactMatch) int { return a.start - b.start })
+
+	var out []byte
+	pos := 0
+	for _, m := range matches {
+		if m.start < pos {
+			continue
+		}
+		out = append(out, p[pos:m.start]...)
+		out = append(out, m.replacement...)
+		pos = m.end
+		if !m.continued {
+			d.found(m.rule, d.offset+int64(m.start))
+		}
+	}
+	out = append(out, p[pos:]...)
+	d.offset += int64(len(p))
+	return out
+}
+
+// blockMatches returns the blocks of the rule i in p, a block still open at
+// the end of p continues in the next chunk
+func (d *redactor) blockMatches(i int, p []byte) []redactMatch {
+	rule := d.rules[i]
+	var matches []redactMatch
+	for pos := 0; pos < len(p); {
+		m := redactMatch{start: pos, rule: i, continued: true}
+		if d.blocks[i] < 0 {
+			loc := rule.Pattern.FindIndex(p[pos:])
+			if loc == nil {
+				break
+			}
+			m = redactMatch{start: pos + loc[0], rule: i, replacement: []byte(rule.Replacement)}
+			pos += loc[1]
+			d.blocks[i] = loc[1] - loc[0]
+		}
+		rest := p[pos:]
+		loc := rule.End.FindIndex(rest)
+		switch {
+		case loc != nil && d.blocks[i]+loc[1] <= redactMaxBlock:
+			pos += loc[1]
+			d.blocks[i] = -1
+		case d.blocks[i]+len(rest) <= redactMaxBlock:
+			d.blocks[i] += len(rest)
+			pos = len(p)
+		default:
+			// the end was not found in time, the rest is not a block
+			pos += redactMaxBlock - d.blocks[i]
+			d.blocks[i] = -1
+		}
+		m.end = pos
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// found counts a match of the rule i at offset in the stream
+func (d *redactor) found(i int, offset int64) {
+	if d.hits == nil {
+		d.hits = make([]redactHits, len(d.rules))
+	}
+	h := &d.hits[i]
+	h.count++
+	if len(h.offsets) < redactMaxOffsets {
+		h.offsets = append(h.offsets, offset)
+	}
+}
+
+// redactString applies the rules to s, a block open in s ends with it
+func (d *redactor) redactString(s string) string {
+	if d == nil {
+		return s
+	}
+	c := newRedactor(d.rules, func(RedactRule) bool { return true })
+	return string(c.redact([]byte(s)))
+}
+
+// Redaction is the report of the matches of a redaction rule in a stream of
+// a session, without the redacted text
+type Redaction struct {
+	Set  string `json:"set"`
+	Rule string `json:"rule"`
+	// Stream is "output" or "input"
+	Stream string `json:"stream"`
+	Count  int    `json:"count"`
+	// Offsets are the byte offsets of the matches in the stream as
+	// produced by the session, for the first 1000 matches
+	Offsets []int64 `json:"offsets"`
+}
+
+// redactions returns the report of the matches of the rules of a stream
+func (d *redactor) redactions(stream string) []Redaction {
+	if d == nil {
+		return nil
+	}
+	var report []Redaction
+	for i, h := range d.hits {
+		if h.count > 0 {
+			rule := d.rules[i]
+			report = append(report, Redaction{Set: rule.Set, Rule: rule.Name, Stream: stream, Count: h.count, Offsets: h.offsets})
+		}
+	}
+	return report
+}
+
+// writeRedactions writes the report of the redactions next to the log file
+// if a rule matched, so that the exposure of a secret is known even though
+// the recording does not show it
+func (r *Recorder) writeRedactions() error {
+	report := append(r.redactOutput.redactions("output"), r.redactInput.redactions("input")...)
+	if len(report) == 0 || r.logPath == "" {
+		return nil
+	}
+
+	path := strings.TrimSuffix(r.logPath, ".log") + ".redactions.json"
+	f, err := r.opts.FS.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		SessionID  string      `json:"sessionId"`
+		Redactions []Redaction `json:"redactions"`
+	}{r.opts.SessionID, report}); err != nil {
+		return err
+	}
+	r.Attach(path)
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchResize records the terminal resizes until the returned function is
+// called
+func (r *Recorder) watchResize() func() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGWINCH)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-sigChan:
+				_ = r.resize()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(stop)
+		<-done
+	}
+}
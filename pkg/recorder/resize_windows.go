@@ -0,0 +1,6 @@
+package recorder
+
+// watchResize is a no-op, Windows consoles do not signal resizes
+func (r *Recorder) watchResize() func() {
+	return func() {}
+}
@@ -0,0 +1,86 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+const (
+	// retryDelay is the delay before the first reconnection, doubled for
+	// every attempt up to maxRetryDelay
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// transientError matches the kubectl errors of a connection that may succeed
+// when tried again
+var transientError = regexp.MustCompile(`(?i)error dialing backend|TLS handshake timeout|connection reset by peer|http2: client connection lost|unexpected EOF|i/o timeout|connection refused|ServiceUnavailable|too many requests|lost connection to pod|use of closed network connection|broken pipe|websocket: close 1006`)
+
+// Transient tells if the failure may not happen again, e.g. a lost
+// connection, as opposed to a missing pod or a denied exec
+func (f *ExecFailure) Transient() bool {
+	return f.Reason != FailureForbidden && f.Reason != FailureUnauthorized && transientError.MatchString(f.Message)
+}
+
+// retry starts the command again while it fails with a transient error and
+// Retries allows it, the error of the last command is returned
+func (r *Recorder) retry(cmdErr error) error {
+	for attempt := 1; attempt <= r.opts.Retries; attempt++ {
+		var exitErr *exec.ExitError
+		if !errors.As(cmdErr, &exitErr) {
+			return cmdErr
+		}
+		// the output of the failed command is recorded before its error is
+		// classified
+		r.drainOutput()
+		r.mu.Lock()
+		failure := ClassifyExecFailure(r.tail)
+		stopped := r.detached || r.idleTimedOut
+		r.mu.Unlock()
+		if stopped || r.terminated.Load() || failure == nil || !failure.Transient() {
+			return cmdErr
+		}
+
+		delay := min(retryDelay<<(attempt-1), maxRetryDelay)
+		fmt.Fprintf(r.opts.Stderr, "\r\n[execrec] kubectl exec failed (%s), reconnecting in %s (%d/%d)\r\n", failure.Reason, delay, attempt, r.opts.Retries)
+		r.markReconnect(attempt, failure)
+		time.Sleep(delay)
+		if r.terminated.Load() {
+			return cmdErr
+		}
+
+		spawn := r.spawnPipe
+		if r.ptyFile != nil {
+			spawn = r.spawnPTY
+		}
+		if err := spawn(); err != nil {
+			return err
+		}
+		r.copyOutputs()
+		cmdErr = r.cmd.Wait()
+	}
+	return cmdErr
+}
+
+// markReconnect records a reconnect marker and sends a reconnect event with
+// the failure of the previous attempt
+func (r *Recorder) markReconnect(attempt int, failure *ExecFailure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// the next attempt is classified on its own output
+	r.tail = nil
+	if r.opts.NoRecord != "" {
+		return
+	}
+	r.flushRedacted()
+	ev := r.Event("reconnect")
+	now := r.opts.Now()
+	ev.Time = now.Format(time.RFC3339)
+	ev.Attempt = attempt
+	ev.ExecFailure = failure
+	r.writeLog([]byte(r.marker(fmt.Sprintf("[reconnect] attempt=%d reason=%s time=%s\n", attempt, failure.Reason, now.Format(r.opts.TimeFormat)))))
+	r.tee.event(ev)
+}
@@ -0,0 +1,40 @@
+package recorder
+
+import "regexp"
+
+// SecretRules are the built-in redaction rules of the well-known formats of
+// credentials, their patterns only match these formats so that they can be
+// applied to every session
+var SecretRules = []RedactRule{
+	secretRule("aws-access-key", `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`),
+	secretRule("aws-secret-key", `(?i)(aws_?secret_?access_?key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+]{40}\b`),
+	// the private key of a GCP service account key file is a PEM key in a
+	// JSON string, with escaped line endings
+	secretRule("gcp-service-account-key", `("private_key"\s*:\s*")-----BEGIN [A-Z ]*PRIVATE KEY-----[^"]*`),
+	secretRule("jwt", `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,}`),
+	secretBlockRule("pem-private-key", `-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`, `-----END [A-Z ]*PRIVATE KEY( BLOCK)?-----`),
+	// the credentials of a kubeconfig, in YAML or JSON, and of the kubectl
+	// flags
+	secretRule("kubeconfig-token", `((?:^|[\s{,])(?:token|id-token|refresh-token|access-token|client-key-data|"token"|"client-key-data")\s*:\s*["']?|--token[= ]["']?)[A-Za-z0-9._~+/=-]{16,}`),
+}
+
+// secretRule returns a built-in rule redacting the matches of pattern, its
+// first group is kept, e.g. the name of a key before its value
+func secretRule(name, pattern string) RedactRule {
+	re := regexp.MustCompile(pattern)
+	replacement := "[REDACTED]"
+	if re.NumSubexp() > 0 {
+		replacement = "${1}" + replacement
+	}
+	return RedactRule{Set: "secrets", Name: name, Pattern: re, Replacement: replacement, Input: true, Output: true}
+}
+
+// secretBlockRule returns a built-in rule redacting the blocks from start to
+// end
+func secretBlockRule(name, start, end string) RedactRule {
+	return RedactRule{
+		Set: "secrets", Name: name,
+		Pattern: regexp.MustCompile(start), End: regexp.MustCompile(end),
+		Replacement: "[REDACTED]", Input: true, Output: true,
+	}
+}
@@ -0,0 +1,103 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// SignalAction is what happens when kubectl execrec receives a signal during
+// a session
+type SignalAction string
+
+const (
+	// SignalTerminate terminates kubectl exec with SIGTERM, the session ends
+	// and is recorded and uploaded as usual
+	SignalTerminate SignalAction = "terminate"
+	// SignalForward sends the signal to kubectl exec as is
+	SignalForward SignalAction = "forward"
+)
+
+// signalNames are the signals handled during a session, by name
+var signalNames = map[string]syscall.Signal{
+	"int":  syscall.SIGINT,
+	"term": syscall.SIGTERM,
+	"hup":  syscall.SIGHUP,
+	"quit": syscall.SIGQUIT,
+}
+
+// DefaultSignals are the actions of the signals handled during a session:
+// all of them terminate it, so that e.g. closing the terminal window does not
+// leave kubectl exec running and the session without its footer
+var DefaultSignals = map[syscall.Signal]SignalAction{
+	syscall.SIGINT:  SignalTerminate,
+	syscall.SIGTERM: SignalTerminate,
+	syscall.SIGHUP:  SignalTerminate,
+	syscall.SIGQUIT: SignalTerminate,
+}
+
+// ParseSignals parses the actions of signals in the form
+// "hup=forward,quit=terminate", the signals not given keep their default
+// action
+func ParseSignals(s string) (map[syscall.Signal]SignalAction, error) {
+	signals := map[syscall.Signal]SignalAction{}
+	for sig, action := range DefaultSignals {
+		signals[sig] = action
+	}
+	for _, a := range strings.Split(s, ",") {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		name, action, ok := strings.Cut(a, "=")
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "sig")
+		sig, known := signalNames[name]
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid signal action %q, expected 'signal=action' with int, term, hup or quit", strings.TrimSpace(a))
+		}
+		switch action := SignalAction(strings.TrimSpace(action)); action {
+		case SignalTerminate, SignalForward:
+			signals[sig] = action
+		default:
+			return nil, fmt.Errorf("invalid signal action %q, expected forward or terminate", action)
+		}
+	}
+	return signals, nil
+}
+
+// forwardSignals applies the actions of Signals to the signals received until
+// the returned function is called
+func (r *Recorder) forwardSignals() func() {
+	sigChan := make(chan os.Signal, 1)
+	for sig := range r.opts.Signals {
+		signal.Notify(sigChan, sig)
+	}
+	stop := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigChan:
+				action := r.opts.Signals[sig.(syscall.Signal)]
+				r.log("session").Debug("signal received", "signal", sig, "action", action)
+				if sig == syscall.SIGHUP && action == SignalTerminate {
+					// the terminal is gone, the session is finished and
+					// uploaded even if more hangups follow
+					signal.Ignore(syscall.SIGHUP)
+				}
+				if action == SignalForward {
+					r.signal(sig)
+				} else {
+					r.terminate()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		signal.Stop(sigChan)
+	}
+}
@@ -0,0 +1,148 @@
+package recorder
+
+import "strings"
+
+// Sink receives the session lifecycle and the recorded output in addition
+// to the local log file
+type Sink interface {
+	// Start is called once the log header has been written
+	Start(ev Event) error
+	// Write is called with every chunk of session output, p must not be
+	// retained after Write returns
+	Write(p []byte) error
+	// End is called once the footer has been written
+	End(ev Event) error
+	// Close releases the sink's resources
+	Close() error
+}
+
+// EventSink is a Sink that also receives the events happening during the
+// session, such as terminal resizes, in order with the output
+type EventSink interface {
+	Sink
+	// Event is called with every event between Start and End
+	Event(ev Event) error
+}
+
+// StreamSink is a Sink that tells the stdout of the command from its stderr
+// when the command runs without a PTY, and receives the input recorded with
+// RecordInput
+type StreamSink interface {
+	Sink
+	// WriteStream is called instead of Write with the chunks of output of a
+	// command running without a PTY, stream is StreamStdout or StreamStderr,
+	// and with the chunks of input with StreamStdin
+	WriteStream(stream string, p []byte) error
+}
+
+// Impersonation is the identity a session acts as with the kubectl flags
+// --as, --as-group and --as-uid
+type Impersonation struct {
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	UID    string   `json:"uid,omitempty"`
+}
+
+// PodMetadata is what a session tells about its pod, fetched when the session
+// starts so that the recording is self-describing
+type PodMetadata struct {
+	// Labels and Annotations are the ones of the allowlist
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Owners are the owner references of the pod as kind/name, and Workload
+	// the workload managing it, e.g. Deployment/web for a pod of the
+	// ReplicaSet web-5d4f8c7b9
+	Owners         []string `json:"owners,omitempty"`
+	Workload       string   `json:"workload,omitempty"`
+	Node           string   `json:"node,omitempty"`
+	ServiceAccount string   `json:"serviceAccount,omitempty"`
+	// Container is the container of the session, Image its image as given
+	// in the pod spec and ImageDigest the digest of the image it runs, e.g.
+	// sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac,
+	// empty if the container did not start
+	Container   string `json:"container,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+}
+
+// String describes the identity, e.g. "admin (groups system:masters)"
+func (i *Impersonation) String() string {
+	s := i.User
+	if s == "" {
+		s = "the current user"
+	}
+	var details []string
+	if len(i.Groups) > 0 {
+		details = append(details, "groups "+strings.Join(i.Groups, ","))
+	}
+	if i.UID != "" {
+		details = append(details, "uid "+i.UID)
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+// Event describes a session lifecycle event
+type Event struct {
+	Type string `json:"type"`
+	// SessionID identifies the session
+	SessionID string `json:"sessionId"`
+	Command   string `json:"command"`
+	User      string `json:"user"`
+	Context   string `json:"context"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	LogFile   string `json:"logFile"`
+	Version   string `json:"version"`
+	Start     string `json:"start"`
+	End       string `json:"end,omitempty"`
+	// Attachments are files stored alongside the log file
+	Attachments []string `json:"attachments,omitempty"`
+	// Commands are the commands typed in the session, set once it ended
+	Commands []Command `json:"commands,omitempty"`
+	// NoRecord is the reason the session was not recorded, LogFile is empty
+	// then
+	NoRecord string `json:"noRecord,omitempty"`
+	// Detached is set if the user detached from the session with the
+	// detach keys
+	Detached bool `json:"detached,omitempty"`
+	// IdleTimeout is set if the session was terminated for inactivity
+	IdleTimeout bool `json:"idleTimeout,omitempty"`
+	// TimedOut is set if the command was terminated after its timeout
+	TimedOut bool `json:"timedOut,omitempty"`
+	// ExecFailure is the failure of kubectl exec, set once the session
+	// ended if kubectl could not run the command
+	ExecFailure *ExecFailure `json:"execFailure,omitempty"`
+	// Group is the ID of the run of a command on several pods at once the
+	// session is part of
+	Group string `json:"group,omitempty"`
+	// Impersonation is the identity the session acted as, nil without
+	// impersonation, and Reason the reason given for the session
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+	Reason        string         `json:"reason,omitempty"`
+	// Image is the image of the pod created by kubectl run for the session
+	Image string `json:"image,omitempty"`
+	// PodMetadata describes the pod of the session when it started, nil if
+	// it was not fetched
+	PodMetadata *PodMetadata `json:"podMetadata,omitempty"`
+
+	// Time is the time of an event happening during the session
+	Time string `json:"time,omitempty"`
+	// Cols and Rows are the terminal size of a resize event
+	Cols int `json:"cols,omitempty"`
+	Rows int `json:"rows,omitempty"`
+	// Remaining is the number of seconds before the session is terminated
+	// of an idle_warning event
+	Remaining int `json:"remaining,omitempty"`
+	// Bytes is the size of the output of the session so far and
+	// LastActivity the time of its last input or output, of a heartbeat
+	// event
+	Bytes        int64  `json:"bytes,omitempty"`
+	LastActivity string `json:"lastActivity,omitempty"`
+	// Attempt is the number of the reconnection of a reconnect event, its
+	// ExecFailure the failure of the previous attempt
+	Attempt int `json:"attempt,omitempty"`
+}
@@ -0,0 +1,75 @@
+package recorder
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// outputTee duplicates the output of the command to the terminal side with
+// tee(2) when both are pipes, e.g. a session without a PTY whose output is
+// piped to another command: the output reaches the next command without
+// being copied through kubectl execrec, and is then read for the recording.
+// A PTY cannot be teed, its output is always copied.
+type outputTee struct {
+	src syscall.RawConn
+	dst int
+}
+
+// newOutputTee returns the tee of output to w, nil if they are not both
+// pipes
+func newOutputTee(output *os.File, w io.Writer) *outputTee {
+	out, ok := w.(*os.File)
+	if !ok || !isPipe(output) || !isPipe(out) {
+		return nil
+	}
+	src, err := output.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	dst, err := out.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	t := &outputTee{src: src, dst: -1}
+	// the file stays open for the session, its descriptor too
+	if err := dst.Control(func(fd uintptr) { t.dst = int(fd) }); err != nil {
+		return nil
+	}
+	return t
+}
+
+// tee waits for output and duplicates up to max bytes of it, it returns the
+// number of bytes duplicated, which are then read from the output, and false
+// if the output must be copied instead: at its end, while the destination
+// is full or if tee(2) failed, in which case disabled is set
+func (t *outputTee) tee(max int) (n int, ok, disabled bool) {
+	var err error
+	readErr := t.src.Read(func(fd uintptr) bool {
+		var teed int64
+		teed, err = unix.Tee(int(fd), t.dst, max, unix.SPLICE_F_NONBLOCK)
+		n = int(teed)
+		if errors.Is(err, unix.EAGAIN) {
+			// the destination is full if there is output, it is written
+			// by the copy which waits for it
+			queued, _ := unix.IoctlGetInt(int(fd), unix.TIOCINQ)
+			return queued > 0
+		}
+		return true
+	})
+	switch {
+	case readErr != nil, errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+		return 0, false, false
+	case err != nil:
+		return 0, false, true
+	}
+	return n, n > 0, false
+}
+
+func isPipe(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// watchSuspend handles job control until the returned function is called:
+// on SIGTSTP the terminal is restored before kubectl execrec stops, and on
+// SIGCONT it is put back in raw mode and the PTY gets the terminal size,
+// which may have changed in the meantime
+func (r *Recorder) watchSuspend() func() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTSTP, syscall.SIGCONT)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		suspended := false
+		for {
+			select {
+			case sig := <-sigChan:
+				switch {
+				case sig == syscall.SIGTSTP && !suspended:
+					r.suspend()
+					suspended = true
+				case sig == syscall.SIGCONT:
+					if suspended {
+						r.resume()
+						suspended = false
+					}
+					_ = r.resize()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(stop)
+		<-done
+	}
+}
+
+// suspend restores the terminal and stops the process, it runs again once it
+// receives SIGCONT, which may be handled before the stop takes effect
+func (r *Recorder) suspend() {
+	if r.restoreTTY != nil {
+		_ = r.restoreTTY()
+	}
+	r.log("pty").Debug("terminal restored, suspending")
+	_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
+
+// resume puts the terminal back in raw mode after a suspend
+func (r *Recorder) resume() {
+	oldState, err := term.MakeRaw(int(r.opts.Terminal.Fd()))
+	if err != nil {
+		r.log("pty").Warn("failed to put the terminal back in raw mode", "err", err)
+		return
+	}
+	r.restoreTTY = func() error { return term.Restore(int(r.opts.Terminal.Fd()), oldState) }
+	r.log("pty").Debug("resumed, terminal in raw mode")
+}
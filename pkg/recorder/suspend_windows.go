@@ -0,0 +1,6 @@
+package recorder
+
+// watchSuspend is a no-op, Windows has no job control signals
+func (r *Recorder) watchSuspend() func() {
+	return func() {}
+}
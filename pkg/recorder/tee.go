@@ -0,0 +1,305 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the number of output chunks queued for a sink
+	DefaultQueueSize = 1024
+	// DefaultQueueBytes is the size of the output queued for a sink
+	DefaultQueueBytes = 16 << 20
+)
+
+// QueuedSink configures how output is delivered to a sink. Every sink is
+// written to from its own goroutine through a queue, so a slow or failing
+// sink never delays the terminal, the log file or the other sinks.
+type QueuedSink struct {
+	Sink
+	// QueueSize is the number of output chunks queued for the sink,
+	// DefaultQueueSize if zero
+	QueueSize int
+	// QueueBytes caps the memory of the output queued for the sink,
+	// DefaultQueueBytes if zero
+	QueueBytes int
+	// Drop drops output chunks while the queue is full, by default the
+	// session output waits for the sink (backpressure). The sink receives an
+	// output_dropped event with the size of the dropped output once the
+	// queue has room again.
+	Drop bool
+}
+
+// String returns the name of the wrapped sink
+func (q QueuedSink) String() string {
+	return sinkName(q.Sink)
+}
+
+// sinkWorker delivers output to a sink from its own goroutine
+type sinkWorker struct {
+	sink  Sink
+	drop  bool
+	queue chan item
+	done  chan struct{}
+
+	// failed is set once the sink returned an error, guarded by mu
+	mu     sync.Mutex
+	failed bool
+	// dropped is the number of bytes dropped while the queue was full and
+	// unreported the ones not reported to the sink yet
+	dropped    int
+	unreported int
+	// queued is the size of the queued output, at most maxBytes, space is
+	// signaled when it decreases
+	queued   int
+	maxBytes int
+	space    *sync.Cond
+}
+
+// item is a chunk of output or an event queued for a sink
+type item struct {
+	data []byte
+	// chunk holds data, it is released once the item was delivered
+	chunk *chunk
+	// stream is the stream of the output without a PTY, or StreamStdin
+	stream string
+	ev     *Event
+}
+
+// tee dispatches the session lifecycle and output to sink workers
+type tee struct {
+	workers []*sinkWorker
+	stderr  io.Writer
+	now     func() time.Time
+	// started and ended track the worker goroutines
+	started bool
+	ended   bool
+	// session is the start event, the base of the output_dropped events
+	session Event
+}
+
+func newTee(sinks []Sink, stderr io.Writer, now func() time.Time) *tee {
+	t := &tee{stderr: stderr, now: now}
+	for _, s := range sinks {
+		w := &sinkWorker{sink: s, maxBytes: DefaultQueueBytes}
+		w.space = sync.NewCond(&w.mu)
+		size := DefaultQueueSize
+		if q, ok := s.(QueuedSink); ok {
+			w.sink = q.Sink
+			w.drop = q.Drop
+			if q.QueueSize > 0 {
+				size = q.QueueSize
+			}
+			if q.QueueBytes > 0 {
+				w.maxBytes = q.QueueBytes
+			}
+		}
+		w.queue = make(chan item, size)
+		w.done = make(chan struct{})
+		t.workers = append(t.workers, w)
+	}
+	return t
+}
+
+// start sends the start event to every sink and starts the workers, a sink
+// failing to start is disabled
+func (t *tee) start(ev Event) {
+	t.started = true
+	t.session = ev
+	for _, w := range t.workers {
+		if err := w.sink.Start(ev); err != nil {
+			t.fail(w, err)
+		}
+		go t.run(w)
+	}
+}
+
+func (t *tee) run(w *sinkWorker) {
+	defer close(w.done)
+	for it := range w.queue {
+		w.release(len(it.data))
+		if w.isFailed() {
+			it.chunk.release()
+			continue
+		}
+		var err error
+		if it.ev != nil {
+			err = w.sink.(EventSink).Event(*it.ev)
+		} else if s, ok := w.sink.(StreamSink); ok && it.stream != "" {
+			err = s.WriteStream(it.stream, it.data)
+		} else {
+			err = w.sink.Write(it.data)
+		}
+		// the sinks do not retain the output
+		it.chunk.release()
+		if err != nil {
+			t.fail(w, err)
+		}
+	}
+}
+
+// write queues a chunk of output of a stream for every sink, stream is empty
+// behind a PTY
+func (t *tee) write(p []byte, stream string) {
+	t.share(p, stream, func(w *sinkWorker) bool { return true })
+}
+
+// input queues a chunk of input for every sink telling streams apart
+func (t *tee) input(p []byte) {
+	t.share(p, StreamStdin, func(w *sinkWorker) bool {
+		_, ok := w.sink.(StreamSink)
+		return ok
+	})
+}
+
+// share queues a single copy of a chunk, p is reused by the caller, for the
+// workers that receive it
+func (t *tee) share(p []byte, stream string, receives func(w *sinkWorker) bool) {
+	var receivers [8]*sinkWorker
+	workers := receivers[:0]
+	for _, w := range t.workers {
+		if receives(w) && !w.isFailed() {
+			workers = append(workers, w)
+		}
+	}
+	if len(workers) == 0 {
+		return
+	}
+	c := newChunk(p, len(workers))
+	for _, w := range workers {
+		t.enqueue(w, item{data: c.data, chunk: c, stream: stream})
+	}
+}
+
+// event queues an event for every sink receiving events
+func (t *tee) event(ev Event) {
+	for _, w := range t.workers {
+		if _, ok := w.sink.(EventSink); !ok || w.isFailed() {
+			continue
+		}
+		t.enqueue(w, item{ev: &ev})
+	}
+}
+
+// enqueue queues an item for a worker. The output queued is capped at the
+// number of chunks and the size of the queue: the session waits for the sink,
+// or with Drop the output is dropped and an output_dropped event queued once
+// the queue has room again.
+func (t *tee) enqueue(w *sinkWorker, it item) {
+	n := len(it.data)
+	w.mu.Lock()
+	if w.drop && w.queued > 0 && w.queued+n > w.maxBytes {
+		w.dropped += n
+		w.unreported += n
+		w.mu.Unlock()
+		it.chunk.release()
+		return
+	}
+	// a chunk larger than the queue is queued alone
+	for !w.drop && !w.failed && w.queued > 0 && w.queued+n > w.maxBytes {
+		w.space.Wait()
+	}
+	w.queued += n
+	unreported := w.unreported
+	w.unreported = 0
+	w.mu.Unlock()
+
+	if _, ok := w.sink.(EventSink); ok && unreported > 0 {
+		ev := t.session
+		ev.Type = "output_dropped"
+		ev.Time = t.now().Format(time.RFC3339)
+		ev.Bytes = int64(unreported)
+		select {
+		case w.queue <- item{ev: &ev}:
+		default:
+			// reported with the next drop
+			w.mu.Lock()
+			w.unreported += unreported
+			w.mu.Unlock()
+		}
+	}
+	if !w.drop {
+		w.queue <- it
+		return
+	}
+	select {
+	case w.queue <- it:
+	default:
+		w.release(n)
+		it.chunk.release()
+		w.mu.Lock()
+		w.dropped += n
+		w.unreported += n
+		w.mu.Unlock()
+	}
+}
+
+// release frees the size of a delivered chunk in the queue
+func (w *sinkWorker) release(n int) {
+	if n == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.queued -= n
+	w.space.Broadcast()
+	w.mu.Unlock()
+}
+
+// end waits for the queued output to be delivered and sends the end event
+func (t *tee) end(ev Event) {
+	t.ended = true
+	for _, w := range t.workers {
+		close(w.queue)
+	}
+	for _, w := range t.workers {
+		<-w.done
+		if w.dropped > 0 {
+			fmt.Fprintf(t.stderr, "Warning: sink %s dropped %d bytes of output\n", sinkName(w.sink), w.dropped)
+		}
+		if w.isFailed() {
+			continue
+		}
+		if err := w.sink.End(ev); err != nil {
+			fmt.Fprintf(t.stderr, "Warning: sink %s: %v\n", sinkName(w.sink), err)
+		}
+	}
+}
+
+// close closes every sink
+func (t *tee) close() {
+	if t.started && !t.ended {
+		t.ended = true
+		for _, w := range t.workers {
+			close(w.queue)
+			<-w.done
+		}
+	}
+	for _, w := range t.workers {
+		_ = w.sink.Close()
+	}
+}
+
+// fail disables a sink so that a broken sink never interrupts the session
+func (t *tee) fail(w *sinkWorker, err error) {
+	w.mu.Lock()
+	w.failed = true
+	w.space.Broadcast()
+	w.mu.Unlock()
+	fmt.Fprintf(t.stderr, "\r\nWarning: disabling sink %s: %v\r\n", sinkName(w.sink), err)
+}
+
+func (w *sinkWorker) isFailed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failed
+}
+
+// sinkName returns the name of a sink for messages
+func sinkName(s Sink) string {
+	if n, ok := s.(fmt.Stringer); ok {
+		return n.String()
+	}
+	return fmt.Sprintf("%T", s)
+}
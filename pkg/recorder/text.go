@@ -0,0 +1,215 @@
+package recorder
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// states of the escape sequence parser of textWriter
+const (
+	textGround = iota
+	textEscape
+	textCSI
+	textString
+	textStringEscape
+	textCharset
+)
+
+// textWriter renders terminal output as plain text: escape sequences are
+// removed, and carriage returns, backspaces and line erasures are applied to
+// the current line before it is written. The screens of full screen
+// applications are replaced by a marker as they are not lines of text.
+type textWriter struct {
+	w     io.Writer
+	state int
+	// params are the parameters of the current CSI sequence
+	params []byte
+	// line is the current line and col the cursor position in it
+	line []rune
+	col  int
+	// partial is an incomplete UTF-8 sequence
+	partial []byte
+	// onLine is called with every line before it is written
+	onLine func(line string)
+	// modes are the DEC private modes set by the output
+	modes map[int]bool
+}
+
+func newTextWriter(w io.Writer) *textWriter {
+	return &textWriter{w: w, modes: map[int]bool{}}
+}
+
+// NewTextWriter returns a writer rendering terminal output as plain text to
+// w like the plain text transcript, a line is written once it ends
+func NewTextWriter(w io.Writer) io.Writer {
+	return newTextWriter(w)
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch t.state {
+		case textGround:
+			if err := t.ground(b); err != nil {
+				return 0, err
+			}
+		case textEscape:
+			switch b {
+			case '[':
+				t.state = textCSI
+				t.params = t.params[:0]
+			case ']', 'P', 'X', '^', '_':
+				t.state = textString
+			case '(', ')', '*', '+':
+				t.state = textCharset
+			default:
+				t.state = textGround
+			}
+		case textCSI:
+			if b >= 0x40 && b <= 0x7e {
+				t.state = textGround
+				if err := t.csi(b); err != nil {
+					return 0, err
+				}
+			} else {
+				t.params = append(t.params, b)
+			}
+		case textString:
+			switch b {
+			case 0x07:
+				t.state = textGround
+			case 0x1b:
+				t.state = textStringEscape
+			}
+		case textStringEscape:
+			if b == '\\' {
+				t.state = textGround
+			} else {
+				t.state = textString
+			}
+		case textCharset:
+			t.state = textGround
+		}
+	}
+	return len(p), nil
+}
+
+func (t *textWriter) ground(b byte) error {
+	if altScreen(t.modes) {
+		if b == 0x1b {
+			t.state = textEscape
+		}
+		return nil
+	}
+	if b < utf8.RuneSelf {
+		t.flushPartial()
+	}
+	switch {
+	case b == 0x1b:
+		t.state = textEscape
+	case b == '\n':
+		line := t.take()
+		if t.onLine != nil {
+			t.onLine(line)
+		}
+		_, err := io.WriteString(t.w, line+"\n")
+		return err
+	case b == '\r':
+		t.col = 0
+	case b == '\b':
+		if t.col > 0 {
+			t.col--
+		}
+	case b == '\t':
+		t.put('\t')
+	case b < 0x20 || b == 0x7f:
+		// other control characters are not printed
+	case b < utf8.RuneSelf:
+		t.put(rune(b))
+	default:
+		t.partial = append(t.partial, b)
+		for len(t.partial) > 0 && utf8.FullRune(t.partial) {
+			r, size := utf8.DecodeRune(t.partial)
+			t.put(r)
+			t.partial = t.partial[size:]
+		}
+	}
+	return nil
+}
+
+// csi applies the CSI sequences affecting the text of the current line and
+// the alternate screen
+func (t *textWriter) csi(final byte) error {
+	if final == 'h' || final == 'l' {
+		alt := altScreen(t.modes)
+		setPrivateModes(t.modes, t.params, final)
+		switch {
+		case !alt && altScreen(t.modes):
+			// the current line is left as is by full screen applications
+			line := t.take()
+			if line != "" {
+				line += "\n"
+			}
+			_, err := io.WriteString(t.w, line+"[full screen application]\n")
+			return err
+		case alt && !altScreen(t.modes):
+			t.line = t.line[:0]
+			t.col = 0
+		}
+		return nil
+	}
+
+	n, _ := strconv.Atoi(string(t.params))
+	switch final {
+	case 'K':
+		switch n {
+		case 0:
+			if t.col < len(t.line) {
+				t.line = t.line[:t.col]
+			}
+		case 1:
+			for i := 0; i < t.col && i < len(t.line); i++ {
+				t.line[i] = ' '
+			}
+		case 2:
+			t.line = t.line[:0]
+		}
+	case 'C':
+		t.col += max(n, 1)
+	case 'D':
+		t.col = max(t.col-max(n, 1), 0)
+	case 'G':
+		t.col = max(n-1, 0)
+	}
+	return nil
+}
+
+// put writes a character at the cursor position
+func (t *textWriter) put(r rune) {
+	for len(t.line) < t.col {
+		t.line = append(t.line, ' ')
+	}
+	if t.col < len(t.line) {
+		t.line[t.col] = r
+	} else {
+		t.line = append(t.line, r)
+	}
+	t.col++
+}
+
+// flushPartial prints an incomplete UTF-8 sequence as a replacement character
+func (t *textWriter) flushPartial() {
+	if len(t.partial) > 0 {
+		t.partial = t.partial[:0]
+		t.put(utf8.RuneError)
+	}
+}
+
+// take returns the current line and starts a new one
+func (t *textWriter) take() string {
+	s := strings.TrimRight(string(t.line), " ")
+	t.line = t.line[:0]
+	t.col = 0
+	return s
+}
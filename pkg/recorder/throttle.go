@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// throttle caps the output recorded per minute, the output over the cap is
+// omitted and replaced by a marker once recording resumes
+type throttle struct {
+	// rate is the number of bytes recorded per minute
+	rate int64
+	now  func() time.Time
+
+	// window is the start of the current minute, recorded and omitted the
+	// output recorded and omitted in it
+	window   time.Time
+	recorded int64
+	omitted  int64
+}
+
+// filter returns what to record for a chunk of output, last is the last
+// byte recorded so far
+func (t *throttle) filter(p []byte, last byte) []byte {
+	var out []byte
+	if now := t.now(); now.Sub(t.window) >= time.Minute {
+		out = t.flush(last)
+		t.window = now
+		t.recorded = 0
+	}
+	if t.omitted > 0 {
+		t.omitted += int64(len(p))
+		return out
+	}
+
+	keep := p
+	if allowed := t.rate - t.recorded; int64(len(p)) > allowed {
+		keep = p[:allowed]
+		// stop on a complete line if possible
+		if i := bytes.LastIndexByte(keep, '\n'); i >= 0 {
+			keep = keep[:i+1]
+		}
+		t.omitted = int64(len(p) - len(keep))
+	}
+	t.recorded += int64(len(keep))
+	return append(out, keep...)
+}
+
+// flush returns the marker of the omitted output, if any
+func (t *throttle) flush(last byte) []byte {
+	if t.omitted == 0 {
+		return nil
+	}
+	marker := fmt.Sprintf("[... %d bytes omitted ...]\n", t.omitted)
+	if last != '\n' {
+		marker = "\n" + marker
+	}
+	t.omitted = 0
+	return []byte(marker)
+}
@@ -0,0 +1,39 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrTimeout is the error of a command terminated after Timeout
+var ErrTimeout = errors.New("the command timed out")
+
+// watchTimeout terminates the command once it ran for Timeout, until the
+// returned function is called
+func (r *Recorder) watchTimeout() func() {
+	if r.opts.Timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(r.opts.Timeout, r.timeout)
+	return func() { timer.Stop() }
+}
+
+// timeout terminates the command and kills it if it ignores SIGTERM
+func (r *Recorder) timeout() {
+	fmt.Fprintf(r.opts.Stderr, "\r\n[execrec] the command was terminated after the timeout of %s\r\n", r.opts.Timeout)
+	r.mu.Lock()
+	r.timedOut = true
+	if r.opts.NoRecord == "" {
+		r.flushRedacted()
+		ev := r.Event("timeout")
+		now := r.opts.Now()
+		ev.Time = now.Format(time.RFC3339)
+		r.writeLog([]byte(r.marker(fmt.Sprintf("[timeout] after=%s time=%s\n", r.opts.Timeout, now.Format(r.opts.TimeFormat)))))
+		r.tee.event(ev)
+	}
+	r.mu.Unlock()
+	r.terminate()
+	time.AfterFunc(detachTimeout, func() { r.signal(os.Kill) })
+}
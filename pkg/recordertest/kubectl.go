@@ -0,0 +1,185 @@
+// Package recordertest provides fakes to test the recorder and its sinks
+// deterministically: a scripted kubectl run as a child process, a terminal
+// backed by a PTY and a sink keeping what it receives in memory.
+//
+// The scripted kubectl runs the test binary itself, whose TestMain must call
+// Main:
+//
+//	func TestMain(m *testing.M) { recordertest.Main(m) }
+//
+//	func TestSession(t *testing.T) {
+//		kubectl := recordertest.NewKubectl(
+//			recordertest.Print("$ "),
+//			recordertest.Expect("ls"),
+//			recordertest.Print("file.txt\n"),
+//			recordertest.Exit(0),
+//		)
+//		sink := &recordertest.Sink{}
+//		rec := recorder.New(recorder.Options{
+//			Name:    "kubectl",
+//			LogDir:  t.TempDir(),
+//			Stdin:   strings.NewReader("ls\n"),
+//			NoPTY:   true,
+//			Sinks:   []recorder.Sink{sink},
+//			Command: kubectl.Command,
+//		})
+//		...
+//	}
+package recordertest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptEnv is the environment variable holding the script of the kubectl
+// run by Command
+const scriptEnv = "RECORDERTEST_KUBECTL_SCRIPT"
+
+// Step is a step of the script of a Kubectl, made with Print, PrintErr,
+// Expect, Sleep, WaitSignal or Exit
+type Step struct {
+	Action   string        `json:"action"`
+	Text     string        `json:"text,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Code     int           `json:"code,omitempty"`
+}
+
+// Print writes text to stdout
+func Print(text string) Step {
+	return Step{Action: "print", Text: text}
+}
+
+// PrintErr writes text to stderr
+func PrintErr(text string) Step {
+	return Step{Action: "printerr", Text: text}
+}
+
+// Expect reads a line of input and exits with code 2 if it is not line
+func Expect(line string) Step {
+	return Step{Action: "expect", Text: line}
+}
+
+// Sleep waits for d
+func Sleep(d time.Duration) Step {
+	return Step{Action: "sleep", Duration: d}
+}
+
+// WaitSignal waits for a signal, e.g. "SIGTERM", and prints "got SIGTERM"
+// to stdout
+func WaitSignal(name string) Step {
+	return Step{Action: "signal", Text: name}
+}
+
+// Exit exits with code
+func Exit(code int) Step {
+	return Step{Action: "exit", Code: code}
+}
+
+// Kubectl is a fake kubectl running a script of steps, the script ends with
+// exit code 0 unless it exits before
+type Kubectl struct {
+	steps []Step
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+// NewKubectl returns a kubectl running steps
+func NewKubectl(steps ...Step) *Kubectl {
+	return &Kubectl{steps: steps}
+}
+
+// Command returns the command running the script, it is the Command of
+// recorder.Options. The test binary is run again as the fake kubectl, see
+// Main.
+func (k *Kubectl) Command(name string, args ...string) *exec.Cmd {
+	k.mu.Lock()
+	k.calls = append(k.calls, append([]string{name}, args...))
+	k.mu.Unlock()
+
+	script, err := json.Marshal(k.steps)
+	if err != nil {
+		panic(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), scriptEnv+"="+string(script))
+	return cmd
+}
+
+// Calls returns the arguments of the commands started, with their name
+func (k *Kubectl) Calls() [][]string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([][]string(nil), k.calls...)
+}
+
+// Main runs the tests, or the script of a Kubectl when the test binary is
+// run by Command. It is called by TestMain.
+func Main(m *testing.M) {
+	if script, ok := os.LookupEnv(scriptEnv); ok {
+		os.Exit(runScript(script))
+	}
+	os.Exit(m.Run())
+}
+
+// runScript runs the steps of a script and returns the exit code
+func runScript(script string) int {
+	var steps []Step
+	if err := json.Unmarshal([]byte(script), &steps); err != nil {
+		fmt.Fprintf(os.Stderr, "recordertest: invalid script: %v\n", err)
+		return 2
+	}
+	// the signals waited for are caught from the start, so that a signal
+	// sent before its step is not lost
+	sigs := make(chan os.Signal, 8)
+	for _, s := range steps {
+		if s.Action == "signal" {
+			sig, ok := signals[s.Text]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "recordertest: unknown signal %q\n", s.Text)
+				return 2
+			}
+			signal.Notify(sigs, sig)
+		}
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for _, s := range steps {
+		switch s.Action {
+		case "print":
+			fmt.Fprint(os.Stdout, s.Text)
+		case "printerr":
+			fmt.Fprint(os.Stderr, s.Text)
+		case "expect":
+			line, err := in.ReadString('\n')
+			if got := strings.TrimRight(line, "\r\n"); got != s.Text {
+				fmt.Fprintf(os.Stderr, "recordertest: expected input %q, got %q (%v)\n", s.Text, got, err)
+				return 2
+			}
+		case "sleep":
+			time.Sleep(s.Duration)
+		case "signal":
+			for sig := range sigs {
+				if sig == signals[s.Text] {
+					fmt.Fprintf(os.Stdout, "got %s\n", s.Text)
+					break
+				}
+			}
+		case "exit":
+			return s.Code
+		default:
+			fmt.Fprintf(os.Stderr, "recordertest: unknown step %q\n", s.Action)
+			return 2
+		}
+	}
+	return 0
+}
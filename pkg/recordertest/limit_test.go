@@ -0,0 +1,123 @@
+package recordertest_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/recordertest"
+)
+
+// lines returns a script printing n lines of output, one chunk each
+func lines(n int) []recordertest.Step {
+	var steps []recordertest.Step
+	for range n {
+		steps = append(steps, recordertest.Print(strings.Repeat("x", 99)+"\n"), recordertest.Sleep(time.Millisecond))
+	}
+	return steps
+}
+
+func TestMaxLogSizeStop(t *testing.T) {
+	kubectl := recordertest.NewKubectl(lines(20)...)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{MaxLogSize: 1024, Sinks: []recorder.Sink{sink}})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	log := s.log(t)
+	if !strings.Contains(log, "[recording stopped] log size limit reached, recording stopped\n") {
+		t.Errorf("log file has no stop marker:\n%s", log)
+	}
+	if strings.Count(log, "x\n") >= 20 {
+		t.Errorf("the output over the limit was recorded:\n%s", log)
+	}
+	// the sinks and the terminal still receive the whole session
+	if got := strings.Count(sink.Output(), "x\n"); got != 20 {
+		t.Errorf("the sink received %d lines, want 20", got)
+	}
+	if got := strings.Count(s.stdout.String(), "x\n"); got != 20 {
+		t.Errorf("stdout has %d lines, want 20", got)
+	}
+}
+
+func TestMaxLogSizeRotate(t *testing.T) {
+	kubectl := recordertest.NewKubectl(lines(20)...)
+	s := newSession(t, kubectl, recorder.Options{MaxLogSize: 1024, MaxLogSizePolicy: recorder.SizePolicyRotate})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	path := s.rec.LogPath()
+	parts, err := filepath.Glob(path + ".*")
+	if err != nil || len(parts) == 0 {
+		t.Fatalf("no rotated parts of %s (%v)", path, err)
+	}
+	if log := s.log(t); !strings.Contains(log, "[rotated] continued in "+filepath.Base(path)+".1\n") {
+		t.Errorf("log file has no rotation marker:\n%s", log)
+	}
+	if log := s.log(t); len(log) > 1024 {
+		t.Errorf("log file is %d bytes", len(log))
+	}
+	total := strings.Count(s.log(t), "x\n")
+	for n := 1; n <= len(parts); n++ {
+		part := readFile(t, fmt.Sprintf("%s.%d", path, n))
+		if len(part) > 1024 {
+			t.Errorf("part %d is %d bytes", n, len(part))
+		}
+		total += strings.Count(part, "x\n")
+	}
+	if total != 20 {
+		t.Errorf("the parts have %d lines, want 20", total)
+	}
+	last := readFile(t, fmt.Sprintf("%s.%d", path, len(parts)))
+	if !strings.Contains(last, "[session] end=") {
+		t.Errorf("the last part has no footer:\n%s", last)
+	}
+}
+
+func TestMaxLogSizeRotateLargeOutput(t *testing.T) {
+	// output larger than a part, in one chunk and without newlines
+	kubectl := recordertest.NewKubectl(recordertest.Print(strings.Repeat("y", 5000)))
+	s := newSession(t, kubectl, recorder.Options{MaxLogSize: 1024, MaxLogSizePolicy: recorder.SizePolicyRotate})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	path := s.rec.LogPath()
+	parts, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := append([]string{path}, parts...)
+	total := 0
+	for _, file := range files {
+		part := readFile(t, file)
+		if len(part) > 1024 {
+			t.Errorf("%s is %d bytes", filepath.Base(file), len(part))
+		}
+		total += strings.Count(part, "y")
+	}
+	if total != 5000 {
+		t.Errorf("the parts have %d bytes of output, want 5000", total)
+	}
+}
+
+func TestMaxOutputRate(t *testing.T) {
+	kubectl := recordertest.NewKubectl(lines(20)...)
+	s := newSession(t, kubectl, recorder.Options{MaxOutputRate: 500})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	log := s.log(t)
+	if !strings.Contains(log, " bytes omitted ...]\n") {
+		t.Errorf("log file has no omitted output marker:\n%s", log)
+	}
+	if got := strings.Count(s.stdout.String(), "x\n"); got != 20 {
+		t.Errorf("stdout has %d lines, want 20", got)
+	}
+}
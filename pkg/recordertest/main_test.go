@@ -0,0 +1,87 @@
+package recordertest_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/recordertest"
+)
+
+func TestMain(m *testing.M) { recordertest.Main(m) }
+
+// session is a recorded session of a Kubectl
+type session struct {
+	rec    *recorder.Recorder
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// newSession returns the recorder of a session of kubectl without a PTY, the
+// options not set in opts are the ones of a test
+func newSession(t *testing.T, kubectl *recordertest.Kubectl, opts recorder.Options) *session {
+	t.Helper()
+	s := &session{}
+	opts.Name = "kubectl"
+	opts.Args = []string{"exec", "-it", "web", "--", "sh"}
+	opts.User = "alice"
+	opts.Context = "test"
+	opts.Command = kubectl.Command
+	if opts.LogDir == "" {
+		opts.LogDir = t.TempDir()
+	}
+	if opts.Terminal == nil {
+		opts.NoPTY = true
+		if opts.Stdin == nil {
+			opts.Stdin = bytes.NewReader(nil)
+		}
+		if opts.Stdout == nil {
+			opts.Stdout = &s.stdout
+		}
+	}
+	opts.Stderr = &s.stderr
+	s.rec = recorder.New(opts)
+	return s
+}
+
+// run starts the session and waits for its end, it returns the error of
+// Wait
+func (s *session) run(t *testing.T) error {
+	t.Helper()
+	if err := s.rec.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	err := s.rec.Wait()
+	if cerr := s.rec.Close(); cerr != nil {
+		t.Fatalf("Close: %v", cerr)
+	}
+	return err
+}
+
+// log returns the log file of the session
+func (s *session) log(t *testing.T) string {
+	t.Helper()
+	return readFile(t, s.rec.LogPath())
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// exitCode returns the exit code of the command of a session, -1 if it did
+// not exit
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1
+	}
+	return exitErr.ExitCode()
+}
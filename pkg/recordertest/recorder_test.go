@@ -0,0 +1,171 @@
+package recordertest_test
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/recordertest"
+)
+
+func TestRecord(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("$ "),
+		recordertest.Expect("ls"),
+		recordertest.Print("file.txt\n"),
+		recordertest.Exit(0),
+	)
+	s := newSession(t, kubectl, recorder.Options{Stdin: strings.NewReader("ls\n")})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if got := s.stdout.String(); got != "$ file.txt\n" {
+		t.Errorf("stdout = %q, want %q", got, "$ file.txt\n")
+	}
+	log := s.log(t)
+	for _, want := range []string{
+		"[command] kubectl exec -it web -- sh\n",
+		"[session] start=",
+		" user=alice context=test ",
+		"$ file.txt\n",
+		"[session] end=",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log file does not contain %q:\n%s", want, log)
+		}
+	}
+	calls := kubectl.Calls()
+	if len(calls) != 1 || strings.Join(calls[0], " ") != "kubectl exec -it web -- sh" {
+		t.Errorf("calls = %q", calls)
+	}
+}
+
+func TestRecordExitCode(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("command terminated with exit code 3\n"),
+		recordertest.Exit(3),
+	)
+	s := newSession(t, kubectl, recorder.Options{})
+	if err := s.run(t); exitCode(err) != 3 {
+		t.Fatalf("Wait = %v, want exit code 3", err)
+	}
+	if log := s.log(t); !strings.Contains(log, "[session] end=") {
+		t.Errorf("the log file has no footer:\n%s", log)
+	}
+}
+
+func TestRecordStderrMarker(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("out\n"),
+		recordertest.Sleep(50*time.Millisecond),
+		recordertest.PrintErr("err\n"),
+		recordertest.Sleep(50*time.Millisecond),
+		recordertest.Print("more\n"),
+	)
+	s := newSession(t, kubectl, recorder.Options{})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	log := s.log(t)
+	if want := "out\n[stderr]\nerr\n[stdout]\nmore\n"; !strings.Contains(log, want) {
+		t.Errorf("log file does not contain %q:\n%s", want, log)
+	}
+}
+
+func TestRecordPromptMarkers(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("$ "),
+		// the prompt regex matches the current line of a chunk of output
+		recordertest.Sleep(50*time.Millisecond),
+		recordertest.Expect("ls"),
+		recordertest.Print("file.txt\n$ "),
+		recordertest.Expect("exit"),
+	)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{
+		Stdin:         strings.NewReader("ls\nexit\n"),
+		PromptMarkers: true,
+		PromptRegex:   regexp.MustCompile(`\$$`),
+		Sinks:         []recorder.Sink{sink},
+	})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	log := s.log(t)
+	for _, want := range []string{"[prompt] n=1 ", "[prompt] n=2 "} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log file does not contain %q:\n%s", want, log)
+		}
+	}
+	if got, want := strings.Join(sink.Types(), ","), "start,prompt,prompt,end"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestRecordRedact(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("token=hunter2\n"),
+	)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{
+		Redact: []recorder.RedactRule{{Name: "password", Pattern: regexp.MustCompile(`hunter2`), Replacement: "[REDACTED]", Output: true}},
+		Sinks:  []recorder.Sink{sink},
+	})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if log := s.log(t); strings.Contains(log, "hunter2") || !strings.Contains(log, "token=[REDACTED]\n") {
+		t.Errorf("the log file is not redacted:\n%s", log)
+	}
+	if got := sink.Output(); got != "token=[REDACTED]\n" {
+		t.Errorf("sink output = %q", got)
+	}
+	// the terminal still displays the output
+	if got := s.stdout.String(); got != "token=hunter2\n" {
+		t.Errorf("stdout = %q", got)
+	}
+}
+
+func TestRecordNoRecord(t *testing.T) {
+	kubectl := recordertest.NewKubectl(recordertest.Print("secret\n"))
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{NoRecord: "break glass", Sinks: []recorder.Sink{sink}})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if path := s.rec.LogPath(); path != "" {
+		t.Errorf("log file %s created", path)
+	}
+	if got := sink.Output(); got != "" {
+		t.Errorf("sink output = %q, want none", got)
+	}
+	if got, want := strings.Join(sink.Types(), ","), "start,end"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestRecordSameLogName(t *testing.T) {
+	dir := t.TempDir()
+	logName := template.Must(recorder.ParseLogName("{{.User}}"))
+	var paths []string
+	for _, out := range []string{"first\n", "second\n"} {
+		kubectl := recordertest.NewKubectl(recordertest.Print(out))
+		s := newSession(t, kubectl, recorder.Options{LogDir: dir, LogName: logName})
+		if err := s.run(t); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+		if log := s.log(t); !strings.Contains(log, out) {
+			t.Errorf("log file %s does not contain %q:\n%s", s.rec.LogPath(), out, log)
+		}
+		paths = append(paths, filepath.Base(s.rec.LogPath()))
+	}
+	// the second session does not overwrite the log file of the first
+	if got := strings.Join(paths, ","); got != "alice.log,alice-2.log" {
+		t.Errorf("log files = %s, want alice.log,alice-2.log", got)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package recordertest
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals are the signals a Kubectl can wait for
+var signals = map[string]os.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+}
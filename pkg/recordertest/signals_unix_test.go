@@ -0,0 +1,163 @@
+//go:build !windows
+
+package recordertest_test
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/recordertest"
+)
+
+// waitOutput waits until the sink received s
+func waitOutput(t *testing.T, sink *recordertest.Sink, s string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(sink.Output(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q not received, the output is %q", s, sink.Output())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// start starts the session and returns the error of run once it ended
+func start(t *testing.T, s *session) <-chan error {
+	t.Helper()
+	if err := s.rec.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := s.rec.Wait()
+		_ = s.rec.Close()
+		done <- err
+	}()
+	return done
+}
+
+// wait waits for the end of a session started with start
+func wait(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("the session did not end")
+		return nil
+	}
+}
+
+func TestSignalTerminate(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("ready\n"),
+		recordertest.WaitSignal("SIGTERM"),
+		recordertest.Exit(143),
+	)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{Sinks: []recorder.Sink{sink}})
+	done := start(t, s)
+	waitOutput(t, sink, "ready\n")
+	// kubectl execrec is terminated, e.g. by closing the terminal window
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	err := wait(t, done)
+
+	if exitCode(err) != 143 {
+		t.Errorf("Wait = %v, want exit code 143", err)
+	}
+	log := s.log(t)
+	if !strings.Contains(log, "got SIGTERM\n") || !strings.Contains(log, "[session] end=") {
+		t.Errorf("the terminated session was not recorded:\n%s", log)
+	}
+	if got := strings.Join(sink.Types(), ","); got != "start,end" {
+		t.Errorf("events = %s, want start,end", got)
+	}
+}
+
+func TestSignalForward(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("ready\n"),
+		recordertest.WaitSignal("SIGHUP"),
+		recordertest.Print("still running\n"),
+	)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{
+		Signals: map[syscall.Signal]recorder.SignalAction{syscall.SIGHUP: recorder.SignalForward},
+		Sinks:   []recorder.Sink{sink},
+	})
+	done := start(t, s)
+	waitOutput(t, sink, "ready\n")
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(t, done); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if log := s.log(t); !strings.Contains(log, "got SIGHUP\nstill running\n") {
+		t.Errorf("the signal was not forwarded:\n%s", log)
+	}
+}
+
+func TestMaxLogSizeTerminate(t *testing.T) {
+	steps := append(lines(20), recordertest.WaitSignal("SIGTERM"), recordertest.Exit(143))
+	kubectl := recordertest.NewKubectl(steps...)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{
+		MaxLogSize:       1024,
+		MaxLogSizePolicy: recorder.SizePolicyTerminate,
+		Sinks:            []recorder.Sink{sink},
+	})
+	err := wait(t, start(t, s))
+
+	if exitCode(err) != 143 {
+		t.Errorf("Wait = %v, want exit code 143", err)
+	}
+	if log := s.log(t); !strings.Contains(log, "[recording stopped] log size limit reached, terminating the session\n") {
+		t.Errorf("log file has no stop marker:\n%s", log)
+	}
+	if !strings.Contains(sink.Output(), "got SIGTERM\n") {
+		t.Errorf("kubectl did not receive SIGTERM, the output is %q", sink.Output())
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	term, err := recordertest.NewTerminal(80, 24)
+	if err != nil {
+		t.Skipf("no PTY: %v", err)
+	}
+	defer term.Close()
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("$ "),
+		recordertest.Expect("ls"),
+		recordertest.Print("file.txt\n"),
+	)
+	opts := recorder.Options{RecordInput: true}
+	term.Apply(&opts)
+	s := newSession(t, kubectl, opts)
+	done := start(t, s)
+	if err := term.WaitFor("$ ", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := term.Type("ls\r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := term.WaitFor("file.txt", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(t, done); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if log := s.log(t); !strings.Contains(log, "$ ls\r\nfile.txt\r\n") {
+		t.Errorf("log file does not contain the session behind the PTY:\n%q", log)
+	}
+	input := readFile(t, strings.TrimSuffix(s.rec.LogPath(), ".log")+".in")
+	if input != "ls\r" {
+		t.Errorf("input = %q, want %q", input, "ls\r")
+	}
+}
@@ -0,0 +1,8 @@
+package recordertest
+
+import "os"
+
+// signals are the signals a Kubectl can wait for
+var signals = map[string]os.Signal{
+	"SIGINT": os.Interrupt,
+}
@@ -0,0 +1,136 @@
+package recordertest
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// Sink is a recorder.EventSink and recorder.StreamSink keeping what it
+// receives in memory, it is safe for concurrent use
+type Sink struct {
+	// Delay slows every call down, e.g. to fill the queue of the sink
+	Delay time.Duration
+
+	mu sync.Mutex
+	// err is returned by the calls once set with Fail
+	err     error
+	events  []recorder.Event
+	output  bytes.Buffer
+	streams map[string]*bytes.Buffer
+	closed  bool
+}
+
+var (
+	_ recorder.EventSink  = (*Sink)(nil)
+	_ recorder.StreamSink = (*Sink)(nil)
+)
+
+// Fail makes the next calls return err
+func (s *Sink) Fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *Sink) Start(ev recorder.Event) error {
+	return s.event(ev)
+}
+
+func (s *Sink) Event(ev recorder.Event) error {
+	return s.event(ev)
+}
+
+func (s *Sink) End(ev recorder.Event) error {
+	return s.event(ev)
+}
+
+func (s *Sink) event(ev recorder.Event) error {
+	time.Sleep(s.Delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *Sink) Write(p []byte) error {
+	return s.WriteStream("", p)
+}
+
+// WriteStream keeps the chunks of every stream apart, the output is also
+// kept with the output of Write
+func (s *Sink) WriteStream(stream string, p []byte) error {
+	time.Sleep(s.Delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if stream != recorder.StreamStdin {
+		s.output.Write(p)
+	}
+	if stream != "" {
+		if s.streams == nil {
+			s.streams = map[string]*bytes.Buffer{}
+		}
+		if s.streams[stream] == nil {
+			s.streams[stream] = &bytes.Buffer{}
+		}
+		s.streams[stream].Write(p)
+	}
+	return nil
+}
+
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Events returns the events received, including the start and end events
+func (s *Sink) Events() []recorder.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recorder.Event(nil), s.events...)
+}
+
+// Types returns the types of the events received, e.g. ["start", "end"]
+func (s *Sink) Types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := make([]string, len(s.events))
+	for i, ev := range s.events {
+		types[i] = ev.Type
+	}
+	return types
+}
+
+// Output returns the output received
+func (s *Sink) Output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output.String()
+}
+
+// Stream returns the chunks of a stream received, e.g. recorder.StreamStderr
+func (s *Sink) Stream(stream string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[stream] == nil {
+		return ""
+	}
+	return s.streams[stream].String()
+}
+
+// Closed tells if the sink was closed
+func (s *Sink) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
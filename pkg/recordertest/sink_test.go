@@ -0,0 +1,106 @@
+package recordertest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+	"github.com/keidarcy/kubectl-execrec/pkg/recordertest"
+)
+
+func TestSinkEvents(t *testing.T) {
+	kubectl := recordertest.NewKubectl(recordertest.Print("hello\n"))
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{SessionID: "01TEST", Reason: "INC-1", Sinks: []recorder.Sink{sink}})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	events := sink.Events()
+	if len(events) != 2 || events[0].Type != "start" || events[1].Type != "end" {
+		t.Fatalf("events = %v, want start and end", sink.Types())
+	}
+	for _, ev := range events {
+		if ev.SessionID != "01TEST" || ev.User != "alice" || ev.Context != "test" || ev.Reason != "INC-1" {
+			t.Errorf("%s event = %+v", ev.Type, ev)
+		}
+	}
+	if got := sink.Output(); got != "hello\n" {
+		t.Errorf("output = %q, want %q", got, "hello\n")
+	}
+	if !sink.Closed() {
+		t.Error("the sink was not closed")
+	}
+}
+
+func TestSinkStreams(t *testing.T) {
+	kubectl := recordertest.NewKubectl(
+		recordertest.Print("out\n"),
+		recordertest.Sleep(50*time.Millisecond),
+		recordertest.PrintErr("err\n"),
+	)
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{Sinks: []recorder.Sink{sink}})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if got := sink.Stream(recorder.StreamStdout); got != "out\n" {
+		t.Errorf("stdout = %q, want %q", got, "out\n")
+	}
+	if got := sink.Stream(recorder.StreamStderr); got != "err\n" {
+		t.Errorf("stderr = %q, want %q", got, "err\n")
+	}
+}
+
+func TestSinkFailure(t *testing.T) {
+	kubectl := recordertest.NewKubectl(recordertest.Print("hello\n"))
+	broken := &recordertest.Sink{}
+	broken.Fail(errors.New("connection refused"))
+	sink := &recordertest.Sink{}
+	s := newSession(t, kubectl, recorder.Options{Sinks: []recorder.Sink{broken, sink}})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// a broken sink does not interrupt the session or the other sinks
+	if got := sink.Output(); got != "hello\n" {
+		t.Errorf("output = %q, want %q", got, "hello\n")
+	}
+	if !strings.Contains(s.stderr.String(), "disabling sink") {
+		t.Errorf("no warning about the broken sink: %q", s.stderr.String())
+	}
+	if log := s.log(t); !strings.Contains(log, "hello\n") {
+		t.Errorf("log file does not contain the output:\n%s", log)
+	}
+	if !broken.Closed() {
+		t.Error("the broken sink was not closed")
+	}
+}
+
+func TestQueuedSinkDrop(t *testing.T) {
+	var steps []recordertest.Step
+	for range 5 {
+		steps = append(steps, recordertest.Print("chunk\n"), recordertest.Sleep(20*time.Millisecond))
+	}
+	kubectl := recordertest.NewKubectl(steps...)
+	slow := &recordertest.Sink{Delay: 200 * time.Millisecond}
+	s := newSession(t, kubectl, recorder.Options{
+		Sinks: []recorder.Sink{recorder.QueuedSink{Sink: slow, QueueSize: 1, Drop: true}},
+	})
+	if err := s.run(t); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if !strings.Contains(s.stderr.String(), "dropped") {
+		t.Errorf("no warning about the dropped output: %q", s.stderr.String())
+	}
+	if got := strings.Count(slow.Output(), "chunk\n"); got == 0 || got == 5 {
+		t.Errorf("the slow sink received %d chunks, want some dropped", got)
+	}
+	// the log file is complete whatever the sink receives
+	if log := s.log(t); strings.Count(log, "chunk\n") != 5 {
+		t.Errorf("log file:\n%s", log)
+	}
+}
@@ -0,0 +1,133 @@
+//go:build !windows
+
+package recordertest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// Terminal is a fake terminal of a session, a PTY whose TTY is the
+// terminal of the recorder while the test types on the other side and reads
+// what is displayed
+type Terminal struct {
+	// TTY is the terminal of the recorder
+	TTY *os.File
+	pty *os.File
+
+	mu     sync.Mutex
+	screen bytes.Buffer
+	// updated is closed and replaced whenever something is displayed
+	updated chan struct{}
+	done    chan struct{}
+}
+
+// NewTerminal opens a terminal of cols columns and rows rows
+func NewTerminal(cols, rows int) (*Terminal, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := pty.Setsize(ptmx, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, err
+	}
+	// a non-blocking PTY is closed while it is read
+	fd, err := syscall.Dup(int(ptmx.Fd()))
+	ptmx.Close()
+	if err == nil {
+		err = syscall.SetNonblock(fd, true)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+	ptmx = os.NewFile(uintptr(fd), "/dev/ptmx")
+	t := &Terminal{TTY: tty, pty: ptmx, updated: make(chan struct{}), done: make(chan struct{})}
+	go t.read()
+	return t, nil
+}
+
+// Apply makes the terminal the terminal, Stdin and Stdout of the recorder
+func (t *Terminal) Apply(opts *recorder.Options) {
+	opts.Terminal = t.TTY
+	opts.Stdin = t.TTY
+	opts.Stdout = t.TTY
+}
+
+func (t *Terminal) read() {
+	defer close(t.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.pty.Read(buf)
+		t.mu.Lock()
+		t.screen.Write(buf[:n])
+		close(t.updated)
+		t.updated = make(chan struct{})
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Type types s on the terminal, e.g. "ls\r" or "\x03" for ctrl-c
+func (t *Terminal) Type(s string) error {
+	_, err := io.WriteString(t.pty, s)
+	return err
+}
+
+// Screen returns everything displayed on the terminal so far
+func (t *Terminal) Screen() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.screen.String()
+}
+
+// WaitFor waits until s was displayed on the terminal
+func (t *Terminal) WaitFor(s string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		t.mu.Lock()
+		found := strings.Contains(t.screen.String(), s)
+		updated := t.updated
+		t.mu.Unlock()
+		if found {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			return fmt.Errorf("%q not displayed after %s, the screen is %q", s, timeout, t.Screen())
+		}
+	}
+}
+
+// Resize resizes the terminal and sends SIGWINCH to the process, as a
+// terminal emulator does
+func (t *Terminal) Resize(cols, rows int) error {
+	if err := pty.Setsize(t.pty, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}); err != nil {
+		return err
+	}
+	return syscall.Kill(os.Getpid(), syscall.SIGWINCH)
+}
+
+// Close closes the terminal, the reads of the TTY by the recorder fail
+func (t *Terminal) Close() error {
+	err := t.pty.Close()
+	<-t.done
+	if terr := t.TTY.Close(); err == nil {
+		err = terr
+	}
+	return err
+}
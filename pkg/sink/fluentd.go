@@ -0,0 +1,211 @@
+package sink
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+const (
+	defaultFluentdTag     = "kubectl-execrec"
+	defaultFluentdPort    = "24224"
+	fluentdConnectTimeout = 10 * time.Second
+)
+
+// Fluentd ships session events and output chunks to fluentd/fluent-bit
+// over the forward protocol.
+//
+// Events are sent with the tag <tag>.events and output chunks with the tag
+// <tag>.output. If a shared key is set the shared-key handshake is performed
+// before sending any record.
+type Fluentd struct {
+	conn net.Conn
+	tag  string
+
+	// session identifies the records of this session
+	session string
+	// seq is the sequence number of the next output chunk
+	seq int
+}
+
+// NewFluentd connects to the forward input at addr ("host[:port]"), tag
+// defaults to "kubectl-execrec" and sharedKey enables the shared-key handshake
+func NewFluentd(addr, tag, sharedKey string) (*Fluentd, error) {
+	if tag == "" {
+		tag = defaultFluentdTag
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultFluentdPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, fluentdConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to fluentd: %w", err)
+	}
+
+	if sharedKey != "" {
+		if err := fluentdHandshake(conn, sharedKey); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("fluentd handshake failed: %w", err)
+		}
+	}
+	return &Fluentd{conn: conn, tag: tag}, nil
+}
+
+func (s *Fluentd) Start(ev recorder.Event) error {
+	s.session = ev.SessionID
+	return s.sendEvent(ev)
+}
+
+func (s *Fluentd) Write(p []byte) error {
+	return s.sendOutput("", p)
+}
+
+// WriteStream sends the output of a command running without a PTY with its
+// stream in the "stream" field
+func (s *Fluentd) WriteStream(stream string, p []byte) error {
+	return s.sendOutput(stream, p)
+}
+
+func (s *Fluentd) sendOutput(stream string, p []byte) error {
+	record := map[string]any{
+		"session": s.session,
+		"seq":     s.seq,
+		"data":    string(p),
+	}
+	if stream != "" {
+		record["stream"] = stream
+	}
+	s.seq++
+	return s.send(s.tag+".output", record)
+}
+
+func (s *Fluentd) Event(ev recorder.Event) error {
+	return s.sendEvent(ev)
+}
+
+func (s *Fluentd) End(ev recorder.Event) error {
+	return s.sendEvent(ev)
+}
+
+func (s *Fluentd) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Fluentd) sendEvent(ev recorder.Event) error {
+	record := map[string]any{
+		"type":    ev.Type,
+		"session": s.session,
+		"command": ev.Command,
+		"user":    ev.User,
+		"context": ev.Context,
+		"logFile": ev.LogFile,
+		"version": ev.Version,
+		"start":   ev.Start,
+	}
+	if ev.End != "" {
+		record["end"] = ev.End
+	}
+	if ev.Time != "" {
+		record["time"] = ev.Time
+	}
+	if len(ev.Commands) > 0 {
+		commands := make([]any, len(ev.Commands))
+		for i, c := range ev.Commands {
+			commands[i] = map[string]any{"time": c.Time, "command": c.Command}
+		}
+		record["commands"] = commands
+	}
+	if ev.Cols != 0 || ev.Rows != 0 {
+		record["cols"] = ev.Cols
+		record["rows"] = ev.Rows
+	}
+	if ev.Remaining != 0 {
+		record["remaining"] = ev.Remaining
+	}
+	if ev.LastActivity != "" {
+		record["bytes"] = ev.Bytes
+		record["lastActivity"] = ev.LastActivity
+	}
+	return s.send(s.tag+".events", record)
+}
+
+// send writes a record in message mode: [tag, time, record]
+func (s *Fluentd) send(tag string, record map[string]any) error {
+	msg := appendMsgpack(nil, []any{tag, time.Now().Unix(), record})
+	if _, err := s.conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send record to fluentd: %w", err)
+	}
+	return nil
+}
+
+// fluentdHandshake performs the shared-key authentication of the forward
+// protocol: HELO from the server, PING from the client, PONG from the server
+func fluentdHandshake(conn net.Conn, sharedKey string) error {
+	_ = conn.SetDeadline(time.Now().Add(fluentdConnectTimeout))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+
+	helo, err := readFluentdMessage(r, "HELO", 2)
+	if err != nil {
+		return err
+	}
+	opts, _ := helo[1].(map[string]any)
+	nonce, _ := opts["nonce"].(string)
+	if auth, _ := opts["auth"].(string); auth != "" {
+		return fmt.Errorf("user authentication is not supported")
+	}
+
+	hostname, _ := os.Hostname()
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	salt := hex.EncodeToString(buf)
+	ping := []any{"PING", hostname, salt, fluentdDigest(salt, hostname, nonce, sharedKey), "", ""}
+	if _, err := conn.Write(appendMsgpack(nil, ping)); err != nil {
+		return err
+	}
+
+	pong, err := readFluentdMessage(r, "PONG", 5)
+	if err != nil {
+		return err
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return fmt.Errorf("authentication failed: %v", pong[2])
+	}
+	serverHostname, _ := pong[3].(string)
+	if pong[4] != fluentdDigest(salt, serverHostname, nonce, sharedKey) {
+		return fmt.Errorf("server shared key mismatch")
+	}
+	return nil
+}
+
+func readFluentdMessage(r *bufio.Reader, typ string, size int) ([]any, error) {
+	v, err := readMsgpack(r)
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := v.([]any)
+	if !ok || len(msg) < size || msg[0] != typ {
+		return nil, fmt.Errorf("unexpected message, expected %s", typ)
+	}
+	return msg, nil
+}
+
+func fluentdDigest(parts ...string) string {
+	sum := sha512.Sum512([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Fluentd) String() string {
+	return "fluentd"
+}
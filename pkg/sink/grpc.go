@@ -0,0 +1,222 @@
+package sink
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/collector"
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// grpcEndTimeout is how long the collector has to respond once the session
+// ended or could not be sent
+const grpcEndTimeout = 30 * time.Second
+
+// grpcMaxChunk is the size of the largest chunk of output sent in a message,
+// larger writes are split so that the messages fit in the frame size limit
+// of the collector with their session ID and stream
+const grpcMaxChunk = collector.MaxFrameSize - 64<<10
+
+// GRPC streams sessions to a collector over gRPC, see the collector package:
+// a session is a single Record call carrying its events and output chunks
+// in order, so that the collector has the session in real time.
+type GRPC struct {
+	client *http.Client
+	url    string
+	token  string
+
+	// session identifies the messages of this session
+	session string
+	// seq is the sequence number of the next chunk
+	seq uint64
+	// body is the request stream of the call, done receives its result and
+	// res is the result once received
+	body *io.PipeWriter
+	done chan grpcResult
+	res  *grpcResult
+}
+
+// grpcResult is the result of a Record call: the number of chunks received by
+// the collector or an error
+type grpcResult struct {
+	chunks uint64
+	err    error
+}
+
+// NewGRPC creates a sink streaming to the collector at addr,
+// "https://host:port", or "http://host:port" for HTTP/2 without TLS, token is
+// sent as a bearer token if set
+func NewGRPC(addr, token string, tlsConfig *tls.Config) (*GRPC, error) {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid gRPC collector URL %q, expected https://host:port or http://host:port", addr)
+	}
+	protocols := new(http.Protocols)
+	if u.Scheme == "http" {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP2(true)
+	}
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+		Protocols:       protocols,
+	}
+	return &GRPC{
+		client: &http.Client{Transport: transport},
+		url:    strings.TrimSuffix(addr, "/") + collector.RecordPath,
+		token:  token,
+	}, nil
+}
+
+// Start starts the call of the session and sends the start event
+func (s *GRPC) Start(ev recorder.Event) error {
+	s.session = ev.SessionID
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, s.url, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	s.body = pw
+	s.done = make(chan grpcResult, 1)
+	go func() {
+		chunks, err := s.call(req)
+		// the messages sent after the call returned fail with its error
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.CloseWithError(errors.New("the gRPC collector ended the session early"))
+		}
+		s.done <- grpcResult{chunks, err}
+	}()
+	return s.sendEvent(ev)
+}
+
+// call runs the Record call until the collector responds
+func (s *GRPC) call(req *http.Request) (uint64, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stream to the gRPC collector: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("gRPC collector returned %s", resp.Status)
+	}
+	var res collector.RecordResponse
+	if msg, err := collector.ReadFrame(resp.Body); err == nil {
+		_ = res.Unmarshal(msg)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	code := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		// a response without messages has the status in its headers
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		return 0, fmt.Errorf("gRPC collector failed with status %s: %s", code, message)
+	}
+	return res.Chunks, nil
+}
+
+func (s *GRPC) Write(p []byte) error {
+	return s.sendChunk("", p)
+}
+
+// WriteStream sends the output of a command running without a PTY and the
+// recorded input with their stream
+func (s *GRPC) WriteStream(stream string, p []byte) error {
+	return s.sendChunk(stream, p)
+}
+
+func (s *GRPC) Event(ev recorder.Event) error {
+	return s.sendEvent(ev)
+}
+
+// End sends the end event and waits for the collector to acknowledge the
+// session
+func (s *GRPC) End(ev recorder.Event) error {
+	if err := s.sendEvent(ev); err != nil {
+		return err
+	}
+	_ = s.body.Close()
+	res := s.result()
+	if res.err == nil && res.chunks != s.seq {
+		return fmt.Errorf("gRPC collector received %d chunks out of %d", res.chunks, s.seq)
+	}
+	return res.err
+}
+
+// result waits for the result of the call
+func (s *GRPC) result() grpcResult {
+	if s.res == nil {
+		select {
+		case res := <-s.done:
+			s.res = &res
+		case <-time.After(grpcEndTimeout):
+			return grpcResult{err: fmt.Errorf("gRPC collector did not respond within %s", grpcEndTimeout)}
+		}
+	}
+	return *s.res
+}
+
+func (s *GRPC) Close() error {
+	if s.body != nil {
+		_ = s.body.CloseWithError(errors.New("sink closed"))
+	}
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *GRPC) sendChunk(stream string, p []byte) error {
+	for len(p) > 0 {
+		n := min(len(p), grpcMaxChunk)
+		chunk := &collector.Chunk{Seq: s.seq, Stream: stream, Data: p[:n]}
+		s.seq++
+		if err := s.send(collector.Message{SessionID: s.session, Chunk: chunk}); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (s *GRPC) sendEvent(ev recorder.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.send(collector.Message{SessionID: s.session, Event: &collector.Event{Type: ev.Type, JSON: data}})
+}
+
+func (s *GRPC) send(m collector.Message) error {
+	if s.body == nil {
+		return errors.New("the session did not start")
+	}
+	if err := collector.WriteFrame(s.body, m.Marshal()); err != nil {
+		// the call ended, e.g. the collector rejected the session
+		if res := s.result(); res.err != nil {
+			return res.err
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *GRPC) String() string {
+	return "grpc"
+}
@@ -0,0 +1,182 @@
+// Package sink provides recorder sinks shipping sessions to log pipelines
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+const (
+	defaultNATSSubject = "kubectl-execrec"
+	natsPublishTimeout = 10 * time.Second
+)
+
+// NATS publishes session events and output chunks to NATS JetStream.
+//
+// Events are published to <subject>.events and output chunks to
+// <subject>.output. Every message carries a Nats-Msg-Id so that retried
+// publishes are de-duplicated by the stream, giving at-least-once delivery
+// without duplicates in the stream itself.
+type NATS struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+
+	// session identifies messages of this session in their Nats-Msg-Id
+	session string
+	// seq is the sequence number of the next output chunk
+	seq int
+	// events is the number of events published during the session
+	events int
+	// pending holds the acks of the output chunks published asynchronously
+	// that were not acknowledged yet
+	pending []jetstream.PubAckFuture
+}
+
+// NewNATS connects to the NATS server at url, subject defaults to
+// "kubectl-execrec" and creds is an optional credentials file
+func NewNATS(url, subject, creds string) (*NATS, error) {
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+
+	opts := []nats.Option{nats.Name("kubectl-execrec")}
+	if creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	return &NATS{conn: conn, js: js, subject: subject}, nil
+}
+
+func (s *NATS) Start(ev recorder.Event) error {
+	s.session = ev.SessionID
+	return s.publishEvent(ev)
+}
+
+func (s *NATS) Write(p []byte) error {
+	return s.publishOutput("", p)
+}
+
+// WriteStream publishes the output of a command running without a PTY with
+// an Execrec-Stream header
+func (s *NATS) WriteStream(stream string, p []byte) error {
+	return s.publishOutput(stream, p)
+}
+
+func (s *NATS) publishOutput(stream string, p []byte) error {
+	msg := nats.NewMsg(s.subject + ".output")
+	// p is reused by the caller
+	msg.Data = append([]byte(nil), p...)
+	msg.Header.Set("Execrec-Session", s.session)
+	msg.Header.Set("Execrec-Seq", fmt.Sprint(s.seq))
+	if stream != "" {
+		msg.Header.Set("Execrec-Stream", stream)
+	}
+
+	f, err := s.js.PublishMsgAsync(msg, jetstream.WithMsgID(fmt.Sprintf("%s-%d", s.session, s.seq)))
+	if err != nil {
+		return err
+	}
+	s.seq++
+	s.prune()
+	s.pending = append(s.pending, f)
+	return nil
+}
+
+// prune drops the output chunks acknowledged so far from pending, so that
+// only the unacknowledged ones are kept until End
+func (s *NATS) prune() {
+	kept := s.pending[:0]
+	for _, f := range s.pending {
+		select {
+		case <-f.Ok():
+		default:
+			kept = append(kept, f)
+		}
+	}
+	clear(s.pending[len(kept):])
+	s.pending = kept
+}
+
+func (s *NATS) Event(ev recorder.Event) error {
+	s.events++
+	return s.publishEvent(ev)
+}
+
+func (s *NATS) End(ev recorder.Event) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.publishEvent(ev)
+}
+
+func (s *NATS) Close() error {
+	s.conn.Close()
+	return nil
+}
+
+// flush waits for the pending output chunks to be acknowledged and
+// republishes only the ones that were not
+func (s *NATS) flush() error {
+	pending := s.pending
+	s.pending = nil
+	for _, f := range pending {
+		select {
+		case <-f.Ok():
+			continue
+		case <-f.Err():
+		case <-time.After(natsPublishTimeout):
+		}
+
+		msg := f.Msg()
+		ctx, cancel := context.WithTimeout(context.Background(), natsPublishTimeout)
+		_, err := s.js.PublishMsg(ctx, msg, jetstream.WithMsgID(msg.Header.Get(jetstream.MsgIDHeader)), jetstream.WithRetryAttempts(3))
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to publish output to NATS: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *NATS) publishEvent(ev recorder.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%s-%s", s.session, ev.Type)
+	if ev.Type != "start" && ev.Type != "end" {
+		id = fmt.Sprintf("%s-%d", id, s.events)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsPublishTimeout)
+	defer cancel()
+	_, err = s.js.Publish(ctx, s.subject+".events", data,
+		jetstream.WithMsgID(id),
+		jetstream.WithRetryAttempts(3))
+	if err != nil {
+		return fmt.Errorf("failed to publish %s event to NATS: %w", ev.Type, err)
+	}
+	return nil
+}
+
+func (s *NATS) String() string {
+	return "nats"
+}
@@ -0,0 +1,381 @@
+package sink
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+const (
+	// liveBacklogSize is the size of the last output sent to a client
+	// joining a running session
+	liveBacklogSize = 64 << 10
+	// liveClientQueue is the number of frames queued for a client, a client
+	// that does not keep up is disconnected
+	liveClientQueue = 256
+	// liveWriteTimeout is how long a frame can take to reach a client
+	liveWriteTimeout = 10 * time.Second
+)
+
+// WebSocket opcodes
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xa
+)
+
+// wsGUID is the key suffix of the WebSocket handshake
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// LiveStream serves the session over a WebSocket on a loopback address while
+// it runs, so that a local tool can display it live. The events are sent as
+// text frames with the JSON of the other sinks and the output as binary
+// frames. A client joining a running session gets the start event and the
+// last 64KiB of output first. Clients authenticate with a token, in the
+// token query parameter or as a bearer token.
+type LiveStream struct {
+	listener net.Listener
+	server   *http.Server
+	token    string
+
+	mu      sync.Mutex
+	clients map[*liveClient]bool
+	// start is the start event and backlog the last output, for the clients
+	// joining later
+	start   []byte
+	backlog []byte
+	ended   bool
+	// writers waits for the clients to receive their last frames
+	writers sync.WaitGroup
+}
+
+// liveClient is a connected WebSocket client
+type liveClient struct {
+	conn net.Conn
+	// frames are the frames queued for the client, done is closed once it
+	// is disconnected
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewLiveStream listens on addr, a loopback address such as
+// "127.0.0.1:7681" or "127.0.0.1:0" for any free port, token is generated if
+// empty
+func NewLiveStream(addr, token string) (*LiveStream, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid live stream address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("invalid live stream address %q, the session is only served on a loopback address", addr)
+	}
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(b)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve the live stream: %w", err)
+	}
+
+	s := &LiveStream{listener: listener, token: token, clients: map[*liveClient]bool{}}
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: liveWriteTimeout}
+	go func() { _ = s.server.Serve(listener) }()
+	return s, nil
+}
+
+// URL returns the WebSocket URL of the session, with its token
+func (s *LiveStream) URL() string {
+	return fmt.Sprintf("ws://%s/?token=%s", s.listener.Addr(), s.token)
+}
+
+func (s *LiveStream) Start(ev recorder.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.start = data
+	s.mu.Unlock()
+	s.broadcast(wsText, data)
+	return nil
+}
+
+func (s *LiveStream) Write(p []byte) error {
+	s.mu.Lock()
+	s.backlog = append(s.backlog, p...)
+	if n := len(s.backlog) - liveBacklogSize; n > 0 {
+		s.backlog = append(s.backlog[:0], s.backlog[n:]...)
+	}
+	s.mu.Unlock()
+	s.broadcast(wsBinary, p)
+	return nil
+}
+
+// WriteStream sends the output of a command running without a PTY, the
+// recorded input is not sent
+func (s *LiveStream) WriteStream(stream string, p []byte) error {
+	if stream == recorder.StreamStdin {
+		return nil
+	}
+	return s.Write(p)
+}
+
+func (s *LiveStream) Event(ev recorder.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.broadcast(wsText, data)
+	return nil
+}
+
+// End sends the end event and disconnects the clients once they received it
+func (s *LiveStream) End(ev recorder.Event) error {
+	if err := s.Event(ev); err != nil {
+		return err
+	}
+	// a normal closure
+	closeFrame := wsFrame(wsClose, []byte{0x03, 0xe8})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	for c := range s.clients {
+		if !c.send(closeFrame) {
+			c.disconnect()
+		}
+	}
+	return nil
+}
+
+// Close waits for the clients to receive the end of the session, if it
+// ended, and disconnects them
+func (s *LiveStream) Close() error {
+	s.mu.Lock()
+	ended := s.ended
+	s.mu.Unlock()
+	if ended {
+		done := make(chan struct{})
+		go func() {
+			s.writers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(liveWriteTimeout):
+		}
+	}
+	s.mu.Lock()
+	for c := range s.clients {
+		c.disconnect()
+		delete(s.clients, c)
+	}
+	s.mu.Unlock()
+	return s.server.Close()
+}
+
+func (s *LiveStream) String() string {
+	return "live-stream"
+}
+
+// broadcast queues a frame for every client, the clients that do not keep up
+// are disconnected
+func (s *LiveStream) broadcast(opcode byte, p []byte) {
+	frame := wsFrame(opcode, p)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if !c.send(frame) {
+			c.disconnect()
+			delete(s.clients, c)
+		}
+	}
+}
+
+// ServeHTTP upgrades an authenticated request to a WebSocket
+func (s *LiveStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "WebSocket connections only", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket connections are not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	c := &liveClient{conn: conn, frames: make(chan []byte, liveClientQueue), done: make(chan struct{})}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if s.start != nil {
+		c.send(wsFrame(wsText, s.start))
+	}
+	if len(s.backlog) > 0 {
+		c.send(wsFrame(wsBinary, s.backlog))
+	}
+	s.clients[c] = true
+	s.writers.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.writers.Done()
+		c.writeFrames()
+	}()
+	c.readFrames(rw.Reader)
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+}
+
+// send queues a frame, it reports false if the queue of the client is full
+func (c *liveClient) send(frame []byte) bool {
+	select {
+	case c.frames <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeFrames writes the queued frames until the client is disconnected or
+// a close frame was written
+func (c *liveClient) writeFrames() {
+	defer c.disconnect()
+	for {
+		select {
+		case frame := <-c.frames:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if _, err := c.conn.Write(frame); err != nil || frame[0]&0x0f == wsClose {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readFrames reads the frames of the client until it disconnects, pings are
+// answered and the rest is ignored
+func (c *liveClient) readFrames(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			c.disconnect()
+			return
+		}
+		switch opcode {
+		case wsClose:
+			c.disconnect()
+			return
+		case wsPing:
+			c.send(wsFrame(wsPong, payload))
+		}
+	}
+}
+
+func (c *liveClient) disconnect() {
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.conn.Close()
+	})
+}
+
+// wsFrame encodes an unmasked WebSocket frame
+func wsFrame(opcode byte, p []byte) []byte {
+	b := []byte{0x80 | opcode}
+	switch n := len(p); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= 0xffff:
+		b = append(b, 126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	return append(b, p...)
+}
+
+// readWSFrame reads a frame of a client, which is masked
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > liveBacklogSize {
+		return 0, nil, fmt.Errorf("WebSocket frame of %d bytes is too large", size)
+	}
+	var mask [4]byte
+	if header[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathStyleConfig writes a copy of the aws cli config file where the profile
+// uses path-style addressing, https://endpoint/bucket/key instead of
+// https://bucket.endpoint/key, and returns its path. The addressing style
+// can only be set in the config file.
+func pathStyleConfig(profile string) (string, error) {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	section := "default"
+	if profile != "" && profile != "default" {
+		section = "profile " + profile
+	}
+	config := withPathStyle(string(data), section)
+
+	f, err := os.CreateTemp("", "kubectl-execrec-aws-config-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(config); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// withPathStyle sets addressing_style = path in the s3 settings of a section
+// of an aws cli config file
+func withPathStyle(config, section string) string {
+	const style = "  addressing_style = path"
+	lines := strings.Split(config, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		out = append(out, lines[i])
+		if strings.TrimSpace(lines[i]) != "["+section+"]" {
+			continue
+		}
+
+		// add to the nested s3 settings of the section if there are some
+		for j := i + 1; j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "["); j++ {
+			key, value, ok := strings.Cut(lines[j], "=")
+			if ok && strings.TrimSpace(key) == "s3" && strings.TrimSpace(value) == "" {
+				out = append(out, lines[i+1:j+1]...)
+				out = append(out, style)
+				out = append(out, lines[j+1:]...)
+				return strings.Join(out, "\n")
+			}
+		}
+		out = append(out, "s3 =", style)
+		out = append(out, lines[i+1:]...)
+		return strings.Join(out, "\n")
+	}
+	return strings.TrimRight(config, "\n") + "\n[" + section + "]\ns3 =\n" + style + "\n"
+}
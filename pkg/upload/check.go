@@ -0,0 +1,58 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Checker is implemented by uploaders that can check that they are able to
+// upload, their tools, credentials and destination, without uploading
+type Checker interface {
+	Check() error
+}
+
+// Check checks that the buckets of the uploads, including the ones of the
+// routes, can be accessed with the credentials of the uploads
+func (u *S3) Check() error {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("check")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	var buckets []string
+	for _, loc := range u.locations() {
+		if !slices.Contains(buckets, loc[0]) {
+			buckets = append(buckets, loc[0])
+		}
+	}
+	for _, bucket := range buckets {
+		if err := u.run(env, "s3api", "head-bucket", "--bucket", bucket); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// Check connects to the file server
+func (u *SFTP) Check() error {
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return fmt.Errorf("sftp is not installed")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sftp", u.args()...)
+	cmd.Stdin = strings.NewReader("pwd\n")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("sftp error: %s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
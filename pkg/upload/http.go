@@ -0,0 +1,209 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+const httpUploadTimeout = 5 * time.Minute
+
+// HTTP uploads log files and their metadata to an HTTP endpoint.
+//
+// With PUT the log file is sent to the URL and the metadata JSON to the URL
+// with a .json suffix, attachments are sent to the URL rendered for their file
+// name. With POST everything is sent in a single multipart/form-data request
+// with the parts "metadata", "log" and one "attachment" part per attachment.
+type HTTP struct {
+	// URL is the URL template
+	URL string
+	// Method is PUT or POST, PUT if empty
+	Method string
+	// Token is a bearer token
+	Token string
+	// Headers are extra request headers
+	Headers http.Header
+	// TLS configures client certificates and custom CAs
+	TLS TLS
+
+	client *http.Client
+}
+
+func (u *HTTP) Upload(ev recorder.Event) (string, error) {
+	location, err := u.Location(ev)
+	if err != nil {
+		return "", err
+	}
+	if u.client == nil {
+		if u.client, err = newHTTPClient(httpUploadTimeout, u.TLS); err != nil {
+			return location, err
+		}
+	}
+
+	meta, err := json.Marshal(ev)
+	if err != nil {
+		return location, err
+	}
+
+	if strings.EqualFold(u.Method, http.MethodPost) {
+		return location, u.post(location, ev, meta)
+	}
+
+	for _, file := range sessionFiles(ev) {
+		url, err := renderFilePath(u.URL, ev, file)
+		if err != nil {
+			return location, err
+		}
+		if err := u.put(file, url); err != nil {
+			return location, err
+		}
+	}
+	return location, u.do(http.MethodPut, location+".json", "application/json", bytes.NewReader(meta))
+}
+
+func (u *HTTP) Location(ev recorder.Event) (string, error) {
+	return RenderPath(u.URL, ev)
+}
+
+// put sends a local file
+func (u *HTTP) put(file, url string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return u.do(http.MethodPut, url, "text/plain; charset=utf-8", f)
+}
+
+// post sends the metadata, the log file and the attachments in a multipart
+// request, the body is streamed to avoid loading large logs in memory
+func (u *HTTP) post(url string, ev recorder.Event, meta []byte) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			part, err := mw.CreateFormField("metadata")
+			if err != nil {
+				return err
+			}
+			if _, err := part.Write(meta); err != nil {
+				return err
+			}
+			for i, file := range sessionFiles(ev) {
+				name := "attachment"
+				if i == 0 {
+					name = "log"
+				}
+				if err := writeFilePart(mw, name, file); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	return u.do(http.MethodPost, url, mw.FormDataContentType(), pr)
+}
+
+// writeFilePart writes a local file as a part of a multipart request
+func writeFilePart(mw *multipart.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	part, err := mw.CreateFormFile(name, filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+func (u *HTTP) do(method, url, contentType string, body io.Reader) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range u.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP upload error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP upload error: %s %s: %s %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Open streams a file, e.g. a log file to replay, with the token, the
+// headers and the TLS config of the uploads
+func (u *HTTP) Open(url string) (io.ReadCloser, error) {
+	if u.client == nil {
+		var err error
+		if u.client, err = newHTTPClient(httpUploadTimeout, u.TLS); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range u.Headers {
+		req.Header[k] = v
+	}
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+	return openResponse(u.client, req, "HTTP")
+}
+
+// openResponse sends a GET request and returns the body of a successful
+// response, kind names the protocol in errors
+func openResponse(client *http.Client, req *http.Request, kind string) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s error: %w", kind, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s error: GET %s: %s", kind, req.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ParseHeaders parses headers in the form "Name: value; Name: value"
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, h := range strings.Split(s, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected 'Name: value'", strings.TrimSpace(h))
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
@@ -0,0 +1,571 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// S3 uploads log files to S3 or S3-compatible storage with the aws cli
+type S3 struct {
+	Bucket string
+	// Endpoint is a custom endpoint URL for S3-compatible storages
+	Endpoint string
+	// PathStyle uses path-style addressing, required by MinIO without a
+	// domain
+	PathStyle bool
+	// CABundle is a certificate bundle to verify the endpoint, e.g. of a
+	// corporate CA, and InsecureSkipVerify disables the verification
+	CABundle           string
+	InsecureSkipVerify bool
+	// Path is the object key template, DefaultPath if empty
+	Path string
+	// StorageClass is the storage class of the objects, e.g. STANDARD_IA,
+	// the default of the bucket if empty
+	StorageClass string
+	// Routes send the sessions of some namespaces or contexts to another
+	// bucket or prefix, the first matching route is used
+	Routes []S3Route
+
+	// Profile and Region are the AWS profile and region of the upload,
+	// independent of the default ones of the user
+	Profile string
+	Region  string
+	// RoleARN is a role assumed for the upload, e.g. in the account of the
+	// audit bucket, with the optional ExternalID
+	RoleARN    string
+	ExternalID string
+
+	// Tags are added to the user, namespace, pod and session ID in the
+	// metadata of the objects, and in their tags if Tagging is set
+	Tags    map[string]string
+	Tagging bool
+
+	// LockMode is the Object Lock retention mode of the objects, GOVERNANCE
+	// or COMPLIANCE, they are retained for LockRetention after the end of
+	// the session or until LockUntil
+	LockMode      string
+	LockRetention time.Duration
+	LockUntil     time.Time
+
+	// ContentAddressed stores the files under their SHA-256, see
+	// ContentPrefix, and their usual keys hold a pointer to them
+	ContentAddressed bool
+}
+
+// StorageClasses are the S3 storage classes of uploaded objects
+var StorageClasses = []string{
+	"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA",
+	"INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE", "GLACIER_IR",
+}
+
+// ParseStorageClass parses a storage class, case-insensitively
+func ParseStorageClass(s string) (string, error) {
+	class := strings.ToUpper(strings.TrimSpace(s))
+	if class == "" || slices.Contains(StorageClasses, class) {
+		return class, nil
+	}
+	return "", fmt.Errorf("invalid S3 storage class %q, expected one of %s", s, strings.Join(StorageClasses, ", "))
+}
+
+// ParseRetention parses an Object Lock retention, a duration such as
+// "2555d" or "720h" or a date such as "2030-01-01"
+func ParseRetention(s string) (time.Duration, time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return 0, t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return 0, t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, time.Time{}, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, time.Time{}, nil
+	}
+	return 0, time.Time{}, fmt.Errorf("invalid Object Lock retention %q, expected a number of days such as 365d, a duration or a date", s)
+}
+
+// retainUntil returns the date until which the objects of a session are
+// retained
+func (u *S3) retainUntil(ev recorder.Event) time.Time {
+	if !u.LockUntil.IsZero() {
+		return u.LockUntil
+	}
+	end, err := time.Parse(time.RFC3339, ev.End)
+	if err != nil {
+		end = time.Now()
+	}
+	return end.Add(u.LockRetention)
+}
+
+// S3Route sends the sessions whose Field matches Pattern to Bucket, under
+// Prefix if set
+type S3Route struct {
+	// Field is "namespace" or "context"
+	Field   string
+	Pattern string
+	Bucket  string
+	Prefix  string
+}
+
+// ParseS3Routes parses routes in the form "field:pattern=bucket/prefix;
+// field:pattern=bucket", e.g. "namespace:payments-*=pci-audit/sessions"
+func ParseS3Routes(s string) ([]S3Route, error) {
+	var routes []S3Route
+	for _, r := range strings.Split(s, ";") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		selector, dest, ok := strings.Cut(r, "=")
+		field, pattern, ok2 := strings.Cut(selector, ":")
+		field, pattern = strings.TrimSpace(field), strings.TrimSpace(pattern)
+		if !ok || !ok2 || pattern == "" || (field != "namespace" && field != "context") {
+			return nil, fmt.Errorf("invalid S3 route %q, expected 'namespace:pattern=bucket/prefix' or 'context:pattern=bucket/prefix'", r)
+		}
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(dest), "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 route %q, the bucket is empty", r)
+		}
+		routes = append(routes, S3Route{Field: field, Pattern: pattern, Bucket: bucket, Prefix: strings.Trim(prefix, "/")})
+	}
+	return routes, nil
+}
+
+// match tells if the route applies to a session
+func (r S3Route) match(ev recorder.Event) bool {
+	if r.Field == "namespace" {
+		return MatchGlob(r.Pattern, ev.Namespace)
+	}
+	return MatchGlob(r.Pattern, ev.Context)
+}
+
+// ParseTags parses tags in the form "key=value,key=value"
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, t := range strings.Split(s, ",") {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(t, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected 'key=value'", strings.TrimSpace(t))
+		}
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// sessionTags returns the metadata and tags of the objects of a session
+func (u *S3) sessionTags(ev recorder.Event) map[string]string {
+	tags := map[string]string{
+		"user":       ev.User,
+		"context":    ev.Context,
+		"cluster":    ev.Cluster,
+		"namespace":  ev.Namespace,
+		"pod":        ev.Pod,
+		"session-id": ev.SessionID,
+	}
+	if ev.ExecFailure != nil {
+		tags["exec-failure"] = ev.ExecFailure.Reason
+	}
+	for k, v := range u.Tags {
+		tags[k] = v
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(tags, k)
+		}
+	}
+	return tags
+}
+
+// tagValue replaces the characters S3 rejects in tags, e.g. the "*" of a
+// context name, with "_"
+func tagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || strings.ContainsRune("+-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// object returns the bucket and the key of one of the files of a session
+func (u *S3) object(ev recorder.Event, file string) (string, string, error) {
+	tmpl := u.Path
+	if tmpl == "" {
+		tmpl = DefaultPath
+	}
+	key, err := renderFilePath(tmpl, ev, file)
+	if err != nil {
+		return "", "", err
+	}
+	for _, r := range u.Routes {
+		if !r.match(ev) {
+			continue
+		}
+		if r.Prefix != "" {
+			key = r.Prefix + "/" + key
+		}
+		return r.Bucket, key, nil
+	}
+	return u.Bucket, key, nil
+}
+
+func (u *S3) Upload(ev recorder.Event) (string, error) {
+	// check aws cli is installed
+	if _, err := exec.LookPath("aws"); err != nil {
+		return "", fmt.Errorf("aws cli is not installed")
+	}
+
+	location, err := u.Location(ev)
+	if err != nil {
+		return "", err
+	}
+	env, cleanup, err := u.environment(ev.SessionID)
+	if err != nil {
+		return location, err
+	}
+	defer cleanup()
+	for _, file := range sessionFiles(ev) {
+		bucket, s3Key, err := u.object(ev, file)
+		if err != nil {
+			return location, err
+		}
+		objects := [][2]string{{bucket, s3Key}}
+		if u.ContentAddressed {
+			content, err := u.putContent(env, ev, file, bucket, s3Key)
+			if err != nil {
+				return location, err
+			}
+			objects = append(objects, content)
+		} else if err := u.copy(env, ev, file, bucket, s3Key, nil); err != nil {
+			return location, err
+		}
+		if !u.Tagging {
+			continue
+		}
+		for _, o := range objects {
+			if err := u.tag(env, ev, o[0], o[1]); err != nil {
+				return location, err
+			}
+		}
+	}
+	return location, nil
+}
+
+func (u *S3) Location(ev recorder.Event) (string, error) {
+	bucket, s3Key, err := u.object(ev, ev.LogFile)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", bucket, s3Key), nil
+}
+
+// Exists tells if the log file of a session was already uploaded
+func (u *S3) Exists(ev recorder.Event) (bool, error) {
+	bucket, s3Key, err := u.object(ev, ev.LogFile)
+	if err != nil {
+		return false, err
+	}
+	env, cleanup, err := u.environment(ev.SessionID)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+	return u.exists(env, bucket, s3Key), nil
+}
+
+// awsArgs returns the aws cli options selecting the profile, unless the
+// credentials of the assumed role are used, the region and the TLS settings
+func (u *S3) awsArgs() []string {
+	var args []string
+	if u.Profile != "" && u.RoleARN == "" {
+		args = append(args, "--profile", u.Profile)
+	}
+	if u.Region != "" {
+		args = append(args, "--region", u.Region)
+	}
+	if u.CABundle != "" {
+		args = append(args, "--ca-bundle", u.CABundle)
+	}
+	if u.InsecureSkipVerify {
+		args = append(args, "--no-verify-ssl")
+	}
+	return args
+}
+
+// environment returns the environment of the aws cli, nil for the current
+// one, with the credentials of RoleARN and the config of PathStyle, cleanup
+// removes the temporary config
+func (u *S3) environment(session string) (env []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if env, err = u.assumeRole(session); err != nil {
+		return nil, cleanup, err
+	}
+	if u.PathStyle {
+		profile := u.Profile
+		if profile == "" && u.RoleARN == "" {
+			profile = os.Getenv("AWS_PROFILE")
+		}
+		config, err := pathStyleConfig(profile)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to configure path-style addressing: %w", err)
+		}
+		cleanup = func() { _ = os.Remove(config) }
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, "AWS_CONFIG_FILE="+config)
+	}
+	return env, cleanup, nil
+}
+
+// assumeRole assumes RoleARN and returns the environment of the aws cli
+// using its temporary credentials, nil if no role is set. session is added
+// to the role session name.
+func (u *S3) assumeRole(session string) ([]string, error) {
+	if u.RoleARN == "" {
+		return nil, nil
+	}
+	var stsArgs []string
+	if u.Profile != "" {
+		stsArgs = append(stsArgs, "--profile", u.Profile)
+	}
+	stsArgs = append(stsArgs, u.awsArgs()...)
+	stsArgs = append(stsArgs, "sts", "assume-role",
+		"--role-arn", u.RoleARN,
+		"--role-session-name", "kubectl-execrec-"+session,
+		"--output", "json")
+	if u.ExternalID != "" {
+		stsArgs = append(stsArgs, "--external-id", u.ExternalID)
+	}
+
+	var stdout, stderr bytes.Buffer
+	stsCmd := exec.Command("aws", stsArgs...)
+	stsCmd.Stdout = &stdout
+	stsCmd.Stderr = &stderr
+	if err := stsCmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to assume the role %s: %w: %s", u.RoleARN, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string `json:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+		}
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("failed to assume the role %s: %w", u.RoleARN, err)
+	}
+	// the temporary credentials replace the profile
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "AWS_PROFILE=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env,
+		"AWS_ACCESS_KEY_ID="+out.Credentials.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+out.Credentials.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+out.Credentials.SessionToken,
+	), nil
+}
+
+// copy copies a local file to bucket/key with the metadata of the session,
+// extra metadata and the SHA-256 of the file unless set in extra. With
+// Object Lock the object is created with its retention by a single
+// put-object, so that it is never stored without it.
+func (u *S3) copy(env []string, ev recorder.Event, file, bucket, key string, extra map[string]string) error {
+	metadata := map[string]string{}
+	if extra["sha256"] == "" {
+		sum, err := FileSHA256(file)
+		if err != nil {
+			return err
+		}
+		metadata["sha256"] = sum
+	}
+	for k, v := range extra {
+		metadata[k] = v
+	}
+	for k, v := range u.sessionTags(ev) {
+		// metadata are HTTP headers, they are kept ASCII
+		metadata[k] = strings.Map(func(r rune) rune {
+			if r < 0x20 || r > 0x7e {
+				return '_'
+			}
+			return r
+		}, v)
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	var args []string
+	if u.LockMode != "" {
+		// S3 requires a checksum of the objects put with a retention
+		args = []string{"s3api", "put-object", "--bucket", bucket, "--key", key, "--body", file,
+			"--metadata", string(data), "--checksum-algorithm", "SHA256",
+			"--object-lock-mode", u.LockMode, "--object-lock-retain-until-date", u.retainUntil(ev).UTC().Format(time.RFC3339)}
+	} else {
+		args = []string{"s3", "cp", "--metadata", string(data), file, fmt.Sprintf("s3://%s/%s", bucket, key)}
+	}
+	if u.StorageClass != "" {
+		args = append(args, "--storage-class", u.StorageClass)
+	}
+	return u.run(env, args...)
+}
+
+// tag sets the tags of an uploaded object
+func (u *S3) tag(env []string, ev recorder.Event, bucket, key string) error {
+	type tag struct {
+		Key   string
+		Value string
+	}
+	var tagging struct{ TagSet []tag }
+	tags := u.sessionTags(ev)
+	for _, k := range sortedKeys(tags) {
+		tagging.TagSet = append(tagging.TagSet, tag{Key: tagValue(k), Value: tagValue(tags[k])})
+	}
+	data, err := json.Marshal(tagging)
+	if err != nil {
+		return err
+	}
+	return u.run(env, "s3api", "put-object-tagging", "--bucket", bucket, "--key", key, "--tagging", string(data))
+}
+
+// retain sets the Object Lock retention of an object already stored, e.g. a
+// content shared with another session, it can only be extended
+func (u *S3) retain(env []string, ev recorder.Event, bucket, key string) error {
+	retention := fmt.Sprintf(`{"Mode":%q,"RetainUntilDate":%q}`, u.LockMode, u.retainUntil(ev).UTC().Format(time.RFC3339))
+	return u.run(env, "s3api", "put-object-retention", "--bucket", bucket, "--key", key, "--retention", retention)
+}
+
+// run runs the aws cli with the environment env if not nil
+func (u *S3) run(env []string, args ...string) error {
+	s3Args := u.s3Args(args...)
+
+	// Capture stderr to see what the error is
+	var stderr bytes.Buffer
+	uploadCmd := exec.Command("aws", s3Args...)
+	uploadCmd.Env = env
+	uploadCmd.Stdout = nil
+	uploadCmd.Stderr = &stderr
+
+	if err := uploadCmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("AWS CLI error: %s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+// s3Args returns the arguments of the aws cli running an s3 or s3api command
+func (u *S3) s3Args(args ...string) []string {
+	s3Args := u.awsArgs()
+	if u.Endpoint != "" {
+		s3Args = append(s3Args, "--endpoint-url", u.Endpoint)
+	}
+	return append(s3Args, args...)
+}
+
+// Open streams an object, e.g. a log file to replay, with the credentials
+// and the endpoint of the uploads
+func (u *S3) Open(bucket, key string) (io.ReadCloser, error) {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return nil, fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("download")
+	if err != nil {
+		return nil, err
+	}
+	if u.ContentAddressed {
+		if bucket, key, err = u.resolve(env, bucket, key); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	cmd := exec.Command("aws", u.s3Args("s3", "cp", fmt.Sprintf("s3://%s/%s", bucket, key), "-")...)
+	cmd.Env = env
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	r := &commandReader{Reader: stdout, cmd: cmd, cleanup: cleanup}
+	cmd.Stderr = &r.stderr
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return r, nil
+}
+
+// commandReader reads the output of the aws cli, its error is returned once
+// the output ended
+type commandReader struct {
+	io.Reader
+	cmd     *exec.Cmd
+	stderr  bytes.Buffer
+	cleanup func()
+	waited  bool
+	err     error
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		// e.g. a missing object ends the output with an error
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *commandReader) wait() error {
+	if r.waited {
+		return r.err
+	}
+	r.waited = true
+	defer r.cleanup()
+	if r.err = r.cmd.Wait(); r.err != nil && r.stderr.Len() > 0 {
+		r.err = fmt.Errorf("AWS CLI error: %s", strings.TrimSpace(r.stderr.String()))
+	}
+	return r.err
+}
+
+func (r *commandReader) Close() error {
+	if !r.waited {
+		_ = r.cmd.Process.Kill()
+		_ = r.wait()
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,150 @@
+package upload
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+func TestParseS3Routes(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		routes []S3Route
+		err    string
+	}{
+		{name: "empty", in: ""},
+		{
+			name: "routes",
+			in:   "namespace:payments-*=pci-audit/sessions/; context:dev-*=s3://dev-audit",
+			routes: []S3Route{
+				{Field: "namespace", Pattern: "payments-*", Bucket: "pci-audit", Prefix: "sessions"},
+				{Field: "context", Pattern: "dev-*", Bucket: "dev-audit"},
+			},
+		},
+		{
+			name:   "trailing separator",
+			in:     " context : prod = audit/prod/eu ;",
+			routes: []S3Route{{Field: "context", Pattern: "prod", Bucket: "audit", Prefix: "prod/eu"}},
+		},
+		{name: "unknown field", in: "pod:web-*=audit", err: "invalid S3 route"},
+		{name: "no destination", in: "namespace:payments-*", err: "invalid S3 route"},
+		{name: "no pattern", in: "namespace:=audit", err: "invalid S3 route"},
+		{name: "no bucket", in: "namespace:payments-*=/sessions", err: "the bucket is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := ParseS3Routes(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseS3Routes error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseS3Routes: %v", err)
+			}
+			if !reflect.DeepEqual(routes, tt.routes) {
+				t.Errorf("routes = %+v, want %+v", routes, tt.routes)
+			}
+		})
+	}
+}
+
+func TestS3Object(t *testing.T) {
+	routes, err := ParseS3Routes("namespace:payments-*=pci-audit/sessions; context:dev-*=dev-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &S3{Bucket: "audit", Routes: routes}
+	tests := []struct {
+		name   string
+		ev     recorder.Event
+		bucket string
+		key    string
+	}{
+		{
+			name:   "namespace route",
+			ev:     recorder.Event{Context: "dev-1", Namespace: "payments-eu"},
+			bucket: "pci-audit",
+			key:    "sessions/kubectl-execrec/dev-1/alice.log",
+		},
+		{
+			name:   "context route",
+			ev:     recorder.Event{Context: "dev-1", Namespace: "default"},
+			bucket: "dev-audit",
+			key:    "kubectl-execrec/dev-1/alice.log",
+		},
+		{
+			name:   "default bucket",
+			ev:     recorder.Event{Context: "arn:aws:eks:eu-west-1:1:cluster/prod", Namespace: "default"},
+			bucket: "audit",
+			key:    "kubectl-execrec/arn-aws-eks-eu-west-1-1-cluster-prod/alice.log",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, key, err := u.object(tt.ev, "/tmp/kubectl-execrec/alice.log")
+			if err != nil {
+				t.Fatalf("object: %v", err)
+			}
+			if bucket != tt.bucket || key != tt.key {
+				t.Errorf("object = s3://%s/%s, want s3://%s/%s", bucket, key, tt.bucket, tt.key)
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		tags map[string]string
+		err  string
+	}{
+		{name: "empty", in: "", tags: map[string]string{}},
+		{name: "tags", in: "team=sre, env = prod ,", tags: map[string]string{"team": "sre", "env": "prod"}},
+		{name: "empty value", in: "reviewed=", tags: map[string]string{"reviewed": ""}},
+		{name: "value with =", in: "query=a=b", tags: map[string]string{"query": "a=b"}},
+		{name: "no value", in: "team", err: `invalid tag "team"`},
+		{name: "no key", in: " =sre", err: `invalid tag "=sre"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := ParseTags(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseTags error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTags: %v", err)
+			}
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", tags, tt.tags)
+			}
+		})
+	}
+}
+
+func TestSessionTags(t *testing.T) {
+	u := &S3{Tags: map[string]string{"team": "sre"}}
+	ev := recorder.Event{User: "alice", Context: "prod", Namespace: "default", Pod: "web", SessionID: "01TEST"}
+	want := map[string]string{
+		"user":       "alice",
+		"context":    "prod",
+		"namespace":  "default",
+		"pod":        "web",
+		"session-id": "01TEST",
+		"team":       "sre",
+	}
+	if got := u.sessionTags(ev); !reflect.DeepEqual(got, want) {
+		t.Errorf("sessionTags = %v, want %v", got, want)
+	}
+	if got := tagValue("prod-*|eu"); got != "prod-__eu" {
+		t.Errorf("tagValue = %q, want %q", got, "prod-__eu")
+	}
+}
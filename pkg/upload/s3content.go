@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// ContentPrefix is the prefix of the files stored by content, under the
+// prefix of the path template and of the route of the session, e.g.
+// "kubectl-execrec/sha256/<hex>" with DefaultPath
+const ContentPrefix = "sha256/"
+
+// contentPointer is the object at the usual key of a file stored by content
+type contentPointer struct {
+	Location string `json:"location"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// pathPrefix returns the prefix of the keys of the path template, up to its
+// first field
+func (u *S3) pathPrefix() string {
+	tmpl := u.Path
+	if tmpl == "" {
+		tmpl = DefaultPath
+	}
+	prefix, _, _ := strings.Cut(tmpl, "{{")
+	return prefix
+}
+
+// contentObject returns the bucket and the key of a file of a session stored
+// by its SHA-256
+func (u *S3) contentObject(ev recorder.Event, sum string) (string, string) {
+	prefix := u.pathPrefix()
+	for _, r := range u.Routes {
+		if !r.match(ev) {
+			continue
+		}
+		if r.Prefix != "" {
+			prefix = r.Prefix + "/" + prefix
+		}
+		return r.Bucket, prefix + ContentPrefix + sum
+	}
+	return u.Bucket, prefix + ContentPrefix + sum
+}
+
+// putContent stores a file by its SHA-256, unless an identical file was
+// already stored, e.g. by an upload that was retried, and writes a pointer to
+// it at bucket/key. It returns the bucket and the key of the content.
+func (u *S3) putContent(env []string, ev recorder.Event, file, bucket, key string) ([2]string, error) {
+	sum, err := FileSHA256(file)
+	if err != nil {
+		return [2]string{}, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return [2]string{}, err
+	}
+	cbucket, ckey := u.contentObject(ev, sum)
+	content := fmt.Sprintf("s3://%s/%s", cbucket, ckey)
+	switch {
+	case !u.exists(env, cbucket, ckey):
+		if err := u.copy(env, ev, file, cbucket, ckey, map[string]string{"sha256": sum}); err != nil {
+			return [2]string{}, err
+		}
+	case u.LockMode != "":
+		// the content is retained at least as long as this session
+		if err := u.retain(env, ev, cbucket, ckey); err != nil {
+			return [2]string{}, err
+		}
+	}
+
+	data, err := json.Marshal(contentPointer{Location: content, SHA256: sum, Size: info.Size()})
+	if err != nil {
+		return [2]string{}, err
+	}
+	pointer, err := os.CreateTemp("", "kubectl-execrec-pointer-*.json")
+	if err != nil {
+		return [2]string{}, err
+	}
+	defer os.Remove(pointer.Name())
+	if _, err := pointer.Write(data); err != nil {
+		pointer.Close()
+		return [2]string{}, err
+	}
+	if err := pointer.Close(); err != nil {
+		return [2]string{}, err
+	}
+	metadata := map[string]string{"sha256": sum, "content-location": content}
+	if err := u.copy(env, ev, pointer.Name(), bucket, key, metadata); err != nil {
+		return [2]string{}, err
+	}
+	return [2]string{cbucket, ckey}, nil
+}
+
+// exists tells if an object exists, an object that cannot be checked is
+// uploaded again
+func (u *S3) exists(env []string, bucket, key string) bool {
+	_, err := u.output(env, "s3api", "head-object", "--bucket", bucket, "--key", key)
+	return err == nil
+}
+
+// resolve returns the content an object points to, the object itself if it
+// is not a pointer
+func (u *S3) resolve(env []string, bucket, key string) (string, string, error) {
+	metadata, err := u.metadata(env, bucket, key)
+	if err != nil {
+		return "", "", err
+	}
+	content, ok := metadata["content-location"]
+	if !ok {
+		return bucket, key, nil
+	}
+	cbucket, ckey, _ := strings.Cut(strings.TrimPrefix(content, "s3://"), "/")
+	if !strings.HasPrefix(content, "s3://") || cbucket == "" || ckey == "" {
+		return "", "", fmt.Errorf("invalid content location %q of s3://%s/%s", content, bucket, key)
+	}
+	return cbucket, ckey, nil
+}
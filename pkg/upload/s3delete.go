@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// SessionObjects returns the objects of a session, its log file at location,
+// "s3://bucket/key", and its attachments, as well as the content they point
+// to with ContentAddressed
+func (u *S3) SessionObjects(location string) ([]S3Object, error) {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return nil, fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("list")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return u.sessionObjects(env, location)
+}
+
+// Delete deletes objects with every version of them, so that no copy is left
+// in a versioned bucket, and returns the locations of the deleted ones. An
+// object under legal hold or retention fails to be deleted.
+func (u *S3) Delete(objects []S3Object) ([]string, error) {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return nil, fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("delete")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var deleted []string
+	for _, o := range objects {
+		versions, err := u.versions(env, o.Bucket, o.Key)
+		if err != nil {
+			return deleted, err
+		}
+		// a bucket that never had versioning has no versions listed
+		if len(versions) == 0 {
+			versions = []string{""}
+		}
+		for _, version := range versions {
+			args := []string{"s3api", "delete-object", "--bucket", o.Bucket, "--key", o.Key}
+			if version != "" {
+				args = append(args, "--version-id", version)
+			}
+			if err := u.run(env, args...); err != nil {
+				return deleted, fmt.Errorf("failed to delete %s: %w", o.Location(), err)
+			}
+		}
+		deleted = append(deleted, o.Location())
+	}
+	return deleted, nil
+}
+
+// versions returns the IDs of the versions and delete markers of an object,
+// "null" for the object of a bucket whose versioning is suspended
+func (u *S3) versions(env []string, bucket, key string) ([]string, error) {
+	out, err := u.output(env, "s3api", "list-object-versions", "--bucket", bucket, "--prefix", key, "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Versions      []struct{ Key, VersionId string }
+		DeleteMarkers []struct{ Key, VersionId string }
+	}
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &res); err != nil {
+			return nil, fmt.Errorf("failed to list the versions of s3://%s/%s: %w", bucket, key, err)
+		}
+	}
+	var versions []string
+	// the prefix also lists the objects whose key starts with key
+	for _, v := range append(res.Versions, res.DeleteMarkers...) {
+		if v.Key == key {
+			versions = append(versions, v.VersionId)
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,114 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// legalHoldTag is the tag of the objects under legal hold in a bucket
+// without Object Lock
+const legalHoldTag = "legal-hold"
+
+// SetLegalHold places or releases the legal hold of the objects of a session,
+// its log file at location, "s3://bucket/key", and its attachments, and
+// returns their locations. The objects get an Object Lock legal hold, which
+// keeps them from being deleted whatever their retention, or a legal-hold
+// tag if the bucket does not have Object Lock.
+func (u *S3) SetLegalHold(location string, on bool) ([]string, error) {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return nil, fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("legal-hold")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	objects, err := u.sessionObjects(env, location)
+	if err != nil {
+		return nil, err
+	}
+	status := "OFF"
+	if on {
+		status = "ON"
+	}
+	var held []string
+	for _, o := range objects {
+		err := u.run(env, "s3api", "put-object-legal-hold", "--bucket", o.Bucket, "--key", o.Key, "--legal-hold", "Status="+status)
+		if err != nil && strings.Contains(strings.ToLower(err.Error()), "object lock configuration") {
+			err = u.tagLegalHold(env, o.Bucket, o.Key, on)
+		}
+		if err != nil {
+			return held, fmt.Errorf("failed to set the legal hold of %s: %w", o.Location(), err)
+		}
+		held = append(held, o.Location())
+	}
+	return held, nil
+}
+
+// sessionObjects returns the objects of a session: its log file at location,
+// "s3://bucket/key", its attachments and the content of the pointers
+func (u *S3) sessionObjects(env []string, location string) ([]S3Object, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !strings.HasPrefix(location, "s3://") || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 object %q, expected s3://bucket/key", location)
+	}
+	// the attachments share the name of the log file up to its extension
+	prefix := key
+	if i := strings.LastIndex(key, ".log"); i > 0 {
+		prefix = key[:i]
+	}
+	objects, err := u.listObjects(env, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no object found at %s", location)
+	}
+	if u.ContentAddressed {
+		for _, o := range objects {
+			cbucket, ckey, err := u.resolve(env, o.Bucket, o.Key)
+			if err != nil {
+				return nil, err
+			}
+			if cbucket != o.Bucket || ckey != o.Key {
+				objects = append(objects, S3Object{Bucket: cbucket, Key: ckey})
+			}
+		}
+	}
+	return objects, nil
+}
+
+// tagLegalHold adds or removes the legal-hold tag of an object, keeping its
+// other tags
+func (u *S3) tagLegalHold(env []string, bucket, key string, on bool) error {
+	type tag struct {
+		Key   string
+		Value string
+	}
+	out, err := u.output(env, "s3api", "get-object-tagging", "--bucket", bucket, "--key", key, "--output", "json")
+	if err != nil {
+		return err
+	}
+	var tagging struct{ TagSet []tag }
+	if err := json.Unmarshal(out, &tagging); err != nil {
+		return fmt.Errorf("failed to read the tags of s3://%s/%s: %w", bucket, key, err)
+	}
+	tags := []tag{}
+	for _, t := range tagging.TagSet {
+		if t.Key != legalHoldTag {
+			tags = append(tags, t)
+		}
+	}
+	if on {
+		tags = append(tags, tag{Key: legalHoldTag, Value: "true"})
+	}
+	tagging.TagSet = tags
+	data, err := json.Marshal(tagging)
+	if err != nil {
+		return err
+	}
+	return u.run(env, "s3api", "put-object-tagging", "--bucket", bucket, "--key", key, "--tagging", string(data))
+}
@@ -0,0 +1,169 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Object is an object uploaded by S3 with the metadata of its session:
+// user, context, cluster, namespace, pod, session-id and the sha256 of the
+// file
+type S3Object struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Location returns the S3 URL of the object
+func (o S3Object) Location() string {
+	return fmt.Sprintf("s3://%s/%s", o.Bucket, o.Key)
+}
+
+// List lists the objects uploaded under the static prefix of Path in the
+// bucket and the buckets of the routes, keep selects the objects whose
+// metadata is fetched, e.g. the log files modified in a period
+func (u *S3) List(keep func(S3Object) bool) ([]S3Object, error) {
+	return u.list(keep, true)
+}
+
+// ListKeys lists the objects like List without their metadata, which takes
+// a request per page of objects rather than per object, see ParseKey
+func (u *S3) ListKeys() ([]S3Object, error) {
+	return u.list(nil, false)
+}
+
+func (u *S3) list(keep func(S3Object) bool, metadata bool) ([]S3Object, error) {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return nil, fmt.Errorf("aws cli is not installed")
+	}
+	env, cleanup, err := u.environment("list")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var objects []S3Object
+	for _, loc := range u.locations() {
+		listed, err := u.listObjects(env, loc[0], loc[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range listed {
+			if keep != nil && !keep(o) {
+				continue
+			}
+			if !metadata {
+				objects = append(objects, o)
+				continue
+			}
+			if o.Metadata, err = u.metadata(env, o.Bucket, o.Key); err != nil {
+				return nil, err
+			}
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+// ParseKey returns the fields of the path template in the key of an object,
+// e.g. Context and File, without the prefix of its route
+func (u *S3) ParseKey(o S3Object) (map[string]string, bool) {
+	tmpl := u.Path
+	if tmpl == "" {
+		tmpl = DefaultPath
+	}
+	for _, r := range u.Routes {
+		if r.Bucket != o.Bucket || r.Prefix == "" {
+			continue
+		}
+		if key, ok := strings.CutPrefix(o.Key, r.Prefix+"/"); ok {
+			if fields, ok := MatchTemplate(tmpl, key); ok {
+				return fields, true
+			}
+		}
+	}
+	return MatchTemplate(tmpl, o.Key)
+}
+
+// locations returns the buckets and prefixes the objects are uploaded to
+func (u *S3) locations() [][2]string {
+	prefix := u.pathPrefix()
+	locations := [][2]string{{u.Bucket, prefix}}
+	for _, r := range u.Routes {
+		loc := [2]string{r.Bucket, prefix}
+		if r.Prefix != "" {
+			loc[1] = r.Prefix + "/" + prefix
+		}
+		if !slices.Contains(locations, loc) {
+			locations = append(locations, loc)
+		}
+	}
+	return locations
+}
+
+// listObjects lists the objects of a bucket under a prefix, the aws cli
+// fetches every page
+func (u *S3) listObjects(env []string, bucket, prefix string) ([]S3Object, error) {
+	args := []string{"s3api", "list-objects-v2", "--bucket", bucket, "--output", "json"}
+	if prefix != "" {
+		args = append(args, "--prefix", prefix)
+	}
+	out, err := u.output(env, args...)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Contents []struct {
+			Key          string
+			Size         int64
+			LastModified time.Time
+		}
+	}
+	// an empty listing has no output
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &res); err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", bucket, prefix, err)
+		}
+	}
+	objects := make([]S3Object, 0, len(res.Contents))
+	for _, c := range res.Contents {
+		objects = append(objects, S3Object{Bucket: bucket, Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+	}
+	return objects, nil
+}
+
+// metadata returns the user metadata of an object
+func (u *S3) metadata(env []string, bucket, key string) (map[string]string, error) {
+	out, err := u.output(env, "s3api", "head-object", "--bucket", bucket, "--key", key, "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var res struct{ Metadata map[string]string }
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("failed to read the metadata of s3://%s/%s: %w", bucket, key, err)
+	}
+	return res.Metadata, nil
+}
+
+// output runs the aws cli like run and returns its output
+func (u *S3) output(env []string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("aws", u.s3Args(args...)...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("AWS CLI error: %s", strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// SFTP uploads log files to a file server with the OpenSSH sftp
+// client. Authentication uses the given identity file or the ssh-agent and
+// ~/.ssh/config as usual.
+type SFTP struct {
+	Host string
+	User string
+	Port string
+	// Key is the identity file, the ssh-agent is used if empty
+	Key string
+	// Path is the remote path template
+	Path string
+}
+
+func (u *SFTP) Location(ev recorder.Event) (string, error) {
+	remotePath, err := RenderPath(u.Path, ev)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sftp://%s/%s", u.target(), strings.TrimPrefix(remotePath, "/")), nil
+}
+
+// target returns the [user@]host to connect to
+func (u *SFTP) target() string {
+	if u.User != "" {
+		return u.User + "@" + u.Host
+	}
+	return u.Host
+}
+
+func (u *SFTP) Upload(ev recorder.Event) (string, error) {
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return "", fmt.Errorf("sftp is not installed")
+	}
+
+	location, err := u.Location(ev)
+	if err != nil {
+		return "", err
+	}
+
+	// create the parent directories, "-" ignores the error if they exist
+	var batch strings.Builder
+	created := map[string]bool{}
+	for _, file := range sessionFiles(ev) {
+		dest, err := renderFilePath(u.Path, ev, file)
+		if err != nil {
+			return location, err
+		}
+		for _, p := range parentDirs(dest) {
+			if !created[p] {
+				created[p] = true
+				fmt.Fprintf(&batch, "-mkdir %s\n", sftpQuote(p))
+			}
+		}
+		fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(file), sftpQuote(dest))
+	}
+
+	var stderr bytes.Buffer
+	uploadCmd := exec.Command("sftp", u.args()...)
+	uploadCmd.Stdin = strings.NewReader(batch.String())
+	uploadCmd.Stderr = &stderr
+
+	if err := uploadCmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return location, fmt.Errorf("sftp error: %s", strings.TrimSpace(stderr.String()))
+		}
+		return location, err
+	}
+	return location, nil
+}
+
+// args returns the arguments of the sftp client running the commands of its
+// stdin on the file server
+func (u *SFTP) args() []string {
+	// non-interactive, read the commands from stdin
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if u.Port != "" {
+		args = append(args, "-P", u.Port)
+	}
+	if u.Key != "" {
+		args = append(args, "-i", u.Key)
+	}
+	return append(args, u.target())
+}
+
+// sftpQuote quotes a path for the sftp batch file
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
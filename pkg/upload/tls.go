@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLS configures the TLS connections of the HTTP based uploaders, for
+// endpoints signed by a private CA or requiring mutual TLS
+type TLS struct {
+	// CABundle is a PEM file of the CA certificates trusted in addition to
+	// the system ones
+	CABundle string
+	// ClientCert and ClientKey are the PEM files of the client certificate
+	// and its key
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+}
+
+// Config returns the TLS config, nil when t is empty
+func (t TLS) Config() (*tls.Config, error) {
+	if t == (TLS{}) {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CABundle != "" {
+		pem, err := os.ReadFile(t.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return nil, fmt.Errorf("a client certificate requires both a certificate and a key file")
+	}
+	if t.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newHTTPClient creates an HTTP client with the TLS config t
+func newHTTPClient(timeout time.Duration, t TLS) (*http.Client, error) {
+	config, err := t.Config()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return &http.Client{Timeout: timeout}, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
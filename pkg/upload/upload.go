@@ -0,0 +1,174 @@
+// Package upload uploads finished session logs to remote storages
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+// DefaultPath is the default remote path template of uploaded log files
+const DefaultPath = "kubectl-execrec/{{.Context}}/{{.File}}"
+
+// Uploader uploads the finished log file to a remote storage
+type Uploader interface {
+	// Upload uploads the log file of the ended session and returns its
+	// remote location, the location is also returned on failure for reporting
+	Upload(ev recorder.Event) (string, error)
+}
+
+// Locator is implemented by uploaders that can tell the remote location of
+// the log file of a session without uploading it
+type Locator interface {
+	Location(ev recorder.Event) (string, error)
+}
+
+// Exister is implemented by uploaders that can tell if the log file of a
+// session is already at its remote location
+type Exister interface {
+	Exists(ev recorder.Event) (bool, error)
+}
+
+// PathData is the data available to remote path templates
+type PathData struct {
+	// Context is the kubectl context of the session
+	Context string
+	// Cluster is the cluster of the context
+	Cluster string
+	// Namespace is the namespace of the pod
+	Namespace string
+	// User is the user running the session
+	User string
+	// File is the base name of the log file
+	File string
+	// ID is the session ID
+	ID string
+}
+
+// RenderPath renders a remote path template for the log file of a session,
+// DefaultPath is used if tmpl is empty
+func RenderPath(tmpl string, ev recorder.Event) (string, error) {
+	return renderFilePath(tmpl, ev, ev.LogFile)
+}
+
+// renderFilePath renders a remote path template for one of the files of a
+// session, the fields cannot add path segments
+func renderFilePath(tmpl string, ev recorder.Event, file string) (string, error) {
+	d := PathData{
+		Context:   recorder.SafeFileName(ev.Context),
+		Cluster:   recorder.SafeFileName(ev.Cluster),
+		Namespace: recorder.SafeFileName(ev.Namespace),
+		User:      recorder.SafeFileName(ev.User),
+		File:      filepath.Base(file),
+		ID:        ev.SessionID,
+	}
+	if tmpl == "" {
+		tmpl = DefaultPath
+	}
+	t, err := template.New("path").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid path template %q: %w", tmpl, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("invalid path template %q: %w", tmpl, err)
+	}
+	return b.String(), nil
+}
+
+// templateField matches the fields of a template, e.g. {{.Context}}
+var templateField = regexp.MustCompile(`\{\{-?\s*\.(\w+)\s*-?\}\}`)
+
+// MatchTemplate matches s with a template made of fields only, such as the
+// remote path or the log file name templates, and returns the values of its
+// fields. A field matches the shortest run of characters but "/", a
+// template with other actions matches nothing.
+func MatchTemplate(tmpl, s string) (map[string]string, bool) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	var names []string
+	last := 0
+	for _, m := range templateField.FindAllStringSubmatchIndex(tmpl, -1) {
+		if strings.Contains(tmpl[last:m[0]], "{{") {
+			return nil, false
+		}
+		expr.WriteString(regexp.QuoteMeta(tmpl[last:m[0]]))
+		expr.WriteString("([^/]*?)")
+		names = append(names, tmpl[m[2]:m[3]])
+		last = m[1]
+	}
+	if strings.Contains(tmpl[last:], "{{") {
+		return nil, false
+	}
+	expr.WriteString(regexp.QuoteMeta(tmpl[last:]) + "$")
+	match := regexp.MustCompile(expr.String()).FindStringSubmatch(s)
+	if match == nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(names))
+	for i, name := range names {
+		// a field used twice has the same value
+		if v, ok := fields[name]; ok && v != match[i+1] {
+			return nil, false
+		}
+		fields[name] = match[i+1]
+	}
+	return fields, true
+}
+
+// MatchGlob matches a name with a pattern where * matches any characters,
+// including the / of EKS cluster ARNs, and ? a single character
+func MatchGlob(pattern, name string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String()).MatchString(name)
+}
+
+// FileSHA256 returns the hex SHA-256 of a file, the checksum of the uploaded
+// files in the session index and the object metadata
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sessionFiles returns the log file followed by its attachments
+func sessionFiles(ev recorder.Event) []string {
+	return append([]string{ev.LogFile}, ev.Attachments...)
+}
+
+// parentDirs returns the parent directories of a remote path, outermost first
+func parentDirs(p string) []string {
+	var parents []string
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	return parents
+}
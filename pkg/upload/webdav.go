@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keidarcy/kubectl-execrec/pkg/recorder"
+)
+
+const webdavTimeout = 5 * time.Minute
+
+// WebDAV uploads log files to a WebDAV server (Nextcloud, SharePoint,
+// Apache mod_dav...) with basic or bearer token authentication
+type WebDAV struct {
+	// URL is the base URL the remote path is appended to
+	URL      string
+	User     string
+	Password string
+	// Token is a bearer token, it takes precedence over basic auth
+	Token string
+	// Path is the remote path template
+	Path string
+	// TLS configures client certificates and custom CAs
+	TLS TLS
+
+	client *http.Client
+}
+
+func (u *WebDAV) Upload(ev recorder.Event) (string, error) {
+	location, err := u.Location(ev)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(u.URL, "/")
+
+	if u.client == nil {
+		if u.client, err = newHTTPClient(webdavTimeout, u.TLS); err != nil {
+			return location, err
+		}
+	}
+
+	created := map[string]bool{}
+	for _, file := range sessionFiles(ev) {
+		dest, err := renderFilePath(u.Path, ev, file)
+		if err != nil {
+			return location, err
+		}
+		dest = strings.TrimPrefix(dest, "/")
+
+		// create the parent collections, 405 means the collection already exists
+		for _, p := range parentDirs(dest) {
+			if created[p] {
+				continue
+			}
+			created[p] = true
+			resp, err := u.do("MKCOL", base+"/"+p+"/", nil)
+			if err != nil {
+				return location, err
+			}
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+				return location, fmt.Errorf("WebDAV error: MKCOL %s: %s", p, resp.Status)
+			}
+		}
+
+		if err := u.put(file, base+"/"+dest); err != nil {
+			return location, err
+		}
+	}
+	return location, nil
+}
+
+func (u *WebDAV) Location(ev recorder.Event) (string, error) {
+	remotePath, err := RenderPath(u.Path, ev)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(u.URL, "/") + "/" + strings.TrimPrefix(remotePath, "/"), nil
+}
+
+// Exists tells if the log file of a session was already uploaded
+func (u *WebDAV) Exists(ev recorder.Event) (bool, error) {
+	location, err := u.Location(ev)
+	if err != nil {
+		return false, err
+	}
+	if u.client == nil {
+		if u.client, err = newHTTPClient(webdavTimeout, u.TLS); err != nil {
+			return false, err
+		}
+	}
+	resp, err := u.do(http.MethodHead, location, nil)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, fmt.Errorf("WebDAV error: HEAD %s: %s", location, resp.Status)
+	}
+	return true, nil
+}
+
+// put uploads a local file
+func (u *WebDAV) put(file, url string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	resp, err := u.do(http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("WebDAV error: PUT %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Open streams a file, e.g. a log file to replay, with the credentials and
+// the TLS config of the uploads
+func (u *WebDAV) Open(url string) (io.ReadCloser, error) {
+	if u.client == nil {
+		var err error
+		if u.client, err = newHTTPClient(webdavTimeout, u.TLS); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.authorize(req)
+	return openResponse(u.client, req, "WebDAV")
+}
+
+// authorize sets the credentials of a request
+func (u *WebDAV) authorize(req *http.Request) {
+	switch {
+	case u.Token != "":
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	case u.User != "":
+		req.SetBasicAuth(u.User, u.Password)
+	}
+}
+
+func (u *WebDAV) do(method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	u.authorize(req)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WebDAV error: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
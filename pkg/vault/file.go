@@ -0,0 +1,206 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Ext is the extension added to encrypted files
+const Ext = ".vault"
+
+// magic starts the encrypted files
+const magic = "kubectl-execrec vault encrypted\n"
+
+// chunkSize is the size of the plaintext chunks sealed independently, so
+// that large logs are never loaded in memory
+const chunkSize = 64 << 10
+
+// header is the JSON line following the magic of an encrypted file
+type header struct {
+	// Mount and Key are the transit engine and key that wrapped the data key
+	Mount string `json:"mount"`
+	Key   string `json:"key"`
+	// DataKey is the data key wrapped by Vault
+	DataKey string `json:"dataKey"`
+	// Nonce is the nonce prefix of the chunks
+	Nonce []byte `json:"nonce"`
+}
+
+// Encryptor encrypts files with data keys of a transit key
+type Encryptor struct {
+	Client *Client
+	// Mount is the path of the transit engine, "transit" if empty
+	Mount string
+	// Key is the name of the transit key
+	Key string
+}
+
+func (e *Encryptor) mount() string {
+	if e.Mount == "" {
+		return "transit"
+	}
+	return e.Mount
+}
+
+// EncryptFile encrypts a file with a new data key into the file with the Ext
+// extension, removes the plaintext file and returns the encrypted one
+func (e *Encryptor) EncryptFile(path string) (string, error) {
+	key, wrapped, err := e.Client.DataKey(e.mount(), e.Key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	h := header{Mount: e.mount(), Key: e.Key, DataKey: wrapped, Nonce: make([]byte, aead.NonceSize()-5)}
+	if _, err := rand.Read(h.Nonce); err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+Ext, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := writeEncrypted(out, in, aead, h); err != nil {
+		out.Close()
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	in.Close()
+	return out.Name(), os.Remove(path)
+}
+
+func writeEncrypted(w io.Writer, r io.Reader, aead cipher.AEAD, h header) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic + string(data) + "\n"); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, chunkSize)
+	for i := uint32(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last, err := isLast(br, err)
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(aead.Seal(nil, nonce(h.Nonce, i, last), buf[:n], nil)); err != nil {
+			return err
+		}
+		if last {
+			return bw.Flush()
+		}
+	}
+}
+
+// isLast tells if a chunk read with io.ReadFull is the last one of r
+func isLast(r *bufio.Reader, readErr error) (bool, error) {
+	if readErr != nil {
+		return true, nil
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Decrypt decrypts an encrypted file, the data key is unwrapped by Vault
+func Decrypt(c *Client, w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil || line != magic {
+		return errors.New("not a file encrypted with Vault")
+	}
+	line, err = br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("invalid encrypted file: %w", err)
+	}
+	var h header
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &h); err != nil {
+		return fmt.Errorf("invalid encrypted file: %w", err)
+	}
+	key, err := c.DecryptKey(h.Mount, h.Key, h.DataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize+aead.Overhead())
+	for i := uint32(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			return errors.New("the encrypted file is truncated")
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last, err := isLast(br, err)
+		if err != nil {
+			return err
+		}
+		// a truncated file ends with a chunk that was not sealed as the last
+		// one and fails to open
+		plain, err := aead.Open(nil, nonce(h.Nonce, i, last), buf[:n], nil)
+		if err != nil {
+			return errors.New("the encrypted file is corrupted or truncated")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of chunk i: the prefix, the chunk number and 1 for
+// the last chunk, so that chunks cannot be reordered and the file cannot be
+// truncated
+func nonce(prefix []byte, i uint32, last bool) []byte {
+	n := make([]byte, 0, len(prefix)+5)
+	n = append(n, prefix...)
+	n = binary.BigEndian.AppendUint32(n, i)
+	if last {
+		return append(n, 1)
+	}
+	return append(n, 0)
+}
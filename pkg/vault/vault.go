@@ -0,0 +1,187 @@
+// Package vault encrypts session logs with the transit engine of HashiCorp
+// Vault and reads the secrets of the settings
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Client is a minimal Vault API client
+type Client struct {
+	// Addr is the address of Vault, e.g. https://vault.example.com:8200
+	Addr string
+	// Token is the Vault token
+	Token string
+	// Namespace is the Vault Enterprise namespace
+	Namespace string
+
+	client *http.Client
+}
+
+// NewClient creates a client configured like the vault cli: with VAULT_ADDR,
+// VAULT_TOKEN or else ~/.vault-token, VAULT_NAMESPACE, VAULT_CACERT and
+// VAULT_SKIP_VERIFY
+func NewClient() (*Client, error) {
+	c := &Client{
+		Addr:      os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+	if c.Addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	if c.Token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			c.Token = strings.TrimSpace(string(data))
+		}
+	}
+	if c.Token == "" {
+		return nil, fmt.Errorf("no Vault token, set VAULT_TOKEN or run vault login")
+	}
+
+	tlsConfig := &tls.Config{}
+	if path := os.Getenv("VAULT_CACERT"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if v := os.Getenv("VAULT_SKIP_VERIFY"); v != "" && v != "0" && v != "false" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.client = &http.Client{Timeout: requestTimeout, Transport: transport}
+	return c, nil
+}
+
+// do sends a request to the Vault API and decodes the data of the response
+// into out
+func (c *Client) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(c.Addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.Token)
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.client
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil && err != io.EOF {
+		return fmt.Errorf("vault: %s %s: %s", method, path, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("vault: %s %s: %s: %s", method, path, resp.Status, strings.Join(result.Errors, ", "))
+		}
+		return fmt.Errorf("vault: %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("vault: %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// DataKey generates a data key with the transit key name of the transit
+// engine mounted at mount, it returns the key and the key wrapped by Vault
+func (c *Client) DataKey(mount, name string) ([]byte, string, error) {
+	var data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	path := fmt.Sprintf("%s/datakey/plaintext/%s", mount, name)
+	if err := c.do(http.MethodPost, path, map[string]any{"bits": 256}, &data); err != nil {
+		return nil, "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return nil, "", fmt.Errorf("vault: invalid data key: %w", err)
+	}
+	return key, data.Ciphertext, nil
+}
+
+// DecryptKey unwraps a data key with the transit key name, Vault audits the
+// request and checks it against the policies of the token
+func (c *Client) DecryptKey(mount, name, ciphertext string) ([]byte, error) {
+	var data struct {
+		Plaintext string `json:"plaintext"`
+	}
+	path := fmt.Sprintf("%s/decrypt/%s", mount, name)
+	if err := c.do(http.MethodPost, path, map[string]string{"ciphertext": ciphertext}, &data); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault: invalid data key: %w", err)
+	}
+	return key, nil
+}
+
+// Secret reads a field of a secret of a KV engine, path is the API path of
+// the secret, e.g. "secret/data/execrec" for a KV version 2 engine
+func (c *Client) Secret(path, field string) (string, error) {
+	var data map[string]any
+	if err := c.do(http.MethodGet, path, nil, &data); err != nil {
+		return "", err
+	}
+	// the fields of KV version 2 secrets are nested with their metadata
+	if nested, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = nested
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault: no field %s in the secret %s", field, path)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}